@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/user"
+	"net/http"
+	"sort"
+)
+
+const (
+	QUERY_PARAM_ESTIMATOR = "estimator"
+)
+
+// A1CEstimatorResponse is the a1c estimator chosen by a user along with all estimators it can choose from
+type A1CEstimatorResponse struct {
+	Estimator string   `json:"estimator"`
+	Available []string `json:"available"`
+}
+
+// a1cEstimator is the endpoint to retrieve the a1c estimator of the current user
+func a1cEstimator(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	settings, err := store.GetUserSettings(context, user.Email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	available := make([]string, 0, len(engine.A1C_ESTIMATORS))
+	for name := range engine.A1C_ESTIMATORS {
+		available = append(available, name)
+	}
+	sort.Strings(available)
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(A1CEstimatorResponse{Estimator: engine.GetA1CEstimator(settings.A1CEstimator).Name(), Available: available})
+}
+
+// updateA1CEstimator sets the a1c estimator of the current user and kicks off the recalculation of its a1c history
+func updateA1CEstimator(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	estimator, ok := engine.A1C_ESTIMATORS[request.FormValue(QUERY_PARAM_ESTIMATOR)]
+	if !ok {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%s].", QUERY_PARAM_ESTIMATOR, request.FormValue(QUERY_PARAM_ESTIMATOR)), 400)
+		return
+	}
+
+	settings, err := store.GetUserSettings(context, user.Email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	if engine.GetA1CEstimator(settings.A1CEstimator).Name() == estimator.Name() {
+		writer.WriteHeader(200)
+		return
+	}
+
+	log.Infof(context, "Updating a1c estimator of user [%s] from [%s] to [%s]", user.Email, settings.A1CEstimator, estimator.Name())
+	settings.A1CEstimator = estimator.Name()
+	if err := store.StoreUserSettings(context, user.Email, *settings); err != nil {
+		util.Propagate(err)
+	}
+
+	if err := engine.RecalculateA1C(context, user.Email); err != nil {
+		log.Criticalf(context, "Couldn't schedule the recalculation of a1c estimates for user [%s]: %v", user.Email, err)
+	}
+
+	writer.WriteHeader(200)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+	"google.golang.org/appengine/urlfetch"
+	"google.golang.org/appengine/user"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	GOOGLE_REVOKE_TOKEN_URL = "https://accounts.google.com/o/oauth2/revoke"
+
+	QUERY_PARAM_CONFIRM_EMAIL = "confirmEmail"
+)
+
+// deleteAccount starts the deletion of the current user's account. Requests from other sites are refused since the
+// session cookie alone would let them trigger the deletion. The user also has to type its email again so that an
+// account isn't deleted by accident. The google token is revoked right away so that we stop accessing the user's
+// drive while the user data is deleted in the background.
+func deleteAccount(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	if !isSameOriginRequest(request) {
+		log.Warningf(context, "Refusing deletion of account [%s] requested from origin [%s] and referer [%s]", user.Email,
+			request.Header.Get("Origin"), request.Referer())
+		http.Error(writer, "Account deletion must be requested from glukit.", 403)
+		return
+	}
+
+	if confirmEmail := request.FormValue(QUERY_PARAM_CONFIRM_EMAIL); !strings.EqualFold(strings.TrimSpace(confirmEmail), user.Email) {
+		log.Infof(context, "Refusing deletion of account [%s] without a matching confirmation email", user.Email)
+		http.Error(writer, "The confirmation email doesn't match the email of the account.", 400)
+		return
+	}
+
+	if pending, err := store.IsAccountDeletionPending(context, user.Email); err != nil {
+		util.Propagate(err)
+	} else if pending {
+		log.Infof(context, "Deletion of account [%s] already in progress", user.Email)
+		writer.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	_, glukitUser, err := store.GetGlukitUser(context, user.Email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	revokeGoogleToken(context, user.Email, glukitUser.RefreshToken)
+
+	tombstone := model.AccountTombstone{EmailHash: store.HashAccountEmail(user.Email), Status: model.ACCOUNT_DELETION_PENDING, RequestedOn: time.Now()}
+	key, err := store.StoreAccountTombstone(context, tombstone)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	task, err := deleteAccountData.Task(user.Email, key.IntID())
+	if err != nil {
+		util.Propagate(err)
+	}
+	taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME)
+
+	log.Infof(context, "Enqueued deletion of account [%s] with tombstone [%d]", user.Email, key.IntID())
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+// isSameOriginRequest returns true if the Origin header of a request, or its Referer when browsers leave the origin
+// out, is this host. Requests with neither are refused.
+func isSameOriginRequest(request *http.Request) bool {
+	source := request.Header.Get("Origin")
+	if len(source) == 0 || source == "null" {
+		source = request.Referer()
+	}
+
+	sourceUrl, err := url.Parse(source)
+	if len(source) == 0 || err != nil {
+		return false
+	}
+
+	return sourceUrl.Host == request.Host
+}
+
+// revokeGoogleToken revokes our access to the user's google account. Failing to do so isn't fatal since the user can
+// still revoke it from the google account settings.
+func revokeGoogleToken(context context.Context, email string, token string) {
+	if len(token) == 0 {
+		return
+	}
+
+	client := urlfetch.Client(context)
+	response, err := client.PostForm(fmt.Sprintf("%s?%s", GOOGLE_REVOKE_TOKEN_URL, url.Values{"token": {token}}.Encode()), nil)
+	if err != nil {
+		log.Warningf(context, "Error revoking google token of user [%s]: %v", email, err)
+		return
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		log.Warningf(context, "Error revoking google token of user [%s], got status [%d]", email, response.StatusCode)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/user"
+	"net/http"
+)
+
+const (
+	// Number of days of calibrations compared with the CGM when no range is requested
+	DEFAULT_ACCURACY_DAYS = 90
+)
+
+func sensorAccuracy(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	sensorAccuracyForEmail(writer, request, user.Email)
+}
+
+func sensorAccuracyForDemo(writer http.ResponseWriter, request *http.Request) {
+	sensorAccuracyForEmail(writer, request, DEMO_EMAIL)
+}
+
+// sensorAccuracyForEmail is the endpoint to retrieve the accuracy of the CGM against meter calibrations over a
+// range, overall, by sensor session and by week. The range defaults to the last 90 days of data.
+func sensorAccuracyForEmail(writer http.ResponseWriter, request *http.Request, email string) {
+	context := appengine.NewContext(request)
+
+	_, _, mostRecentRead, err := store.GetUserData(context, email)
+	if err == store.ErrNoImportedDataFound {
+		log.Debugf(context, "No imported data found for user [%s]", email)
+		http.Error(writer, err.Error(), 204)
+		return
+	} else if err != nil {
+		util.Propagate(err)
+	}
+
+	lowerBound, upperBound, err := newReadRange(request, mostRecentRead.AddDate(0, 0, -1*DEFAULT_ACCURACY_DAYS), mostRecentRead)
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	calibrations, err := store.GetCalibrations(context, email, lowerBound, upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	reads, err := store.GetGlucoseReads(context, email, lowerBound, upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	report, err := engine.CalculateAccuracyReport(calibrations, reads, lowerBound, upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(report)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/user"
+	"net/http"
+)
+
+const (
+	// Number of days covered by an AGP when no range is requested
+	DEFAULT_AGP_DAYS = 14
+)
+
+func ambulatoryGlucoseProfile(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	ambulatoryGlucoseProfileForEmail(writer, request, user.Email)
+}
+
+func ambulatoryGlucoseProfileForDemo(writer http.ResponseWriter, request *http.Request) {
+	ambulatoryGlucoseProfileForEmail(writer, request, DEMO_EMAIL)
+}
+
+// ambulatoryGlucoseProfileForEmail is the endpoint to retrieve the ambulatory glucose profile of a range of reads.
+// The range defaults to the last 14 days of data.
+func ambulatoryGlucoseProfileForEmail(writer http.ResponseWriter, request *http.Request, email string) {
+	context := appengine.NewContext(request)
+
+	_, _, mostRecentRead, err := store.GetUserData(context, email)
+	if err == store.ErrNoImportedDataFound {
+		log.Debugf(context, "No imported data found for user [%s]", email)
+		http.Error(writer, err.Error(), 204)
+		return
+	} else if err != nil {
+		util.Propagate(err)
+	}
+
+	lowerBound, upperBound, err := newReadRange(request, mostRecentRead.AddDate(0, 0, -1*DEFAULT_AGP_DAYS), mostRecentRead)
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	settings, err := store.GetUserSettings(context, email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	reads, err := store.GetGlucoseReads(context, email, lowerBound, upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	agp, err := engine.CalculateAmbulatoryGlucoseProfile(reads, settings.TimeInRangeThresholds, lowerBound, upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	if agp == nil {
+		http.Error(writer, "Not enough reads to calculate an ambulatory glucose profile.", 204)
+		return
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(agp)
+}
+
+// demoAgpReport executes the AGP report page template for the demo user
+func demoAgpReport(w http.ResponseWriter, request *http.Request) {
+	renderAgpReport(w, request, DEMO_EMAIL, DEMO_PATH_PREFIX)
+}
+
+// agpReport executes the AGP report page template
+func agpReport(w http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	w.Header().Set("Content-Security-Policy", CONTENT_SECURITY_POLICY)
+	renderAgpReport(w, request, user.Email, "")
+}
+
+func renderAgpReport(w http.ResponseWriter, request *http.Request, email string, pathPrefix string) {
+	context := appengine.NewContext(request)
+	unitValue, err := resolveGlucoseUnit(email, request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderVariables := &RenderVariables{PathPrefix: pathPrefix, StripePublishableKey: appConfig.StripePublishableKey, SSLHost: appConfig.SSLHost, GlucoseUnit: *unitValue}
+
+	if err := agpTemplate.Execute(w, renderVariables); err != nil {
+		log.Criticalf(context, "Error executing template [%s]", agpTemplate.Name())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
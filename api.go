@@ -5,14 +5,17 @@ import (
 	"fmt"
 	"github.com/alexandre-normand/glukit/app/apimodel"
 	"github.com/alexandre-normand/glukit/app/bufio"
-	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
 	"github.com/alexandre-normand/glukit/app/store"
 	"github.com/alexandre-normand/glukit/app/streaming"
+	"github.com/alexandre-normand/glukit/app/util"
+	"golang.org/x/net/context"
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/log"
 	"io"
 	"net/http"
 	"strings"
+	"time"
 )
 
 const (
@@ -55,6 +58,10 @@ func initApiEndpoints(writer http.ResponseWriter, request *http.Request) {
 	muxRouter.Get(MEALS_V1_ROUTE).Handler(newOauthAuthenticationHandler(http.HandlerFunc(processNewMealData)))
 	muxRouter.Get(GLUCOSEREADS_V1_ROUTE).Handler(newOauthAuthenticationHandler(http.HandlerFunc(processNewGlucoseReadData)))
 	muxRouter.Get(EXERCISES_V1_ROUTE).Handler(newOauthAuthenticationHandler(http.HandlerFunc(processNewExerciseData)))
+	muxRouter.Get(LAB_A1CS_V1_ROUTE).Handler(newOauthAuthenticationHandler(http.HandlerFunc(processNewLabA1CData)))
+	muxRouter.Get(FHIR_OBSERVATIONS_ROUTE).Handler(newOauthAuthenticationHandler(http.HandlerFunc(fhirObservations)))
+	muxRouter.Get(FHIR_MEDICATION_ADMINISTRATIONS_ROUTE).Handler(newOauthAuthenticationHandler(http.HandlerFunc(fhirMedicationAdministrations)))
+	muxRouter.Get(FHIR_IMPORT_ROUTE).Handler(newOauthAuthenticationHandler(http.HandlerFunc(fhirImport)))
 }
 
 // processNewCalibrationData Handles a Post to the calibration endpoint and
@@ -63,13 +70,14 @@ func processNewCalibrationData(writer http.ResponseWriter, request *http.Request
 	context := appengine.NewContext(request)
 	user := CurrentApiUser(request)
 
-	userProfileKey, _, err := store.GetGlukitUser(context, user.Email)
+	userProfileKey, glukitUser, err := store.GetGlukitUser(context, user.Email)
 	if err != nil {
 		log.Warningf(context, "Error getting user to process calibration data, user email is [%s]: %v", user.Email, err)
 		http.Error(writer, "Error getting user to process calibration data", 500)
 		return
 	}
 
+	zone := getUserZone(context, glukitUser)
 	dataStoreWriter := store.NewDataStoreCalibrationBatchWriter(context, userProfileKey)
 	batchingWriter := bufio.NewCalibrationWriterSize(dataStoreWriter, store.GLUKIT_SCORE_PUT_MULTI_SIZE)
 	calibrationStreamer := streaming.NewCalibrationReadStreamerDuration(batchingWriter, apimodel.DAY_OF_DATA_DURATION)
@@ -86,6 +94,10 @@ func processNewCalibrationData(writer http.ResponseWriter, request *http.Request
 			break
 		}
 
+		for i := range c {
+			c[i].Time = resolveApiTime(c[i].Time, zone)
+		}
+
 		log.Debugf(context, "Writing new calibration reads [%v]", c)
 		calibrationStreamer, err = calibrationStreamer.WriteCalibrations(c)
 		if err != nil {
@@ -118,13 +130,14 @@ func processNewGlucoseReadData(writer http.ResponseWriter, request *http.Request
 	context := appengine.NewContext(request)
 	user := CurrentApiUser(request)
 
-	userProfileKey, _, err := store.GetGlukitUser(context, user.Email)
+	userProfileKey, glukitUser, err := store.GetGlukitUser(context, user.Email)
 	if err != nil {
 		log.Warningf(context, "Error getting user to process glucose read data, user email is [%s]: %v", user.Email, err)
 		http.Error(writer, "Error getting user to process glucose read data", 500)
 		return
 	}
 
+	zone := getUserZone(context, glukitUser)
 	dataStoreWriter := store.NewDataStoreGlucoseReadBatchWriter(context, userProfileKey)
 	batchingWriter := bufio.NewGlucoseReadWriterSize(dataStoreWriter, store.GLUKIT_SCORE_PUT_MULTI_SIZE)
 	glucoseReadStreamer := streaming.NewGlucoseStreamerDuration(batchingWriter, apimodel.DAY_OF_DATA_DURATION)
@@ -141,6 +154,10 @@ func processNewGlucoseReadData(writer http.ResponseWriter, request *http.Request
 			break
 		}
 
+		for i := range c {
+			c[i].Time = resolveApiTime(c[i].Time, zone)
+		}
+
 		log.Debugf(context, "Writing [%d] new glucose reads: %v", len(c), c)
 		glucoseReadStreamer, err = glucoseReadStreamer.WriteGlucoseReads(c)
 		if err != nil {
@@ -163,19 +180,10 @@ func processNewGlucoseReadData(writer http.ResponseWriter, request *http.Request
 		return
 	}
 
-	_, glukitUser, err := store.GetGlukitUser(context, user.Email)
-	if err != nil {
+	if _, glukitUser, err := store.GetGlukitUser(context, user.Email); err != nil {
 		log.Warningf(context, "Couldn't get glukit user profile [%s] to recalculate score: %v", user.Email, err)
-	}
-
-	err = engine.StartGlukitScoreBatch(context, glukitUser)
-	if err != nil {
-		log.Warningf(context, "Error starting glukit score calculation batch for user [%s]: %v", user.Email, err)
-	}
-
-	err = engine.StartA1CCalculationBatch(context, glukitUser)
-	if err != nil {
-		log.Warningf(context, "Error starting a1c calculation batch for user [%s]: %v", user.Email, err)
+	} else {
+		startCalculationsAfterImport(context, glukitUser)
 	}
 
 	log.Infof(context, "Wrote glucose reads to the datastore for user [%s]", user.Email)
@@ -188,13 +196,14 @@ func processNewInjectionData(writer http.ResponseWriter, request *http.Request)
 	context := appengine.NewContext(request)
 	user := CurrentApiUser(request)
 
-	userProfileKey, _, err := store.GetGlukitUser(context, user.Email)
+	userProfileKey, glukitUser, err := store.GetGlukitUser(context, user.Email)
 	if err != nil {
 		log.Warningf(context, "Error getting user to process injection data, user email is [%s]: %v", user.Email, err)
 		http.Error(writer, "Error getting user to process injection data", 500)
 		return
 	}
 
+	zone := getUserZone(context, glukitUser)
 	dataStoreWriter := store.NewDataStoreInjectionBatchWriter(context, userProfileKey)
 	batchingWriter := bufio.NewInjectionWriterSize(dataStoreWriter, store.GLUKIT_SCORE_PUT_MULTI_SIZE)
 	injectionStreamer := streaming.NewInjectionStreamerDuration(batchingWriter, apimodel.DAY_OF_DATA_DURATION)
@@ -211,6 +220,10 @@ func processNewInjectionData(writer http.ResponseWriter, request *http.Request)
 			break
 		}
 
+		for i := range p {
+			p[i].Time = resolveApiTime(p[i].Time, zone)
+		}
+
 		log.Debugf(context, "Writing [%d] new injections", len(p))
 		injectionStreamer, err = injectionStreamer.WriteInjections(p)
 		if err != nil {
@@ -243,13 +256,14 @@ func processNewMealData(writer http.ResponseWriter, request *http.Request) {
 	context := appengine.NewContext(request)
 	user := CurrentApiUser(request)
 
-	userProfileKey, _, err := store.GetGlukitUser(context, user.Email)
+	userProfileKey, glukitUser, err := store.GetGlukitUser(context, user.Email)
 	if err != nil {
 		log.Warningf(context, "Error getting user to process meal data, user email is [%s]: %v", user.Email, err)
 		http.Error(writer, "Error getting user to process meal data", 500)
 		return
 	}
 
+	zone := getUserZone(context, glukitUser)
 	dataStoreWriter := store.NewDataStoreMealBatchWriter(context, userProfileKey)
 	batchingWriter := bufio.NewMealWriterSize(dataStoreWriter, store.GLUKIT_SCORE_PUT_MULTI_SIZE)
 	mealStreamer := streaming.NewMealStreamerDuration(batchingWriter, apimodel.DAY_OF_DATA_DURATION)
@@ -266,6 +280,10 @@ func processNewMealData(writer http.ResponseWriter, request *http.Request) {
 			break
 		}
 
+		for i := range meals {
+			meals[i].Time = resolveApiTime(meals[i].Time, zone)
+		}
+
 		log.Debugf(context, "Writing [%d] new meals", len(meals))
 		mealStreamer, err = mealStreamer.WriteMeals(meals)
 		if err != nil {
@@ -298,13 +316,14 @@ func processNewExerciseData(writer http.ResponseWriter, request *http.Request) {
 	context := appengine.NewContext(request)
 	user := CurrentApiUser(request)
 
-	userProfileKey, _, err := store.GetGlukitUser(context, user.Email)
+	userProfileKey, glukitUser, err := store.GetGlukitUser(context, user.Email)
 	if err != nil {
 		log.Warningf(context, "Error getting user to process exercise data, user email is [%s]: %v", user.Email, err)
 		http.Error(writer, "Error getting user to process exercise data", 500)
 		return
 	}
 
+	zone := getUserZone(context, glukitUser)
 	dataStoreWriter := store.NewDataStoreExerciseBatchWriter(context, userProfileKey)
 	batchingWriter := bufio.NewExerciseWriterSize(dataStoreWriter, store.GLUKIT_SCORE_PUT_MULTI_SIZE)
 	exerciseStreamer := streaming.NewExerciseStreamerDuration(batchingWriter, apimodel.DAY_OF_DATA_DURATION)
@@ -321,6 +340,10 @@ func processNewExerciseData(writer http.ResponseWriter, request *http.Request) {
 			break
 		}
 
+		for i := range exercises {
+			exercises[i].Time = resolveApiTime(exercises[i].Time, zone)
+		}
+
 		log.Debugf(context, "Writing [%d] new Exercises", len(exercises))
 		exerciseStreamer, err = exerciseStreamer.WriteExercises(exercises)
 		if err != nil {
@@ -346,3 +369,30 @@ func processNewExerciseData(writer http.ResponseWriter, request *http.Request) {
 	log.Infof(context, "Wrote exercises to the datastore for user [%s]", user.Email)
 	writer.WriteHeader(200)
 }
+
+// getUserZone returns the user's configured zone or nil if it doesn't have one
+func getUserZone(context context.Context, glukitUser *model.GlukitUser) (zone *time.Location) {
+	if len(glukitUser.Timezone) == 0 {
+		return nil
+	}
+
+	zone, err := util.GetOrLoadLocationForName(glukitUser.Timezone)
+	if err != nil {
+		log.Warningf(context, "Invalid timezone [%s] for user [%s], falling back to fixed offsets: %v", glukitUser.Timezone, glukitUser.Email, err)
+		return nil
+	}
+
+	return zone
+}
+
+// resolveApiTime resolves the fixed offset clients send against the user's zone the same way file imports do so that
+// daylight saving transitions don't split a user's history into different locations
+func resolveApiTime(value apimodel.Time, zone *time.Location) apimodel.Time {
+	if zone == nil || !util.IsFixedOffsetLocationName(value.TimeZoneId) {
+		return value
+	}
+
+	timeValue := value.GetTime()
+	location := util.ResolveLocation(timeValue.Format(util.TIMEFORMAT_NO_TZ), timeValue.UTC(), zone)
+	return apimodel.Time{Timestamp: value.Timestamp, TimeZoneId: location.String()}
+}
@@ -0,0 +1,45 @@
+package apimodel
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	LAB_A1C_TAG = "LabA1C"
+)
+
+// LabA1C is an HbA1c result from a lab test, in percent. The time is when the blood sample was taken.
+type LabA1C struct {
+	Time  Time    `json:"time" datastore:"time,noindex"`
+	Value float64 `json:"value" datastore:"value,noindex"`
+}
+
+// GetTime gets the time of a Timestamp value
+func (element LabA1C) GetTime() time.Time {
+	return element.Time.GetTime()
+}
+
+// Validate returns an error if the value isn't a plausible a1c
+func (element LabA1C) Validate() error {
+	if element.Value < 3 || element.Value > 20 {
+		return errors.New(fmt.Sprintf("Invalid lab a1c value [%f], must be a percentage between 3 and 20", element.Value))
+	}
+
+	return nil
+}
+
+type LabA1CSlice []LabA1C
+
+func (slice LabA1CSlice) Len() int {
+	return len(slice)
+}
+
+func (slice LabA1CSlice) Less(i, j int) bool {
+	return slice[i].Time.Timestamp < slice[j].Time.Timestamp
+}
+
+func (slice LabA1CSlice) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
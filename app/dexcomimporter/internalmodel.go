@@ -5,6 +5,7 @@ import (
 	"github.com/alexandre-normand/glukit/app/util"
 	"regexp"
 	"strconv"
+	"time"
 )
 
 type Glucose struct {
@@ -26,6 +27,14 @@ type Event struct {
 	EventTime    string `xml:"EventTime,attr"`
 	EventType    string `xml:"EventType,attr"`
 	Description  string `xml:"Decription,attr"`
+	// Attributes Dexcom Studio doesn't have. They're only written by our export so that it imports back without
+	// losing anything.
+	InsulinName  string  `xml:"InsulinName,attr,omitempty"`
+	InsulinType  string  `xml:"InsulinType,attr,omitempty"`
+	Proteins     float32 `xml:"Proteins,attr,omitempty"`
+	Fat          float32 `xml:"Fat,attr,omitempty"`
+	SaturatedFat float32 `xml:"SaturatedFat,attr,omitempty"`
+	Note         string  `xml:"Note,attr,omitempty"`
 }
 
 // DataTime represents hold a timestamp and a localtime string
@@ -38,12 +47,14 @@ type EventTimestamp struct {
 var mmolValueRegExp = regexp.MustCompile("\\d\\.\\d\\d")
 var mgValueRegExp = regexp.MustCompile("\\d+")
 
-func ConvertXmlGlucoseRead(read Glucose) (*apimodel.GlucoseRead, error) {
+// ConvertXmlGlucoseRead converts a dexcom Glucose element to a GlucoseRead. The timezone is resolved against
+// the given zone (the user's configured zone, which can be nil if unknown).
+func ConvertXmlGlucoseRead(read Glucose, zone *time.Location) (*apimodel.GlucoseRead, error) {
 	// Convert display/internal to timestamp with timezone extracted
 	if timeUTC, err := util.GetTimeUTC(read.InternalTime); err != nil {
 		return nil, err
 	} else {
-		timeLocation := util.GetLocaltimeOffset(read.DisplayTime, timeUTC)
+		timeLocation := util.ResolveLocation(read.DisplayTime, timeUTC, zone)
 
 		unit := getUnitFromValue(read.Value)
 
@@ -72,12 +83,14 @@ func getUnitFromValue(value string) (unit apimodel.GlucoseUnit) {
 	return unit
 }
 
-func ConvertXmlCalibrationRead(calibration Calibration) (*apimodel.CalibrationRead, error) {
+// ConvertXmlCalibrationRead converts a dexcom Meter element to a CalibrationRead. The timezone is resolved against
+// the given zone (the user's configured zone, which can be nil if unknown).
+func ConvertXmlCalibrationRead(calibration Calibration, zone *time.Location) (*apimodel.CalibrationRead, error) {
 	// Convert display/internal to timestamp with timezone extracted
 	if timeUTC, err := util.GetTimeUTC(calibration.InternalTime); err != nil {
 		return nil, err
 	} else {
-		timeLocation := util.GetLocaltimeOffset(calibration.DisplayTime, timeUTC)
+		timeLocation := util.ResolveLocation(calibration.DisplayTime, timeUTC, zone)
 
 		unit := getUnitFromValue(calibration.Value)
 		if value, err := strconv.ParseFloat(calibration.Value, 32); err != nil {
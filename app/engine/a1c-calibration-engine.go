@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"math"
+	"time"
+)
+
+const (
+	// How long before a lab a1c the estimate it's compared with can end. Estimates end with the last read of
+	// their period so this leaves room for a day without data.
+	A1C_COMPARISON_TOLERANCE = time.Duration(2*24) * time.Hour
+
+	// Number of most recent comparisons the glycation index is fitted from. Glycation changes over time so older
+	// lab results shouldn't weigh in forever.
+	A1C_CALIBRATION_MAX_COMPARISONS = 4
+
+	// Largest correction we apply to estimates, in a1c percentage points
+	MAX_GLYCATION_INDEX = 2.0
+)
+
+// NewA1CComparison compares a lab a1c with the estimate of the period ending with it. It returns nil if the
+// estimate doesn't cover the same period.
+func NewA1CComparison(labA1C apimodel.LabA1C, estimate model.A1CEstimate) *model.A1CComparison {
+	labTime := labA1C.GetTime()
+	if estimate.UpperBound.After(labTime) || labTime.Sub(estimate.UpperBound) > A1C_COMPARISON_TOLERANCE {
+		return nil
+	}
+
+	rawEstimate := estimate.Value - estimate.Correction
+	return &model.A1CComparison{
+		LabA1C:             labA1C,
+		Estimate:           estimate.Value,
+		RawEstimate:        rawEstimate,
+		Error:              estimate.Value - labA1C.Value,
+		RawError:           rawEstimate - labA1C.Value,
+		Estimator:          GetA1CEstimator(estimate.Estimator).Name(),
+		EstimateUpperBound: estimate.UpperBound,
+	}
+}
+
+// FitA1CCalibration fits the glycation index of an estimator from comparisons, sorted oldest first. Only the most
+// recent comparisons made with that estimator are used.
+func FitA1CCalibration(comparisons []model.A1CComparison, estimator string) (calibration model.A1CCalibration) {
+	calibration = model.A1CCalibration{Estimator: estimator, UpdatedOn: time.Now()}
+
+	differences := make([]float64, 0)
+	for i := len(comparisons) - 1; i >= 0 && len(differences) < A1C_CALIBRATION_MAX_COMPARISONS; i-- {
+		if comparisons[i].Estimator == estimator {
+			differences = append(differences, -1*comparisons[i].RawError)
+		}
+	}
+
+	if len(differences) == 0 {
+		return calibration
+	}
+
+	glycationIndex, _ := meanAndStandardDeviation(differences)
+	calibration.GlycationIndex = math.Max(-1*MAX_GLYCATION_INDEX, math.Min(MAX_GLYCATION_INDEX, glycationIndex))
+	calibration.ComparisonCount = len(differences)
+	return calibration
+}
+
+// ApplyA1CCalibration corrects an estimate with the user's glycation index if it was fitted for the estimator that
+// produced it
+func ApplyA1CCalibration(estimate *model.A1CEstimate, calibration model.A1CCalibration) {
+	if calibration.ComparisonCount == 0 || calibration.Estimator != estimate.Estimator {
+		return
+	}
+
+	estimate.Value = estimate.Value + calibration.GlycationIndex
+	estimate.Correction = calibration.GlycationIndex
+}
+
+// CompareLabA1Cs compares every lab a1c of a user with its stored estimate for the same period. Lab a1cs without
+// an estimate are skipped.
+func CompareLabA1Cs(context context.Context, email string) (comparisons []model.A1CComparison, err error) {
+	labA1Cs, err := store.GetLabA1Cs(context, email)
+	if err != nil {
+		return nil, err
+	}
+
+	comparisons = make([]model.A1CComparison, 0)
+	limit := 1
+	for _, labA1C := range labA1Cs {
+		labTime := labA1C.GetTime()
+		estimates, err := store.GetA1CEstimates(context, email, store.ScoreScanQuery{Limit: &limit, To: &labTime})
+		if err != nil {
+			return nil, err
+		}
+
+		if len(estimates) == 0 {
+			log.Debugf(context, "No a1c estimate to compare with lab a1c [%v] of user [%s]", labA1C, email)
+			continue
+		}
+
+		if comparison := NewA1CComparison(labA1C, estimates[0]); comparison != nil {
+			comparisons = append(comparisons, *comparison)
+		}
+	}
+
+	return comparisons, nil
+}
+
+// RecalibrateA1C fits the glycation index of a user's current estimator again from all of its lab a1cs. The new
+// calibration applies to estimates calculated from now on.
+func RecalibrateA1C(context context.Context, email string) (calibration *model.A1CCalibration, err error) {
+	settings, err := store.GetUserSettings(context, email)
+	if err != nil {
+		return nil, err
+	}
+
+	comparisons, err := CompareLabA1Cs(context, email)
+	if err != nil {
+		return nil, err
+	}
+
+	fitted := FitA1CCalibration(comparisons, GetA1CEstimator(settings.A1CEstimator).Name())
+	if err = store.StoreA1CCalibration(context, email, fitted); err != nil {
+		return nil, err
+	}
+
+	log.Infof(context, "Fitted glycation index of [%f] from [%d] lab a1cs for user [%s]", fitted.GlycationIndex, fitted.ComparisonCount, email)
+	return &fitted, nil
+}
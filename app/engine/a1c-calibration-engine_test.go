@@ -0,0 +1,61 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"math"
+	"testing"
+	"time"
+)
+
+func newLabA1C(labTime time.Time, value float64) apimodel.LabA1C {
+	return apimodel.LabA1C{apimodel.Time{apimodel.GetTimeMillis(labTime), "America/Los_Angeles"}, value}
+}
+
+func TestA1CComparisonRequiresSamePeriod(t *testing.T) {
+	labTime, _ := time.Parse("02/01/2006 15:04", "18/04/2014 08:00")
+	estimate := model.A1CEstimate{Value: 6.8, Correction: 0.3, UpperBound: labTime.Add(-12 * time.Hour), Estimator: model.A1C_ESTIMATOR_MEDIAN}
+
+	comparison := engine.NewA1CComparison(newLabA1C(labTime, 7.0), estimate)
+	if comparison == nil {
+		t.Fatalf("Expected a comparison for an estimate ending the day before the lab a1c")
+	}
+
+	if math.Abs(comparison.RawEstimate-6.5) > 0.0001 || math.Abs(comparison.RawError+0.5) > 0.0001 || math.Abs(comparison.Error+0.2) > 0.0001 {
+		t.Errorf("Expected a raw estimate of [6.5] with errors of [-0.5] raw and [-0.2] corrected but got [%v]", comparison)
+	}
+
+	estimate.UpperBound = labTime.AddDate(0, 0, -5)
+	if comparison := engine.NewA1CComparison(newLabA1C(labTime, 7.0), estimate); comparison != nil {
+		t.Errorf("Expected no comparison for an estimate ending 5 days before the lab a1c but got [%v]", comparison)
+	}
+}
+
+func TestFitA1CCalibrationUsesRecentComparisonsOfEstimator(t *testing.T) {
+	comparisons := []model.A1CComparison{
+		model.A1CComparison{RawError: -3, Estimator: model.A1C_ESTIMATOR_MEDIAN},
+		model.A1CComparison{RawError: -0.2, Estimator: model.A1C_ESTIMATOR_MEDIAN},
+		model.A1CComparison{RawError: -0.4, Estimator: model.A1C_ESTIMATOR_MEDIAN},
+		model.A1CComparison{RawError: 1, Estimator: model.A1C_ESTIMATOR_GMI},
+		model.A1CComparison{RawError: -0.6, Estimator: model.A1C_ESTIMATOR_MEDIAN},
+		model.A1CComparison{RawError: -0.4, Estimator: model.A1C_ESTIMATOR_MEDIAN},
+	}
+
+	calibration := engine.FitA1CCalibration(comparisons, model.A1C_ESTIMATOR_MEDIAN)
+	if calibration.ComparisonCount != engine.A1C_CALIBRATION_MAX_COMPARISONS || math.Abs(calibration.GlycationIndex-0.4) > 0.0001 {
+		t.Errorf("Expected a glycation index of [0.4] from [%d] comparisons but got [%v]", engine.A1C_CALIBRATION_MAX_COMPARISONS, calibration)
+	}
+
+	estimate := model.A1CEstimate{Value: 6.5, Estimator: model.A1C_ESTIMATOR_MEDIAN}
+	engine.ApplyA1CCalibration(&estimate, calibration)
+	if math.Abs(estimate.Value-6.9) > 0.0001 || estimate.Correction != calibration.GlycationIndex {
+		t.Errorf("Expected a corrected estimate of [6.9] but got [%v]", estimate)
+	}
+
+	estimate = model.A1CEstimate{Value: 6.5, Estimator: model.A1C_ESTIMATOR_GMI}
+	engine.ApplyA1CCalibration(&estimate, calibration)
+	if estimate.Value != 6.5 || estimate.Correction != 0 {
+		t.Errorf("Expected an estimate from another estimator to be left alone but got [%v]", estimate)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/alexandre-normand/glukit/lib/github.com/grd/stat"
 	"golang.org/x/net/context"
 	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
 	"sort"
 	"time"
 )
@@ -20,12 +21,105 @@ const (
 	// A1C estimation scoring period requirement
 	A1C_ESTIMATION_SCORE_PERIOD = 95
 
-	A1C_SCORING_VERSION = 3
+	// The current a1c scoring version. Version 4 fills gaps according to the user's interpolation policy.
+	A1C_SCORING_VERSION = 4
 )
 
-// CalculateA1CEstimate calculates an estimate of a a1c given the last 3 months of data. The current algo is naively assuming that the average of the last
-// 3 months will be an approximation of the a1c.
-func CalculateA1CEstimate(context context.Context, reads []apimodel.GlucoseRead) (a1c *model.A1CEstimate, err error) {
+// A1CEstimator estimates an a1c from a period of reads
+type A1CEstimator interface {
+	// Name returns the name the estimator is stored and selected by
+	Name() string
+
+	// Estimate returns the a1c estimate of reads, sorted by time
+	Estimate(reads []apimodel.GlucoseRead) (a1c float64, err error)
+}
+
+// medianA1CEstimator is our original estimator, the ADAG formula applied to the median instead of the mean. It's
+// less sensitive to the extreme values of sensor errors.
+type medianA1CEstimator struct{}
+
+func (estimator medianA1CEstimator) Name() string {
+	return model.A1C_ESTIMATOR_MEDIAN
+}
+
+func (estimator medianA1CEstimator) Estimate(reads []apimodel.GlucoseRead) (a1c float64, err error) {
+	sortedReads := make(model.ReadStatsSlice, len(reads))
+	copy(sortedReads, reads)
+	sort.Sort(sortedReads)
+	median := stat.MedianFromSortedData(sortedReads)
+	return (median + 77.3) / 35.6, nil
+}
+
+// adagA1CEstimator is the estimated average glucose formula of the A1C-Derived Average Glucose study (Nathan et al.)
+// solved for the a1c
+type adagA1CEstimator struct{}
+
+func (estimator adagA1CEstimator) Name() string {
+	return model.A1C_ESTIMATOR_ADAG
+}
+
+func (estimator adagA1CEstimator) Estimate(reads []apimodel.GlucoseRead) (a1c float64, err error) {
+	mean, err := getMeanValue(reads)
+	if err != nil {
+		return 0, err
+	}
+
+	return (mean + 46.7) / 28.7, nil
+}
+
+// gmiA1CEstimator is the glucose management indicator (Bergenstal et al.)
+type gmiA1CEstimator struct{}
+
+func (estimator gmiA1CEstimator) Name() string {
+	return model.A1C_ESTIMATOR_GMI
+}
+
+func (estimator gmiA1CEstimator) Estimate(reads []apimodel.GlucoseRead) (a1c float64, err error) {
+	mean, err := getMeanValue(reads)
+	if err != nil {
+		return 0, err
+	}
+
+	return GetGlucoseManagementIndicator(mean), nil
+}
+
+// getMeanValue returns the mean value of reads in mg/dL
+func getMeanValue(reads []apimodel.GlucoseRead) (mean float64, err error) {
+	values := make([]float64, len(reads))
+	for i, read := range reads {
+		value, err := read.GetNormalizedValue(apimodel.MG_PER_DL)
+		if err != nil {
+			return 0, err
+		}
+		values[i] = float64(value)
+	}
+
+	mean, _ = meanAndStandardDeviation(values)
+	return mean, nil
+}
+
+// The estimator used when the user hasn't chosen one
+var DEFAULT_A1C_ESTIMATOR A1CEstimator = medianA1CEstimator{}
+
+// A1C_ESTIMATORS are all available estimators by name
+var A1C_ESTIMATORS = map[string]A1CEstimator{
+	model.A1C_ESTIMATOR_MEDIAN: medianA1CEstimator{},
+	model.A1C_ESTIMATOR_ADAG:   adagA1CEstimator{},
+	model.A1C_ESTIMATOR_GMI:    gmiA1CEstimator{},
+}
+
+// GetA1CEstimator returns the estimator with the given name or the default estimator if there's no such estimator
+func GetA1CEstimator(name string) A1CEstimator {
+	if estimator, ok := A1C_ESTIMATORS[name]; ok {
+		return estimator
+	}
+
+	return DEFAULT_A1C_ESTIMATOR
+}
+
+// CalculateA1CEstimate calculates an estimate of a a1c given the last 3 months of data with the given estimator. The
+// gaps between reads are filled according to the interpolation policy first.
+func CalculateA1CEstimate(context context.Context, reads []apimodel.GlucoseRead, estimator A1CEstimator, policy model.InterpolationPolicy) (a1c *model.A1CEstimate, err error) {
 	if len(reads) == 0 {
 		return nil, errors.New(fmt.Sprintf("Insufficient read coverage to estimate a1c, got no reads"))
 	}
@@ -41,22 +135,30 @@ func CalculateA1CEstimate(context context.Context, reads []apimodel.GlucoseRead)
 	if days < A1C_READ_COVERAGE_REQUIREMENT_IN_DAYS {
 		return nil, errors.New(fmt.Sprintf("Insufficient read coverage to estimate a1c, got [%d] days but requires [%d]", days, A1C_READ_COVERAGE_REQUIREMENT_IN_DAYS))
 	} else {
-		sortedReads := model.ReadStatsSlice(reads)
-		sort.Sort(sortedReads)
-		median := stat.MedianFromSortedData(sortedReads)
-		//a1c := (average + 77.3) / 35.6
-		a1c := (median + 77.3) / 35.6
-		log.Debugf(context, "Estimated a1c is [%f]", a1c)
+		interpolatedReads, err := InterpolateReads(reads, policy)
+		if err != nil {
+			return nil, err
+		}
+
+		a1c, err := estimator.Estimate(interpolatedReads)
+		if err != nil {
+			return nil, err
+		}
+		log.Debugf(context, "Estimated a1c with estimator [%s] is [%f]", estimator.Name(), a1c)
 		return &model.A1CEstimate{
 			Value:          a1c,
 			LowerBound:     lowerBound,
 			UpperBound:     upperBound,
 			CalculatedOn:   time.Now(),
-			ScoringVersion: A1C_SCORING_VERSION}, nil
+			ScoringVersion: A1C_SCORING_VERSION,
+			Estimator:      estimator.Name(),
+			ReadCount:      len(reads),
+			Coverage:       model.GetCoveragePercentage(len(reads), lowerBound, upperBound),
+			Interpolation:  policy}, nil
 	}
 }
 
-func EstimateA1C(context context.Context, glukitUser *model.GlukitUser, endOfPeriod time.Time) (a1c *model.A1CEstimate, err error) {
+func EstimateA1C(context context.Context, glukitUser *model.GlukitUser, endOfPeriod time.Time, estimator A1CEstimator, policy model.InterpolationPolicy) (a1c *model.A1CEstimate, err error) {
 	// Get the last period's worth of reads
 	upperBound := util.GetMidnightUTCBefore(endOfPeriod)
 	lowerBound := upperBound.AddDate(0, 0, -1*A1C_ESTIMATION_SCORE_PERIOD)
@@ -65,6 +167,20 @@ func EstimateA1C(context context.Context, glukitUser *model.GlukitUser, endOfPer
 	if reads, err := store.GetGlucoseReads(context, glukitUser.Email, lowerBound, upperBound); err != nil {
 		return &model.UNDEFINED_A1C_ESTIMATE, err
 	} else {
-		return CalculateA1CEstimate(context, reads)
+		return CalculateA1CEstimate(context, reads, estimator, policy)
 	}
 }
+
+// RecalculateA1C calculates all a1c estimates of a user again. This is needed when the user changes its estimator.
+func RecalculateA1C(context context.Context, email string) (err error) {
+	task, err := RunA1CCalculationChunk.Task(email, A1C_CALCULATION_START)
+	if err != nil {
+		log.Criticalf(context, "Couldn't schedule the next execution of [%s] for user [%s]. "+
+			"This breaks batch calculation of a1c estimates for that user!: %v", A1C_BATCH_CALCULATION_FUNCTION_NAME, email, err)
+		return err
+	}
+	taskqueue.Add(context, task, BATCH_CALCULATION_QUEUE_NAME)
+	log.Infof(context, "Queued up recalculation of a1c estimates for user [%s]", email)
+
+	return nil
+}
@@ -45,7 +45,7 @@ func TestCalculationWithInsufficientCoverage(t *testing.T) {
 		r[i] = apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Los_Angeles"}, apimodel.MG_PER_DL, float32(80)}
 	}
 
-	a1cEstimate, err := engine.CalculateA1CEstimate(c, r)
+	a1cEstimate, err := engine.CalculateA1CEstimate(c, r, engine.DEFAULT_A1C_ESTIMATOR, model.NO_INTERPOLATION)
 	if err == nil {
 		t.Errorf("TestCalculationWithInsufficientCoverage failed: should return error when coverage is insufficient but calculated a1c of [%v]", a1cEstimate)
 	}
@@ -58,7 +58,7 @@ func testA1CEstimateFromFixedAverage(t *testing.T, average float32, expectedA1C
 	}
 	defer c.Close()
 
-	a1cEstimate, err := engine.CalculateA1CEstimate(c, generateReadsWithFixedAverage(average, time.Now()))
+	a1cEstimate, err := engine.CalculateA1CEstimate(c, generateReadsWithFixedAverage(average, time.Now()), engine.DEFAULT_A1C_ESTIMATOR, model.NO_INTERPOLATION)
 	if err != nil {
 		t.Fatal(err)
 	} else if roundedValue := roundToOneDecimal(a1cEstimate.Value); roundedValue != expectedA1C {
@@ -121,7 +121,7 @@ func TestFetchAndEstimateFlow(t *testing.T) {
 	c, glukitUser, _ := setupTestData(t, 79, upperDate)
 	defer c.Close()
 
-	a1cEstimate, err := engine.EstimateA1C(c, glukitUser, upperDate)
+	a1cEstimate, err := engine.EstimateA1C(c, glukitUser, upperDate, engine.DEFAULT_A1C_ESTIMATOR, model.NO_INTERPOLATION)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -136,3 +136,19 @@ func TestFetchAndEstimateFlow(t *testing.T) {
 func roundToOneDecimal(value float64) float64 {
 	return float64(int((value+0.05)*10)) / 10
 }
+
+func TestA1CEstimators(t *testing.T) {
+	reads := generateReadsWithFixedAverage(154, time.Now())
+
+	if a1c, err := engine.GetA1CEstimator(model.A1C_ESTIMATOR_ADAG).Estimate(reads); err != nil || roundToOneDecimal(a1c) != 7.0 {
+		t.Errorf("Expected an adag estimate of [7.0] but got [%f]: %v", a1c, err)
+	}
+
+	if a1c, err := engine.GetA1CEstimator(model.A1C_ESTIMATOR_GMI).Estimate(reads); err != nil || roundToOneDecimal(a1c) != 7.0 {
+		t.Errorf("Expected a gmi estimate of [7.0] but got [%f]: %v", a1c, err)
+	}
+
+	if estimator := engine.GetA1CEstimator("unknown"); estimator.Name() != model.A1C_ESTIMATOR_MEDIAN {
+		t.Errorf("Expected unknown estimators to default to [%s] but got [%s]", model.A1C_ESTIMATOR_MEDIAN, estimator.Name())
+	}
+}
@@ -0,0 +1,305 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// A gap in reads at least this long is taken as a sensor change since a new sensor takes 2 hours to warm up
+	SENSOR_SESSION_GAP = time.Duration(2) * time.Hour
+
+	// Minimum number of comparisons over a sensor session to tell how its bias changed
+	ACCURACY_MINIMUM_TREND_COMPARISONS = 3
+
+	// A sensor whose bias changes by at least this many mg/dL per day is drifting
+	ACCURACY_DRIFTING_BIAS_PER_DAY = 5.
+
+	// ISO 15197 limits, an absolute difference under the threshold and a relative one above it
+	ISO_15197_THRESHOLD      = 100.
+	ISO_15197_ABSOLUTE_LIMIT = 15.
+	ISO_15197_RELATIVE_LIMIT = 15.
+
+	ACCURACY_WEEK_DAYS = 7
+)
+
+// errorGridPoint is a point of an error grid with the meter value as the reference and the sensor value as the
+// measurement, both in mg/dL
+type errorGridPoint struct {
+	reference float64
+	measured  float64
+}
+
+// errorGridZone is the area of an error grid between an upper and a lower boundary. A zone without a lower
+// boundary goes all the way down.
+type errorGridZone struct {
+	name  string
+	upper []errorGridPoint
+	lower []errorGridPoint
+}
+
+// The zones of the Parkes (consensus) error grid for type 1 diabetes, with the coordinates of Pfützner et al. Points
+// outside of all of them are in zone E.
+var PARKES_TYPE_1_ZONES = []errorGridZone{
+	errorGridZone{model.ERROR_GRID_ZONE_A,
+		[]errorGridPoint{{0, 50}, {30, 50}, {140, 170}, {280, 380}, {430, 550}},
+		[]errorGridPoint{{50, 0}, {50, 30}, {170, 145}, {385, 300}, {550, 450}}},
+	errorGridZone{model.ERROR_GRID_ZONE_B,
+		[]errorGridPoint{{0, 60}, {30, 60}, {50, 80}, {70, 110}, {260, 550}},
+		[]errorGridPoint{{120, 0}, {120, 30}, {260, 130}, {550, 250}}},
+	errorGridZone{model.ERROR_GRID_ZONE_C,
+		[]errorGridPoint{{0, 100}, {25, 100}, {50, 125}, {80, 215}, {125, 550}},
+		[]errorGridPoint{{250, 0}, {250, 40}, {550, 150}}},
+	errorGridZone{model.ERROR_GRID_ZONE_D,
+		[]errorGridPoint{{0, 150}, {35, 155}, {50, 550}},
+		nil},
+}
+
+// CompareWithMeter pairs every calibration with the CGM value linearly interpolated at the time of the calibration.
+// Calibrations that don't fall between two reads less than COVERAGE_GAP_THRESHOLD apart are skipped. Comparisons are
+// sorted by time.
+func CompareWithMeter(calibrations []apimodel.CalibrationRead, reads []apimodel.GlucoseRead) (comparisons []model.MeterComparison, err error) {
+	values, err := getSortedTimedValues(reads)
+	if err != nil {
+		return nil, err
+	}
+
+	sortedCalibrations := make([]apimodel.CalibrationRead, len(calibrations))
+	copy(sortedCalibrations, calibrations)
+	sort.Sort(apimodel.CalibrationReadSlice(sortedCalibrations))
+
+	comparisons = make([]model.MeterComparison, 0)
+	for _, calibration := range sortedCalibrations {
+		value, err := calibration.GetNormalizedValue(apimodel.MG_PER_DL)
+		if err != nil {
+			return nil, err
+		}
+
+		meterValue := float64(value)
+		sensorValue, ok := getInterpolatedValueAt(values, calibration.GetTime())
+		if !ok || meterValue <= 0 {
+			continue
+		}
+
+		comparisons = append(comparisons, model.MeterComparison{
+			Time:                       calibration.GetTime(),
+			MeterValue:                 meterValue,
+			SensorValue:                sensorValue,
+			Difference:                 sensorValue - meterValue,
+			AbsoluteRelativeDifference: math.Abs(sensorValue-meterValue) / meterValue * 100,
+			ClarkeZone:                 GetClarkeZone(meterValue, sensorValue),
+			ParkesZone:                 GetParkesZone(meterValue, sensorValue)})
+	}
+
+	return comparisons, nil
+}
+
+// getInterpolatedValueAt returns the value at t on the straight line between the reads around it. It returns false
+// if there's no read on one side of t or if the reads around it are too far apart.
+func getInterpolatedValueAt(values []timedValue, t time.Time) (value float64, ok bool) {
+	i := sort.Search(len(values), func(i int) bool {
+		return !values[i].t.Before(t)
+	})
+
+	if i < len(values) && values[i].t.Equal(t) {
+		return values[i].value, true
+	}
+
+	if i == 0 || i == len(values) || values[i].t.Sub(values[i-1].t) > model.COVERAGE_GAP_THRESHOLD {
+		return 0, false
+	}
+
+	return interpolateLinear(values, i-1, t), true
+}
+
+// GetClarkeZone returns the zone of the Clarke error grid (Clarke et al.) of a sensor value compared with its
+// reference meter value, both in mg/dL
+func GetClarkeZone(reference, measured float64) string {
+	switch {
+	case (reference <= 70 && measured <= 70) || (measured <= 1.2*reference && measured >= 0.8*reference):
+		return model.ERROR_GRID_ZONE_A
+	case (reference >= 180 && measured <= 70) || (reference <= 70 && measured >= 180):
+		return model.ERROR_GRID_ZONE_E
+	case (reference >= 70 && reference <= 290 && measured >= reference+110) || (reference >= 130 && reference <= 180 && measured <= 7./5*reference-182):
+		return model.ERROR_GRID_ZONE_C
+	case (reference >= 240 && measured >= 70 && measured <= 180) || (reference <= 175./3 && measured >= 70 && measured <= 180) ||
+		(reference >= 175./3 && reference <= 70 && measured >= 6./5*reference):
+		return model.ERROR_GRID_ZONE_D
+	}
+
+	return model.ERROR_GRID_ZONE_B
+}
+
+// GetParkesZone returns the zone of the Parkes error grid for type 1 diabetes of a sensor value compared with its
+// reference meter value, both in mg/dL. Points on a boundary are in the inner zone.
+func GetParkesZone(reference, measured float64) string {
+	for _, zone := range PARKES_TYPE_1_ZONES {
+		if upper, ok := getBoundaryValue(zone.upper, reference); ok && measured > upper {
+			continue
+		}
+		if lower, ok := getBoundaryValue(zone.lower, reference); ok && measured < lower {
+			continue
+		}
+
+		return zone.name
+	}
+
+	return model.ERROR_GRID_ZONE_E
+}
+
+// getBoundaryValue returns the measured value of a boundary at a reference value. Boundaries are extended past their
+// last point along their last segment. It returns false if the boundary starts after the reference value.
+func getBoundaryValue(boundary []errorGridPoint, reference float64) (measured float64, ok bool) {
+	if len(boundary) == 0 || reference < boundary[0].reference {
+		return 0, false
+	}
+
+	for i := 1; i < len(boundary); i++ {
+		from, to := boundary[i-1], boundary[i]
+		if to.reference > from.reference && (reference <= to.reference || i == len(boundary)-1) {
+			return from.measured + (to.measured-from.measured)*(reference-from.reference)/(to.reference-from.reference), true
+		}
+	}
+
+	return boundary[len(boundary)-1].measured, true
+}
+
+// CalculateSensorAccuracy calculates the accuracy of the comparisons of a period
+func CalculateSensorAccuracy(comparisons []model.MeterComparison, lowerBound, upperBound time.Time) (accuracy model.SensorAccuracy) {
+	accuracy = model.SensorAccuracy{LowerBound: lowerBound, UpperBound: upperBound, ComparisonCount: len(comparisons)}
+	if len(comparisons) == 0 {
+		return accuracy
+	}
+
+	withinISO := 0
+	for _, comparison := range comparisons {
+		accuracy.MARD = accuracy.MARD + comparison.AbsoluteRelativeDifference
+		accuracy.Bias = accuracy.Bias + comparison.Difference
+		if (comparison.MeterValue < ISO_15197_THRESHOLD && math.Abs(comparison.Difference) <= ISO_15197_ABSOLUTE_LIMIT) ||
+			(comparison.MeterValue >= ISO_15197_THRESHOLD && comparison.AbsoluteRelativeDifference <= ISO_15197_RELATIVE_LIMIT) {
+			withinISO = withinISO + 1
+		}
+		addToErrorGridZone(&accuracy.Clarke, comparison.ClarkeZone)
+		addToErrorGridZone(&accuracy.Parkes, comparison.ParkesZone)
+	}
+
+	count := float64(len(comparisons))
+	accuracy.MARD = accuracy.MARD / count
+	accuracy.Bias = accuracy.Bias / count
+	accuracy.ISO15197 = float64(withinISO) / count * 100
+	for _, zones := range []*model.ErrorGridZones{&accuracy.Clarke, &accuracy.Parkes} {
+		zones.A, zones.B, zones.C, zones.D, zones.E = zones.A/count*100, zones.B/count*100, zones.C/count*100, zones.D/count*100, zones.E/count*100
+	}
+
+	return accuracy
+}
+
+func addToErrorGridZone(zones *model.ErrorGridZones, zone string) {
+	switch zone {
+	case model.ERROR_GRID_ZONE_A:
+		zones.A = zones.A + 1
+	case model.ERROR_GRID_ZONE_B:
+		zones.B = zones.B + 1
+	case model.ERROR_GRID_ZONE_C:
+		zones.C = zones.C + 1
+	case model.ERROR_GRID_ZONE_D:
+		zones.D = zones.D + 1
+	default:
+		zones.E = zones.E + 1
+	}
+}
+
+// CalculateAccuracyReport compares the calibrations of a range with the CGM and calculates the accuracy over the
+// range, by sensor session and by week. Sensor sessions are the stretches of reads between gaps of at least
+// SENSOR_SESSION_GAP. Sessions and weeks without calibrations are left out.
+func CalculateAccuracyReport(calibrations []apimodel.CalibrationRead, reads []apimodel.GlucoseRead, lowerBound, upperBound time.Time) (report *model.AccuracyReport, err error) {
+	allComparisons, err := CompareWithMeter(calibrations, reads)
+	if err != nil {
+		return nil, err
+	}
+
+	comparisons := filterComparisons(allComparisons, lowerBound, upperBound)
+	report = &model.AccuracyReport{LowerBound: lowerBound, UpperBound: upperBound, Comparisons: comparisons,
+		Overall: CalculateSensorAccuracy(comparisons, lowerBound, upperBound), Sessions: make([]model.SensorSessionAccuracy, 0),
+		Weekly: make([]model.SensorAccuracy, 0)}
+
+	values, err := getSortedTimedValues(reads)
+	if err != nil {
+		return nil, err
+	}
+
+	for start := 0; start < len(values); {
+		end := start
+		for end+1 < len(values) && values[end+1].t.Sub(values[end].t) < SENSOR_SESSION_GAP {
+			end = end + 1
+		}
+
+		if sessionComparisons := filterComparisons(comparisons, values[start].t, values[end].t); len(sessionComparisons) > 0 {
+			session := model.SensorSessionAccuracy{SensorAccuracy: CalculateSensorAccuracy(sessionComparisons, values[start].t, values[end].t),
+				BiasTrend: calculateBiasTrend(sessionComparisons)}
+			session.Drifting = session.BiasTrend != nil && math.Abs(*session.BiasTrend) >= ACCURACY_DRIFTING_BIAS_PER_DAY
+			report.Sessions = append(report.Sessions, session)
+		}
+
+		start = end + 1
+	}
+
+	for weekStart := lowerBound; weekStart.Before(upperBound); weekStart = weekStart.AddDate(0, 0, ACCURACY_WEEK_DAYS) {
+		weekEnd := weekStart.AddDate(0, 0, ACCURACY_WEEK_DAYS)
+		if weekEnd.After(upperBound) {
+			weekEnd = upperBound
+		}
+
+		if weekComparisons := filterComparisons(comparisons, weekStart, weekEnd); len(weekComparisons) > 0 {
+			report.Weekly = append(report.Weekly, CalculateSensorAccuracy(weekComparisons, weekStart, weekEnd))
+		}
+	}
+
+	return report, nil
+}
+
+// filterComparisons returns the comparisons between two bounds, both inclusive
+func filterComparisons(comparisons []model.MeterComparison, lowerBound, upperBound time.Time) (filtered []model.MeterComparison) {
+	filtered = make([]model.MeterComparison, 0)
+	for _, comparison := range comparisons {
+		if !comparison.Time.Before(lowerBound) && !comparison.Time.After(upperBound) {
+			filtered = append(filtered, comparison)
+		}
+	}
+
+	return filtered
+}
+
+// calculateBiasTrend returns the least squares slope of the difference between the sensor and the meter, in mg/dL
+// per day. It returns nil if there are less than ACCURACY_MINIMUM_TREND_COMPARISONS comparisons or if they're all
+// at the same time.
+func calculateBiasTrend(comparisons []model.MeterComparison) *float64 {
+	if len(comparisons) < ACCURACY_MINIMUM_TREND_COMPARISONS {
+		return nil
+	}
+
+	days := make([]float64, len(comparisons))
+	differences := make([]float64, len(comparisons))
+	for i, comparison := range comparisons {
+		days[i] = comparison.Time.Sub(comparisons[0].Time).Hours() / 24
+		differences[i] = comparison.Difference
+	}
+
+	meanDay, _ := meanAndStandardDeviation(days)
+	meanDifference, _ := meanAndStandardDeviation(differences)
+	covariance, variance := 0., 0.
+	for i := range days {
+		covariance = covariance + (days[i]-meanDay)*(differences[i]-meanDifference)
+		variance = variance + (days[i]-meanDay)*(days[i]-meanDay)
+	}
+
+	if variance == 0 {
+		return nil
+	}
+
+	trend := covariance / variance
+	return &trend
+}
@@ -0,0 +1,101 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"math"
+	"testing"
+	"time"
+)
+
+func newCalibration(t time.Time, value float32) apimodel.CalibrationRead {
+	return apimodel.CalibrationRead{apimodel.Time{apimodel.GetTimeMillis(t), "America/Los_Angeles"}, apimodel.MG_PER_DL, value}
+}
+
+func newFlatReads(start time.Time, duration time.Duration, value float32) []apimodel.GlucoseRead {
+	values := make([]float32, int(duration/(time.Duration(5)*time.Minute)))
+	for i := range values {
+		values[i] = value
+	}
+
+	return newReadsEveryFiveMinutes(start, values)
+}
+
+func TestErrorGridZones(t *testing.T) {
+	for _, test := range []struct {
+		reference, measured float64
+		clarke, parkes      string
+	}{
+		{100, 100, model.ERROR_GRID_ZONE_A, model.ERROR_GRID_ZONE_A},
+		{100, 150, model.ERROR_GRID_ZONE_B, model.ERROR_GRID_ZONE_B},
+		{100, 220, model.ERROR_GRID_ZONE_C, model.ERROR_GRID_ZONE_C},
+		{400, 100, model.ERROR_GRID_ZONE_D, model.ERROR_GRID_ZONE_C},
+		{50, 100, model.ERROR_GRID_ZONE_D, model.ERROR_GRID_ZONE_C},
+		{20, 200, model.ERROR_GRID_ZONE_E, model.ERROR_GRID_ZONE_E},
+	} {
+		if zone := engine.GetClarkeZone(test.reference, test.measured); zone != test.clarke {
+			t.Errorf("TestErrorGridZones failed, expected clarke zone [%s] for [%v] but got [%s]", test.clarke, test, zone)
+		}
+		if zone := engine.GetParkesZone(test.reference, test.measured); zone != test.parkes {
+			t.Errorf("TestErrorGridZones failed, expected parkes zone [%s] for [%v] but got [%s]", test.parkes, test, zone)
+		}
+	}
+}
+
+func TestCompareWithMeterInterpolatesReads(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := newReadsWithGaps(ct, []float32{100, 110, 0, 0, 0, 0, 120})
+	calibrations := []apimodel.CalibrationRead{newCalibration(ct.Add(time.Duration(150)*time.Second), 100),
+		newCalibration(ct.Add(time.Duration(15)*time.Minute), 100)}
+
+	comparisons, err := engine.CompareWithMeter(calibrations, reads)
+	if err != nil {
+		t.Fatalf("Unexpected error comparing with meter: %v", err)
+	}
+
+	if len(comparisons) != 1 {
+		t.Fatalf("TestCompareWithMeterInterpolatesReads failed, expected the calibration in the gap to be skipped but got [%v]", comparisons)
+	}
+
+	if comparison := comparisons[0]; comparison.SensorValue != 105 || comparison.Difference != 5 || comparison.AbsoluteRelativeDifference != 5 {
+		t.Errorf("TestCompareWithMeterInterpolatesReads failed, got [%v]", comparison)
+	}
+}
+
+func TestAccuracyReportBySensorSession(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	secondSessionStart := ct.Add(time.Duration(27) * time.Hour)
+	reads := append(newFlatReads(ct, time.Duration(24)*time.Hour, 100), newFlatReads(secondSessionStart, time.Duration(72)*time.Hour, 100)...)
+
+	calibrations := []apimodel.CalibrationRead{newCalibration(ct.Add(time.Duration(12)*time.Hour), 100)}
+	for day, meterValue := range []float32{100, 90, 80} {
+		calibrations = append(calibrations, newCalibration(secondSessionStart.Add(time.Duration(day*24+12)*time.Hour), meterValue))
+	}
+
+	report, err := engine.CalculateAccuracyReport(calibrations, reads, ct, ct.AddDate(0, 0, 14))
+	if err != nil {
+		t.Fatalf("Unexpected error calculating accuracy report: %v", err)
+	}
+
+	if report.Overall.ComparisonCount != 4 || report.Overall.Bias != 7.5 || report.Overall.Clarke.A != 75 || report.Overall.Parkes.A != 100 || report.Overall.ISO15197 != 75 {
+		t.Errorf("TestAccuracyReportBySensorSession failed, got overall accuracy [%v]", report.Overall)
+	}
+
+	if len(report.Sessions) != 2 {
+		t.Fatalf("TestAccuracyReportBySensorSession failed, expected [2] sensor sessions but got [%v]", report.Sessions)
+	}
+
+	if first := report.Sessions[0]; first.ComparisonCount != 1 || first.MARD != 0 || first.BiasTrend != nil || first.Drifting {
+		t.Errorf("TestAccuracyReportBySensorSession failed, got first session [%v]", first)
+	}
+
+	second := report.Sessions[1]
+	if !second.LowerBound.Equal(secondSessionStart) || second.ComparisonCount != 3 || second.BiasTrend == nil || math.Abs(*second.BiasTrend-10) > 0.001 || !second.Drifting {
+		t.Errorf("TestAccuracyReportBySensorSession failed, got second session [%v] with bias trend [%v]", second, second.BiasTrend)
+	}
+
+	if len(report.Weekly) != 1 || report.Weekly[0].ComparisonCount != 4 {
+		t.Errorf("TestAccuracyReportBySensorSession failed, got weekly accuracy [%v]", report.Weekly)
+	}
+}
@@ -0,0 +1,136 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// Duration of a single AGP bin, in minutes
+	AGP_BIN_MINUTES = 15
+
+	// Number of AGP bins in a day
+	AGP_BINS_PER_DAY = 24 * 60 / AGP_BIN_MINUTES
+
+	// Number of reads a CGM takes in a day, at one read every 5 minutes
+	EXPECTED_READS_PER_DAY = 288
+
+	AGP_DATE_FORMAT = "2006-01-02"
+)
+
+// The percentiles plotted by an AGP
+var AGP_PERCENTILES = []float64{5, 25, 50, 75, 95}
+
+// CalculateAmbulatoryGlucoseProfile builds the ambulatory glucose profile of reads. Reads are binned by the local
+// time of day they were taken at so that days spent in different timezones line up. Like the standard report, it only
+// uses actual reads, gaps aren't interpolated. It returns nil if there are less than 2 reads.
+func CalculateAmbulatoryGlucoseProfile(reads []apimodel.GlucoseRead, thresholds model.TimeInRangeThresholds, lowerBound, upperBound time.Time) (agp *model.AmbulatoryGlucoseProfile, err error) {
+	variability, err := CalculateVariability(reads, DEFAULT_CONGA_HOURS, model.NO_INTERPOLATION)
+	if err != nil || variability == nil {
+		return nil, err
+	}
+
+	timeInRange, err := CalculateTimeInRange(reads, thresholds, lowerBound, upperBound)
+	if err != nil {
+		return nil, err
+	}
+
+	binValues := make([][]float64, AGP_BINS_PER_DAY)
+	dailyValues := make(map[string][][]float64)
+	for _, read := range reads {
+		value, err := read.GetNormalizedValue(apimodel.MG_PER_DL)
+		if err != nil {
+			return nil, err
+		}
+
+		localTime := read.GetTime()
+		bin := GetAgpBin(localTime)
+		binValues[bin] = append(binValues[bin], float64(value))
+
+		date := localTime.Format(AGP_DATE_FORMAT)
+		if _, ok := dailyValues[date]; !ok {
+			dailyValues[date] = make([][]float64, AGP_BINS_PER_DAY)
+		}
+		dailyValues[date][bin] = append(dailyValues[date][bin], float64(value))
+	}
+
+	agp = new(model.AmbulatoryGlucoseProfile)
+	agp.LowerBound = lowerBound
+	agp.UpperBound = upperBound
+	agp.DayCount = len(dailyValues)
+
+	agp.Bins = make([]model.AgpBin, AGP_BINS_PER_DAY)
+	for i, values := range binValues {
+		agp.Bins[i] = model.AgpBin{MinuteOfDay: i * AGP_BIN_MINUTES, ReadCount: len(values)}
+		if len(values) == 0 {
+			continue
+		}
+
+		sort.Float64s(values)
+		percentiles := make([]float64, len(AGP_PERCENTILES))
+		for j, percentile := range AGP_PERCENTILES {
+			percentiles[j] = GetPercentileFromSortedValues(values, percentile)
+		}
+		agp.Bins[i].P5, agp.Bins[i].P25, agp.Bins[i].P50, agp.Bins[i].P75, agp.Bins[i].P95 = &percentiles[0], &percentiles[1], &percentiles[2], &percentiles[3], &percentiles[4]
+	}
+
+	dates := make([]string, 0, len(dailyValues))
+	for date := range dailyValues {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+
+	agp.DailyProfiles = make([]model.DailyGlucoseProfile, len(dates))
+	for i, date := range dates {
+		profile := model.DailyGlucoseProfile{Date: date, Values: make([]*float64, AGP_BINS_PER_DAY)}
+		for bin, values := range dailyValues[date] {
+			if len(values) > 0 {
+				mean, _ := meanAndStandardDeviation(values)
+				profile.Values[bin] = &mean
+			}
+		}
+		agp.DailyProfiles[i] = profile
+	}
+
+	expectedReads := upperBound.Sub(lowerBound).Hours() / 24 * EXPECTED_READS_PER_DAY
+	agp.Summary = model.AgpSummary{
+		ReadCount:                  variability.ReadCount,
+		SensorActivePercentage:     math.Min(100, float64(variability.ReadCount)*100/expectedReads),
+		Mean:                       variability.Mean,
+		GlucoseManagementIndicator: GetGlucoseManagementIndicator(variability.Mean),
+		StandardDeviation:          variability.StandardDeviation,
+		CoefficientOfVariation:     variability.CoefficientOfVariation,
+		TimeInRange:                timeInRange,
+	}
+
+	return agp, nil
+}
+
+// GetAgpBin returns the index of the AGP bin of a local time
+func GetAgpBin(localTime time.Time) int {
+	return (localTime.Hour()*60 + localTime.Minute()) / AGP_BIN_MINUTES
+}
+
+// GetPercentileFromSortedValues returns a percentile (between 0 and 100) of sorted values, interpolating linearly
+// between the closest ranks
+func GetPercentileFromSortedValues(values []float64, percentile float64) float64 {
+	if len(values) == 1 {
+		return values[0]
+	}
+
+	rank := percentile / 100 * float64(len(values)-1)
+	lower := int(math.Floor(rank))
+	if lower >= len(values)-1 {
+		return values[len(values)-1]
+	}
+
+	return values[lower] + (rank-float64(lower))*(values[lower+1]-values[lower])
+}
+
+// GetGlucoseManagementIndicator returns the GMI (Bergenstal et al.), the A1C equivalent of a mean glucose in mg/dL
+func GetGlucoseManagementIndicator(mean float64) float64 {
+	return 3.31 + 0.02392*mean
+}
@@ -0,0 +1,53 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"testing"
+	"time"
+)
+
+func TestPercentileFromSortedValues(t *testing.T) {
+	values := []float64{100, 110, 120, 130, 140}
+	if percentile := engine.GetPercentileFromSortedValues(values, 50); percentile != 120 {
+		t.Errorf("Expected median of [120] but got [%f]", percentile)
+	}
+
+	if percentile := engine.GetPercentileFromSortedValues(values, 25); percentile != 110 {
+		t.Errorf("Expected 25th percentile of [110] but got [%f]", percentile)
+	}
+
+	if percentile := engine.GetPercentileFromSortedValues(values, 95); percentile != 138 {
+		t.Errorf("Expected 95th percentile of [138] but got [%f]", percentile)
+	}
+}
+
+func TestAgpBinsUseLocalTime(t *testing.T) {
+	location, _ := time.LoadLocation("America/Montreal")
+	ct := time.Date(2014, time.April, 18, 8, 0, 0, 0, location)
+
+	// The same local time of day on two days spent in different timezones should land in the same bin
+	reads := []apimodel.GlucoseRead{
+		apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(ct), "America/Montreal"}, apimodel.MG_PER_DL, 100},
+		apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(time.Date(2014, time.April, 19, 8, 5, 0, 0, time.UTC).Add(7 * time.Hour)), "America/Los_Angeles"}, apimodel.MG_PER_DL, 200},
+	}
+
+	agp, err := engine.CalculateAmbulatoryGlucoseProfile(reads, model.DEFAULT_TIME_IN_RANGE_THRESHOLDS, ct, ct.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatalf("Unexpected error calculating agp: %v", err)
+	}
+
+	bin := agp.Bins[8*60/engine.AGP_BIN_MINUTES]
+	if bin.ReadCount != 2 || *bin.P50 != 150 {
+		t.Errorf("Expected both reads in the 8:00 bin with a median of [150] but got [%v]", bin)
+	}
+
+	if len(agp.DailyProfiles) != 2 || agp.DailyProfiles[0].Date != "2014-04-18" || agp.DailyProfiles[1].Date != "2014-04-19" {
+		t.Errorf("Expected daily profiles for 2014-04-18 and 2014-04-19 but got [%v]", agp.DailyProfiles)
+	}
+
+	if len(agp.Bins) != engine.AGP_BINS_PER_DAY {
+		t.Errorf("Expected [%d] bins but got [%d]", engine.AGP_BINS_PER_DAY, len(agp.Bins))
+	}
+}
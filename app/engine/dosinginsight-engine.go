@@ -0,0 +1,369 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/delay"
+	"google.golang.org/appengine/log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DOSING_INSIGHT_FUNCTION_NAME = "runDosingInsightCalculation"
+
+	// Number of days of data analyzed by a dosing insight
+	DOSING_INSIGHT_PERIOD_DAYS = 28
+
+	// How long after a bolus its effect on glucose is measured
+	DOSING_WINDOW_DURATION = time.Duration(4) * time.Hour
+
+	// How far from a meal a bolus is considered to be for that meal
+	DOSING_BOLUS_MATCHING_TOLERANCE = time.Duration(15) * time.Minute
+
+	// How far from the start and end of a window the reads used to measure it can be
+	DOSING_READ_TOLERANCE = time.Duration(10) * time.Minute
+
+	// Windows starting with more than this still on board from earlier events aren't clean
+	DOSING_MAX_INSULIN_ON_BOARD = 0.5
+	DOSING_MAX_CARBS_ON_BOARD   = 5.
+
+	// How close to its starting glucose a meal window must end for its carb ratio to be estimated without a
+	// correction factor
+	DOSING_MEAL_RETURN_TOLERANCE = 30.
+
+	// How far from a day apart and how different in units two untyped injections can be to be the same daily basal dose
+	DOSING_BASAL_RECURRENCE_TOLERANCE = time.Duration(90) * time.Minute
+	DOSING_BASAL_UNITS_TOLERANCE      = 0.2
+)
+
+// Names of the basal insulins an injection can be recognized by when its insulin type isn't known
+var BASAL_INSULIN_NAMES = []string{"lantus", "levemir", "tresiba", "toujeo", "basaglar", "semglee", "glargine", "detemir", "degludec", "nph"}
+
+var RunDosingInsightTask = delay.Func(DOSING_INSIGHT_FUNCTION_NAME, RunDosingInsightCalculation)
+
+// IsBasalInjection returns true if an injection is of basal insulin. Dexcom doesn't record insulin types so an
+// untyped injection is basal if its insulin name is a known basal insulin or if it's a daily dose: an untyped
+// injection of about the same units is taken about a day before or after it and it isn't for a meal.
+func IsBasalInjection(injection apimodel.Injection, injections []apimodel.Injection, meals []apimodel.Meal) bool {
+	if len(injection.InsulinType) > 0 {
+		return strings.EqualFold(injection.InsulinType, model.INSULIN_TYPE_BASAL)
+	}
+
+	insulinName := strings.ToLower(injection.InsulinName)
+	for _, basalName := range BASAL_INSULIN_NAMES {
+		if strings.Contains(insulinName, basalName) {
+			return true
+		}
+	}
+
+	injectionTime := injection.GetTime()
+	for _, meal := range meals {
+		if absDuration(meal.GetTime().Sub(injectionTime)) <= DOSING_BOLUS_MATCHING_TOLERANCE {
+			return false
+		}
+	}
+
+	for _, other := range injections {
+		if len(other.InsulinType) > 0 || injection.Units <= 0 || math.Abs(float64(other.Units-injection.Units))/float64(injection.Units) > DOSING_BASAL_UNITS_TOLERANCE {
+			continue
+		}
+
+		dayApart := absDuration(other.GetTime().Sub(injectionTime))
+		if absDuration(dayApart-time.Duration(24)*time.Hour) <= DOSING_BASAL_RECURRENCE_TOLERANCE {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mealWindow is a meal bolus window along with the glucose change it ended with
+type mealWindow struct {
+	t     time.Time
+	carbs float64
+	units float64
+	delta float64
+}
+
+// CalculateDosingInsight estimates the effective carb ratio and correction factor of a user by block of the day
+// from clean windows of the period. A meal window is a meal with its bolus and a correction window is a bolus,
+// started above the high threshold, without a meal. Windows must be uninterrupted by other meals or boluses for
+// DOSING_WINDOW_DURATION and start with nothing significant on board. Basal injections are ignored, see
+// IsBasalInjection for how they're told apart from boluses.
+func CalculateDosingInsight(reads []apimodel.GlucoseRead, injections []apimodel.Injection, meals []apimodel.Meal, settings model.UserSettings, lowerBound, upperBound time.Time) (insight model.DosingInsight, err error) {
+	values, err := getSortedTimedValues(reads)
+	if err != nil {
+		return insight, err
+	}
+
+	boluses := make([]apimodel.Injection, 0, len(injections))
+	for _, injection := range injections {
+		if !IsBasalInjection(injection, injections, meals) {
+			boluses = append(boluses, injection)
+		}
+	}
+
+	correctionFactors := make(map[string][]float64)
+	mealWindows := make(map[string][]mealWindow)
+	for i, bolus := range boluses {
+		start := bolus.GetTime()
+		if start.Before(lowerBound) || start.After(upperBound) {
+			continue
+		}
+
+		if !isCleanDosingWindow(boluses, []int{i}, meals, -1, start, settings.OnBoard) {
+			continue
+		}
+
+		startValue, startOk := getValueNear(values, start)
+		endValue, endOk := getValueNear(values, start.Add(DOSING_WINDOW_DURATION))
+		if !startOk || !endOk || startValue <= settings.TimeInRangeThresholds.High || bolus.Units <= 0 {
+			continue
+		}
+
+		if correctionFactor := (startValue - endValue) / float64(bolus.Units); correctionFactor > 0 {
+			timeOfDay := getValueGroup(MEAL_TIMES_OF_DAY, float64(start.Hour()))
+			correctionFactors[timeOfDay] = append(correctionFactors[timeOfDay], correctionFactor)
+		}
+	}
+
+	for i, meal := range meals {
+		mealTime := meal.GetTime()
+		if mealTime.Before(lowerBound) || mealTime.After(upperBound) || meal.Carbohydrates <= 0 {
+			continue
+		}
+
+		// The window starts with the earliest of the meal and its boluses
+		start := mealTime
+		units := 0.
+		mealBoluses := make([]int, 0)
+		for j, bolus := range boluses {
+			if absDuration(bolus.GetTime().Sub(mealTime)) <= DOSING_BOLUS_MATCHING_TOLERANCE {
+				mealBoluses = append(mealBoluses, j)
+				units = units + float64(bolus.Units)
+				if bolus.GetTime().Before(start) {
+					start = bolus.GetTime()
+				}
+			}
+		}
+
+		if units <= 0 || !isCleanDosingWindow(boluses, mealBoluses, meals, i, start, settings.OnBoard) {
+			continue
+		}
+
+		startValue, startOk := getValueNear(values, start)
+		endValue, endOk := getValueNear(values, start.Add(DOSING_WINDOW_DURATION))
+		if !startOk || !endOk {
+			continue
+		}
+
+		timeOfDay := getValueGroup(MEAL_TIMES_OF_DAY, float64(mealTime.Hour()))
+		mealWindows[timeOfDay] = append(mealWindows[timeOfDay], mealWindow{mealTime, float64(meal.Carbohydrates), units, endValue - startValue})
+	}
+
+	allCorrectionFactors := make([]float64, 0)
+	for _, group := range MEAL_TIMES_OF_DAY {
+		allCorrectionFactors = append(allCorrectionFactors, correctionFactors[group.name]...)
+	}
+	overallCorrectionFactor := newDosingEstimate(allCorrectionFactors)
+
+	insight = model.DosingInsight{LowerBound: lowerBound, UpperBound: upperBound, Blocks: make([]model.DosingInsightBlock, len(MEAL_TIMES_OF_DAY))}
+	allCarbRatios := make([]float64, 0)
+	for i, group := range MEAL_TIMES_OF_DAY {
+		correctionFactor := newDosingEstimate(correctionFactors[group.name])
+
+		// Meal windows that didn't end where they started are adjusted with the best correction factor we have
+		adjustment := correctionFactor
+		if adjustment.WindowCount == 0 {
+			adjustment = overallCorrectionFactor
+		}
+
+		carbRatios := make([]float64, 0)
+		for _, window := range mealWindows[group.name] {
+			if carbRatio, ok := getCarbRatio(window, adjustment); ok {
+				carbRatios = append(carbRatios, carbRatio)
+			}
+		}
+		allCarbRatios = append(allCarbRatios, carbRatios...)
+
+		insight.Blocks[i] = model.DosingInsightBlock{TimeOfDay: group.name, CarbRatio: newDosingEstimate(carbRatios), CorrectionFactor: correctionFactor}
+	}
+
+	insight.Overall = model.DosingInsightBlock{TimeOfDay: "All", CarbRatio: newDosingEstimate(allCarbRatios), CorrectionFactor: overallCorrectionFactor}
+
+	return insight, nil
+}
+
+// isCleanDosingWindow returns true if no bolus or meal other than the ones of the window happens from
+// DOSING_BOLUS_MATCHING_TOLERANCE before its start to its end and if nothing significant is on board at its start
+func isCleanDosingWindow(boluses []apimodel.Injection, windowBoluses []int, meals []apimodel.Meal, windowMeal int, start time.Time, settings model.OnBoardSettings) bool {
+	end := start.Add(DOSING_WINDOW_DURATION)
+	earlierBoluses := make([]apimodel.Injection, 0)
+	for i, bolus := range boluses {
+		if containsIndex(windowBoluses, i) {
+			continue
+		}
+
+		if t := bolus.GetTime(); t.After(start.Add(-1*DOSING_BOLUS_MATCHING_TOLERANCE)) && !t.After(end) {
+			return false
+		}
+		earlierBoluses = append(earlierBoluses, bolus)
+	}
+
+	earlierMeals := make([]apimodel.Meal, 0)
+	for i, meal := range meals {
+		if i == windowMeal {
+			continue
+		}
+
+		if t := meal.GetTime(); t.After(start.Add(-1*DOSING_BOLUS_MATCHING_TOLERANCE)) && !t.After(end) {
+			return false
+		}
+		earlierMeals = append(earlierMeals, meal)
+	}
+
+	onBoard := CalculateOnBoard(earlierBoluses, earlierMeals, settings, []time.Time{start})[0]
+	return onBoard.InsulinOnBoard <= DOSING_MAX_INSULIN_ON_BOARD && onBoard.CarbsOnBoard <= DOSING_MAX_CARBS_ON_BOARD
+}
+
+func containsIndex(indexes []int, index int) bool {
+	for _, candidate := range indexes {
+		if candidate == index {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getCarbRatio returns the carbs covered by each unit of a meal window. The glucose change of the window is turned
+// into the insulin that was missing, or in excess, with the correction factor. Without a correction factor, only
+// windows that ended close to where they started are used.
+func getCarbRatio(window mealWindow, correctionFactor model.DosingEstimate) (carbRatio float64, ok bool) {
+	effectiveUnits := window.units
+	if correctionFactor.WindowCount > 0 {
+		effectiveUnits = window.units + window.delta/correctionFactor.Value
+	} else if math.Abs(window.delta) > DOSING_MEAL_RETURN_TOLERANCE {
+		return 0, false
+	}
+
+	if effectiveUnits <= 0 {
+		return 0, false
+	}
+
+	return window.carbs / effectiveUnits, true
+}
+
+// getValueNear returns the value of the read closest to a time if there's one within DOSING_READ_TOLERANCE
+func getValueNear(values []timedValue, t time.Time) (value float64, ok bool) {
+	i := sort.Search(len(values), func(i int) bool {
+		return !values[i].t.Before(t)
+	})
+
+	closest := -1
+	for _, candidate := range []int{i - 1, i} {
+		if candidate < 0 || candidate >= len(values) {
+			continue
+		}
+
+		distance := absDuration(values[candidate].t.Sub(t))
+		if distance <= DOSING_READ_TOLERANCE && (closest < 0 || distance < absDuration(values[closest].t.Sub(t))) {
+			closest = candidate
+		}
+	}
+
+	if closest < 0 {
+		return 0, false
+	}
+
+	return values[closest].value, true
+}
+
+// newDosingEstimate returns the median of values with a confidence that is high with at least 6 windows within 25%
+// of each other, medium with at least 3 windows within 50% of each other and low otherwise
+func newDosingEstimate(values []float64) (estimate model.DosingEstimate) {
+	if len(values) == 0 {
+		return estimate
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	estimate.Value = GetPercentileFromSortedValues(sorted, 50)
+	estimate.P25 = GetPercentileFromSortedValues(sorted, 25)
+	estimate.P75 = GetPercentileFromSortedValues(sorted, 75)
+	estimate.WindowCount = len(sorted)
+
+	spread := (estimate.P75 - estimate.P25) / estimate.Value
+	switch {
+	case estimate.WindowCount >= 6 && spread <= 0.25:
+		estimate.Confidence = model.DOSING_CONFIDENCE_HIGH
+	case estimate.WindowCount >= 3 && spread <= 0.5:
+		estimate.Confidence = model.DOSING_CONFIDENCE_MEDIUM
+	default:
+		estimate.Confidence = model.DOSING_CONFIDENCE_LOW
+	}
+
+	return estimate
+}
+
+// StartDosingInsightBatch schedules the calculation of the dosing insight of a user at the end of the current week
+// and right away if it was never calculated
+func StartDosingInsightBatch(context context.Context, glukitUser *model.GlukitUser) (err error) {
+	insight, err := store.GetDosingInsight(context, glukitUser.Email)
+	if err != nil {
+		return err
+	}
+
+	return scheduleWeeklyCalculation(context, glukitUser.Email, RunDosingInsightTask, "dosingInsight", insight.CalculatedOn.IsZero())
+}
+
+// RunDosingInsightCalculation calculates the dosing insight of the last DOSING_INSIGHT_PERIOD_DAYS of a user's data
+// and replaces the previous one
+func RunDosingInsightCalculation(context context.Context, userEmail string) {
+	_, _, upperBound, err := store.GetUserData(context, userEmail)
+	if err == store.ErrNoImportedDataFound {
+		log.Infof(context, "No data for user [%s], skipping dosing insight", userEmail)
+		return
+	} else if err != nil {
+		util.Propagate(err)
+	}
+
+	settings, err := store.GetUserSettings(context, userEmail)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	lowerBound := upperBound.AddDate(0, 0, -1*DOSING_INSIGHT_PERIOD_DAYS)
+	reads, err := store.GetGlucoseReads(context, userEmail, lowerBound, upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	// Earlier events are needed to know what was on board at the start of the first windows
+	injections, err := store.GetInjections(context, userEmail, lowerBound.Add(-1*ONBOARD_LOOKBACK), upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+	meals, err := store.GetMeals(context, userEmail, lowerBound.Add(-1*ONBOARD_LOOKBACK), upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	insight, err := CalculateDosingInsight(reads, injections, meals, *settings, lowerBound, upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+	insight.CalculatedOn = time.Now()
+
+	util.Propagate(store.StoreDosingInsight(context, userEmail, insight))
+	log.Infof(context, "Calculated dosing insight of user [%s] with [%d] meal windows and [%d] correction windows", userEmail,
+		insight.Overall.CarbRatio.WindowCount, insight.Overall.CorrectionFactor.WindowCount)
+}
@@ -0,0 +1,125 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"math"
+	"testing"
+	"time"
+)
+
+// newDosingReads returns 11 hours of reads starting at 5 AM in Los Angeles. A correction brings glucose down
+// linearly from 250 to 150 between hours 1 and 5 and a meal makes it peak at 200 and come back to 150 between
+// hours 6 and 10.
+func newDosingReads(start time.Time) []apimodel.GlucoseRead {
+	values := make([]float32, 0)
+	for minutes := 0; minutes <= 11*60; minutes = minutes + 5 {
+		value := 150.
+		switch {
+		case minutes < 60:
+			value = 250
+		case minutes < 5*60:
+			value = 250 - float64(minutes-60)*100/(4*60)
+		case minutes >= 6*60 && minutes < 7*60+30:
+			value = 150 + float64(minutes-6*60)*50/90
+		case minutes >= 7*60+30 && minutes < 10*60:
+			value = 200 - float64(minutes-7*60-30)*50/150
+		}
+		values = append(values, float32(value))
+	}
+
+	return newReadsEveryFiveMinutes(start, values)
+}
+
+func newDosingEvents(start time.Time) ([]apimodel.Injection, []apimodel.Meal) {
+	injections := []apimodel.Injection{
+		apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(start.Add(time.Duration(1) * time.Hour)), "America/Los_Angeles"}, float32(2), "Humalog", model.INSULIN_TYPE_RAPID},
+		apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(start.Add(time.Duration(6) * time.Hour)), "America/Los_Angeles"}, float32(6), "Humalog", model.INSULIN_TYPE_RAPID},
+	}
+	meals := []apimodel.Meal{apimodel.Meal{Time: apimodel.Time{apimodel.GetTimeMillis(start.Add(time.Duration(6) * time.Hour)), "America/Los_Angeles"}, Carbohydrates: 60}}
+
+	return injections, meals
+}
+
+func TestCalculateDosingInsight(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 12:00")
+	reads := newDosingReads(ct)
+	injections, meals := newDosingEvents(ct)
+
+	insight, err := engine.CalculateDosingInsight(reads, injections, meals, model.DEFAULT_USER_SETTINGS, ct, ct.Add(time.Duration(11)*time.Hour))
+	if err != nil {
+		t.Fatalf("Unexpected error calculating dosing insight: %v", err)
+	}
+
+	morning, midday := insight.Blocks[0], insight.Blocks[1]
+	if morning.TimeOfDay != engine.MEAL_TIME_OF_DAY_MORNING || midday.TimeOfDay != engine.MEAL_TIME_OF_DAY_MIDDAY {
+		t.Fatalf("Unexpected blocks: %v", insight.Blocks)
+	}
+
+	if morning.CorrectionFactor.WindowCount != 1 || math.Abs(morning.CorrectionFactor.Value-50) > 0.001 {
+		t.Errorf("Expected a morning correction factor of [50] from a single window but got [%v]", morning.CorrectionFactor)
+	}
+
+	if midday.CarbRatio.WindowCount != 1 || math.Abs(midday.CarbRatio.Value-10) > 0.001 {
+		t.Errorf("Expected a midday carb ratio of [10] from a single window but got [%v]", midday.CarbRatio)
+	}
+
+	if midday.CarbRatio.Confidence != model.DOSING_CONFIDENCE_LOW {
+		t.Errorf("Expected low confidence from a single window but got [%s]", midday.CarbRatio.Confidence)
+	}
+
+	if insight.Overall.CorrectionFactor.WindowCount != 1 || insight.Overall.CarbRatio.WindowCount != 1 {
+		t.Errorf("Expected one window of each kind overall but got [%v]", insight.Overall)
+	}
+}
+
+func TestInterruptedDosingWindowsAreIgnored(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 12:00")
+	reads := newDosingReads(ct)
+	injections, meals := newDosingEvents(ct)
+
+	// A snack during each window
+	meals = append([]apimodel.Meal{apimodel.Meal{Time: apimodel.Time{apimodel.GetTimeMillis(ct.Add(time.Duration(2) * time.Hour)), "America/Los_Angeles"}, Carbohydrates: 15}}, meals...)
+	meals = append(meals, apimodel.Meal{Time: apimodel.Time{apimodel.GetTimeMillis(ct.Add(time.Duration(8) * time.Hour)), "America/Los_Angeles"}, Carbohydrates: 15})
+
+	insight, err := engine.CalculateDosingInsight(reads, injections, meals, model.DEFAULT_USER_SETTINGS, ct, ct.Add(time.Duration(11)*time.Hour))
+	if err != nil {
+		t.Fatalf("Unexpected error calculating dosing insight: %v", err)
+	}
+
+	if insight.Overall.CorrectionFactor.WindowCount != 0 || insight.Overall.CarbRatio.WindowCount != 0 {
+		t.Errorf("Expected no clean window but got [%v]", insight.Overall)
+	}
+}
+
+func TestIsBasalInjection(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 22:00")
+	newInjection := func(offset time.Duration, units float32, name, insulinType string) apimodel.Injection {
+		return apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(ct.Add(offset)), "America/Los_Angeles"}, units, name, insulinType}
+	}
+
+	nightly := newInjection(0, 20, "", "")
+	nextNight := newInjection(time.Duration(24)*time.Hour+time.Duration(30)*time.Minute, 21, "", "")
+	mealBolus := newInjection(time.Duration(12)*time.Hour, 6, "", "")
+	nextMealBolus := newInjection(time.Duration(36)*time.Hour, 6, "", "")
+	injections := []apimodel.Injection{nightly, nextNight, mealBolus, nextMealBolus}
+	meals := []apimodel.Meal{apimodel.Meal{Time: mealBolus.Time, Carbohydrates: 60}, apimodel.Meal{Time: nextMealBolus.Time, Carbohydrates: 60}}
+
+	for _, test := range []struct {
+		injection apimodel.Injection
+		basal     bool
+	}{
+		{nightly, true},
+		{nextNight, true},
+		{mealBolus, false},
+		{newInjection(time.Duration(3)*time.Hour, 2, "", ""), false},
+		{newInjection(time.Duration(3)*time.Hour, 2, "Lantus", ""), true},
+		{newInjection(0, 20, "", model.INSULIN_TYPE_RAPID), false},
+		{newInjection(time.Duration(3)*time.Hour, 2, "", model.INSULIN_TYPE_BASAL), true},
+	} {
+		if basal := engine.IsBasalInjection(test.injection, injections, meals); basal != test.basal {
+			t.Errorf("TestIsBasalInjection failed, expected basal to be [%t] for [%v] but got [%t]", test.basal, test.injection, basal)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package engine
 
 import (
+	"crypto/sha1"
+	"fmt"
 	"github.com/alexandre-normand/glukit/app/model"
 	"github.com/alexandre-normand/glukit/app/store"
 	"github.com/alexandre-normand/glukit/app/util"
@@ -25,11 +27,35 @@ var RunA1CCalculationChunk = delay.Func(A1C_BATCH_CALCULATION_FUNCTION_NAME, fun
 		"real one which we define in init() to override this implementation!")
 })
 
+var RunTimeInRangeCalculationChunk = delay.Func(TIME_IN_RANGE_BATCH_CALCULATION_FUNCTION_NAME, func(context context.Context, userEmail string,
+	lowerBound time.Time) {
+	log.Criticalf(context, "This function purely exists as a workaround to the \"initialization loop\" error that "+
+		"shows up because the function calls itself. This implementation defines the same signature as the "+
+		"real one which we define in init() to override this implementation!")
+})
+
+var RunEpisodeDetectionChunk = delay.Func(EPISODE_DETECTION_FUNCTION_NAME, func(context context.Context, userEmail string,
+	lowerBound time.Time) {
+	log.Criticalf(context, "This function purely exists as a workaround to the \"initialization loop\" error that "+
+		"shows up because the function calls itself. This implementation defines the same signature as the "+
+		"real one which we define in init() to override this implementation!")
+})
+
+var RunInvalidatedRecalculationChunk = delay.Func(INVALIDATED_RECALCULATION_FUNCTION_NAME, func(context context.Context, userEmail string,
+	startedOn time.Time, after time.Time) {
+	log.Criticalf(context, "This function purely exists as a workaround to the \"initialization loop\" error that "+
+		"shows up because the function calls itself. This implementation defines the same signature as the "+
+		"real one which we define in init() to override this implementation!")
+})
+
 const (
-	PERIODS_PER_BATCH                            = 6
-	BATCH_CALCULATION_QUEUE_NAME                 = "batch-calculation"
-	GLUKIT_SCORE_BATCH_CALCULATION_FUNCTION_NAME = "runGlukitScoreCalculationChunk"
-	A1C_BATCH_CALCULATION_FUNCTION_NAME          = "runA1CCalculationChunk"
+	PERIODS_PER_BATCH                             = 6
+	BATCH_CALCULATION_QUEUE_NAME                  = "batch-calculation"
+	GLUKIT_SCORE_BATCH_CALCULATION_FUNCTION_NAME  = "runGlukitScoreCalculationChunk"
+	A1C_BATCH_CALCULATION_FUNCTION_NAME           = "runA1CCalculationChunk"
+	TIME_IN_RANGE_BATCH_CALCULATION_FUNCTION_NAME = "runTimeInRangeCalculationChunk"
+	EPISODE_DETECTION_FUNCTION_NAME               = "runEpisodeDetectionChunk"
+	INVALIDATED_RECALCULATION_FUNCTION_NAME       = "runInvalidatedRecalculationChunk"
 )
 
 func RunGlukitScoreBatchCalculation(context context.Context, userEmail string, lowerBound time.Time) {
@@ -40,6 +66,11 @@ func RunGlukitScoreBatchCalculation(context context.Context, userEmail string, l
 		return
 	}
 
+	settings, err := store.GetUserSettings(context, userEmail)
+	if err != nil {
+		util.Propagate(err)
+	}
+
 	bestScore := glukitUser.BestScore
 	mostRecentScore := glukitUser.MostRecentScore
 	glukitScoreBatch := make([]model.GlukitScore, 0)
@@ -53,17 +84,18 @@ func RunGlukitScoreBatchCalculation(context context.Context, userEmail string, l
 	// This will likely go through a few calculations for which we don't have data yet but this seems like the fair
 	// price to pay for making sure we don't stop processing glukit scores because someone might have stopped using their CGM for a week or so.
 	for periodUpperBound = lowerBound.AddDate(0, 0, 1); periodUpperBound.Before(time.Now()) && periodUpperBound.Before(upperBound); periodUpperBound = periodUpperBound.AddDate(0, 0, 1) {
-		glukitScore, err := CalculateGlukitScore(context, glukitUser, periodUpperBound)
+		glukitScore, err := CalculateGlukitScore(context, glukitUser, periodUpperBound, settings.Interpolation, GetScoringProfile(settings.ScoringProfile))
 		if err != nil {
 			util.Propagate(err)
 		}
 
-		if glukitScore.IsBetterThan(glukitUser.BestScore) {
+		if glukitScore.IsBetterThan(bestScore) {
 			bestScore = *glukitScore
 		}
 
 		if glukitScore.Value != model.UNDEFINED_SCORE_VALUE {
-			if periodUpperBound.After(mostRecentScore.UpperBound) {
+			// A score for the same period replaces the previous one since it's a recalculation
+			if !glukitScore.UpperBound.Before(mostRecentScore.UpperBound) {
 				mostRecentScore = *glukitScore
 			}
 
@@ -109,6 +141,17 @@ func RunA1CBatchCalculation(context context.Context, userEmail string, lowerBoun
 		return
 	}
 
+	settings, err := store.GetUserSettings(context, userEmail)
+	if err != nil {
+		util.Propagate(err)
+	}
+	estimator := GetA1CEstimator(settings.A1CEstimator)
+
+	calibration, err := store.GetA1CCalibration(context, userEmail)
+	if err != nil {
+		util.Propagate(err)
+	}
+
 	mostRecentA1C := glukitUser.MostRecentA1C
 	a1cBatch := make([]model.A1CEstimate, 0)
 	var periodUpperBound time.Time
@@ -120,12 +163,14 @@ func RunA1CBatchCalculation(context context.Context, userEmail string, lowerBoun
 	// This will likely go through a few calculations for which we don't have data yet but this seems like the fair
 	// price to pay for making sure we don't stop processing estimates because someone might have stopped using their CGM for a week or so.
 	for periodUpperBound = lowerBound.AddDate(0, 0, 1); periodUpperBound.Before(time.Now()) && periodUpperBound.Before(upperBound); periodUpperBound = periodUpperBound.AddDate(0, 0, 1) {
-		a1cEstimate, err := EstimateA1C(context, glukitUser, periodUpperBound)
+		a1cEstimate, err := EstimateA1C(context, glukitUser, periodUpperBound, estimator, settings.Interpolation)
 		if err != nil {
 			log.Warningf(context, "Error trying to calculate a1c for user [%s] with upper bound [%s]: %v", userEmail, periodUpperBound, err)
 		} else {
+			ApplyA1CCalibration(a1cEstimate, *calibration)
 			a1cBatch = append(a1cBatch, *a1cEstimate)
-			if periodUpperBound.After(mostRecentA1C.UpperBound) {
+			// An estimate for the same period replaces the previous one since it's a recalculation
+			if !a1cEstimate.UpperBound.Before(mostRecentA1C.UpperBound) {
 				mostRecentA1C = *a1cEstimate
 			}
 		}
@@ -157,5 +202,259 @@ func RunA1CBatchCalculation(context context.Context, userEmail string, lowerBoun
 		log.Infof(context, "Queued up next chunk of a1c calculation for user [%s] and lowerBound [%s]", userEmail, periodUpperBound.Format(util.TIMEFORMAT))
 	} else {
 		log.Infof(context, "Done with a1c estimation for user [%s]", userEmail)
+
+		// Estimates of periods with a lab a1c might have just been calculated
+		if _, err := RecalibrateA1C(context, userEmail); err != nil {
+			log.Warningf(context, "Error recalibrating a1c estimates for user [%s]: %v", userEmail, err)
+		}
+	}
+}
+
+// RunInvalidatedBatchRecalculation calculates again the glukit scores and a1c estimates of a user overlapping days
+// marked dirty up to when the recalculation started or with an outdated scoring version. Windows are recalculated
+// oldest first, the next chunk picks up after the last window of this one. Outdated calculations that can't be
+// calculated anymore are deleted so that they don't show up as outdated forever.
+func RunInvalidatedBatchRecalculation(context context.Context, userEmail string, startedOn time.Time, after time.Time) {
+	glukitUser, _, _, err := store.GetUserData(context, userEmail)
+	if _, ok := err.(store.StoreError); err != nil && !ok {
+		log.Errorf(context, "We're trying to run a recalculation of invalidated scores for user [%s] that doesn't exist. "+
+			"Got error: %v", userEmail, err)
+		return
+	}
+
+	settings, err := store.GetUserSettings(context, userEmail)
+	if err != nil {
+		util.Propagate(err)
+	}
+	estimator := GetA1CEstimator(settings.A1CEstimator)
+	profile := GetScoringProfile(settings.ScoringProfile)
+
+	calibration, err := store.GetA1CCalibration(context, userEmail)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	dirtyDays, err := store.GetDirtyDays(context, userEmail, startedOn)
+	if err != nil {
+		util.Propagate(err)
 	}
+	dirtyDayStarts := make([]time.Time, len(dirtyDays))
+	for i, day := range dirtyDays {
+		dirtyDayStarts[i] = day.Start
+	}
+
+	outdatedScores, err := store.GetOutdatedGlukitScoreBounds(context, userEmail, SCORING_VERSION)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	outdatedA1Cs, err := store.GetOutdatedA1CEstimateBounds(context, userEmail, A1C_SCORING_VERSION)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	now := time.Now()
+	scoreBounds := GetInvalidatedUpperBounds(dirtyDayStarts, outdatedScores, GLUKIT_SCORE_PERIOD, after, now)
+	a1cBounds := GetInvalidatedUpperBounds(dirtyDayStarts, outdatedA1Cs, A1C_ESTIMATION_SCORE_PERIOD, after, now)
+	batchEnd, done := getInvalidatedBatchEnd(scoreBounds, a1cBounds)
+
+	log.Debugf(context, "Recalculating [%d] glukit scores and [%d] a1c estimates of user [%s] for [%d] dirty days, [%d] outdated scores "+
+		"and [%d] outdated estimates up to [%s]", len(scoreBounds), len(a1cBounds), userEmail, len(dirtyDays), len(outdatedScores), len(outdatedA1Cs), batchEnd)
+
+	bestScore := glukitUser.BestScore
+	mostRecentScore := glukitUser.MostRecentScore
+	glukitScoreBatch := make([]model.GlukitScore, 0)
+	calculatedScores := make(map[int64]bool)
+	for _, upperBound := range scoreBounds {
+		if upperBound.After(batchEnd) {
+			break
+		}
+
+		glukitScore, err := CalculateGlukitScore(context, glukitUser, upperBound, settings.Interpolation, profile)
+		if err != nil {
+			util.Propagate(err)
+		}
+
+		if glukitScore.Value == model.UNDEFINED_SCORE_VALUE {
+			continue
+		}
+
+		// The best score might be the one that was just calculated again
+		if glukitScore.IsBetterThan(bestScore) || glukitScore.UpperBound.Equal(bestScore.UpperBound) {
+			bestScore = *glukitScore
+		}
+
+		if !glukitScore.UpperBound.Before(mostRecentScore.UpperBound) {
+			mostRecentScore = *glukitScore
+		}
+
+		glukitScoreBatch = append(glukitScoreBatch, *glukitScore)
+		calculatedScores[glukitScore.UpperBound.Unix()] = true
+	}
+
+	mostRecentA1C := glukitUser.MostRecentA1C
+	a1cBatch := make([]model.A1CEstimate, 0)
+	calculatedA1Cs := make(map[int64]bool)
+	recalculatedA1CWindows := make([]time.Time, 0)
+	for _, upperBound := range a1cBounds {
+		if upperBound.After(batchEnd) {
+			break
+		}
+
+		a1cEstimate, err := EstimateA1C(context, glukitUser, upperBound, estimator, settings.Interpolation)
+		if err != nil {
+			log.Warningf(context, "Error trying to calculate a1c for user [%s] with upper bound [%s]: %v", userEmail, upperBound, err)
+			continue
+		}
+
+		ApplyA1CCalibration(a1cEstimate, *calibration)
+		a1cBatch = append(a1cBatch, *a1cEstimate)
+		calculatedA1Cs[a1cEstimate.UpperBound.Unix()] = true
+		recalculatedA1CWindows = append(recalculatedA1CWindows, upperBound)
+		if !a1cEstimate.UpperBound.Before(mostRecentA1C.UpperBound) {
+			mostRecentA1C = *a1cEstimate
+		}
+	}
+
+	store.StoreGlukitScoreBatch(context, userEmail, glukitScoreBatch)
+	store.StoreA1CBatch(context, userEmail, a1cBatch)
+
+	if stale := getStaleBounds(outdatedScores, calculatedScores, after, batchEnd); len(stale) > 0 {
+		if err := store.DeleteGlukitScores(context, userEmail, stale); err != nil {
+			util.Propagate(err)
+		}
+	}
+
+	staleA1Cs := getStaleBounds(outdatedA1Cs, calculatedA1Cs, after, batchEnd)
+	// Estimates are keyed by their last read so a window calculated again can end up under a new key. The estimate
+	// it replaces is deleted so that the window doesn't have two.
+	if len(recalculatedA1CWindows) > 0 {
+		existing, err := store.GetA1CEstimateBounds(context, userEmail, recalculatedA1CWindows[0].AddDate(0, 0, -1), recalculatedA1CWindows[len(recalculatedA1CWindows)-1])
+		if err != nil {
+			util.Propagate(err)
+		}
+		staleA1Cs = append(staleA1Cs, getReplacedBounds(existing, calculatedA1Cs, recalculatedA1CWindows, staleA1Cs)...)
+	}
+
+	if len(staleA1Cs) > 0 {
+		if err := store.DeleteA1CEstimates(context, userEmail, staleA1Cs); err != nil {
+			util.Propagate(err)
+		}
+	}
+
+	if bestScore != glukitUser.BestScore || mostRecentScore != glukitUser.MostRecentScore || mostRecentA1C != glukitUser.MostRecentA1C {
+		glukitUser.BestScore = bestScore
+		glukitUser.MostRecentScore = mostRecentScore
+		glukitUser.MostRecentA1C = mostRecentA1C
+		if _, err := store.StoreUserProfile(context, time.Now(), *glukitUser); err != nil {
+			util.Propagate(err)
+		} else {
+			log.Debugf(context, "Updated glukit user [%s] with a best score of [%v], most recent score of [%v] and most recent a1c of [%v]",
+				glukitUser.Email, bestScore, mostRecentScore, mostRecentA1C)
+		}
+	}
+
+	if !done {
+		task, err := RunInvalidatedRecalculationChunk.Task(userEmail, startedOn, batchEnd)
+		if err != nil {
+			log.Criticalf(context, "Couldn't schedule the next execution of [%s] for user [%s]. "+
+				"This breaks recalculation of invalidated scores for that user!: %v", INVALIDATED_RECALCULATION_FUNCTION_NAME, userEmail, err)
+		}
+		taskqueue.Add(context, task, BATCH_CALCULATION_QUEUE_NAME)
+
+		log.Infof(context, "Queued up next chunk of invalidated score recalculation for user [%s] after [%s]", userEmail, batchEnd.Format(util.TIMEFORMAT))
+		return
+	}
+
+	if err := store.ClearDirtyDays(context, userEmail, startedOn); err != nil {
+		util.Propagate(err)
+	}
+	log.Infof(context, "Done with recalculation of invalidated scores for user [%s]", userEmail)
+
+	// Estimates of periods with a lab a1c might have just been calculated again
+	if len(a1cBatch) > 0 || !after.IsZero() {
+		if _, err := RecalibrateA1C(context, userEmail); err != nil {
+			log.Warningf(context, "Error recalibrating a1c estimates for user [%s]: %v", userEmail, err)
+		}
+	}
+}
+
+// getStaleBounds returns the outdated upper bounds of windows of a batch that weren't replaced by a calculation
+func getStaleBounds(outdated []time.Time, calculated map[int64]bool, after time.Time, batchEnd time.Time) (stale []time.Time) {
+	stale = make([]time.Time, 0)
+	for _, upperBound := range outdated {
+		window := getWindowUpperBound(upperBound)
+		if window.After(after) && !window.After(batchEnd) && !calculated[upperBound.Unix()] {
+			stale = append(stale, upperBound)
+		}
+	}
+
+	return stale
+}
+
+// getReplacedBounds returns the upper bounds of existing calculations of windows that were just calculated again under
+// another upper bound. Bounds already known to be stale are left out.
+func getReplacedBounds(existing []time.Time, calculated map[int64]bool, windows []time.Time, stale []time.Time) (replaced []time.Time) {
+	recalculated := make(map[int64]bool)
+	for _, window := range windows {
+		recalculated[window.Unix()] = true
+	}
+	known := make(map[int64]bool)
+	for _, upperBound := range stale {
+		known[upperBound.Unix()] = true
+	}
+
+	replaced = make([]time.Time, 0)
+	for _, upperBound := range existing {
+		if recalculated[getWindowUpperBound(upperBound).Unix()] && !calculated[upperBound.Unix()] && !known[upperBound.Unix()] {
+			replaced = append(replaced, upperBound)
+		}
+	}
+
+	return replaced
+}
+
+// scheduleWeeklyCalculation schedules a calculation of a user at the end of the current week and right away if it
+// was never calculated. Tasks are named after the calculation, the user and the week so that calling this on every
+// import still results in a single calculation per week. Task names stay reserved once used so the initial
+// calculation is named after the hour, a first run that found no data gets retried by a later import.
+func scheduleWeeklyCalculation(context context.Context, email string, function *delay.Function, name string, neverCalculated bool) (err error) {
+	userHash := fmt.Sprintf("%x", sha1.Sum([]byte(email)))
+	if neverCalculated {
+		if err = enqueueWeeklyCalculation(context, email, function, name+"-"+userHash+"-initial-"+time.Now().UTC().Format("2006010215"), time.Now()); err != nil {
+			return err
+		}
+	}
+
+	endOfWeek := getEndOfWeek(time.Now())
+	return enqueueWeeklyCalculation(context, email, function, name+"-"+userHash+"-"+endOfWeek.Format("20060102"), endOfWeek)
+}
+
+// getEndOfWeek returns the start of the next monday, in UTC
+func getEndOfWeek(t time.Time) time.Time {
+	startOfDay := t.UTC().Truncate(time.Duration(24) * time.Hour)
+	daysToMonday := (8 - int(startOfDay.Weekday())) % 7
+	if daysToMonday == 0 {
+		daysToMonday = 7
+	}
+
+	return startOfDay.AddDate(0, 0, daysToMonday)
+}
+
+func enqueueWeeklyCalculation(context context.Context, email string, function *delay.Function, name string, eta time.Time) (err error) {
+	task, err := function.Task(email)
+	if err != nil {
+		return err
+	}
+	task.Name = name
+	task.ETA = eta
+
+	if _, err = taskqueue.Add(context, task, BATCH_CALCULATION_QUEUE_NAME); err == taskqueue.ErrTaskAlreadyAdded {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	log.Infof(context, "Scheduled calculation [%s] for user [%s] at [%s]", name, email, eta.Format(util.TIMEFORMAT))
+	return nil
 }
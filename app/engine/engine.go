@@ -15,48 +15,74 @@ import (
 )
 
 const (
-	// The multiplier applied to any deviation from the target, on the low spectrum (i.e. anything less than 83)
+	// The multiplier applied to any deviation from the target of the classic profile, on the low spectrum (i.e. anything less than 83)
 	LOW_MULTIPLIER = 1
-	// The multiplier applied to any deviation from the target, on the high spectrum (i.e. anything above 83)
+	// The multiplier applied to any deviation from the target of the classic profile, on the high spectrum (i.e. anything above 83)
 	HIGH_MULTIPLIER = 2
 	// Glukit score calculation period
 	GLUKIT_SCORE_PERIOD = 7
 	// One period of reads minus on day for potential data gaps
 	READS_REQUIREMENT = 288 * (GLUKIT_SCORE_PERIOD - 1)
-	// The current Glukit scoring version
-	SCORING_VERSION = 1
+	// The current Glukit scoring version. Version 3 fills gaps according to the user's interpolation policy.
+	SCORING_VERSION = 3
+	// The first scoring version that scores reads with the user's scoring profile
+	FIRST_PROFILE_SCORING_VERSION = 2
 	// The max number of days to look back when starting a new batch of calculation
 	MAX_CALCULATION_DAYS_TO_LOOK_BACK = 30
+	// The internal value where the user facing mapping bottoms out. The mapping only decreases up to this value and
+	// rises again past it.
+	MAX_USER_FACING_INTERNAL_VALUE = 981300
 )
 
 // January 1st, 2014
 var A1C_CALCULATION_START = time.Unix(1388534400, 0)
 
+// CLASSIC_SCORING_PROFILE is the original scoring of the glukit score, used for users that never set a profile and
+// kept alongside every score for comparison
+var CLASSIC_SCORING_PROFILE = model.ScoringProfile{Target: model.TARGET_GLUCOSE_VALUE, HighMultiplier: HIGH_MULTIPLIER, LowMultiplier: LOW_MULTIPLIER}
+
+// GetScoringProfile returns the profile or the classic profile if it isn't defined
+func GetScoringProfile(profile model.ScoringProfile) model.ScoringProfile {
+	if profile.IsDefined() {
+		return profile
+	}
+
+	return CLASSIC_SCORING_PROFILE
+}
+
 // CalculateGlukitScore computes the GlukitScore for a given user. This is done in a few steps:
-//   1. Get the latest GLUKIT_SCORE_PERIOD days of reads
+//   1. Get the latest GLUKIT_SCORE_PERIOD days of reads and fill their gaps according to the interpolation policy
 //   2. For the most recent reads up to READS_REQUIREMENT, calculate the individual score
-//      contribution and add it to the GlukitScore.
+//      contribution with the scoring profile and with the classic profile and add them to the GlukitScore.
 //   3. If we had enough reads to satisfy the requirements, we return the sum of
 //      all individual score contributions.
-func CalculateGlukitScore(context context.Context, glukitUser *model.GlukitUser, endOfPeriod time.Time) (glukitScore *model.GlukitScore, err error) {
+func CalculateGlukitScore(context context.Context, glukitUser *model.GlukitUser, endOfPeriod time.Time, policy model.InterpolationPolicy, profile model.ScoringProfile) (glukitScore *model.GlukitScore, err error) {
 	// Get the last period's worth of reads
 	upperBound := util.GetMidnightUTCBefore(endOfPeriod)
 	lowerBound := upperBound.AddDate(0, 0, -1*GLUKIT_SCORE_PERIOD)
 	score := model.UNDEFINED_SCORE_VALUE
+	classicScore := int64(0)
+	periodReadCount := 0
 
 	log.Debugf(context, "Getting reads for glukit score calculation from [%s] to [%s]", lowerBound, upperBound)
 	if reads, err := store.GetGlucoseReads(context, glukitUser.Email, lowerBound, upperBound); err != nil {
 		return &model.UNDEFINED_SCORE, err
 	} else {
-		// We might want to do some interpolation of missing reads at some point but for now, we'll only use
-		// actual values. Since we know we'll have gaps in a 2 weeks window because of sensor warm-ups, let's
-		// just normalize by stopping after the equivalent of full 14 days of reads (assuming most people won't have
-		// more than 2 days worth of missing data)
+		// Interpolation only fills short dropouts. Since we know we'll still have longer gaps in a 2 weeks window
+		// because of sensor warm-ups, let's just normalize by stopping after the equivalent of full 14 days of reads
+		// (assuming most people won't have more than 2 days worth of missing data)
+		interpolatedReads, err := InterpolateReads(reads, policy)
+		if err != nil {
+			return &model.UNDEFINED_SCORE, err
+		}
+
 		readCount := 0
+		periodReadCount = len(reads)
 		score = 0
 
-		for i := 0; i < len(reads) && i < READS_REQUIREMENT; i++ {
-			score = score + int64(CalculateIndividualReadScoreWeight(context, reads[i]))
+		for i := 0; i < len(interpolatedReads) && i < READS_REQUIREMENT; i++ {
+			score = score + int64(CalculateIndividualReadScoreWeight(context, interpolatedReads[i], profile))
+			classicScore = classicScore + int64(CalculateIndividualReadScoreWeight(context, interpolatedReads[i], CLASSIC_SCORING_PROFILE))
 			readCount = readCount + 1
 		}
 
@@ -75,15 +101,21 @@ func CalculateGlukitScore(context context.Context, glukitUser *model.GlukitUser,
 			LowerBound:     lowerBound,
 			UpperBound:     upperBound,
 			CalculatedOn:   time.Now(),
-			ScoringVersion: SCORING_VERSION}
+			ScoringVersion: SCORING_VERSION,
+			ReadCount:      periodReadCount,
+			Coverage:       model.GetCoveragePercentage(periodReadCount, lowerBound, upperBound),
+			Interpolation:  policy,
+			Profile:        profile,
+			ClassicValue:   classicScore}
 	}
 
 	return glukitScore, nil
 }
 
-// An individual score is either 0 if it's straight on perfection (83) or it's the deviation from 83 weighted
-// by whether it's high (multiplier of 2) or lower (multiplier of 1)
-func CalculateIndividualReadScoreWeight(context context.Context, read apimodel.GlucoseRead) (weightedScoreContribution float64) {
+// An individual score is either 0 if it's straight on the profile's target (83 for the classic profile) or it's the
+// deviation from the target weighted by whether it's high (multiplier of 2 for the classic profile) or lower
+// (multiplier of 1 for the classic profile). Reads under the profile's hypo threshold add the hypo penalty.
+func CalculateIndividualReadScoreWeight(context context.Context, read apimodel.GlucoseRead, profile model.ScoringProfile) (weightedScoreContribution float64) {
 	weightedScoreContribution = 0.
 	convertedValue, err := read.GetNormalizedValue(apimodel.MG_PER_DL)
 	if err != nil {
@@ -91,27 +123,44 @@ func CalculateIndividualReadScoreWeight(context context.Context, read apimodel.G
 	}
 	value := float64(convertedValue)
 
-	if value > model.TARGET_GLUCOSE_VALUE {
-		weightedScoreContribution = (value - model.TARGET_GLUCOSE_VALUE) * HIGH_MULTIPLIER
-	} else if value < model.TARGET_GLUCOSE_VALUE {
-		weightedScoreContribution = -(value - model.TARGET_GLUCOSE_VALUE) * LOW_MULTIPLIER
+	if value > profile.Target {
+		weightedScoreContribution = (value - profile.Target) * profile.HighMultiplier
+	} else if value < profile.Target {
+		weightedScoreContribution = -(value - profile.Target) * profile.LowMultiplier
+	}
+
+	if value < profile.HypoThreshold {
+		weightedScoreContribution = weightedScoreContribution + profile.HypoMultiplier*math.Pow(profile.HypoThreshold-value, profile.HypoExponent)
 	}
 
 	return weightedScoreContribution
 }
 
-// CalculateUserFacingScore maps an internal GlukitScore to a user facing value (should be between 0 and 100)
+// CalculateUserFacingScore maps an internal GlukitScore to a user facing value between 0 and 100. The mapping was
+// fitted to values of the classic profile so values of other profiles past its minimum get the worst score.
 func CalculateUserFacingScore(internal model.GlukitScore) (external *int64) {
 	if internal.Value == model.UNDEFINED_SCORE_VALUE {
 		return nil
 	} else {
-		internalAsFloat := float64(internal.Value)
+		internalAsFloat := math.Min(float64(internal.Value), MAX_USER_FACING_INTERNAL_VALUE)
 		externalAsFloat := 100 + 1.043e-9*math.Pow(internalAsFloat, 2) + 6.517e-22*math.Pow(internalAsFloat, 4) - 0.0003676*internalAsFloat - 1.434e-15*math.Pow(internalAsFloat, 3)
-		externalAsInt := int64(externalAsFloat)
+		externalAsInt := int64(math.Max(0, math.Min(100, externalAsFloat)))
 		return &externalAsInt
 	}
 }
 
+// CalculateClassicUserFacingScore maps the classic value of an internal GlukitScore to a user facing value. Scores
+// calculated before scoring profiles were scored with the classic profile.
+func CalculateClassicUserFacingScore(internal model.GlukitScore) (external *int64) {
+	if internal.ScoringVersion < FIRST_PROFILE_SCORING_VERSION {
+		return CalculateUserFacingScore(internal)
+	}
+
+	classic := internal
+	classic.Value = internal.ClassicValue
+	return CalculateUserFacingScore(classic)
+}
+
 // StartGlukitScoreBatch tries to calculate glukit scores for any week following the most recent calculated score
 func StartGlukitScoreBatch(context context.Context, glukitUser *model.GlukitUser) (err error) {
 	lowerBoundOfLastScore := glukitUser.MostRecentScore.LowerBound
@@ -139,6 +188,32 @@ func StartGlukitScoreBatch(context context.Context, glukitUser *model.GlukitUser
 	return nil
 }
 
+// RecalculateGlukitScores calculates all glukit scores of a user again. This is needed when the user changes its
+// scoring profile or its interpolation policy. The best score is reset since scores of different profiles can't be compared.
+func RecalculateGlukitScores(context context.Context, email string) (err error) {
+	glukitUser, err := store.GetUserProfile(context, store.GetUserKey(context, email))
+	if err != nil {
+		return err
+	}
+
+	glukitUser.BestScore = model.UNDEFINED_SCORE
+	if _, err := store.StoreUserProfile(context, time.Now(), *glukitUser); err != nil {
+		return err
+	}
+
+	// Scores are recalculated from the same start as a1c estimates
+	task, err := RunGlukitScoreCalculationChunk.Task(email, A1C_CALCULATION_START)
+	if err != nil {
+		log.Criticalf(context, "Couldn't schedule the next execution of [%s] for user [%s]. "+
+			"This breaks batch calculation of glukit scores for that user!: %v", GLUKIT_SCORE_BATCH_CALCULATION_FUNCTION_NAME, email, err)
+		return err
+	}
+	taskqueue.Add(context, task, BATCH_CALCULATION_QUEUE_NAME)
+	log.Infof(context, "Queued up recalculation of glukit scores for user [%s]", email)
+
+	return nil
+}
+
 // StartA1CCalculationBatch tries to calculate a1c estimates for any week following the most recent calculated glukit score (a hack, we should have the most recent
 // a1c calculation date)
 func StartA1CCalculationBatch(context context.Context, glukitUser *model.GlukitUser) (err error) {
@@ -0,0 +1,87 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"math"
+	"testing"
+	"time"
+)
+
+func newScoredRead(value float32) apimodel.GlucoseRead {
+	return apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(time.Now()), "America/Los_Angeles"}, apimodel.MG_PER_DL, value}
+}
+
+func TestClassicReadScoreWeight(t *testing.T) {
+	for value, expected := range map[float32]float64{83: 0, 100: 34, 60: 23} {
+		if weight := engine.CalculateIndividualReadScoreWeight(nil, newScoredRead(value), engine.CLASSIC_SCORING_PROFILE); weight != expected {
+			t.Errorf("TestClassicReadScoreWeight failed, expected weight of [%f] for [%f] but got [%f]", expected, value, weight)
+		}
+	}
+}
+
+func TestReadScoreWeightWithHypoPenalty(t *testing.T) {
+	profile := model.ScoringProfile{Target: 110, HighMultiplier: 1, LowMultiplier: 3, HypoThreshold: 70, HypoMultiplier: 2, HypoExponent: 2}
+	if err := profile.Validate(); err != nil {
+		t.Fatalf("Unexpected invalid profile: %v", err)
+	}
+
+	for value, expected := range map[float32]float64{110: 0, 150: 40, 80: 90, 60: 150 + 2*100} {
+		if weight := engine.CalculateIndividualReadScoreWeight(nil, newScoredRead(value), profile); math.Abs(weight-expected) > 0.001 {
+			t.Errorf("TestReadScoreWeightWithHypoPenalty failed, expected weight of [%f] for [%f] but got [%f]", expected, value, weight)
+		}
+	}
+}
+
+func TestInvalidScoringProfiles(t *testing.T) {
+	for _, profile := range []model.ScoringProfile{
+		model.ScoringProfile{Target: 40, HighMultiplier: 1, LowMultiplier: 1},
+		model.ScoringProfile{Target: 100, HighMultiplier: 0, LowMultiplier: 1},
+		model.ScoringProfile{Target: 100, HighMultiplier: 1, LowMultiplier: 1, HypoThreshold: 120, HypoMultiplier: 1, HypoExponent: 1},
+		model.ScoringProfile{Target: 100, HighMultiplier: 1, LowMultiplier: 1, HypoThreshold: 70, HypoMultiplier: 1, HypoExponent: 5},
+		model.ScoringProfile{Target: math.NaN(), HighMultiplier: math.NaN(), LowMultiplier: math.NaN()},
+		model.ScoringProfile{Target: 100, HighMultiplier: 1, LowMultiplier: 1, HypoThreshold: 70, HypoMultiplier: math.NaN(), HypoExponent: 1},
+		model.ScoringProfile{Target: 100, HighMultiplier: math.Inf(1), LowMultiplier: 1},
+	} {
+		if err := profile.Validate(); err == nil {
+			t.Errorf("TestInvalidScoringProfiles failed, expected profile [%v] to be invalid", profile)
+		}
+	}
+}
+
+func TestClassicUserFacingScore(t *testing.T) {
+	if score := engine.CalculateClassicUserFacingScore(model.UNDEFINED_SCORE); score != nil {
+		t.Errorf("TestClassicUserFacingScore failed, expected no classic score for an undefined score but got [%d]", *score)
+	}
+
+	profiled := model.GlukitScore{Value: 40000, ClassicValue: 20000, ScoringVersion: engine.SCORING_VERSION}
+	classic := engine.CalculateClassicUserFacingScore(profiled)
+	expected := engine.CalculateUserFacingScore(model.GlukitScore{Value: 20000})
+	if classic == nil || *classic != *expected {
+		t.Errorf("TestClassicUserFacingScore failed, expected classic score [%d] but got [%v]", *expected, classic)
+	}
+
+	legacy := model.GlukitScore{Value: 20000, ScoringVersion: 1}
+	if classic := engine.CalculateClassicUserFacingScore(legacy); classic == nil || *classic != *expected {
+		t.Errorf("TestClassicUserFacingScore failed, expected the value of a legacy score to be its classic score but got [%v]", classic)
+	}
+}
+
+func TestUserFacingScoreIsClamped(t *testing.T) {
+	previous := int64(100)
+	for value := int64(0); value <= 3000000; value = value + 10000 {
+		score := engine.CalculateUserFacingScore(model.GlukitScore{Value: value})
+		if score == nil || *score < 0 || *score > 100 {
+			t.Fatalf("TestUserFacingScoreIsClamped failed, expected a user facing score between 0 and 100 for [%d] but got [%v]", value, score)
+		}
+		if *score > previous {
+			t.Errorf("TestUserFacingScoreIsClamped failed, expected the user facing score of [%d] to be at most [%d] but got [%d]", value, previous, *score)
+		}
+		previous = *score
+	}
+
+	if worst := engine.CalculateUserFacingScore(model.GlukitScore{Value: 3000000}); *worst != 0 {
+		t.Errorf("TestUserFacingScoreIsClamped failed, expected the worst user facing score of [0] but got [%d]", *worst)
+	}
+}
@@ -0,0 +1,282 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+	"sort"
+	"time"
+)
+
+const (
+	// How long reads have to stay beyond a threshold for an episode to start
+	EPISODE_MINIMUM_DURATION = time.Duration(15) * time.Minute
+
+	// How long reads have to stay back within a threshold for an episode to end
+	EPISODE_RECOVERY_DURATION = time.Duration(15) * time.Minute
+
+	// Reads further apart than this end an episode since we don't know what happened in between
+	EPISODE_MAX_READ_GAP = time.Duration(20) * time.Minute
+
+	// The time a single CGM read stands for
+	READ_INTERVAL = time.Duration(5) * time.Minute
+
+	// The number of days of reads scanned for episodes by a single batch task
+	EPISODE_DAYS_PER_BATCH = 30
+
+	// How far before a chunk reads are scanned again to pick up episodes that started in the previous one
+	EPISODE_DETECTION_OVERLAP = time.Duration(24) * time.Hour
+)
+
+// January 1st, 2014
+var EPISODE_DETECTION_START = time.Unix(1388534400, 0)
+
+// EpisodeDefinition is a threshold that reads must go beyond for an episode to be detected. Hypoglycemia
+// episodes are below their threshold and hyperglycemia episodes are above. Episodes only end once reads are back
+// within the recovery threshold, which is the threshold itself except for level 2 hypoglycemia.
+type EpisodeDefinition struct {
+	Type              string
+	Level             int
+	Threshold         float64
+	RecoveryThreshold float64
+}
+
+func (definition EpisodeDefinition) isBeyond(value float64) bool {
+	if definition.Type == model.EPISODE_TYPE_HYPOGLYCEMIA {
+		return value < definition.Threshold
+	}
+
+	return value > definition.Threshold
+}
+
+// isRecovered returns true if value is back within the recovery threshold
+func (definition EpisodeDefinition) isRecovered(value float64) bool {
+	if definition.Type == model.EPISODE_TYPE_HYPOGLYCEMIA {
+		return value >= definition.RecoveryThreshold
+	}
+
+	return value <= definition.RecoveryThreshold
+}
+
+// isMoreExtreme returns true if value is further beyond the threshold than extreme
+func (definition EpisodeDefinition) isMoreExtreme(value, extreme float64) bool {
+	if definition.Type == model.EPISODE_TYPE_HYPOGLYCEMIA {
+		return value < extreme
+	}
+
+	return value > extreme
+}
+
+// NewEpisodeDefinitions returns the consensus episode definitions (level 1 and level 2 hypoglycemia and
+// hyperglycemia) for the given time in range thresholds. Level 2 hypoglycemia only ends once reads are back to
+// the level 1 threshold.
+func NewEpisodeDefinitions(thresholds model.TimeInRangeThresholds) []EpisodeDefinition {
+	return []EpisodeDefinition{
+		EpisodeDefinition{model.EPISODE_TYPE_HYPOGLYCEMIA, 1, thresholds.Low, thresholds.Low},
+		EpisodeDefinition{model.EPISODE_TYPE_HYPOGLYCEMIA, 2, thresholds.VeryLow, thresholds.Low},
+		EpisodeDefinition{model.EPISODE_TYPE_HYPERGLYCEMIA, 1, thresholds.High, thresholds.High},
+		EpisodeDefinition{model.EPISODE_TYPE_HYPERGLYCEMIA, 2, thresholds.VeryHigh, thresholds.VeryHigh},
+	}
+}
+
+// episodeCandidate is an excursion beyond a threshold that might turn into an episode
+type episodeCandidate struct {
+	start         time.Time
+	lastBeyond    time.Time
+	extreme       timedValue
+	confirmed     bool
+	recoveryStart *time.Time
+}
+
+func (candidate *episodeCandidate) toEpisode(definition EpisodeDefinition, ongoing bool, end time.Time) model.GlycemicEpisode {
+	return model.GlycemicEpisode{
+		Type:            definition.Type,
+		Level:           definition.Level,
+		Threshold:       definition.Threshold,
+		Start:           candidate.start,
+		End:             end,
+		DurationMinutes: int(end.Sub(candidate.start).Minutes()),
+		Extreme:         candidate.extreme.value,
+		ExtremeTime:     candidate.extreme.t,
+		Ongoing:         ongoing,
+	}
+}
+
+// end returns when the episode ended when we lose track of it: the start of its recovery or its last read
+// beyond the threshold
+func (candidate *episodeCandidate) end() time.Time {
+	if candidate.recoveryStart != nil {
+		return *candidate.recoveryStart
+	}
+
+	return candidate.lastBeyond
+}
+
+// DetectEpisodes detects the episodes of every definition in reads. An episode starts once reads stay beyond its
+// threshold for EPISODE_MINIMUM_DURATION and ends with the first read of a recovery of at least
+// EPISODE_RECOVERY_DURATION within its recovery threshold. Episodes are returned sorted by start.
+func DetectEpisodes(reads []apimodel.GlucoseRead, definitions []EpisodeDefinition) (episodes []model.GlycemicEpisode, err error) {
+	values, err := getSortedTimedValues(reads)
+	if err != nil {
+		return nil, err
+	}
+
+	episodes = make([]model.GlycemicEpisode, 0)
+	for _, definition := range definitions {
+		episodes = append(episodes, detectEpisodesOfDefinition(values, definition)...)
+	}
+
+	sort.Sort(episodesByStart(episodes))
+	return episodes, nil
+}
+
+func detectEpisodesOfDefinition(values []timedValue, definition EpisodeDefinition) (episodes []model.GlycemicEpisode) {
+	var candidate *episodeCandidate
+	for i, current := range values {
+		if candidate != nil && i > 0 && current.t.Sub(values[i-1].t) > EPISODE_MAX_READ_GAP {
+			if candidate.confirmed {
+				episodes = append(episodes, candidate.toEpisode(definition, false, candidate.end()))
+			}
+			candidate = nil
+		}
+
+		if definition.isBeyond(current.value) {
+			if candidate == nil {
+				candidate = &episodeCandidate{start: current.t, extreme: current}
+			} else if definition.isMoreExtreme(current.value, candidate.extreme.value) {
+				candidate.extreme = current
+			}
+			candidate.lastBeyond = current.t
+			candidate.recoveryStart = nil
+
+			if candidate.lastBeyond.Sub(candidate.start)+READ_INTERVAL >= EPISODE_MINIMUM_DURATION {
+				candidate.confirmed = true
+			}
+		} else if candidate != nil {
+			if !candidate.confirmed {
+				// Too short to be an episode
+				candidate = nil
+				continue
+			}
+
+			if !definition.isRecovered(current.value) {
+				// Not beyond the threshold anymore but not recovered either, the episode goes on
+				candidate.lastBeyond = current.t
+				candidate.recoveryStart = nil
+				continue
+			}
+
+			if candidate.recoveryStart == nil {
+				recoveryStart := current.t
+				candidate.recoveryStart = &recoveryStart
+			}
+
+			if current.t.Sub(*candidate.recoveryStart)+READ_INTERVAL >= EPISODE_RECOVERY_DURATION {
+				episodes = append(episodes, candidate.toEpisode(definition, false, *candidate.recoveryStart))
+				candidate = nil
+			}
+		}
+	}
+
+	if candidate != nil && candidate.confirmed {
+		episodes = append(episodes, candidate.toEpisode(definition, true, values[len(values)-1].t))
+	}
+
+	return episodes
+}
+
+type episodesByStart []model.GlycemicEpisode
+
+func (slice episodesByStart) Len() int {
+	return len(slice)
+}
+
+func (slice episodesByStart) Less(i, j int) bool {
+	return slice[i].Start.Before(slice[j].Start)
+}
+
+func (slice episodesByStart) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+// StartEpisodeDetectionBatch detects the episodes of the reads following the last detection
+func StartEpisodeDetectionBatch(context context.Context, glukitUser *model.GlukitUser) (err error) {
+	progress, err := store.GetEpisodeDetectionProgress(context, glukitUser.Email, EPISODE_DETECTION_START)
+	if err != nil {
+		return err
+	}
+
+	return enqueueEpisodeDetectionChunk(context, glukitUser.Email, progress.DetectedUntil)
+}
+
+// RedetectEpisodes detects all episodes of a user again. This is needed when the user changes its thresholds.
+func RedetectEpisodes(context context.Context, email string) (err error) {
+	return enqueueEpisodeDetectionChunk(context, email, EPISODE_DETECTION_START)
+}
+
+func enqueueEpisodeDetectionChunk(context context.Context, email string, lowerBound time.Time) (err error) {
+	task, err := RunEpisodeDetectionChunk.Task(email, lowerBound)
+	if err != nil {
+		log.Criticalf(context, "Couldn't schedule the next execution of [%s] for user [%s]. "+
+			"This breaks detection of episodes for that user!: %v", EPISODE_DETECTION_FUNCTION_NAME, email, err)
+		return err
+	}
+	taskqueue.Add(context, task, BATCH_CALCULATION_QUEUE_NAME)
+	log.Infof(context, "Queued up chunk of episode detection for user [%s] and lowerBound [%s]", email, lowerBound.Format(util.TIMEFORMAT))
+
+	return nil
+}
+
+// RunEpisodeDetectionBatch detects the episodes of a chunk of EPISODE_DAYS_PER_BATCH days of reads using the user's
+// time in range thresholds and replaces the ones previously detected. It schedules itself to run again with the
+// following chunk until it reaches the present.
+func RunEpisodeDetectionBatch(context context.Context, userEmail string, lowerBound time.Time) {
+	settings, err := store.GetUserSettings(context, userEmail)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	batchUpperBound := lowerBound.AddDate(0, 0, EPISODE_DAYS_PER_BATCH)
+	reads, err := store.GetGlucoseReads(context, userEmail, lowerBound.Add(-1*EPISODE_DETECTION_OVERLAP), batchUpperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	if len(reads) > 0 {
+		episodes, err := DetectEpisodes(reads, NewEpisodeDefinitions(settings.TimeInRangeThresholds))
+		if err != nil {
+			util.Propagate(err)
+		}
+
+		// An episode starting with the first read might have started before it. The one detected with the
+		// previous chunk is kept instead.
+		firstRead := reads[0].GetTime()
+		for len(episodes) > 0 && !episodes[0].Start.After(firstRead) {
+			episodes = episodes[1:]
+		}
+
+		// Failing the task makes the queue retry it
+		util.Propagate(store.ReplaceGlycemicEpisodes(context, userEmail, firstRead.Add(time.Second), batchUpperBound, episodes))
+	}
+
+	// The last chunk only counts as detected up to its last read so that the next detection picks up from there
+	detectedUntil := batchUpperBound
+	if !batchUpperBound.Before(time.Now()) {
+		detectedUntil = lowerBound
+		if len(reads) > 0 && reads[len(reads)-1].GetTime().After(lowerBound) {
+			detectedUntil = reads[len(reads)-1].GetTime()
+		}
+	}
+
+	util.Propagate(store.StoreEpisodeDetectionProgress(context, userEmail, model.EpisodeDetectionProgress{DetectedUntil: detectedUntil}))
+
+	if batchUpperBound.Before(time.Now()) {
+		enqueueEpisodeDetectionChunk(context, userEmail, batchUpperBound)
+	} else {
+		log.Infof(context, "Done with episode detection for user [%s]", userEmail)
+	}
+}
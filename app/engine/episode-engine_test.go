@@ -0,0 +1,132 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"testing"
+	"time"
+)
+
+var HYPOGLYCEMIA_DEFINITIONS = []engine.EpisodeDefinition{engine.EpisodeDefinition{model.EPISODE_TYPE_HYPOGLYCEMIA, 1, 70, 70}}
+
+func TestDetectEpisodeWithRecovery(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := newReadsEveryFiveMinutes(ct, []float32{100, 100, 65, 60, 55, 60, 65, 100, 100, 100, 100})
+
+	episodes, err := engine.DetectEpisodes(reads, HYPOGLYCEMIA_DEFINITIONS)
+	if err != nil {
+		t.Fatalf("Unexpected error detecting episodes: %v", err)
+	}
+
+	if len(episodes) != 1 {
+		t.Fatalf("Expected a single episode but got [%d]: %v", len(episodes), episodes)
+	}
+
+	episode := episodes[0]
+	if !episode.Start.Equal(reads[2].GetTime()) {
+		t.Errorf("Expected episode to start at [%s] but got [%s]", reads[2].GetTime(), episode.Start)
+	}
+	if !episode.End.Equal(reads[7].GetTime()) {
+		t.Errorf("Expected episode to end at [%s] but got [%s]", reads[7].GetTime(), episode.End)
+	}
+	if episode.DurationMinutes != 25 {
+		t.Errorf("Expected duration of [25] minutes but got [%d]", episode.DurationMinutes)
+	}
+	if episode.Extreme != 55 || !episode.ExtremeTime.Equal(reads[4].GetTime()) {
+		t.Errorf("Expected nadir of [55] at [%s] but got [%f] at [%s]", reads[4].GetTime(), episode.Extreme, episode.ExtremeTime)
+	}
+	if episode.Ongoing {
+		t.Errorf("Expected recovered episode to not be ongoing")
+	}
+}
+
+func TestDetectEpisodesIgnoresShortExcursions(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := newReadsEveryFiveMinutes(ct, []float32{100, 65, 60, 100, 100, 100, 100})
+
+	episodes, err := engine.DetectEpisodes(reads, HYPOGLYCEMIA_DEFINITIONS)
+	if err != nil {
+		t.Fatalf("Unexpected error detecting episodes: %v", err)
+	}
+
+	if len(episodes) != 0 {
+		t.Errorf("Expected no episode for a 10 minute excursion but got %v", episodes)
+	}
+}
+
+func TestDetectOngoingEpisode(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := newReadsEveryFiveMinutes(ct, []float32{100, 60, 60, 60, 60})
+
+	episodes, err := engine.DetectEpisodes(reads, HYPOGLYCEMIA_DEFINITIONS)
+	if err != nil {
+		t.Fatalf("Unexpected error detecting episodes: %v", err)
+	}
+
+	if len(episodes) != 1 {
+		t.Fatalf("Expected a single episode but got [%d]: %v", len(episodes), episodes)
+	}
+
+	if !episodes[0].Ongoing {
+		t.Errorf("Expected episode still beyond its threshold at the end of the reads to be ongoing")
+	}
+	if !episodes[0].End.Equal(reads[4].GetTime()) {
+		t.Errorf("Expected ongoing episode to end at the last read [%s] but got [%s]", reads[4].GetTime(), episodes[0].End)
+	}
+}
+
+func TestDetectEpisodeWithInterruptedRecovery(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := newReadsEveryFiveMinutes(ct, []float32{100, 60, 60, 60, 60, 100, 100, 60, 60, 100, 100, 100, 100})
+
+	episodes, err := engine.DetectEpisodes(reads, HYPOGLYCEMIA_DEFINITIONS)
+	if err != nil {
+		t.Fatalf("Unexpected error detecting episodes: %v", err)
+	}
+
+	if len(episodes) != 1 {
+		t.Fatalf("Expected a brief recovery to not split the episode but got [%d] episodes: %v", len(episodes), episodes)
+	}
+
+	if !episodes[0].Start.Equal(reads[1].GetTime()) || !episodes[0].End.Equal(reads[9].GetTime()) {
+		t.Errorf("Expected episode from [%s] to [%s] but got [%s] to [%s]", reads[1].GetTime(), reads[9].GetTime(), episodes[0].Start, episodes[0].End)
+	}
+}
+
+func TestLevel2HypoglycemiaRecoversAtLevel1Threshold(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := newReadsEveryFiveMinutes(ct, []float32{100, 50, 50, 50, 60, 60, 60, 60, 65, 100, 100, 100, 100})
+
+	definitions := []engine.EpisodeDefinition{engine.NewEpisodeDefinitions(model.DEFAULT_TIME_IN_RANGE_THRESHOLDS)[1]}
+	episodes, err := engine.DetectEpisodes(reads, definitions)
+	if err != nil {
+		t.Fatalf("Unexpected error detecting episodes: %v", err)
+	}
+
+	if len(episodes) != 1 {
+		t.Fatalf("Expected a single level 2 episode but got [%d]: %v", len(episodes), episodes)
+	}
+
+	// Reads between 54 and 70 mg/dL aren't a recovery from level 2 hypoglycemia
+	if episode := episodes[0]; episode.Level != 2 || !episode.End.Equal(reads[9].GetTime()) {
+		t.Errorf("Expected level 2 episode to end at [%s] but got level [%d] ending at [%s]", reads[9].GetTime(), episode.Level, episode.End)
+	}
+}
+
+func TestDetectEpisodesOfEveryLevel(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := newReadsEveryFiveMinutes(ct, []float32{100, 60, 50, 50, 50, 60, 100, 100, 100, 100})
+
+	episodes, err := engine.DetectEpisodes(reads, engine.NewEpisodeDefinitions(model.DEFAULT_TIME_IN_RANGE_THRESHOLDS))
+	if err != nil {
+		t.Fatalf("Unexpected error detecting episodes: %v", err)
+	}
+
+	if len(episodes) != 2 {
+		t.Fatalf("Expected a level 1 and a level 2 episode but got [%d]: %v", len(episodes), episodes)
+	}
+
+	if episodes[0].Level != 1 || episodes[1].Level != 2 {
+		t.Errorf("Expected level 1 episode to start before level 2 episode but got levels [%d] and [%d]", episodes[0].Level, episodes[1].Level)
+	}
+}
@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// How long after an exercise session lows are considered delayed effects of the session
+	EXERCISE_DELAYED_WINDOW = time.Duration(24) * time.Hour
+
+	// The fraction of the expected reads of the delayed window needed to tell about delayed effects
+	EXERCISE_MINIMUM_DELAYED_COVERAGE = 0.5
+
+	EXERCISE_INTENSITY_UNKNOWN = "Unknown"
+)
+
+// Intensities of Dexcom exercise events, from the least to the most intense. Other intensities are grouped after
+// these in alphabetical order.
+var EXERCISE_INTENSITIES = []string{"Light", "Medium", "Heavy"}
+
+// Duration buckets by minutes of exercise
+var EXERCISE_DURATION_BUCKETS = []valueGroup{
+	valueGroup{"0-30min", 0, 30},
+	valueGroup{"30-60min", 30, 60},
+	valueGroup{"60min+", 60, math.MaxFloat64},
+}
+
+// CalculateExerciseImpacts calculates the glucose impact of every exercise session. Sessions without a duration or
+// without reads close to their start and end are skipped. Impacts are returned in the order of exercises.
+func CalculateExerciseImpacts(exercises []apimodel.Exercise, reads []apimodel.GlucoseRead, thresholds model.TimeInRangeThresholds) (impacts []model.ExerciseImpact, err error) {
+	values, err := getSortedTimedValues(reads)
+	if err != nil {
+		return nil, err
+	}
+
+	impacts = make([]model.ExerciseImpact, 0)
+	for _, exercise := range exercises {
+		if impact := calculateExerciseImpact(exercise, values, thresholds); impact != nil {
+			impacts = append(impacts, *impact)
+		}
+	}
+
+	return impacts, nil
+}
+
+func calculateExerciseImpact(exercise apimodel.Exercise, values []timedValue, thresholds model.TimeInRangeThresholds) (impact *model.ExerciseImpact) {
+	if exercise.DurationMinutes <= 0 {
+		return nil
+	}
+
+	start := exercise.GetTime()
+	end := start.Add(time.Duration(exercise.DurationMinutes) * time.Minute)
+	startGlucose, startOk := getValueNear(values, start)
+	endGlucose, endOk := getValueNear(values, end)
+	if !startOk || !endOk {
+		return nil
+	}
+
+	impact = &model.ExerciseImpact{Exercise: exercise, DurationGroup: getValueGroup(EXERCISE_DURATION_BUCKETS, float64(exercise.DurationMinutes)),
+		StartGlucose: startGlucose, EndGlucose: endGlucose, Drop: startGlucose - endGlucose, Nadir: math.Min(startGlucose, endGlucose)}
+	impact.DropPerHour = impact.Drop * 60 / float64(exercise.DurationMinutes)
+
+	first := sort.Search(len(values), func(i int) bool {
+		return !values[i].t.Before(start)
+	})
+	last := first
+	for ; last < len(values) && !values[last].t.After(end); last++ {
+		impact.Nadir = math.Min(impact.Nadir, values[last].value)
+	}
+
+	delayed := make([]timedValue, 0)
+	for i := last; i < len(values) && !values[i].t.After(end.Add(EXERCISE_DELAYED_WINDOW)); i++ {
+		delayed = append(delayed, values[i])
+	}
+	if float64(len(delayed)) < EXERCISE_MINIMUM_DELAYED_COVERAGE*float64(EXERCISE_DELAYED_WINDOW)/float64(READ_INTERVAL) {
+		return impact
+	}
+
+	delayedValues := getValues(delayed)
+	nadir := delayedValues[0]
+	for _, value := range delayedValues {
+		nadir = math.Min(nadir, value)
+	}
+	lbgi, _ := CalculateRiskIndices(delayedValues)
+	impact.DelayedNadir, impact.DelayedLBGI = &nadir, &lbgi
+
+	lows := detectEpisodesOfDefinition(delayed, EpisodeDefinition{model.EPISODE_TYPE_HYPOGLYCEMIA, 1, thresholds.Low, thresholds.Low})
+	if len(lows) > 0 {
+		impact.DelayedHypoglycemiaStart = &lows[0].Start
+	}
+
+	return impact
+}
+
+// AggregateExerciseImpacts averages exercise impacts by intensity and by duration bucket. Groups without sessions
+// are left out.
+func AggregateExerciseImpacts(impacts []model.ExerciseImpact) (byIntensity []model.ExerciseImpactAggregate, byDuration []model.ExerciseImpactAggregate) {
+	intensities := append([]string{}, EXERCISE_INTENSITIES...)
+	otherIntensities := make([]string, 0)
+	for _, impact := range impacts {
+		intensity := getExerciseIntensity(impact.Exercise)
+		if !containsString(intensities, intensity) && !containsString(otherIntensities, intensity) {
+			otherIntensities = append(otherIntensities, intensity)
+		}
+	}
+	sort.Strings(otherIntensities)
+
+	byIntensity = aggregateExerciseImpacts(impacts, append(intensities, otherIntensities...), getExerciseIntensity)
+
+	durations := make([]string, len(EXERCISE_DURATION_BUCKETS))
+	for i, bucket := range EXERCISE_DURATION_BUCKETS {
+		durations[i] = bucket.name
+	}
+	byDuration = aggregateExerciseImpacts(impacts, durations, func(exercise apimodel.Exercise) string {
+		return getValueGroup(EXERCISE_DURATION_BUCKETS, float64(exercise.DurationMinutes))
+	})
+
+	return byIntensity, byDuration
+}
+
+// getExerciseIntensity returns the intensity of an exercise as one of EXERCISE_INTENSITIES when it's one of them,
+// regardless of case
+func getExerciseIntensity(exercise apimodel.Exercise) string {
+	intensity := strings.TrimSpace(exercise.Intensity)
+	if intensity == "" {
+		return EXERCISE_INTENSITY_UNKNOWN
+	}
+
+	for _, known := range EXERCISE_INTENSITIES {
+		if strings.EqualFold(known, intensity) {
+			return known
+		}
+	}
+
+	return intensity
+}
+
+func containsString(values []string, value string) bool {
+	for _, current := range values {
+		if current == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func aggregateExerciseImpacts(impacts []model.ExerciseImpact, groups []string, groupOf func(exercise apimodel.Exercise) string) (aggregates []model.ExerciseImpactAggregate) {
+	aggregates = make([]model.ExerciseImpactAggregate, 0)
+	for _, group := range groups {
+		aggregate := model.ExerciseImpactAggregate{Group: group}
+		delayedCount := 0
+		delayedLBGISum := 0.
+		delayedHypoglycemiaCount := 0
+		for _, impact := range impacts {
+			if groupOf(impact.Exercise) != group {
+				continue
+			}
+
+			aggregate.SessionCount = aggregate.SessionCount + 1
+			aggregate.MeanDrop = aggregate.MeanDrop + impact.Drop
+			aggregate.MeanDropPerHour = aggregate.MeanDropPerHour + impact.DropPerHour
+			aggregate.MeanNadir = aggregate.MeanNadir + impact.Nadir
+			if impact.DelayedLBGI != nil {
+				delayedCount = delayedCount + 1
+				delayedLBGISum = delayedLBGISum + *impact.DelayedLBGI
+				if impact.DelayedHypoglycemiaStart != nil {
+					delayedHypoglycemiaCount = delayedHypoglycemiaCount + 1
+				}
+			}
+		}
+
+		if aggregate.SessionCount == 0 {
+			continue
+		}
+
+		aggregate.MeanDrop = aggregate.MeanDrop / float64(aggregate.SessionCount)
+		aggregate.MeanDropPerHour = aggregate.MeanDropPerHour / float64(aggregate.SessionCount)
+		aggregate.MeanNadir = aggregate.MeanNadir / float64(aggregate.SessionCount)
+		if delayedCount > 0 {
+			meanDelayedLBGI := delayedLBGISum / float64(delayedCount)
+			delayedHypoglycemiaRate := float64(delayedHypoglycemiaCount) / float64(delayedCount)
+			aggregate.MeanDelayedLBGI, aggregate.DelayedHypoglycemiaRate = &meanDelayedLBGI, &delayedHypoglycemiaRate
+		}
+
+		aggregates = append(aggregates, aggregate)
+	}
+
+	return aggregates
+}
@@ -0,0 +1,108 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"math"
+	"testing"
+	"time"
+)
+
+// newExerciseReads returns two days of reads starting at midnight. Glucose goes down linearly from 150 to 90
+// during an hour of exercise at 10 AM and, when lowAfter is set, goes down to 60 for an hour at 3 AM on the next day.
+func newExerciseReads(start time.Time, lowAfter bool) []apimodel.GlucoseRead {
+	values := make([]float32, 0)
+	for minutes := 0; minutes < 2*24*60; minutes = minutes + 5 {
+		value := 150.
+		switch {
+		case minutes >= 10*60 && minutes <= 11*60:
+			value = 150 - float64(minutes-10*60)
+		case minutes > 11*60 && minutes < 12*60:
+			value = 90
+		case lowAfter && minutes >= 27*60 && minutes < 28*60:
+			value = 60
+		}
+		values = append(values, float32(value))
+	}
+
+	return newReadsEveryFiveMinutes(start, values)
+}
+
+func newExercise(t time.Time, durationMinutes int, intensity string) apimodel.Exercise {
+	return apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(t), "America/Los_Angeles"}, durationMinutes, intensity, ""}
+}
+
+func TestCalculateExerciseImpactWithDelayedLow(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := newExerciseReads(ct, true)
+	exercises := []apimodel.Exercise{newExercise(ct.Add(time.Duration(10)*time.Hour), 60, "Medium")}
+
+	impacts, err := engine.CalculateExerciseImpacts(exercises, reads, model.DEFAULT_TIME_IN_RANGE_THRESHOLDS)
+	if err != nil {
+		t.Fatalf("Unexpected error calculating exercise impacts: %v", err)
+	}
+	if len(impacts) != 1 {
+		t.Fatalf("TestCalculateExerciseImpactWithDelayedLow failed, expected 1 impact but got [%d]", len(impacts))
+	}
+
+	impact := impacts[0]
+	if impact.StartGlucose != 150 || impact.EndGlucose != 90 || impact.Drop != 60 || impact.DropPerHour != 60 || impact.Nadir != 90 {
+		t.Errorf("TestCalculateExerciseImpactWithDelayedLow failed, got impact [%v]", impact)
+	}
+	if impact.DurationGroup != "60min+" {
+		t.Errorf("TestCalculateExerciseImpactWithDelayedLow failed, got duration group [%s]", impact.DurationGroup)
+	}
+
+	if impact.DelayedNadir == nil || *impact.DelayedNadir != 60 || impact.DelayedLBGI == nil || *impact.DelayedLBGI <= 0 {
+		t.Errorf("TestCalculateExerciseImpactWithDelayedLow failed, got delayed nadir [%v] and lbgi [%v]", impact.DelayedNadir, impact.DelayedLBGI)
+	}
+	if expected := ct.Add(time.Duration(27) * time.Hour); impact.DelayedHypoglycemiaStart == nil || !impact.DelayedHypoglycemiaStart.Equal(expected) {
+		t.Errorf("TestCalculateExerciseImpactWithDelayedLow failed, expected delayed low at [%s] but got [%v]", expected, impact.DelayedHypoglycemiaStart)
+	}
+}
+
+func TestExerciseImpactWithoutReadsIsSkipped(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := newExerciseReads(ct, false)
+	exercises := []apimodel.Exercise{newExercise(ct.Add(time.Duration(-5)*time.Hour), 30, "Light"), newExercise(ct.Add(time.Duration(10)*time.Hour), 0, "Light")}
+
+	impacts, err := engine.CalculateExerciseImpacts(exercises, reads, model.DEFAULT_TIME_IN_RANGE_THRESHOLDS)
+	if err != nil {
+		t.Fatalf("Unexpected error calculating exercise impacts: %v", err)
+	}
+	if len(impacts) != 0 {
+		t.Errorf("TestExerciseImpactWithoutReadsIsSkipped failed, expected no impacts but got [%v]", impacts)
+	}
+}
+
+func TestAggregateExerciseImpacts(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	delayedLBGI := 2.
+	impacts := []model.ExerciseImpact{
+		model.ExerciseImpact{Exercise: newExercise(ct, 20, "Heavy"), Drop: 40, DropPerHour: 120, Nadir: 80},
+		model.ExerciseImpact{Exercise: newExercise(ct, 45, "light"), Drop: 10, DropPerHour: 13, Nadir: 100, DelayedLBGI: &delayedLBGI, DelayedHypoglycemiaStart: &ct},
+		model.ExerciseImpact{Exercise: newExercise(ct, 45, "Light"), Drop: 30, DropPerHour: 40, Nadir: 90, DelayedLBGI: &delayedLBGI},
+		model.ExerciseImpact{Exercise: newExercise(ct, 90, ""), Drop: 50, DropPerHour: 33, Nadir: 70},
+	}
+
+	byIntensity, byDuration := engine.AggregateExerciseImpacts(impacts)
+	if len(byIntensity) != 3 || byIntensity[0].Group != "Light" || byIntensity[1].Group != "Heavy" || byIntensity[2].Group != engine.EXERCISE_INTENSITY_UNKNOWN {
+		t.Fatalf("TestAggregateExerciseImpacts failed, got intensity groups [%v]", byIntensity)
+	}
+
+	light := byIntensity[0]
+	if light.SessionCount != 2 || light.MeanDrop != 20 || light.MeanNadir != 95 {
+		t.Errorf("TestAggregateExerciseImpacts failed, got light aggregate [%v]", light)
+	}
+	if light.DelayedHypoglycemiaRate == nil || math.Abs(*light.DelayedHypoglycemiaRate-0.5) > 0.001 || light.MeanDelayedLBGI == nil || *light.MeanDelayedLBGI != 2 {
+		t.Errorf("TestAggregateExerciseImpacts failed, got light delayed rate [%v] and lbgi [%v]", light.DelayedHypoglycemiaRate, light.MeanDelayedLBGI)
+	}
+	if byIntensity[1].DelayedHypoglycemiaRate != nil {
+		t.Errorf("TestAggregateExerciseImpacts failed, sessions without delayed reads shouldn't have a rate but got [%v]", *byIntensity[1].DelayedHypoglycemiaRate)
+	}
+
+	if len(byDuration) != 3 || byDuration[0].SessionCount != 1 || byDuration[1].SessionCount != 2 || byDuration[2].SessionCount != 1 {
+		t.Errorf("TestAggregateExerciseImpacts failed, got duration aggregates [%v]", byDuration)
+	}
+}
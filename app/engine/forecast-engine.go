@@ -0,0 +1,358 @@
+package engine
+
+import (
+	"errors"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"math"
+	"time"
+)
+
+const (
+	// Number of previous glucose changes a forecast step depends on
+	FORECAST_ORDER = 3
+
+	// The interval between forecast steps, the usual interval between CGM reads
+	FORECAST_INTERVAL = time.Duration(5) * time.Minute
+
+	// The furthest a forecast can go
+	FORECAST_MAX_HORIZON = time.Duration(60) * time.Minute
+
+	// Number of days of reads a forecast model is fitted from
+	FORECAST_TRAINING_DAYS = 14
+
+	// The most recent fraction of the reads kept out of the fitting to measure the model's error
+	FORECAST_HOLDOUT_FRACTION = 0.2
+
+	// The minimum number of training samples required to fit a model
+	FORECAST_MINIMUM_TRAINING_SAMPLES = 100
+
+	// Forecasts are kept within what a CGM can report
+	FORECAST_MIN_VALUE = 40.
+	FORECAST_MAX_VALUE = 400.
+
+	// Regularization of the least squares fit that keeps it solvable when a feature is always zero
+	FORECAST_RIDGE = 1e-6
+)
+
+// forecastSegment is a run of reads FORECAST_INTERVAL apart along with the insulin and carbs on board at each of them
+type forecastSegment struct {
+	values         []timedValue
+	insulinOnBoard []float64
+	carbsOnBoard   []float64
+}
+
+// forecastSample is the change of glucose following a read and the features it's forecasted from
+type forecastSample struct {
+	t        time.Time
+	features []float64
+	target   float64
+}
+
+// FitForecastModel fits an autoregressive model to the reads. The insulin and carbs on board are only used if
+// onBoardSettings isn't nil. The model's error is measured by forecasting the most recent FORECAST_HOLDOUT_FRACTION of
+// the reads with a model fitted without them. It returns nil if there aren't enough reads 5 minutes apart.
+func FitForecastModel(reads []apimodel.GlucoseRead, injections []apimodel.Injection, meals []apimodel.Meal, onBoardSettings *model.OnBoardSettings) (forecastModel *model.ForecastModel, err error) {
+	values, err := getSortedTimedValues(reads)
+	if err != nil || len(values) < 2 {
+		return nil, err
+	}
+
+	segments := newForecastSegments(values, injections, meals, onBoardSettings)
+	usesOnBoard := onBoardSettings != nil
+	cutoff := values[0].t.Add(time.Duration(float64(values[len(values)-1].t.Sub(values[0].t)) * (1 - FORECAST_HOLDOUT_FRACTION)))
+
+	samples := make([]forecastSample, 0)
+	trainingSamples := make([]forecastSample, 0)
+	for _, segment := range segments {
+		for _, sample := range getForecastSamples(segment, usesOnBoard) {
+			samples = append(samples, sample)
+			if !sample.t.After(cutoff) {
+				trainingSamples = append(trainingSamples, sample)
+			}
+		}
+	}
+
+	if len(trainingSamples) < FORECAST_MINIMUM_TRAINING_SAMPLES {
+		return nil, nil
+	}
+
+	holdoutModel, err := fitForecastSamples(trainingSamples, usesOnBoard)
+	if err != nil {
+		return nil, err
+	}
+
+	forecastModel, err = fitForecastSamples(samples, usesOnBoard)
+	if err != nil {
+		return nil, err
+	}
+	forecastModel.Holdout = measureForecastError(*holdoutModel, segments, injections, meals, onBoardSettings, cutoff)
+
+	return forecastModel, nil
+}
+
+// ForecastGlucose projects glucose after the most recent read, every 5 minutes up to the horizon. The insulin and
+// carbs on board are projected from the injections and meals given, assuming nothing else happens. It returns nil if
+// the most recent reads aren't enough to start a forecast.
+func ForecastGlucose(forecastModel model.ForecastModel, reads []apimodel.GlucoseRead, injections []apimodel.Injection, meals []apimodel.Meal, onBoardSettings model.OnBoardSettings, horizon time.Duration) (points []model.ForecastPoint, err error) {
+	if horizon > FORECAST_MAX_HORIZON {
+		return nil, errors.New("Forecast horizon can't be more than an hour")
+	}
+
+	values, err := getSortedTimedValues(reads)
+	if err != nil || len(values) == 0 {
+		return nil, err
+	}
+
+	segments := newForecastSegments(values, nil, nil, nil)
+	recent := segments[len(segments)-1].values
+	if len(recent) < FORECAST_ORDER+1 {
+		return nil, nil
+	}
+
+	steps := int(horizon / FORECAST_INTERVAL)
+	times, insulinOnBoard, carbsOnBoard := projectOnBoard(forecastModel, injections, meals, &onBoardSettings, recent[len(recent)-1].t, steps)
+	forecast := predictGlucose(forecastModel, getValues(recent[len(recent)-FORECAST_ORDER-1:]), insulinOnBoard, carbsOnBoard, steps)
+	points = make([]model.ForecastPoint, steps)
+	for i := range points {
+		points[i] = model.ForecastPoint{Time: times[i+1], Value: forecast[i]}
+	}
+
+	return points, nil
+}
+
+// projectOnBoard returns the times of the origin and of every step after it along with the insulin and carbs on board
+// at those times. Those stay at zero when the model doesn't use them or there are no on board settings.
+func projectOnBoard(forecastModel model.ForecastModel, injections []apimodel.Injection, meals []apimodel.Meal, onBoardSettings *model.OnBoardSettings, origin time.Time, steps int) (times []time.Time, insulinOnBoard, carbsOnBoard []float64) {
+	times = make([]time.Time, steps+1)
+	for i := range times {
+		times[i] = origin.Add(time.Duration(i) * FORECAST_INTERVAL)
+	}
+
+	insulinOnBoard = make([]float64, steps+1)
+	carbsOnBoard = make([]float64, steps+1)
+	if forecastModel.UsesOnBoard && onBoardSettings != nil {
+		for i, value := range CalculateOnBoard(injections, meals, *onBoardSettings, times) {
+			insulinOnBoard[i], carbsOnBoard[i] = value.InsulinOnBoard, value.CarbsOnBoard
+		}
+	}
+
+	return times, insulinOnBoard, carbsOnBoard
+}
+
+// getEventsUntil returns the injections and meals that happened at or before the given time
+func getEventsUntil(injections []apimodel.Injection, meals []apimodel.Meal, until time.Time) (pastInjections []apimodel.Injection, pastMeals []apimodel.Meal) {
+	pastInjections = make([]apimodel.Injection, 0, len(injections))
+	for _, injection := range injections {
+		if !injection.GetTime().After(until) {
+			pastInjections = append(pastInjections, injection)
+		}
+	}
+
+	pastMeals = make([]apimodel.Meal, 0, len(meals))
+	for _, meal := range meals {
+		if !meal.GetTime().After(until) {
+			pastMeals = append(pastMeals, meal)
+		}
+	}
+
+	return pastInjections, pastMeals
+}
+
+// newForecastSegments splits values in runs of reads FORECAST_INTERVAL apart, give or take READ_MATCHING_TOLERANCE.
+// Reads too close to the previous one are skipped.
+func newForecastSegments(values []timedValue, injections []apimodel.Injection, meals []apimodel.Meal, onBoardSettings *model.OnBoardSettings) (segments []forecastSegment) {
+	segments = make([]forecastSegment, 0)
+	var current []timedValue
+	for _, value := range values {
+		if len(current) > 0 {
+			gap := value.t.Sub(current[len(current)-1].t)
+			if gap < FORECAST_INTERVAL-READ_MATCHING_TOLERANCE {
+				continue
+			} else if gap > FORECAST_INTERVAL+READ_MATCHING_TOLERANCE {
+				segments = append(segments, forecastSegment{values: current})
+				current = nil
+			}
+		}
+		current = append(current, value)
+	}
+	segments = append(segments, forecastSegment{values: current})
+
+	for i := range segments {
+		segments[i].insulinOnBoard = make([]float64, len(segments[i].values))
+		segments[i].carbsOnBoard = make([]float64, len(segments[i].values))
+		if onBoardSettings == nil {
+			continue
+		}
+
+		times := make([]time.Time, len(segments[i].values))
+		for j, value := range segments[i].values {
+			times[j] = value.t
+		}
+		for j, value := range CalculateOnBoard(injections, meals, *onBoardSettings, times) {
+			segments[i].insulinOnBoard[j], segments[i].carbsOnBoard[j] = value.InsulinOnBoard, value.CarbsOnBoard
+		}
+	}
+
+	return segments
+}
+
+// getForecastSamples returns a sample for every read of a segment that has FORECAST_ORDER changes before it and a
+// read after it
+func getForecastSamples(segment forecastSegment, usesOnBoard bool) (samples []forecastSample) {
+	samples = make([]forecastSample, 0)
+	for k := FORECAST_ORDER; k+1 < len(segment.values); k++ {
+		history := getValues(segment.values[k-FORECAST_ORDER : k+1])
+		features := getForecastFeatures(history, segment.insulinOnBoard[k]-segment.insulinOnBoard[k+1], segment.carbsOnBoard[k]-segment.carbsOnBoard[k+1], usesOnBoard)
+		samples = append(samples, forecastSample{segment.values[k+1].t, features, segment.values[k+1].value - segment.values[k].value})
+	}
+
+	return samples
+}
+
+// getForecastFeatures returns the most recent changes of glucose, most recent first, followed by the insulin and
+// carbs absorbed during the step when the model uses them. History holds the last FORECAST_ORDER+1 values, oldest
+// first.
+func getForecastFeatures(history []float64, insulinAbsorbed, carbsAbsorbed float64, usesOnBoard bool) (features []float64) {
+	features = make([]float64, 0, FORECAST_ORDER+2)
+	for i := len(history) - 1; i > 0; i-- {
+		features = append(features, history[i]-history[i-1])
+	}
+
+	if usesOnBoard {
+		features = append(features, insulinAbsorbed, carbsAbsorbed)
+	}
+
+	return features
+}
+
+func fitForecastSamples(samples []forecastSample, usesOnBoard bool) (forecastModel *model.ForecastModel, err error) {
+	featureCount := len(samples[0].features)
+	a := make([][]float64, featureCount)
+	b := make([]float64, featureCount)
+	for i := range a {
+		a[i] = make([]float64, featureCount)
+	}
+
+	// Normal equations of the least squares fit
+	for _, sample := range samples {
+		for i := 0; i < featureCount; i++ {
+			b[i] = b[i] + sample.features[i]*sample.target
+			for j := 0; j < featureCount; j++ {
+				a[i][j] = a[i][j] + sample.features[i]*sample.features[j]
+			}
+		}
+	}
+	for i := range a {
+		a[i][i] = a[i][i] + FORECAST_RIDGE*float64(len(samples))
+	}
+
+	coefficients, err := solveLinearSystem(a, b)
+	if err != nil {
+		return nil, err
+	}
+
+	forecastModel = &model.ForecastModel{Coefficients: coefficients[:FORECAST_ORDER], UsesOnBoard: usesOnBoard, TrainingSampleCount: len(samples)}
+	if usesOnBoard {
+		forecastModel.InsulinCoefficient, forecastModel.CarbCoefficient = coefficients[FORECAST_ORDER], coefficients[FORECAST_ORDER+1]
+	}
+
+	return forecastModel, nil
+}
+
+// solveLinearSystem solves a·x = b with gaussian elimination and partial pivoting. Both a and b are modified.
+func solveLinearSystem(a [][]float64, b []float64) (x []float64, err error) {
+	n := len(b)
+	for column := 0; column < n; column++ {
+		pivot := column
+		for row := column + 1; row < n; row++ {
+			if math.Abs(a[row][column]) > math.Abs(a[pivot][column]) {
+				pivot = row
+			}
+		}
+		if a[pivot][column] == 0 {
+			return nil, errors.New("Singular system, can't fit forecast model")
+		}
+		a[column], a[pivot] = a[pivot], a[column]
+		b[column], b[pivot] = b[pivot], b[column]
+
+		for row := column + 1; row < n; row++ {
+			factor := a[row][column] / a[column][column]
+			for k := column; k < n; k++ {
+				a[row][k] = a[row][k] - factor*a[column][k]
+			}
+			b[row] = b[row] - factor*b[column]
+		}
+	}
+
+	x = make([]float64, n)
+	for row := n - 1; row >= 0; row-- {
+		sum := b[row]
+		for k := row + 1; k < n; k++ {
+			sum = sum - a[row][k]*x[k]
+		}
+		x[row] = sum / a[row][row]
+	}
+
+	return x, nil
+}
+
+// predictGlucose forecasts steps values following history, the last FORECAST_ORDER+1 values, oldest first. Insulin
+// and carbs on board hold the values at the last read and at every step.
+func predictGlucose(forecastModel model.ForecastModel, history []float64, insulinOnBoard, carbsOnBoard []float64, steps int) (forecast []float64) {
+	window := make([]float64, len(history))
+	copy(window, history)
+
+	forecast = make([]float64, steps)
+	for step := 0; step < steps; step++ {
+		features := getForecastFeatures(window, insulinOnBoard[step]-insulinOnBoard[step+1], carbsOnBoard[step]-carbsOnBoard[step+1], forecastModel.UsesOnBoard)
+
+		change := 0.
+		for i, coefficient := range forecastModel.Coefficients {
+			change = change + coefficient*features[i]
+		}
+		if forecastModel.UsesOnBoard {
+			change = change + forecastModel.InsulinCoefficient*features[FORECAST_ORDER] + forecastModel.CarbCoefficient*features[FORECAST_ORDER+1]
+		}
+
+		value := math.Max(FORECAST_MIN_VALUE, math.Min(FORECAST_MAX_VALUE, window[len(window)-1]+change))
+		forecast[step] = value
+		window = append(window[1:], value)
+	}
+
+	return forecast
+}
+
+// measureForecastError forecasts an hour from every read after the cutoff that has an hour of reads following it
+// and compares the forecast with the reads 30 and 60 minutes later. Like a live forecast, the insulin and carbs on
+// board are projected only from the injections and meals known at the time of that read.
+func measureForecastError(forecastModel model.ForecastModel, segments []forecastSegment, injections []apimodel.Injection, meals []apimodel.Meal, onBoardSettings *model.OnBoardSettings, cutoff time.Time) (forecastError model.ForecastError) {
+	steps := int(FORECAST_MAX_HORIZON / FORECAST_INTERVAL)
+	halfway := steps / 2
+	var absolute30, squared30, absolute60, squared60 float64
+	for _, segment := range segments {
+		for k := FORECAST_ORDER; k+steps < len(segment.values); k++ {
+			if !segment.values[k].t.After(cutoff) {
+				continue
+			}
+
+			pastInjections, pastMeals := getEventsUntil(injections, meals, segment.values[k].t)
+			_, insulinOnBoard, carbsOnBoard := projectOnBoard(forecastModel, pastInjections, pastMeals, onBoardSettings, segment.values[k].t, steps)
+			forecast := predictGlucose(forecastModel, getValues(segment.values[k-FORECAST_ORDER:k+1]), insulinOnBoard, carbsOnBoard, steps)
+			error30 := forecast[halfway-1] - segment.values[k+halfway].value
+			error60 := forecast[steps-1] - segment.values[k+steps].value
+
+			forecastError.SampleCount = forecastError.SampleCount + 1
+			absolute30, squared30 = absolute30+math.Abs(error30), squared30+error30*error30
+			absolute60, squared60 = absolute60+math.Abs(error60), squared60+error60*error60
+		}
+	}
+
+	if forecastError.SampleCount > 0 {
+		count := float64(forecastError.SampleCount)
+		forecastError.MAE30, forecastError.RMSE30 = absolute30/count, math.Sqrt(squared30/count)
+		forecastError.MAE60, forecastError.RMSE60 = absolute60/count, math.Sqrt(squared60/count)
+	}
+
+	return forecastError
+}
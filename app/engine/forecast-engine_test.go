@@ -0,0 +1,89 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"math"
+	"testing"
+	"time"
+)
+
+// sineGlucose oscillates between 100 and 200 every 3 hours
+func sineGlucose(minutes int) float32 {
+	return float32(150 + 50*math.Sin(2*math.Pi*float64(minutes)/180))
+}
+
+func TestForecastOfPredictableGlucose(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	values := make([]float32, 3*288)
+	for i := range values {
+		values[i] = sineGlucose(i * 5)
+	}
+	reads := newReadsEveryFiveMinutes(ct, values)
+
+	forecastModel, err := engine.FitForecastModel(reads, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error fitting forecast model: %v", err)
+	}
+
+	if forecastModel == nil {
+		t.Fatalf("Expected a forecast model from 3 days of reads")
+	}
+
+	if forecastModel.Holdout.SampleCount == 0 || forecastModel.Holdout.MAE30 > 1 || forecastModel.Holdout.RMSE60 > 1 {
+		t.Errorf("Expected near perfect forecasts of a sine wave but got holdout error of [%v]", forecastModel.Holdout)
+	}
+
+	points, err := engine.ForecastGlucose(*forecastModel, reads, nil, nil, model.OnBoardSettings{}, engine.FORECAST_MAX_HORIZON)
+	if err != nil {
+		t.Fatalf("Unexpected error forecasting glucose: %v", err)
+	}
+
+	if len(points) != 12 {
+		t.Fatalf("Expected [12] forecast points for an hour but got [%d]", len(points))
+	}
+
+	for i, point := range points {
+		minutes := (len(values) + i) * 5
+		if expected := float64(sineGlucose(minutes)); math.Abs(point.Value-expected) > 1 {
+			t.Errorf("Expected forecast of [%f] at [%s] but got [%f]", expected, point.Time, point.Value)
+		}
+		if expectedTime := ct.Add(time.Duration(minutes) * time.Minute); !point.Time.Equal(expectedTime) {
+			t.Errorf("Expected forecast point at [%s] but got [%s]", expectedTime, point.Time)
+		}
+	}
+}
+
+func TestForecastModelRequiresEnoughReads(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	values := make([]float32, 36)
+	for i := range values {
+		values[i] = sineGlucose(i * 5)
+	}
+
+	forecastModel, err := engine.FitForecastModel(newReadsEveryFiveMinutes(ct, values), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error fitting forecast model: %v", err)
+	}
+
+	if forecastModel != nil {
+		t.Errorf("Expected no forecast model from 3 hours of reads but got [%v]", forecastModel)
+	}
+}
+
+func TestForecastModelWithNothingOnBoard(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	values := make([]float32, 288)
+	for i := range values {
+		values[i] = sineGlucose(i * 5)
+	}
+
+	forecastModel, err := engine.FitForecastModel(newReadsEveryFiveMinutes(ct, values), nil, nil, &model.OnBoardSettings{CarbAbsorptionRate: model.DEFAULT_CARB_ABSORPTION_RATE})
+	if err != nil {
+		t.Fatalf("Unexpected error fitting forecast model without injections or meals: %v", err)
+	}
+
+	if forecastModel == nil || !forecastModel.UsesOnBoard || forecastModel.InsulinCoefficient != 0 || forecastModel.CarbCoefficient != 0 {
+		t.Errorf("Expected a model using on board values with null coefficients but got [%v]", forecastModel)
+	}
+}
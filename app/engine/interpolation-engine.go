@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// Gaps shorter than this aren't missing any read, the CGM was only a little late
+	INTERPOLATION_MINIMUM_GAP = model.EXPECTED_READ_INTERVAL + model.EXPECTED_READ_INTERVAL/2
+)
+
+// InterpolateReads fills the gaps between reads according to a policy. Reads are returned sorted by time with, for
+// every gap of up to the policy's max gap, a read added every EXPECTED_READ_INTERVAL. Added reads are in mg/dL and
+// in the timezone of the read before the gap. Longer gaps are left as they are so that they stay out of calculations.
+func InterpolateReads(reads []apimodel.GlucoseRead, policy model.InterpolationPolicy) (interpolatedReads []apimodel.GlucoseRead, err error) {
+	sortedReads := make([]apimodel.GlucoseRead, len(reads))
+	copy(sortedReads, reads)
+	sort.Sort(apimodel.GlucoseReadSlice(sortedReads))
+
+	var interpolate func(values []timedValue, i int, t time.Time) float64
+	switch policy.Name {
+	case model.INTERPOLATION_POLICY_LINEAR:
+		interpolate = interpolateLinear
+	case model.INTERPOLATION_POLICY_SPLINE:
+		interpolate = interpolateSpline
+	default:
+		return sortedReads, nil
+	}
+
+	values := make([]timedValue, len(sortedReads))
+	for i, read := range sortedReads {
+		value, err := read.GetNormalizedValue(apimodel.MG_PER_DL)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = timedValue{read.GetTime(), float64(value)}
+	}
+
+	maxGap := time.Duration(policy.MaxGapMinutes) * time.Minute
+	interpolatedReads = make([]apimodel.GlucoseRead, 0, len(sortedReads))
+	for i, read := range sortedReads {
+		interpolatedReads = append(interpolatedReads, read)
+		if i == len(sortedReads)-1 {
+			break
+		}
+
+		if gap := values[i+1].t.Sub(values[i].t); gap < INTERPOLATION_MINIMUM_GAP || gap > maxGap {
+			continue
+		}
+
+		for t := values[i].t.Add(model.EXPECTED_READ_INTERVAL); values[i+1].t.Sub(t) >= model.EXPECTED_READ_INTERVAL/2; t = t.Add(model.EXPECTED_READ_INTERVAL) {
+			interpolatedReads = append(interpolatedReads, apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(t), read.Time.TimeZoneId},
+				apimodel.MG_PER_DL, float32(interpolate(values, i, t))})
+		}
+	}
+
+	return interpolatedReads, nil
+}
+
+// interpolateLinear returns the value at t on the straight line between values[i] and values[i+1]
+func interpolateLinear(values []timedValue, i int, t time.Time) float64 {
+	before, after := values[i], values[i+1]
+	return before.value + (after.value-before.value)*float64(t.Sub(before.t))/float64(after.t.Sub(before.t))
+}
+
+// interpolateSpline returns the value at t on a monotone cubic Hermite spline (Fritsch-Carlson) between values[i]
+// and values[i+1]. Being monotone, the spline never goes beyond the values around the gap so it can't make up a
+// low or a high that wasn't read.
+func interpolateSpline(values []timedValue, i int, t time.Time) float64 {
+	before, after := values[i], values[i+1]
+	gapMinutes := after.t.Sub(before.t).Minutes()
+	slope := (after.value - before.value) / gapMinutes
+
+	beforeTangent, afterTangent := 0., 0.
+	if slope != 0 {
+		beforeTangent = getSplineTangent(values, i-1, i, slope)
+		afterTangent = getSplineTangent(values, i+1, i+2, slope)
+
+		alpha, beta := math.Max(0, beforeTangent/slope), math.Max(0, afterTangent/slope)
+		if norm := alpha*alpha + beta*beta; norm > 9 {
+			alpha, beta = alpha*3/math.Sqrt(norm), beta*3/math.Sqrt(norm)
+		}
+		beforeTangent, afterTangent = alpha*slope, beta*slope
+	}
+
+	s := t.Sub(before.t).Minutes() / gapMinutes
+	return (2*s*s*s-3*s*s+1)*before.value + (s*s*s-2*s*s+s)*gapMinutes*beforeTangent +
+		(-2*s*s*s+3*s*s)*after.value + (s*s*s-s*s)*gapMinutes*afterTangent
+}
+
+// getSplineTangent returns the tangent of the spline at the end of a gap shared with the segment from values[from]
+// to values[to], which is the average of the slopes of the gap and of that segment, if there's one
+func getSplineTangent(values []timedValue, from, to int, gapSlope float64) float64 {
+	if from < 0 || to >= len(values) || !values[to].t.After(values[from].t) {
+		return gapSlope
+	}
+
+	return ((values[to].value-values[from].value)/values[to].t.Sub(values[from].t).Minutes() + gapSlope) / 2
+}
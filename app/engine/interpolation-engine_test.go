@@ -0,0 +1,99 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"math"
+	"testing"
+	"time"
+)
+
+// newReadsWithGaps returns reads every five minutes with the values of a series, leaving out the reads whose value
+// is 0
+func newReadsWithGaps(start time.Time, values []float32) (reads []apimodel.GlucoseRead) {
+	reads = make([]apimodel.GlucoseRead, 0)
+	for _, read := range newReadsEveryFiveMinutes(start, values) {
+		if read.Value != 0 {
+			reads = append(reads, read)
+		}
+	}
+
+	return reads
+}
+
+func TestNoInterpolationKeepsGaps(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := newReadsWithGaps(ct, []float32{100, 0, 0, 130})
+
+	interpolated, err := engine.InterpolateReads(reads, model.NO_INTERPOLATION)
+	if err != nil {
+		t.Fatalf("Unexpected error interpolating reads: %v", err)
+	}
+
+	if len(interpolated) != 2 {
+		t.Errorf("TestNoInterpolationKeepsGaps failed, expected [2] reads but got [%v]", interpolated)
+	}
+}
+
+func TestLinearInterpolationFillsShortGapsOnly(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	values := []float32{100, 0, 0, 130}
+	values = append(values, make([]float32, 12)...)
+	values = append(values, 150)
+	reads := newReadsWithGaps(ct, values)
+
+	interpolated, err := engine.InterpolateReads(reads, model.InterpolationPolicy{Name: model.INTERPOLATION_POLICY_LINEAR, MaxGapMinutes: 30})
+	if err != nil {
+		t.Fatalf("Unexpected error interpolating reads: %v", err)
+	}
+
+	if len(interpolated) != 5 {
+		t.Fatalf("TestLinearInterpolationFillsShortGapsOnly failed, expected [5] reads but got [%v]", interpolated)
+	}
+
+	for i, expected := range []float32{100, 110, 120, 130, 150} {
+		if read := interpolated[i]; math.Abs(float64(read.Value-expected)) > 0.001 {
+			t.Errorf("TestLinearInterpolationFillsShortGapsOnly failed, expected [%f] at [%d] but got [%v]", expected, i, read)
+		}
+	}
+
+	if expected := ct.Add(time.Duration(5) * time.Minute); !interpolated[1].GetTime().Equal(expected) {
+		t.Errorf("TestLinearInterpolationFillsShortGapsOnly failed, expected interpolated read at [%s] but got [%s]", expected, interpolated[1].GetTime())
+	}
+}
+
+func TestSplineInterpolationIsMonotone(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := newReadsWithGaps(ct, []float32{100, 102, 0, 0, 0, 0, 180, 250})
+
+	interpolated, err := engine.InterpolateReads(reads, model.InterpolationPolicy{Name: model.INTERPOLATION_POLICY_SPLINE, MaxGapMinutes: 30})
+	if err != nil {
+		t.Fatalf("Unexpected error interpolating reads: %v", err)
+	}
+
+	if len(interpolated) != 8 {
+		t.Fatalf("TestSplineInterpolationIsMonotone failed, expected [8] reads but got [%v]", interpolated)
+	}
+
+	for i := 2; i < 6; i++ {
+		if interpolated[i].Value <= interpolated[i-1].Value || interpolated[i].Value >= 180 {
+			t.Errorf("TestSplineInterpolationIsMonotone failed, expected increasing values between 102 and 180 but got [%v]", interpolated)
+		}
+	}
+}
+
+func TestVariabilityRecordsInterpolation(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := newReadsWithGaps(ct, []float32{100, 0, 0, 130})
+	policy := model.InterpolationPolicy{Name: model.INTERPOLATION_POLICY_LINEAR, MaxGapMinutes: 30}
+
+	statistics, err := engine.CalculateVariability(reads, engine.DEFAULT_CONGA_HOURS, policy)
+	if err != nil {
+		t.Fatalf("Unexpected error calculating variability: %v", err)
+	}
+
+	if statistics.Interpolation != policy || statistics.ReadCount != 2 || statistics.Mean != 115 {
+		t.Errorf("TestVariabilityRecordsInterpolation failed, got [%v]", statistics)
+	}
+}
@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/util"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+	"sort"
+	"time"
+)
+
+const (
+	// Number of windows of each of glukit scores and a1c estimates calculated again by a single task
+	INVALIDATED_WINDOWS_PER_BATCH = 30
+
+	// Length of the time slots of the recalculation of invalidated scores. All imports of a user during a slot are
+	// recalculated by a single chain of tasks that starts at the end of it.
+	INVALIDATED_RECALCULATION_SLOT = time.Duration(10) * time.Minute
+)
+
+type timesAscending []time.Time
+
+func (slice timesAscending) Len() int {
+	return len(slice)
+}
+
+func (slice timesAscending) Less(i, j int) bool {
+	return slice[i].Before(slice[j])
+}
+
+func (slice timesAscending) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+// StartInvalidatedRecalculation kicks off the recalculation of the glukit scores and a1c estimates of a user that
+// overlap days with new reads or that were calculated with an older scoring version. Unlike the other batches
+// which only move forward from the most recent calculation, this catches up on backfilled data and version bumps.
+// The first task is named after the user and the current time slot and delayed to the end of that slot so that an
+// upload of many files only queues up a single recalculation covering all of them.
+func StartInvalidatedRecalculation(context context.Context, glukitUser *model.GlukitUser) (err error) {
+	slotEnd := time.Now().Truncate(INVALIDATED_RECALCULATION_SLOT).Add(INVALIDATED_RECALCULATION_SLOT)
+	task, err := RunInvalidatedRecalculationChunk.Task(glukitUser.Email, slotEnd, time.Time{})
+	if err != nil {
+		log.Criticalf(context, "Couldn't schedule the next execution of [%s] for user [%s]. "+
+			"This breaks recalculation of invalidated scores for that user!: %v", INVALIDATED_RECALCULATION_FUNCTION_NAME, glukitUser.Email, err)
+		return err
+	}
+	userHash := fmt.Sprintf("%x", sha1.Sum([]byte(glukitUser.Email)))
+	task.Name = INVALIDATED_RECALCULATION_FUNCTION_NAME + "-" + userHash + "-" + slotEnd.UTC().Format("200601021504")
+	task.ETA = slotEnd
+
+	if _, err = taskqueue.Add(context, task, BATCH_CALCULATION_QUEUE_NAME); err == taskqueue.ErrTaskAlreadyAdded {
+		log.Debugf(context, "Invalidated score recalculation for user [%s] already queued up for [%s]", glukitUser.Email, slotEnd.Format(util.TIMEFORMAT))
+		return nil
+	} else if err != nil {
+		return err
+	}
+	log.Infof(context, "Queued up first chunk of invalidated score recalculation for user [%s] at [%s]", glukitUser.Email, slotEnd.Format(util.TIMEFORMAT))
+
+	return nil
+}
+
+// GetInvalidatedUpperBounds returns the upper bounds of the windows of periodDays that need to be calculated again,
+// oldest first. Those are the windows overlapping a dirty day and the windows of outdated calculations. Only windows
+// ending after the given bound and done by now are returned since later ones are left to the regular batches.
+func GetInvalidatedUpperBounds(dirtyDays []time.Time, outdated []time.Time, periodDays int, after time.Time, now time.Time) (upperBounds []time.Time) {
+	windows := make(map[int64]time.Time)
+	addWindow := func(upperBound time.Time) {
+		if upperBound.After(after) && !upperBound.After(now) {
+			windows[upperBound.Unix()] = upperBound
+		}
+	}
+
+	// A window ending at midnight covers the periodDays before it so a day is part of the windows ending on the
+	// periodDays midnights that follow it
+	for _, day := range dirtyDays {
+		dayStart := util.GetMidnightUTCBefore(day)
+		for i := 1; i <= periodDays; i++ {
+			addWindow(dayStart.AddDate(0, 0, i))
+		}
+	}
+
+	for _, upperBound := range outdated {
+		addWindow(getWindowUpperBound(upperBound))
+	}
+
+	upperBounds = make([]time.Time, 0, len(windows))
+	for _, upperBound := range windows {
+		upperBounds = append(upperBounds, upperBound)
+	}
+	sort.Sort(timesAscending(upperBounds))
+
+	return upperBounds
+}
+
+// getWindowUpperBound returns the midnight ending the window of a calculation. Glukit scores end on midnight but a1c
+// estimates end on their last read.
+func getWindowUpperBound(upperBound time.Time) time.Time {
+	midnight := util.GetMidnightUTCBefore(upperBound)
+	if midnight.Before(upperBound) {
+		return midnight.AddDate(0, 0, 1)
+	}
+
+	return midnight
+}
+
+// getInvalidatedBatchEnd returns the upper bound of the last window of the next batch so that a batch calculates at
+// most INVALIDATED_WINDOWS_PER_BATCH windows of each kind
+func getInvalidatedBatchEnd(scoreBounds []time.Time, a1cBounds []time.Time) (batchEnd time.Time, done bool) {
+	done = true
+	for _, bounds := range [][]time.Time{scoreBounds, a1cBounds} {
+		if len(bounds) > INVALIDATED_WINDOWS_PER_BATCH {
+			done = false
+			if last := bounds[INVALIDATED_WINDOWS_PER_BATCH-1]; batchEnd.IsZero() || last.Before(batchEnd) {
+				batchEnd = last
+			}
+		}
+	}
+
+	if done {
+		for _, bounds := range [][]time.Time{scoreBounds, a1cBounds} {
+			if len(bounds) > 0 && bounds[len(bounds)-1].After(batchEnd) {
+				batchEnd = bounds[len(bounds)-1]
+			}
+		}
+	}
+
+	return batchEnd, done
+}
@@ -0,0 +1,40 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/engine"
+	"testing"
+	"time"
+)
+
+func TestInvalidatedUpperBoundsOfDirtyDays(t *testing.T) {
+	dirtyDay := time.Date(2014, time.April, 18, 0, 0, 0, 0, time.UTC)
+	now := dirtyDay.AddDate(0, 0, 5).Add(time.Duration(3) * time.Hour)
+
+	upperBounds := engine.GetInvalidatedUpperBounds([]time.Time{dirtyDay.Add(time.Duration(13) * time.Hour), dirtyDay}, nil, engine.GLUKIT_SCORE_PERIOD, time.Time{}, now)
+	if len(upperBounds) != 5 {
+		t.Fatalf("TestInvalidatedUpperBoundsOfDirtyDays failed, expected [5] windows ending by now but got [%v]", upperBounds)
+	}
+
+	for i, upperBound := range upperBounds {
+		if expected := dirtyDay.AddDate(0, 0, i+1); !upperBound.Equal(expected) {
+			t.Errorf("TestInvalidatedUpperBoundsOfDirtyDays failed, expected window [%d] to end at [%s] but got [%s]", i, expected, upperBound)
+		}
+	}
+
+	after := dirtyDay.AddDate(0, 0, 3)
+	if upperBounds := engine.GetInvalidatedUpperBounds([]time.Time{dirtyDay}, nil, engine.GLUKIT_SCORE_PERIOD, after, now); len(upperBounds) != 2 || !upperBounds[0].Equal(after.AddDate(0, 0, 1)) {
+		t.Errorf("TestInvalidatedUpperBoundsOfDirtyDays failed, expected the [2] windows after [%s] but got [%v]", after, upperBounds)
+	}
+}
+
+func TestInvalidatedUpperBoundsOfOutdatedCalculations(t *testing.T) {
+	midnight := time.Date(2014, time.April, 18, 0, 0, 0, 0, time.UTC)
+	now := midnight.AddDate(0, 1, 0)
+	outdated := []time.Time{midnight.AddDate(0, 0, 2), midnight.Add(time.Duration(-5) * time.Minute), midnight}
+
+	upperBounds := engine.GetInvalidatedUpperBounds(nil, outdated, engine.A1C_ESTIMATION_SCORE_PERIOD, time.Time{}, now)
+	if len(upperBounds) != 2 || !upperBounds[0].Equal(midnight) || !upperBounds[1].Equal(midnight.AddDate(0, 0, 2)) {
+		t.Errorf("TestInvalidatedUpperBoundsOfOutdatedCalculations failed, expected windows ending at [%s] and [%s] but got [%v]",
+			midnight, midnight.AddDate(0, 0, 2), upperBounds)
+	}
+}
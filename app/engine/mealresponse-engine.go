@@ -0,0 +1,218 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// How long before a meal we look for the read used as its baseline
+	MEAL_BASELINE_WINDOW = time.Duration(30) * time.Minute
+
+	// How long after a meal reads are attributed to its response
+	MEAL_RESPONSE_DURATION = time.Duration(3) * time.Hour
+
+	// The period over which the incremental area under the curve is calculated
+	MEAL_INCREMENTAL_AUC_DURATION = time.Duration(2) * time.Hour
+
+	// The minimum number of reads following a meal for its response to be calculated, an hour of CGM reads
+	MEAL_RESPONSE_MINIMUM_READS = 12
+
+	MEAL_TIME_OF_DAY_MORNING = "Morning"
+	MEAL_TIME_OF_DAY_MIDDAY  = "Midday"
+	MEAL_TIME_OF_DAY_EVENING = "Evening"
+	MEAL_TIME_OF_DAY_NIGHT   = "Night"
+)
+
+// valueGroup is a group of events (i.e. meals) by a range of some value of the event. The lower bound is inclusive
+// and the upper bound exclusive. A range with a lower bound greater than its upper bound wraps around.
+type valueGroup struct {
+	name       string
+	lowerBound float64
+	upperBound float64
+}
+
+func (group valueGroup) contains(value float64) bool {
+	if group.lowerBound > group.upperBound {
+		return value >= group.lowerBound || value < group.upperBound
+	}
+
+	return value >= group.lowerBound && value < group.upperBound
+}
+
+// Times of day by the local hour at which meals were eaten
+var MEAL_TIMES_OF_DAY = []valueGroup{
+	valueGroup{MEAL_TIME_OF_DAY_MORNING, 5, 11},
+	valueGroup{MEAL_TIME_OF_DAY_MIDDAY, 11, 16},
+	valueGroup{MEAL_TIME_OF_DAY_EVENING, 16, 22},
+	valueGroup{MEAL_TIME_OF_DAY_NIGHT, 22, 5},
+}
+
+// Carb buckets by grams of carbohydrates
+var MEAL_CARB_BUCKETS = []valueGroup{
+	valueGroup{"0-15g", 0, 15},
+	valueGroup{"15-30g", 15, 30},
+	valueGroup{"30-60g", 30, 60},
+	valueGroup{"60g+", 60, math.MaxFloat64},
+}
+
+// CalculateMealResponses calculates the glucose response of every meal. Meals without a read in the
+// MEAL_BASELINE_WINDOW before them or with less than MEAL_RESPONSE_MINIMUM_READS reads after them are skipped.
+// Responses are returned in the order of meals.
+func CalculateMealResponses(meals []apimodel.Meal, reads []apimodel.GlucoseRead) (responses []model.MealResponse, err error) {
+	values, err := getSortedTimedValues(reads)
+	if err != nil {
+		return nil, err
+	}
+
+	responses = make([]model.MealResponse, 0)
+	for _, meal := range meals {
+		if response := calculateMealResponse(meal, values); response != nil {
+			responses = append(responses, *response)
+		}
+	}
+
+	return responses, nil
+}
+
+func calculateMealResponse(meal apimodel.Meal, values []timedValue) (response *model.MealResponse) {
+	mealTime := meal.GetTime()
+
+	// The first read strictly after the meal, the baseline is the one right before it
+	first := sort.Search(len(values), func(i int) bool {
+		return values[i].t.After(mealTime)
+	})
+	if first == 0 || mealTime.Sub(values[first-1].t) > MEAL_BASELINE_WINDOW {
+		return nil
+	}
+	baseline := values[first-1]
+
+	end := first
+	for end < len(values) && values[end].t.Sub(mealTime) <= MEAL_RESPONSE_DURATION {
+		end = end + 1
+	}
+	if end-first < MEAL_RESPONSE_MINIMUM_READS {
+		return nil
+	}
+
+	response = &model.MealResponse{Meal: meal, Baseline: baseline.value, TimeOfDay: getValueGroup(MEAL_TIMES_OF_DAY, float64(mealTime.Hour())),
+		CarbBucket: getValueGroup(MEAL_CARB_BUCKETS, float64(meal.Carbohydrates))}
+
+	// The peak is searched after the meal only, a response that keeps falling peaks on its first read
+	peak := values[first]
+	for _, current := range values[first+1 : end] {
+		if current.value > peak.value {
+			peak = current
+		}
+	}
+	response.Peak = peak.value
+	response.PeakExcursion = peak.value - baseline.value
+	response.TimeToPeakMinutes = int(peak.t.Sub(mealTime).Minutes())
+
+	twoHoursLater := mealTime.Add(time.Duration(2) * time.Hour)
+	var twoHourMatch *timedValue
+	for i, current := range values[first:end] {
+		distance := absDuration(current.t.Sub(twoHoursLater))
+		if distance <= READ_MATCHING_TOLERANCE && (twoHourMatch == nil || distance < absDuration(twoHourMatch.t.Sub(twoHoursLater))) {
+			twoHourMatch = &values[first+i]
+		}
+	}
+	if twoHourMatch != nil {
+		delta := twoHourMatch.value - baseline.value
+		response.TwoHourDelta = &delta
+	}
+
+	response.IncrementalAUC = calculateIncrementalAUC(baseline, values[first:end], mealTime.Add(MEAL_INCREMENTAL_AUC_DURATION))
+
+	return response
+}
+
+// calculateIncrementalAUC returns the area of the curve above the baseline with the trapezoidal rule, ignoring the
+// area below it. The curve starts at the baseline read and stops at the last read before the end.
+func calculateIncrementalAUC(baseline timedValue, values []timedValue, end time.Time) (area float64) {
+	previous := baseline
+	for _, current := range values {
+		if current.t.After(end) {
+			break
+		}
+
+		minutes := current.t.Sub(previous.t).Minutes()
+		area = area + minutes*(positiveOrZero(previous.value-baseline.value)+positiveOrZero(current.value-baseline.value))/2
+		previous = current
+	}
+
+	return area
+}
+
+func positiveOrZero(value float64) float64 {
+	if value < 0 {
+		return 0
+	}
+
+	return value
+}
+
+func getValueGroup(groups []valueGroup, value float64) string {
+	for _, group := range groups {
+		if group.contains(value) {
+			return group.name
+		}
+	}
+
+	return ""
+}
+
+// AggregateMealResponses averages meal responses by time of day and by carb bucket. Groups without meals are left
+// out.
+func AggregateMealResponses(responses []model.MealResponse) (byTimeOfDay []model.MealResponseAggregate, byCarbs []model.MealResponseAggregate) {
+	byTimeOfDay = aggregateMealResponses(responses, MEAL_TIMES_OF_DAY, func(response model.MealResponse) string {
+		return response.TimeOfDay
+	})
+	byCarbs = aggregateMealResponses(responses, MEAL_CARB_BUCKETS, func(response model.MealResponse) string {
+		return response.CarbBucket
+	})
+
+	return byTimeOfDay, byCarbs
+}
+
+func aggregateMealResponses(responses []model.MealResponse, groups []valueGroup, groupOf func(response model.MealResponse) string) (aggregates []model.MealResponseAggregate) {
+	aggregates = make([]model.MealResponseAggregate, 0)
+	for _, group := range groups {
+		aggregate := model.MealResponseAggregate{Group: group.name}
+		twoHourDeltaCount := 0
+		twoHourDeltaSum := 0.
+		for _, response := range responses {
+			if groupOf(response) != group.name {
+				continue
+			}
+
+			aggregate.MealCount = aggregate.MealCount + 1
+			aggregate.MeanPeakExcursion = aggregate.MeanPeakExcursion + response.PeakExcursion
+			aggregate.MeanTimeToPeakMinutes = aggregate.MeanTimeToPeakMinutes + float64(response.TimeToPeakMinutes)
+			aggregate.MeanIncrementalAUC = aggregate.MeanIncrementalAUC + response.IncrementalAUC
+			if response.TwoHourDelta != nil {
+				twoHourDeltaCount = twoHourDeltaCount + 1
+				twoHourDeltaSum = twoHourDeltaSum + *response.TwoHourDelta
+			}
+		}
+
+		if aggregate.MealCount == 0 {
+			continue
+		}
+
+		aggregate.MeanPeakExcursion = aggregate.MeanPeakExcursion / float64(aggregate.MealCount)
+		aggregate.MeanTimeToPeakMinutes = aggregate.MeanTimeToPeakMinutes / float64(aggregate.MealCount)
+		aggregate.MeanIncrementalAUC = aggregate.MeanIncrementalAUC / float64(aggregate.MealCount)
+		if twoHourDeltaCount > 0 {
+			meanTwoHourDelta := twoHourDeltaSum / float64(twoHourDeltaCount)
+			aggregate.MeanTwoHourDelta = &meanTwoHourDelta
+		}
+
+		aggregates = append(aggregates, aggregate)
+	}
+
+	return aggregates
+}
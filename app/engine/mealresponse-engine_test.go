@@ -0,0 +1,129 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"math"
+	"testing"
+	"time"
+)
+
+// newMealResponseReads returns reads every 5 minutes that are flat at 100 for the first 10 minutes, climb linearly
+// to 160 over an hour, go back down to 100 over the next hour and stay there for another hour
+func newMealResponseReads(start time.Time) []apimodel.GlucoseRead {
+	values := []float32{100, 100}
+	for i := 1; i <= 12; i++ {
+		values = append(values, float32(100+5*i))
+	}
+	for i := 11; i >= 0; i-- {
+		values = append(values, float32(100+5*i))
+	}
+	for i := 0; i < 12; i++ {
+		values = append(values, 100)
+	}
+
+	return newReadsEveryFiveMinutes(start, values)
+}
+
+func TestCalculateMealResponse(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := newMealResponseReads(ct)
+	meal := apimodel.Meal{Time: reads[1].Time, Carbohydrates: 45}
+
+	responses, err := engine.CalculateMealResponses([]apimodel.Meal{meal}, reads)
+	if err != nil {
+		t.Fatalf("Unexpected error calculating meal responses: %v", err)
+	}
+
+	if len(responses) != 1 {
+		t.Fatalf("Expected a single meal response but got [%d]", len(responses))
+	}
+
+	response := responses[0]
+	if response.Baseline != 100 {
+		t.Errorf("Expected baseline of [100] but got [%f]", response.Baseline)
+	}
+	if response.Peak != 160 || response.PeakExcursion != 60 {
+		t.Errorf("Expected peak of [160] and excursion of [60] but got [%f] and [%f]", response.Peak, response.PeakExcursion)
+	}
+	if response.TimeToPeakMinutes != 60 {
+		t.Errorf("Expected time to peak of [60] minutes but got [%d]", response.TimeToPeakMinutes)
+	}
+	if response.TwoHourDelta == nil || *response.TwoHourDelta != 0 {
+		t.Errorf("Expected two hour delta of [0] but got [%v]", response.TwoHourDelta)
+	}
+	if math.Abs(response.IncrementalAUC-3600) > 0.001 {
+		t.Errorf("Expected incremental area under the curve of [3600] but got [%f]", response.IncrementalAUC)
+	}
+	if response.CarbBucket != "30-60g" {
+		t.Errorf("Expected carb bucket [30-60g] but got [%s]", response.CarbBucket)
+	}
+	if response.TimeOfDay != engine.MEAL_TIME_OF_DAY_EVENING {
+		t.Errorf("Expected meal at [%s] to be in the [%s] but got [%s]", meal.GetTime(), engine.MEAL_TIME_OF_DAY_EVENING, response.TimeOfDay)
+	}
+}
+
+func TestMealResponsePeaksAfterTheMeal(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	values := make([]float32, 30)
+	for i := range values {
+		values[i] = float32(200 - 2*i)
+	}
+	reads := newReadsEveryFiveMinutes(ct, values)
+
+	// A meal between two reads of a falling curve, the baseline read before it is higher than any read after it
+	meal := apimodel.Meal{Time: apimodel.Time{apimodel.GetTimeMillis(ct.Add(time.Duration(7) * time.Minute)), "America/Los_Angeles"}, Carbohydrates: 15}
+	responses, err := engine.CalculateMealResponses([]apimodel.Meal{meal}, reads)
+	if err != nil {
+		t.Fatalf("Unexpected error calculating meal responses: %v", err)
+	}
+
+	if len(responses) != 1 {
+		t.Fatalf("Expected a single meal response but got [%d]", len(responses))
+	}
+
+	if response := responses[0]; response.TimeToPeakMinutes != 3 || response.Peak != 196 {
+		t.Errorf("Expected peak of [196] [3] minutes after the meal but got [%f] after [%d] minutes", response.Peak, response.TimeToPeakMinutes)
+	}
+}
+func TestMealWithoutBaselineIsSkipped(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := newMealResponseReads(ct)
+	meal := apimodel.Meal{Time: apimodel.Time{apimodel.GetTimeMillis(ct.Add(time.Duration(-1) * time.Hour)), "America/Los_Angeles"}, Carbohydrates: 45}
+
+	responses, err := engine.CalculateMealResponses([]apimodel.Meal{meal}, reads)
+	if err != nil {
+		t.Fatalf("Unexpected error calculating meal responses: %v", err)
+	}
+
+	if len(responses) != 0 {
+		t.Errorf("Expected meal without a read before it to be skipped but got %v", responses)
+	}
+}
+
+func TestAggregateMealResponses(t *testing.T) {
+	twoHourDelta := 20.
+	responses := []model.MealResponse{
+		model.MealResponse{PeakExcursion: 40, TimeToPeakMinutes: 45, TwoHourDelta: &twoHourDelta, IncrementalAUC: 2000, TimeOfDay: engine.MEAL_TIME_OF_DAY_MORNING, CarbBucket: "30-60g"},
+		model.MealResponse{PeakExcursion: 80, TimeToPeakMinutes: 75, IncrementalAUC: 5000, TimeOfDay: engine.MEAL_TIME_OF_DAY_MORNING, CarbBucket: "60g+"},
+	}
+
+	byTimeOfDay, byCarbs := engine.AggregateMealResponses(responses)
+
+	if len(byTimeOfDay) != 1 {
+		t.Fatalf("Expected a single time of day group but got [%d]: %v", len(byTimeOfDay), byTimeOfDay)
+	}
+
+	morning := byTimeOfDay[0]
+	if morning.MealCount != 2 || morning.MeanPeakExcursion != 60 || morning.MeanTimeToPeakMinutes != 60 || morning.MeanIncrementalAUC != 3500 {
+		t.Errorf("Unexpected aggregate of morning meals: %v", morning)
+	}
+	if morning.MeanTwoHourDelta == nil || *morning.MeanTwoHourDelta != 20 {
+		t.Errorf("Expected mean two hour delta of meals that have one to be [20] but got [%v]", morning.MeanTwoHourDelta)
+	}
+
+	if len(byCarbs) != 2 || byCarbs[0].Group != "30-60g" || byCarbs[1].Group != "60g+" {
+		t.Errorf("Expected carb groups [30-60g] and [60g+] but got %v", byCarbs)
+	}
+}
@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	// The interval between values of an on board series
+	ONBOARD_SERIES_INTERVAL = time.Duration(5) * time.Minute
+
+	// How far back injections and meals can still be on board, the longest duration of action of an insulin
+	ONBOARD_LOOKBACK = time.Duration(model.MAX_INSULIN_DURATION_MINUTES) * time.Minute
+)
+
+// GetInsulinActivityCurve returns the activity curve of an insulin type. Curves defined by the user take precedence
+// over the default ones. Insulin types without a curve, including injections that don't have a type, are assumed to
+// be rapid.
+func GetInsulinActivityCurve(curves []model.InsulinActivityCurve, insulinType string) model.InsulinActivityCurve {
+	if curve, ok := findInsulinActivityCurve(curves, insulinType); ok {
+		return curve
+	}
+
+	if curve, ok := findInsulinActivityCurve(curves, model.INSULIN_TYPE_RAPID); ok {
+		return curve
+	}
+
+	return model.DEFAULT_INSULIN_ACTIVITY_CURVES[0]
+}
+
+func findInsulinActivityCurve(curves []model.InsulinActivityCurve, insulinType string) (curve model.InsulinActivityCurve, ok bool) {
+	for _, candidates := range [][]model.InsulinActivityCurve{curves, model.DEFAULT_INSULIN_ACTIVITY_CURVES} {
+		for _, curve := range candidates {
+			if strings.EqualFold(curve.InsulinType, insulinType) {
+				return curve, true
+			}
+		}
+	}
+
+	return curve, false
+}
+
+// GetInsulinOnBoardFraction returns the fraction of an injection still active after some time with the exponential
+// insulin activity model (see https://github.com/LoopKit/Loop/issues/388)
+func GetInsulinOnBoardFraction(curve model.InsulinActivityCurve, elapsed time.Duration) float64 {
+	t := elapsed.Minutes()
+	duration := float64(curve.DurationMinutes)
+	peak := float64(curve.PeakMinutes)
+	if t < 0 || t >= duration {
+		return 0
+	}
+
+	// Time constant of the exponential decay, rise time factor and auxiliary scale factor
+	tau := peak * (1 - peak/duration) / (1 - 2*peak/duration)
+	a := 2 * tau / duration
+	s := 1 / (1 - a + (1+a)*math.Exp(-duration/tau))
+
+	return 1 - s*(1-a)*((t*t/(tau*duration*(1-a))-t/tau-1)*math.Exp(-t/tau)+1)
+}
+
+// GetCarbsOnBoard returns the grams of carbs of a meal that are still to be absorbed after some time, given a
+// linear absorption at absorptionRate grams per hour
+func GetCarbsOnBoard(carbohydrates float64, absorptionRate float64, elapsed time.Duration) float64 {
+	if elapsed < 0 {
+		return 0
+	}
+
+	return math.Max(0, carbohydrates-absorptionRate*elapsed.Hours())
+}
+
+// CalculateOnBoard calculates the insulin and carbs on board at each of the given times. Injections and meals
+// that happened within ONBOARD_LOOKBACK before the first time must be included for the first values to be complete.
+func CalculateOnBoard(injections []apimodel.Injection, meals []apimodel.Meal, settings model.OnBoardSettings, times []time.Time) (values []model.OnBoardValue) {
+	curves := make([]model.InsulinActivityCurve, len(injections))
+	for i, injection := range injections {
+		curves[i] = GetInsulinActivityCurve(settings.InsulinActivityCurves, injection.InsulinType)
+	}
+
+	values = make([]model.OnBoardValue, len(times))
+	for i, t := range times {
+		values[i].Time = t
+		for j, injection := range injections {
+			values[i].InsulinOnBoard = values[i].InsulinOnBoard + float64(injection.Units)*GetInsulinOnBoardFraction(curves[j], t.Sub(injection.GetTime()))
+		}
+		for _, meal := range meals {
+			values[i].CarbsOnBoard = values[i].CarbsOnBoard + GetCarbsOnBoard(float64(meal.Carbohydrates), settings.CarbAbsorptionRate, t.Sub(meal.GetTime()))
+		}
+	}
+
+	return values
+}
+
+// GetOnBoardSeriesTimes returns the times of an on board series between two bounds, both inclusive
+func GetOnBoardSeriesTimes(lowerBound, upperBound time.Time) (times []time.Time) {
+	times = make([]time.Time, 0)
+	for t := lowerBound; !t.After(upperBound); t = t.Add(ONBOARD_SERIES_INTERVAL) {
+		times = append(times, t)
+	}
+
+	return times
+}
@@ -0,0 +1,76 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestInsulinOnBoardFractionDecreasesOverDurationOfAction(t *testing.T) {
+	curve := engine.GetInsulinActivityCurve(nil, model.INSULIN_TYPE_RAPID)
+
+	if fraction := engine.GetInsulinOnBoardFraction(curve, 0); math.Abs(fraction-1) > 0.0001 {
+		t.Errorf("Expected all of the insulin to be on board at injection time but got [%f]", fraction)
+	}
+
+	if fraction := engine.GetInsulinOnBoardFraction(curve, time.Duration(curve.DurationMinutes)*time.Minute); fraction != 0 {
+		t.Errorf("Expected no insulin on board at the end of the duration of action but got [%f]", fraction)
+	}
+
+	if fraction := engine.GetInsulinOnBoardFraction(curve, time.Duration(-5)*time.Minute); fraction != 0 {
+		t.Errorf("Expected no insulin on board before the injection but got [%f]", fraction)
+	}
+
+	previous := 1.
+	for minutes := 5; minutes < curve.DurationMinutes; minutes = minutes + 5 {
+		fraction := engine.GetInsulinOnBoardFraction(curve, time.Duration(minutes)*time.Minute)
+		if fraction > previous || fraction < 0 {
+			t.Fatalf("Expected insulin on board to decrease over time but got [%f] after [%d] minutes following [%f]", fraction, minutes, previous)
+		}
+		previous = fraction
+	}
+}
+
+func TestInsulinActivityCurveOfUnknownTypeIsRapid(t *testing.T) {
+	userCurves := []model.InsulinActivityCurve{model.InsulinActivityCurve{InsulinType: model.INSULIN_TYPE_RAPID, PeakMinutes: 65, DurationMinutes: 300}}
+
+	if curve := engine.GetInsulinActivityCurve(userCurves, "Bolus"); curve != userCurves[0] {
+		t.Errorf("Expected unknown insulin type to use the user's rapid curve but got [%v]", curve)
+	}
+
+	if curve := engine.GetInsulinActivityCurve(userCurves, "ultrarapid"); curve != model.DEFAULT_INSULIN_ACTIVITY_CURVES[1] {
+		t.Errorf("Expected default ultra rapid curve but got [%v]", curve)
+	}
+}
+
+func TestCalculateOnBoard(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	injections := []apimodel.Injection{apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(ct), "America/Los_Angeles"}, float32(4), "Humalog", model.INSULIN_TYPE_RAPID}}
+	meals := []apimodel.Meal{apimodel.Meal{Time: apimodel.Time{apimodel.GetTimeMillis(ct), "America/Los_Angeles"}, Carbohydrates: 45}}
+	settings := model.OnBoardSettings{CarbAbsorptionRate: 30}
+
+	values := engine.CalculateOnBoard(injections, meals, settings, engine.GetOnBoardSeriesTimes(ct, ct.Add(time.Duration(2)*time.Hour)))
+
+	if len(values) != 25 {
+		t.Fatalf("Expected [25] values over 2 hours but got [%d]", len(values))
+	}
+
+	if math.Abs(values[0].InsulinOnBoard-4) > 0.0001 || values[0].CarbsOnBoard != 45 {
+		t.Errorf("Expected everything on board at the start but got [%v]", values[0])
+	}
+
+	if values[6].CarbsOnBoard != 30 {
+		t.Errorf("Expected [30] grams of carbs on board after 30 minutes but got [%f]", values[6].CarbsOnBoard)
+	}
+
+	if values[24].CarbsOnBoard != 0 {
+		t.Errorf("Expected all carbs to be absorbed after 2 hours but got [%f]", values[24].CarbsOnBoard)
+	}
+
+	if values[24].InsulinOnBoard <= 0 || values[24].InsulinOnBoard >= values[12].InsulinOnBoard {
+		t.Errorf("Expected insulin still on board after 2 hours to be less than after 1 hour but got [%f] and [%f]", values[24].InsulinOnBoard, values[12].InsulinOnBoard)
+	}
+}
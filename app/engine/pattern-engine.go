@@ -0,0 +1,322 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/delay"
+	"google.golang.org/appengine/log"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	PATTERN_DETECTION_FUNCTION_NAME = "runPatternDetection"
+
+	// Number of days of data analyzed by a pattern detection
+	PATTERN_PERIOD_DAYS = 28
+
+	// Overnight lows are lows starting between midnight and this local hour
+	PATTERN_OVERNIGHT_END_HOUR = 6
+
+	// The dawn phenomenon is the rise from the lowest read between midnight and PATTERN_DAWN_NADIR_END_HOUR to the
+	// mean of the reads between PATTERN_DAWN_MORNING_START_HOUR and PATTERN_DAWN_MORNING_END_HOUR that precede any meal
+	PATTERN_DAWN_NADIR_END_HOUR     = 5
+	PATTERN_DAWN_MORNING_START_HOUR = 6
+	PATTERN_DAWN_MORNING_END_HOUR   = 8
+	PATTERN_DAWN_MINIMUM_RISE       = 20.
+
+	// How long after the end of a low going above the high threshold is considered a rebound
+	PATTERN_REBOUND_WINDOW = time.Duration(3) * time.Hour
+
+	// The peak excursion of a meal response that makes it a spike
+	PATTERN_POST_MEAL_SPIKE_EXCURSION = 60.
+
+	// The fraction of its expected reads a window of a day must have for the day to be eligible for a pattern
+	PATTERN_MINIMUM_WINDOW_COVERAGE = 0.5
+
+	// The minimum number of days a pattern must be observed on to be reported
+	PATTERN_MINIMUM_SUPPORTING_DAYS = 3
+)
+
+// The minimum strength of each type of pattern to be reported. Lows are reported at a lower frequency since
+// they're the most dangerous and post-meal spikes have to happen after most meals of their slot to be consistent.
+var PATTERN_MINIMUM_STRENGTHS = map[string]float64{
+	model.PATTERN_TYPE_OVERNIGHT_LOW:   0.1,
+	model.PATTERN_TYPE_DAWN_PHENOMENON: 0.3,
+	model.PATTERN_TYPE_REBOUND_HIGH:    0.3,
+	model.PATTERN_TYPE_POST_MEAL_SPIKE: 0.5,
+}
+
+var RunPatternDetectionTask = delay.Func(PATTERN_DETECTION_FUNCTION_NAME, RunPatternDetection)
+
+// patternObservations are the days that were eligible for a pattern along with the magnitude of the pattern on the
+// days it was observed. When a pattern is observed more than once on a day, the most extreme magnitude is kept.
+type patternObservations struct {
+	patternType string
+	mealSlot    string
+	lowest      bool
+	eligible    map[string]bool
+	magnitudes  map[string]float64
+}
+
+func newPatternObservations(patternType string, mealSlot string, lowest bool) *patternObservations {
+	return &patternObservations{patternType, mealSlot, lowest, make(map[string]bool), make(map[string]float64)}
+}
+
+func (observations *patternObservations) addEligibleDay(date string) {
+	observations.eligible[date] = true
+}
+
+func (observations *patternObservations) observe(date string, magnitude float64) {
+	observations.eligible[date] = true
+	if previous, ok := observations.magnitudes[date]; ok && (magnitude < previous) != observations.lowest {
+		return
+	}
+	observations.magnitudes[date] = magnitude
+}
+
+// toPattern returns the pattern that was observed or nil if it wasn't observed often enough to be reported
+func (observations *patternObservations) toPattern() *model.GlucosePattern {
+	if len(observations.magnitudes) < PATTERN_MINIMUM_SUPPORTING_DAYS {
+		return nil
+	}
+
+	strength := float64(len(observations.magnitudes)) / float64(len(observations.eligible))
+	if strength < PATTERN_MINIMUM_STRENGTHS[observations.patternType] {
+		return nil
+	}
+
+	pattern := model.GlucosePattern{Type: observations.patternType, MealSlot: observations.mealSlot,
+		SupportingDays: make([]string, 0, len(observations.magnitudes)), EligibleDays: len(observations.eligible), Strength: strength}
+	magnitudes := make([]float64, 0, len(observations.magnitudes))
+	for date, magnitude := range observations.magnitudes {
+		pattern.SupportingDays = append(pattern.SupportingDays, date)
+		magnitudes = append(magnitudes, magnitude)
+	}
+	sort.Strings(pattern.SupportingDays)
+	pattern.Magnitude, _ = meanAndStandardDeviation(magnitudes)
+
+	return &pattern
+}
+
+// DetectPatterns finds the patterns that recurred over the days of a period: overnight lows, the dawn phenomenon,
+// highs rebounding from lows and spikes after the meals of each meal slot. Days are local and only days with
+// enough data to tell are eligible for a pattern. Patterns are only returned when they were observed on at least
+// PATTERN_MINIMUM_SUPPORTING_DAYS with their minimum strength.
+func DetectPatterns(reads []apimodel.GlucoseRead, meals []apimodel.Meal, thresholds model.TimeInRangeThresholds, lowerBound, upperBound time.Time) (patterns []model.GlucosePattern, err error) {
+	values, err := getSortedTimedValues(reads)
+	if err != nil {
+		return nil, err
+	}
+
+	dailyValues := make(map[string][]timedValue)
+	for _, value := range values {
+		if value.t.Before(lowerBound) || value.t.After(upperBound) {
+			continue
+		}
+		date := value.t.Format(AGP_DATE_FORMAT)
+		dailyValues[date] = append(dailyValues[date], value)
+	}
+
+	periodMeals := make([]apimodel.Meal, 0, len(meals))
+	firstMeals := make(map[string]time.Time)
+	for _, meal := range meals {
+		mealTime := meal.GetTime()
+		if mealTime.Before(lowerBound) || mealTime.After(upperBound) {
+			continue
+		}
+		periodMeals = append(periodMeals, meal)
+
+		date := mealTime.Format(AGP_DATE_FORMAT)
+		if first, ok := firstMeals[date]; !ok || mealTime.Before(first) {
+			firstMeals[date] = mealTime
+		}
+	}
+
+	lows, err := DetectEpisodes(reads, []EpisodeDefinition{EpisodeDefinition{model.EPISODE_TYPE_HYPOGLYCEMIA, 1, thresholds.Low, thresholds.Low}})
+	if err != nil {
+		return nil, err
+	}
+
+	overnightLows := newPatternObservations(model.PATTERN_TYPE_OVERNIGHT_LOW, "", true)
+	dawnPhenomenon := newPatternObservations(model.PATTERN_TYPE_DAWN_PHENOMENON, "", false)
+	for date, dayValues := range dailyValues {
+		if hasWindowCoverage(getValuesBetweenHours(dayValues, 0, PATTERN_OVERNIGHT_END_HOUR), PATTERN_OVERNIGHT_END_HOUR) {
+			overnightLows.addEligibleDay(date)
+		}
+
+		if rise, eligible := getDawnRise(dayValues, firstMeals[date], thresholds); eligible {
+			dawnPhenomenon.addEligibleDay(date)
+			if rise >= PATTERN_DAWN_MINIMUM_RISE {
+				dawnPhenomenon.observe(date, rise)
+			}
+		}
+	}
+
+	reboundHighs := newPatternObservations(model.PATTERN_TYPE_REBOUND_HIGH, "", false)
+	for _, low := range lows {
+		if low.Start.Before(lowerBound) || low.Start.After(upperBound) || low.Ongoing {
+			continue
+		}
+
+		date := low.Start.Format(AGP_DATE_FORMAT)
+		if low.Start.Hour() < PATTERN_OVERNIGHT_END_HOUR {
+			overnightLows.observe(date, low.Extreme)
+		}
+
+		reboundHighs.addEligibleDay(date)
+		if peak, ok := getPeakAfter(values, low.End, PATTERN_REBOUND_WINDOW); ok && peak > thresholds.High {
+			reboundHighs.observe(date, peak)
+		}
+	}
+
+	responses, err := CalculateMealResponses(periodMeals, reads)
+	if err != nil {
+		return nil, err
+	}
+
+	postMealSpikes := make(map[string]*patternObservations)
+	for _, timeOfDay := range MEAL_TIMES_OF_DAY {
+		postMealSpikes[timeOfDay.name] = newPatternObservations(model.PATTERN_TYPE_POST_MEAL_SPIKE, timeOfDay.name, false)
+	}
+	for _, response := range responses {
+		date := response.Meal.GetTime().Format(AGP_DATE_FORMAT)
+		postMealSpikes[response.TimeOfDay].addEligibleDay(date)
+		if response.PeakExcursion >= PATTERN_POST_MEAL_SPIKE_EXCURSION {
+			postMealSpikes[response.TimeOfDay].observe(date, response.PeakExcursion)
+		}
+	}
+
+	allObservations := []*patternObservations{overnightLows, dawnPhenomenon, reboundHighs}
+	for _, timeOfDay := range MEAL_TIMES_OF_DAY {
+		allObservations = append(allObservations, postMealSpikes[timeOfDay.name])
+	}
+
+	patterns = make([]model.GlucosePattern, 0)
+	for _, observations := range allObservations {
+		if pattern := observations.toPattern(); pattern != nil {
+			patterns = append(patterns, *pattern)
+		}
+	}
+
+	return patterns, nil
+}
+
+// getDawnRise returns the dawn rise of a day and whether the day has enough reads without a meal to tell. Days
+// with a low during the night aren't eligible since rising from it would be a rebound.
+func getDawnRise(dayValues []timedValue, firstMeal time.Time, thresholds model.TimeInRangeThresholds) (rise float64, eligible bool) {
+	night := getValuesBetweenHours(dayValues, 0, PATTERN_DAWN_NADIR_END_HOUR)
+	if !hasWindowCoverage(night, PATTERN_DAWN_NADIR_END_HOUR) || (!firstMeal.IsZero() && firstMeal.Hour() < PATTERN_DAWN_NADIR_END_HOUR) {
+		return 0, false
+	}
+
+	morning := make([]float64, 0)
+	for _, value := range getValuesBetweenHours(dayValues, PATTERN_DAWN_MORNING_START_HOUR, PATTERN_DAWN_MORNING_END_HOUR) {
+		if !firstMeal.IsZero() && !value.t.Before(firstMeal) {
+			break
+		}
+		morning = append(morning, value.value)
+	}
+	if float64(len(morning)) < PATTERN_MINIMUM_WINDOW_COVERAGE*getExpectedReadCount(PATTERN_DAWN_MORNING_END_HOUR-PATTERN_DAWN_MORNING_START_HOUR) {
+		return 0, false
+	}
+
+	nadir := night[0].value
+	for _, value := range night {
+		nadir = math.Min(nadir, value.value)
+	}
+	if nadir < thresholds.Low {
+		return 0, false
+	}
+
+	morningMean, _ := meanAndStandardDeviation(morning)
+	return morningMean - nadir, true
+}
+
+// getPeakAfter returns the highest value in the window following a time and whether there was any value in it
+func getPeakAfter(values []timedValue, t time.Time, window time.Duration) (peak float64, ok bool) {
+	i := sort.Search(len(values), func(i int) bool {
+		return values[i].t.After(t)
+	})
+
+	for ; i < len(values) && !values[i].t.After(t.Add(window)); i++ {
+		if !ok || values[i].value > peak {
+			peak = values[i].value
+			ok = true
+		}
+	}
+
+	return peak, ok
+}
+
+// getValuesBetweenHours returns the values of a day from a local hour up to, but excluding, another
+func getValuesBetweenHours(dayValues []timedValue, fromHour, toHour int) []timedValue {
+	window := make([]timedValue, 0)
+	for _, value := range dayValues {
+		if hour := value.t.Hour(); hour >= fromHour && hour < toHour {
+			window = append(window, value)
+		}
+	}
+
+	return window
+}
+
+func hasWindowCoverage(window []timedValue, hours int) bool {
+	return float64(len(window)) >= PATTERN_MINIMUM_WINDOW_COVERAGE*getExpectedReadCount(hours)
+}
+
+func getExpectedReadCount(hours int) float64 {
+	return float64(time.Duration(hours)*time.Hour) / float64(READ_INTERVAL)
+}
+
+// StartPatternDetectionBatch schedules the detection of the patterns of a user at the end of the current week and
+// right away if they were never detected
+func StartPatternDetectionBatch(context context.Context, glukitUser *model.GlukitUser) (err error) {
+	report, err := store.GetPatternReport(context, glukitUser.Email)
+	if err != nil {
+		return err
+	}
+
+	return scheduleWeeklyCalculation(context, glukitUser.Email, RunPatternDetectionTask, "patternDetection", report.CalculatedOn.IsZero())
+}
+
+// RunPatternDetection detects the patterns of the last PATTERN_PERIOD_DAYS of a user's data and replaces the
+// previous ones
+func RunPatternDetection(context context.Context, userEmail string) {
+	_, _, upperBound, err := store.GetUserData(context, userEmail)
+	if err == store.ErrNoImportedDataFound {
+		log.Infof(context, "No data for user [%s], skipping pattern detection", userEmail)
+		return
+	} else if err != nil {
+		util.Propagate(err)
+	}
+
+	settings, err := store.GetUserSettings(context, userEmail)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	lowerBound := upperBound.AddDate(0, 0, -1*PATTERN_PERIOD_DAYS)
+	reads, err := store.GetGlucoseReads(context, userEmail, lowerBound, upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+	meals, err := store.GetMeals(context, userEmail, lowerBound, upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	patterns, err := DetectPatterns(reads, meals, settings.TimeInRangeThresholds, lowerBound, upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	report := model.PatternReport{PatternDetection: model.PatternDetection{LowerBound: lowerBound, UpperBound: upperBound, CalculatedOn: time.Now()},
+		Patterns: patterns}
+	util.Propagate(store.StorePatternReport(context, userEmail, report))
+	log.Infof(context, "Detected [%d] patterns for user [%s]", len(patterns), userEmail)
+}
@@ -0,0 +1,126 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"math"
+	"testing"
+	"time"
+)
+
+// newDailyReads returns reads every five minutes for a number of days starting at local midnight in Los Angeles.
+// The value of each read is given by its minute of the day.
+func newDailyReads(days int, valueAt func(minuteOfDay int) float64) (start time.Time, reads []apimodel.GlucoseRead) {
+	location, _ := time.LoadLocation("America/Los_Angeles")
+	start = time.Date(2014, time.April, 18, 0, 0, 0, 0, location)
+
+	values := make([]float32, 0)
+	for minutes := 0; minutes < days*24*60; minutes = minutes + 5 {
+		values = append(values, float32(valueAt(minutes%(24*60))))
+	}
+
+	return start, newReadsEveryFiveMinutes(start, values)
+}
+
+func findPattern(patterns []model.GlucosePattern, patternType string, mealSlot string) *model.GlucosePattern {
+	for i := range patterns {
+		if patterns[i].Type == patternType && patterns[i].MealSlot == mealSlot {
+			return &patterns[i]
+		}
+	}
+
+	return nil
+}
+
+func TestDetectOvernightLowsWithReboundHighs(t *testing.T) {
+	start, reads := newDailyReads(7, func(minute int) float64 {
+		switch {
+		case minute >= 2*60 && minute < 3*60:
+			return 55
+		case minute >= 4*60 && minute < 5*60:
+			return 220
+		}
+		return 110
+	})
+
+	patterns, err := engine.DetectPatterns(reads, []apimodel.Meal{}, model.DEFAULT_TIME_IN_RANGE_THRESHOLDS, start, start.AddDate(0, 0, 7))
+	if err != nil {
+		t.Fatalf("Unexpected error detecting patterns: %v", err)
+	}
+
+	overnightLows := findPattern(patterns, model.PATTERN_TYPE_OVERNIGHT_LOW, "")
+	if overnightLows == nil || len(overnightLows.SupportingDays) != 7 || overnightLows.EligibleDays != 7 || overnightLows.Strength != 1 || overnightLows.Magnitude != 55 {
+		t.Errorf("TestDetectOvernightLowsWithReboundHighs failed, got overnight lows [%v]", overnightLows)
+	} else if overnightLows.SupportingDays[0] != "2014-04-18" {
+		t.Errorf("TestDetectOvernightLowsWithReboundHighs failed, got supporting days [%v]", overnightLows.SupportingDays)
+	}
+
+	reboundHighs := findPattern(patterns, model.PATTERN_TYPE_REBOUND_HIGH, "")
+	if reboundHighs == nil || len(reboundHighs.SupportingDays) != 7 || reboundHighs.Magnitude != 220 {
+		t.Errorf("TestDetectOvernightLowsWithReboundHighs failed, got rebound highs [%v]", reboundHighs)
+	}
+
+	if dawn := findPattern(patterns, model.PATTERN_TYPE_DAWN_PHENOMENON, ""); dawn != nil {
+		t.Errorf("TestDetectOvernightLowsWithReboundHighs failed, nights with lows shouldn't show a dawn phenomenon but got [%v]", dawn)
+	}
+}
+
+func TestDetectDawnPhenomenon(t *testing.T) {
+	start, reads := newDailyReads(5, func(minute int) float64 {
+		switch {
+		case minute < 5*60:
+			return 100
+		case minute < 8*60:
+			return 140
+		}
+		return 120
+	})
+
+	patterns, err := engine.DetectPatterns(reads, []apimodel.Meal{}, model.DEFAULT_TIME_IN_RANGE_THRESHOLDS, start, start.AddDate(0, 0, 5))
+	if err != nil {
+		t.Fatalf("Unexpected error detecting patterns: %v", err)
+	}
+
+	if len(patterns) != 1 {
+		t.Fatalf("TestDetectDawnPhenomenon failed, expected only the dawn phenomenon but got [%v]", patterns)
+	}
+
+	if dawn := patterns[0]; dawn.Type != model.PATTERN_TYPE_DAWN_PHENOMENON || len(dawn.SupportingDays) != 5 || math.Abs(dawn.Magnitude-40) > 0.001 {
+		t.Errorf("TestDetectDawnPhenomenon failed, got [%v]", dawn)
+	}
+}
+
+func TestDetectPostMealSpikesBySlot(t *testing.T) {
+	start, reads := newDailyReads(4, func(minute int) float64 {
+		switch {
+		case minute >= 8*60 && minute < 10*60:
+			return 110 + float64(minute-8*60)
+		case minute >= 12*60 && minute < 14*60:
+			return 110 + float64(minute-12*60)/6
+		}
+		return 110
+	})
+
+	meals := make([]apimodel.Meal, 0)
+	for day := 0; day < 4; day++ {
+		for _, hour := range []int{8, 12} {
+			mealTime := start.AddDate(0, 0, day).Add(time.Duration(hour) * time.Hour)
+			meals = append(meals, apimodel.Meal{Time: apimodel.Time{apimodel.GetTimeMillis(mealTime), "America/Los_Angeles"}, Carbohydrates: 50})
+		}
+	}
+
+	patterns, err := engine.DetectPatterns(reads, meals, model.DEFAULT_TIME_IN_RANGE_THRESHOLDS, start, start.AddDate(0, 0, 4))
+	if err != nil {
+		t.Fatalf("Unexpected error detecting patterns: %v", err)
+	}
+
+	morning := findPattern(patterns, model.PATTERN_TYPE_POST_MEAL_SPIKE, engine.MEAL_TIME_OF_DAY_MORNING)
+	if morning == nil || len(morning.SupportingDays) != 4 || morning.Strength != 1 || morning.Magnitude < engine.PATTERN_POST_MEAL_SPIKE_EXCURSION {
+		t.Errorf("TestDetectPostMealSpikesBySlot failed, got morning spikes [%v]", morning)
+	}
+
+	if midday := findPattern(patterns, model.PATTERN_TYPE_POST_MEAL_SPIKE, engine.MEAL_TIME_OF_DAY_MIDDAY); midday != nil {
+		t.Errorf("TestDetectPostMealSpikesBySlot failed, midday meals don't spike but got [%v]", midday)
+	}
+}
@@ -0,0 +1,252 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	TIME_IN_RANGE_SCORING_VERSION = 2
+
+	// The number of days of time in range calculated by a single batch task
+	TIME_IN_RANGE_DAYS_PER_BATCH = 30
+)
+
+// CalculateTimeInRange calculates the percentage of reads in each time in range band. Values are rounded to the
+// closest mg/dL before being compared to the thresholds. It returns nil if there are no reads.
+func CalculateTimeInRange(reads []apimodel.GlucoseRead, thresholds model.TimeInRangeThresholds, lowerBound, upperBound time.Time) (timeInRange *model.TimeInRange, err error) {
+	if len(reads) == 0 {
+		return nil, nil
+	}
+
+	var veryLow, low, inRange, high, veryHigh int
+	for _, read := range reads {
+		convertedValue, err := read.GetNormalizedValue(apimodel.MG_PER_DL)
+		if err != nil {
+			return nil, err
+		}
+
+		value := math.Floor(float64(convertedValue) + 0.5)
+		switch {
+		case value < thresholds.VeryLow:
+			veryLow = veryLow + 1
+		case value < thresholds.Low:
+			low = low + 1
+		case value <= thresholds.High:
+			inRange = inRange + 1
+		case value <= thresholds.VeryHigh:
+			high = high + 1
+		default:
+			veryHigh = veryHigh + 1
+		}
+	}
+
+	total := float64(len(reads))
+	return &model.TimeInRange{
+		VeryLow:        float64(veryLow) * 100 / total,
+		Low:            float64(low) * 100 / total,
+		InRange:        float64(inRange) * 100 / total,
+		High:           float64(high) * 100 / total,
+		VeryHigh:       float64(veryHigh) * 100 / total,
+		ReadCount:      len(reads),
+		Thresholds:     thresholds,
+		LowerBound:     lowerBound,
+		UpperBound:     upperBound,
+		CalculatedOn:   time.Now(),
+		ScoringVersion: TIME_IN_RANGE_SCORING_VERSION}, nil
+}
+
+// AggregateTimeInRanges combines time in ranges into a single one covering all of their periods. Each one is
+// weighted by its read count.
+func AggregateTimeInRanges(timeInRanges []model.TimeInRange) (aggregate model.TimeInRange) {
+	for i, timeInRange := range timeInRanges {
+		weight := float64(timeInRange.ReadCount)
+		aggregate.VeryLow = aggregate.VeryLow + timeInRange.VeryLow*weight
+		aggregate.Low = aggregate.Low + timeInRange.Low*weight
+		aggregate.InRange = aggregate.InRange + timeInRange.InRange*weight
+		aggregate.High = aggregate.High + timeInRange.High*weight
+		aggregate.VeryHigh = aggregate.VeryHigh + timeInRange.VeryHigh*weight
+		aggregate.ReadCount = aggregate.ReadCount + timeInRange.ReadCount
+
+		if i == 0 || timeInRange.LowerBound.Before(aggregate.LowerBound) {
+			aggregate.LowerBound = timeInRange.LowerBound
+		}
+		if i == 0 || timeInRange.UpperBound.After(aggregate.UpperBound) {
+			aggregate.UpperBound = timeInRange.UpperBound
+			aggregate.Thresholds = timeInRange.Thresholds
+		}
+		if timeInRange.CalculatedOn.After(aggregate.CalculatedOn) {
+			aggregate.CalculatedOn = timeInRange.CalculatedOn
+		}
+		aggregate.ScoringVersion = timeInRange.ScoringVersion
+	}
+
+	if aggregate.ReadCount > 0 {
+		total := float64(aggregate.ReadCount)
+		aggregate.VeryLow = aggregate.VeryLow / total
+		aggregate.Low = aggregate.Low / total
+		aggregate.InRange = aggregate.InRange / total
+		aggregate.High = aggregate.High / total
+		aggregate.VeryHigh = aggregate.VeryHigh / total
+	}
+
+	return aggregate
+}
+
+// GetRollingTimeInRanges returns, for each of the first count daily time in ranges, the aggregate of the window of
+// days ending with it. The daily time in ranges must be sorted most recent first and include the days preceding
+// the first count ones that are needed to fill their windows.
+func GetRollingTimeInRanges(dailyTimeInRanges []model.TimeInRange, count int, windowDays int) (rolling []model.TimeInRange) {
+	if count > len(dailyTimeInRanges) {
+		count = len(dailyTimeInRanges)
+	}
+
+	rolling = make([]model.TimeInRange, count)
+	for i := 0; i < count; i++ {
+		windowStart := dailyTimeInRanges[i].UpperBound.AddDate(0, 0, -1*windowDays)
+		end := i + 1
+		for end < len(dailyTimeInRanges) && dailyTimeInRanges[end].UpperBound.After(windowStart) {
+			end = end + 1
+		}
+
+		rolling[i] = AggregateTimeInRanges(dailyTimeInRanges[i:end])
+	}
+
+	return rolling
+}
+
+// StartTimeInRangeBatch calculates the daily time in ranges following the most recent one. The most recent day is
+// calculated again since it might have been calculated before all of its reads were imported. Time in ranges of an
+// older scoring version are deleted and all days are calculated again since their days might not line up with the
+// current ones.
+func StartTimeInRangeBatch(context context.Context, glukitUser *model.GlukitUser) (err error) {
+	deletedCount, err := store.DeleteOutdatedTimeInRanges(context, glukitUser.Email, TIME_IN_RANGE_SCORING_VERSION)
+	if err != nil {
+		return err
+	} else if deletedCount > 0 {
+		return enqueueTimeInRangeChunk(context, glukitUser.Email, A1C_CALCULATION_START)
+	}
+
+	limit := 1
+	lowerBound := A1C_CALCULATION_START
+	if mostRecent, err := store.GetTimeInRanges(context, glukitUser.Email, store.ScoreScanQuery{Limit: &limit}); err != nil {
+		return err
+	} else if len(mostRecent) > 0 {
+		lowerBound = mostRecent[0].LowerBound
+	}
+
+	return enqueueTimeInRangeChunk(context, glukitUser.Email, lowerBound)
+}
+
+// RecalculateTimeInRange calculates all daily time in ranges of a user again. This is needed when the user changes
+// its thresholds.
+func RecalculateTimeInRange(context context.Context, email string) (err error) {
+	return enqueueTimeInRangeChunk(context, email, A1C_CALCULATION_START)
+}
+
+func enqueueTimeInRangeChunk(context context.Context, email string, lowerBound time.Time) (err error) {
+	task, err := RunTimeInRangeCalculationChunk.Task(email, lowerBound)
+	if err != nil {
+		log.Criticalf(context, "Couldn't schedule the next execution of [%s] for user [%s]. "+
+			"This breaks batch calculation of time in range for that user!: %v", TIME_IN_RANGE_BATCH_CALCULATION_FUNCTION_NAME, email, err)
+		return err
+	}
+	taskqueue.Add(context, task, BATCH_CALCULATION_QUEUE_NAME)
+	log.Infof(context, "Queued up chunk of time in range calculation for user [%s] and lowerBound [%s]", email, lowerBound.Format(util.TIMEFORMAT))
+
+	return nil
+}
+
+// GetLocalDayBounds returns the bounds of count consecutive local days, the first one starting at the midnight
+// before lowerBound. Each day ends at the following midnight in the location in effect at its start according to the
+// timezone segments, which must be sorted by start time. The fallback location is used before the first segment.
+func GetLocalDayBounds(lowerBound time.Time, count int, segments model.TimezoneSegmentSlice, fallback *time.Location) (bounds []time.Time) {
+	bounds = make([]time.Time, count+1)
+	bounds[0] = util.GetMidnightBefore(lowerBound, segments.GetLocationAt(lowerBound, fallback))
+	for i := 1; i <= count; i++ {
+		location := segments.GetLocationAt(bounds[i-1], fallback)
+		bounds[i] = util.GetMidnightBefore(bounds[i-1].In(location).AddDate(0, 0, 1), location)
+	}
+
+	return bounds
+}
+
+// RunTimeInRangeBatchCalculation calculates the time in range of each local day of a chunk of TIME_IN_RANGE_DAYS_PER_BATCH
+// days using the user's thresholds. It schedules itself to run again with the following chunk until it reaches the present.
+func RunTimeInRangeBatchCalculation(context context.Context, userEmail string, lowerBound time.Time) {
+	settings, err := store.GetUserSettings(context, userEmail)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	_, glukitUser, err := store.GetGlukitUser(context, userEmail)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	userLocation, err := util.GetOrLoadLocationForName(glukitUser.Timezone)
+	if err != nil {
+		log.Warningf(context, "Error loading timezone [%s] of user [%s], using UTC days: %v", glukitUser.Timezone, userEmail, err)
+		userLocation = time.UTC
+	}
+
+	// Segments that end before the batch aren't scanned so the location in effect at its start is the fallback
+	fallback, err := store.GetLocationAt(context, userEmail, lowerBound, userLocation)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	// Local days can't be longer than two UTC days so this covers every segment in effect during the batch
+	segmentsUpperBound := lowerBound.AddDate(0, 0, 2*TIME_IN_RANGE_DAYS_PER_BATCH)
+	segments, err := store.GetTimezoneSegments(context, userEmail, store.ScoreScanQuery{From: &lowerBound, To: &segmentsUpperBound})
+	if err != nil {
+		util.Propagate(err)
+	}
+	sort.Sort(model.TimezoneSegmentSlice(segments))
+
+	dayBounds := GetLocalDayBounds(lowerBound, TIME_IN_RANGE_DAYS_PER_BATCH, segments, fallback)
+	batchLowerBound := dayBounds[0]
+	batchUpperBound := dayBounds[len(dayBounds)-1]
+
+	reads, err := store.GetGlucoseReads(context, userEmail, batchLowerBound, batchUpperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	timeInRanges := make([]model.TimeInRange, 0)
+	startIndex := 0
+	for i := 0; i < len(dayBounds)-1 && dayBounds[i].Before(time.Now()); i++ {
+		dayStart, dayEnd := dayBounds[i], dayBounds[i+1]
+		endIndex := startIndex
+		for endIndex < len(reads) && reads[endIndex].GetTime().Before(dayEnd) {
+			endIndex = endIndex + 1
+		}
+
+		timeInRange, err := CalculateTimeInRange(reads[startIndex:endIndex], settings.TimeInRangeThresholds, dayStart, dayEnd)
+		if err != nil {
+			util.Propagate(err)
+		}
+
+		if timeInRange != nil {
+			timeInRanges = append(timeInRanges, *timeInRange)
+		}
+		startIndex = endIndex
+	}
+
+	// Failing the task makes the queue retry it
+	util.Propagate(store.StoreTimeInRangeBatch(context, userEmail, timeInRanges))
+
+	if batchUpperBound.Before(time.Now()) {
+		enqueueTimeInRangeChunk(context, userEmail, batchUpperBound)
+	} else {
+		log.Infof(context, "Done with time in range calculation for user [%s]", userEmail)
+	}
+}
@@ -0,0 +1,96 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"testing"
+	"time"
+)
+
+func TestCalculateTimeInRangeBands(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	values := []float32{40, 54, 69, 70, 120, 180, 181, 250, 251, 300}
+	reads := make([]apimodel.GlucoseRead, len(values))
+	for i, value := range values {
+		reads[i] = apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(ct.Add(time.Duration(i*5) * time.Minute)), "America/Los_Angeles"}, apimodel.MG_PER_DL, value}
+	}
+
+	timeInRange, err := engine.CalculateTimeInRange(reads, model.DEFAULT_TIME_IN_RANGE_THRESHOLDS, ct, ct.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("Unexpected error calculating time in range: %v", err)
+	}
+
+	if timeInRange.VeryLow != 10 || timeInRange.Low != 20 || timeInRange.InRange != 30 || timeInRange.High != 20 || timeInRange.VeryHigh != 20 {
+		t.Errorf("Expected bands of [10, 20, 30, 20, 20] but got [%v]", timeInRange)
+	}
+
+	if timeInRange.ReadCount != len(values) {
+		t.Errorf("Expected read count of [%d] but got [%d]", len(values), timeInRange.ReadCount)
+	}
+}
+
+func TestCalculateTimeInRangeWithoutReads(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	timeInRange, err := engine.CalculateTimeInRange(nil, model.DEFAULT_TIME_IN_RANGE_THRESHOLDS, ct, ct.AddDate(0, 0, 1))
+	if err != nil || timeInRange != nil {
+		t.Errorf("Expected no time in range without reads but got [%v]: %v", timeInRange, err)
+	}
+}
+
+func TestRollingTimeInRangesAreWeightedByReadCount(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	daily := []model.TimeInRange{
+		model.TimeInRange{InRange: 100, ReadCount: 288, LowerBound: ct.AddDate(0, 0, 2), UpperBound: ct.AddDate(0, 0, 3)},
+		model.TimeInRange{InRange: 50, High: 50, ReadCount: 96, LowerBound: ct.AddDate(0, 0, 1), UpperBound: ct.AddDate(0, 0, 2)},
+		model.TimeInRange{InRange: 0, High: 100, ReadCount: 288, LowerBound: ct, UpperBound: ct.AddDate(0, 0, 1)},
+	}
+
+	rolling := engine.GetRollingTimeInRanges(daily, 2, 2)
+	if len(rolling) != 2 {
+		t.Fatalf("Expected [2] rolling time in ranges but got [%d]", len(rolling))
+	}
+
+	if rolling[0].ReadCount != 384 || rolling[0].InRange != 87.5 || !rolling[0].LowerBound.Equal(ct.AddDate(0, 0, 1)) {
+		t.Errorf("Expected first window with [384] reads, [87.5] in range starting on [%s] but got [%v]", ct.AddDate(0, 0, 1), rolling[0])
+	}
+
+	if rolling[1].ReadCount != 384 || rolling[1].InRange != 12.5 {
+		t.Errorf("Expected second window with [384] reads and [12.5] in range but got [%v]", rolling[1])
+	}
+}
+
+func TestLocalDayBoundsFollowTheUserLocation(t *testing.T) {
+	pacific, _ := time.LoadLocation("America/Los_Angeles")
+
+	// Daylight saving time starts on March 9th 2014 so that day is only 23 hours long
+	lowerBound := time.Date(2014, time.March, 8, 12, 0, 0, 0, time.UTC)
+	bounds := engine.GetLocalDayBounds(lowerBound, 2, nil, pacific)
+
+	expected := []time.Time{time.Date(2014, time.March, 8, 8, 0, 0, 0, time.UTC), time.Date(2014, time.March, 9, 8, 0, 0, 0, time.UTC),
+		time.Date(2014, time.March, 10, 7, 0, 0, 0, time.UTC)}
+	for i := range expected {
+		if !bounds[i].Equal(expected[i]) {
+			t.Errorf("Expected bound [%d] to be [%s] but got [%s]", i, expected[i], bounds[i])
+		}
+	}
+}
+
+func TestLocalDayBoundsFollowTimezoneSegments(t *testing.T) {
+	pacific, _ := time.LoadLocation("America/Los_Angeles")
+
+	// The user lands in New York on April 19th
+	landing := time.Date(2014, time.April, 19, 20, 0, 0, 0, time.UTC)
+	segments := model.TimezoneSegmentSlice{model.TimezoneSegment{Start: landing, End: landing.Add(72 * time.Hour), Timezone: "America/New_York"}}
+
+	lowerBound := time.Date(2014, time.April, 18, 12, 0, 0, 0, time.UTC)
+	bounds := engine.GetLocalDayBounds(lowerBound, 3, segments, pacific)
+
+	expected := []time.Time{time.Date(2014, time.April, 18, 7, 0, 0, 0, time.UTC), time.Date(2014, time.April, 19, 7, 0, 0, 0, time.UTC),
+		time.Date(2014, time.April, 20, 7, 0, 0, 0, time.UTC), time.Date(2014, time.April, 21, 4, 0, 0, 0, time.UTC)}
+	for i := range expected {
+		if !bounds[i].Equal(expected[i]) {
+			t.Errorf("Expected bound [%d] to be [%s] but got [%s]", i, expected[i], bounds[i])
+		}
+	}
+}
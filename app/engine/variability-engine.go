@@ -0,0 +1,263 @@
+package engine
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// Default number of hours between the reads compared by CONGA
+	DEFAULT_CONGA_HOURS = 1
+
+	// Maximum distance between a read and the time it's matched to when comparing reads across hours or days
+	READ_MATCHING_TOLERANCE = time.Duration(150) * time.Second
+)
+
+// timedValue is a read value in mg/dL along with its time
+type timedValue struct {
+	t     time.Time
+	value float64
+}
+
+// getSortedTimedValues returns the values of reads in mg/dL, sorted by time
+func getSortedTimedValues(reads []apimodel.GlucoseRead) (values []timedValue, err error) {
+	sortedReads := make([]apimodel.GlucoseRead, len(reads))
+	copy(sortedReads, reads)
+	sort.Sort(apimodel.GlucoseReadSlice(sortedReads))
+
+	values = make([]timedValue, len(sortedReads))
+	for i, read := range sortedReads {
+		value, err := read.GetNormalizedValue(apimodel.MG_PER_DL)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = timedValue{read.GetTime(), float64(value)}
+	}
+
+	return values, nil
+}
+
+// CalculateVariability calculates the glycemic variability statistics of a series of reads with their gaps filled
+// according to the interpolation policy. CONGA compares reads congaHours apart and MODD compares reads 24 hours
+// apart. It returns nil if there are less than 2 reads.
+func CalculateVariability(reads []apimodel.GlucoseRead, congaHours int, policy model.InterpolationPolicy) (statistics *model.VariabilityStatistics, err error) {
+	if len(reads) < 2 {
+		return nil, nil
+	}
+
+	interpolatedReads, err := InterpolateReads(reads, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := getSortedTimedValues(interpolatedReads)
+	if err != nil {
+		return nil, err
+	}
+
+	mean, sd := meanAndStandardDeviation(getValues(values))
+	lbgi, hbgi := CalculateRiskIndices(getValues(values))
+
+	// Reads of 0 are bogus but they shouldn't turn the coefficient of variation into NaN
+	coefficientOfVariation := 0.
+	if mean > 0 {
+		coefficientOfVariation = sd / mean * 100
+	}
+
+	statistics = &model.VariabilityStatistics{
+		ReadCount:              len(reads),
+		Mean:                   mean,
+		StandardDeviation:      sd,
+		CoefficientOfVariation: coefficientOfVariation,
+		MAGE:                   calculateMAGE(getValues(values), sd),
+		CONGA:                  calculateCONGA(values, time.Duration(congaHours)*time.Hour),
+		CONGAHours:             congaHours,
+		MODD:                   calculateMODD(values),
+		LBGI:                   lbgi,
+		HBGI:                   hbgi,
+		LowerBound:             values[0].t,
+		UpperBound:             values[len(values)-1].t,
+		Interpolation:          policy,
+	}
+
+	return statistics, nil
+}
+
+// CalculateRiskIndices returns the low and high blood glucose indices (Kovatchev et al.) of values in mg/dL. Values
+// below 1 mg/dL are bogus and skipped since the symmetrization isn't defined for them.
+func CalculateRiskIndices(values []float64) (lbgi, hbgi float64) {
+	count := 0
+	for _, value := range values {
+		if value < 1 {
+			continue
+		}
+		count = count + 1
+
+		// Symmetrization of the glucose scale so that hypo and hyperglycemia weigh the same
+		f := 1.509 * (math.Pow(math.Log(value), 1.084) - 5.381)
+		risk := 10 * f * f
+		if f < 0 {
+			lbgi = lbgi + risk
+		} else {
+			hbgi = hbgi + risk
+		}
+	}
+
+	if count == 0 {
+		return 0, 0
+	}
+
+	return lbgi / float64(count), hbgi / float64(count)
+}
+
+// calculateMAGE returns the mean amplitude of glycemic excursions. Only swings between a peak and a nadir larger
+// than one standard deviation are counted. Both ascending and descending excursions are included.
+func calculateMAGE(values []float64, sd float64) *float64 {
+	var amplitudes []float64
+	peak, nadir, lastExtreme := values[0], values[0], values[0]
+	direction := 0
+
+	for _, value := range values[1:] {
+		switch direction {
+		case 0:
+			peak = math.Max(peak, value)
+			nadir = math.Min(nadir, value)
+			if peak-nadir > sd {
+				if value == peak {
+					direction, lastExtreme = 1, nadir
+				} else {
+					direction, lastExtreme = -1, peak
+				}
+			}
+		case 1:
+			if value > peak {
+				peak = value
+			} else if peak-value > sd {
+				amplitudes = append(amplitudes, peak-lastExtreme)
+				direction, lastExtreme, nadir = -1, peak, value
+			}
+		case -1:
+			if value < nadir {
+				nadir = value
+			} else if value-nadir > sd {
+				amplitudes = append(amplitudes, lastExtreme-nadir)
+				direction, lastExtreme, peak = 1, nadir, value
+			}
+		}
+	}
+
+	// The excursion in progress counts if it's already large enough
+	if direction == 1 && peak-lastExtreme > sd {
+		amplitudes = append(amplitudes, peak-lastExtreme)
+	} else if direction == -1 && lastExtreme-nadir > sd {
+		amplitudes = append(amplitudes, lastExtreme-nadir)
+	}
+
+	if len(amplitudes) == 0 {
+		return nil
+	}
+
+	mage, _ := meanAndStandardDeviation(amplitudes)
+	return &mage
+}
+
+// calculateCONGA returns the continuous overall net glycemic action, the standard deviation of the differences
+// between each read and the one interval earlier
+func calculateCONGA(values []timedValue, interval time.Duration) *float64 {
+	differences := getDifferences(values, interval)
+	if len(differences) < 2 {
+		return nil
+	}
+
+	_, conga := meanAndStandardDeviation(differences)
+	return &conga
+}
+
+// calculateMODD returns the mean of daily differences, the mean absolute difference between reads taken at the
+// same time on consecutive days
+func calculateMODD(values []timedValue) *float64 {
+	differences := getDifferences(values, 24*time.Hour)
+	if len(differences) == 0 {
+		return nil
+	}
+
+	for i := range differences {
+		differences[i] = math.Abs(differences[i])
+	}
+
+	modd, _ := meanAndStandardDeviation(differences)
+	return &modd
+}
+
+// getDifferences returns the difference between each value and the value closest to interval earlier. Values
+// without a match within READ_MATCHING_TOLERANCE are skipped.
+func getDifferences(values []timedValue, interval time.Duration) (differences []float64) {
+	differences = make([]float64, 0)
+	j := 0
+	for _, current := range values {
+		target := current.t.Add(-interval)
+
+		// Advance to the last value at or before the target
+		for j+1 < len(values) && !values[j+1].t.After(target) {
+			j = j + 1
+		}
+
+		match := -1
+		for _, candidate := range []int{j, j + 1} {
+			if candidate >= len(values) || values[candidate].t.Sub(current.t) >= 0 {
+				continue
+			}
+
+			distance := values[candidate].t.Sub(target)
+			if distance < 0 {
+				distance = -distance
+			}
+
+			if distance <= READ_MATCHING_TOLERANCE && (match < 0 || distance < absDuration(values[match].t.Sub(target))) {
+				match = candidate
+			}
+		}
+
+		if match >= 0 {
+			differences = append(differences, current.value-values[match].value)
+		}
+	}
+
+	return differences
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func getValues(values []timedValue) []float64 {
+	raw := make([]float64, len(values))
+	for i, value := range values {
+		raw[i] = value.value
+	}
+	return raw
+}
+
+// meanAndStandardDeviation returns the mean and the sample standard deviation of values
+func meanAndStandardDeviation(values []float64) (mean, sd float64) {
+	for _, value := range values {
+		mean = mean + value
+	}
+	mean = mean / float64(len(values))
+
+	if len(values) < 2 {
+		return mean, 0
+	}
+
+	for _, value := range values {
+		sd = sd + (value-mean)*(value-mean)
+	}
+
+	return mean, math.Sqrt(sd / float64(len(values)-1))
+}
@@ -0,0 +1,138 @@
+package engine_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"math"
+	"testing"
+	"time"
+)
+
+func newReadsEveryFiveMinutes(start time.Time, values []float32) (reads []apimodel.GlucoseRead) {
+	reads = make([]apimodel.GlucoseRead, len(values))
+	for i, value := range values {
+		reads[i] = apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(start.Add(time.Duration(i*5) * time.Minute)), "America/Los_Angeles"}, apimodel.MG_PER_DL, value}
+	}
+
+	return reads
+}
+
+func TestVariabilityOfFlatReads(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	values := make([]float32, 36)
+	for i := range values {
+		values[i] = 110
+	}
+
+	statistics, err := engine.CalculateVariability(newReadsEveryFiveMinutes(ct, values), engine.DEFAULT_CONGA_HOURS, model.NO_INTERPOLATION)
+	if err != nil {
+		t.Fatalf("Unexpected error calculating variability: %v", err)
+	}
+
+	if statistics.Mean != 110 || statistics.StandardDeviation != 0 || statistics.CoefficientOfVariation != 0 {
+		t.Errorf("Expected mean of [110] without deviation but got [%v]", statistics)
+	}
+
+	if statistics.MAGE != nil {
+		t.Errorf("Expected no MAGE without excursions but got [%f]", *statistics.MAGE)
+	}
+
+	if statistics.CONGA == nil || *statistics.CONGA != 0 {
+		t.Errorf("Expected CONGA of [0] but got [%v]", statistics.CONGA)
+	}
+
+	if statistics.MODD != nil {
+		t.Errorf("Expected no MODD with less than a day of reads but got [%f]", *statistics.MODD)
+	}
+}
+
+func TestMAGEOfRegularExcursions(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	values := make([]float32, 0)
+	for excursion := 0; excursion < 4; excursion++ {
+		for step := 0; step < 10; step++ {
+			if excursion%2 == 0 {
+				values = append(values, float32(100+step*10))
+			} else {
+				values = append(values, float32(200-step*10))
+			}
+		}
+	}
+	values = append(values, 100)
+
+	statistics, err := engine.CalculateVariability(newReadsEveryFiveMinutes(ct, values), engine.DEFAULT_CONGA_HOURS, model.NO_INTERPOLATION)
+	if err != nil {
+		t.Fatalf("Unexpected error calculating variability: %v", err)
+	}
+
+	if statistics.MAGE == nil || *statistics.MAGE != 100 {
+		t.Errorf("Expected MAGE of [100] but got [%v]", statistics.MAGE)
+	}
+}
+
+func TestMODDOfConsecutiveDays(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	values := make([]float32, 2*288)
+	for i := range values {
+		values[i] = float32(100 + i%288%50)
+		if i >= 288 {
+			values[i] = values[i] + 10
+		}
+	}
+
+	statistics, err := engine.CalculateVariability(newReadsEveryFiveMinutes(ct, values), 2, model.NO_INTERPOLATION)
+	if err != nil {
+		t.Fatalf("Unexpected error calculating variability: %v", err)
+	}
+
+	if statistics.MODD == nil || math.Abs(*statistics.MODD-10) > 0.0001 {
+		t.Errorf("Expected MODD of [10] but got [%v]", statistics.MODD)
+	}
+
+	if statistics.CONGAHours != 2 {
+		t.Errorf("Expected CONGA hours of [2] but got [%d]", statistics.CONGAHours)
+	}
+}
+
+func TestRiskIndices(t *testing.T) {
+	lbgi, hbgi := engine.CalculateRiskIndices([]float64{40, 40})
+	if lbgi <= 0 || hbgi != 0 {
+		t.Errorf("Expected only a low risk for hypoglycemic values but got lbgi [%f] and hbgi [%f]", lbgi, hbgi)
+	}
+
+	lbgi, hbgi = engine.CalculateRiskIndices([]float64{400, 400})
+	if lbgi != 0 || hbgi <= 0 {
+		t.Errorf("Expected only a high risk for hyperglycemic values but got lbgi [%f] and hbgi [%f]", lbgi, hbgi)
+	}
+
+	lbgi, hbgi = engine.CalculateRiskIndices([]float64{112.5})
+	if lbgi > 0.01 || hbgi > 0.01 {
+		t.Errorf("Expected no risk for a value of 112.5 but got lbgi [%f] and hbgi [%f]", lbgi, hbgi)
+	}
+}
+
+func TestRiskIndicesSkipBogusValues(t *testing.T) {
+	lbgi, hbgi := engine.CalculateRiskIndices([]float64{0, 0.5, 40, 40})
+	expectedLbgi, expectedHbgi := engine.CalculateRiskIndices([]float64{40, 40})
+	if math.IsNaN(lbgi) || math.IsNaN(hbgi) || lbgi != expectedLbgi || hbgi != expectedHbgi {
+		t.Errorf("Expected lbgi [%f] and hbgi [%f] without the bogus values but got lbgi [%f] and hbgi [%f]", expectedLbgi, expectedHbgi, lbgi, hbgi)
+	}
+
+	lbgi, hbgi = engine.CalculateRiskIndices([]float64{0, 0})
+	if lbgi != 0 || hbgi != 0 {
+		t.Errorf("Expected no risk without any valid value but got lbgi [%f] and hbgi [%f]", lbgi, hbgi)
+	}
+}
+
+func TestVariabilityOfZeroReads(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	statistics, err := engine.CalculateVariability(newReadsEveryFiveMinutes(ct, []float32{0, 0, 0, 0}), engine.DEFAULT_CONGA_HOURS, model.NO_INTERPOLATION)
+	if err != nil {
+		t.Fatalf("Unexpected error calculating variability: %v", err)
+	}
+
+	if math.IsNaN(statistics.CoefficientOfVariation) || math.IsNaN(statistics.LBGI) || math.IsNaN(statistics.HBGI) {
+		t.Errorf("Expected statistics without NaN values but got [%v]", statistics)
+	}
+}
@@ -0,0 +1,160 @@
+// Package exporter provides the functions to get a user's data out of glukit in formats other tools understand.
+package exporter
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"golang.org/x/net/context"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Data types that can be exported
+const (
+	GLUCOSE_READS = "glucosereads"
+	CALIBRATIONS  = "calibrations"
+	INJECTIONS    = "injections"
+	MEALS         = "meals"
+	EXERCISES     = "exercises"
+)
+
+var ALL_DATA_TYPES = []string{GLUCOSE_READS, CALIBRATIONS, INJECTIONS, MEALS, EXERCISES}
+
+// IsSupportedDataType returns true if the data type can be exported as CSV
+func IsSupportedDataType(dataType string) bool {
+	for _, supported := range ALL_DATA_TYPES {
+		if dataType == supported {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ExportCsv streams all elements of the given data type between the two (inclusive) bounds to the writer as CSV. Glucose
+// values are normalized to the given unit and times are written as the local time in which they were recorded along with
+// their timezone.
+func ExportCsv(context context.Context, email string, dataType string, lowerBound time.Time, upperBound time.Time, glucoseUnit apimodel.GlucoseUnit, writer io.Writer) (err error) {
+	csvWriter := csv.NewWriter(writer)
+
+	switch dataType {
+	case GLUCOSE_READS:
+		csvWriter.Write([]string{"localTime", "timezone", "value", "unit"})
+		err = store.ScanGlucoseReads(context, email, lowerBound, upperBound, func(reads []apimodel.GlucoseRead) error {
+			return WriteGlucoseReadsCsv(csvWriter, reads, glucoseUnit)
+		})
+	case CALIBRATIONS:
+		csvWriter.Write([]string{"localTime", "timezone", "value", "unit"})
+		err = store.ScanCalibrations(context, email, lowerBound, upperBound, func(calibrations []apimodel.CalibrationRead) error {
+			return WriteCalibrationsCsv(csvWriter, calibrations, glucoseUnit)
+		})
+	case INJECTIONS:
+		csvWriter.Write([]string{"localTime", "timezone", "units", "insulinName", "insulinType"})
+		err = store.ScanInjections(context, email, lowerBound, upperBound, func(injections []apimodel.Injection) error {
+			return WriteInjectionsCsv(csvWriter, injections)
+		})
+	case MEALS:
+		csvWriter.Write([]string{"localTime", "timezone", "carbohydrates", "proteins", "fat", "saturatedFat"})
+		err = store.ScanMeals(context, email, lowerBound, upperBound, func(meals []apimodel.Meal) error {
+			return WriteMealsCsv(csvWriter, meals)
+		})
+	case EXERCISES:
+		csvWriter.Write([]string{"localTime", "timezone", "durationInMinutes", "intensity", "description"})
+		err = store.ScanExercises(context, email, lowerBound, upperBound, func(exercises []apimodel.Exercise) error {
+			return WriteExercisesCsv(csvWriter, exercises)
+		})
+	default:
+		return errors.New(fmt.Sprintf("Unsupported data type [%s], should be one of %v.", dataType, ALL_DATA_TYPES))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// WriteGlucoseReadsCsv writes the reads as CSV records with their value normalized to the given unit
+func WriteGlucoseReadsCsv(csvWriter *csv.Writer, reads []apimodel.GlucoseRead, glucoseUnit apimodel.GlucoseUnit) (err error) {
+	for _, read := range reads {
+		value, err := read.GetNormalizedValue(glucoseUnit)
+		if err != nil {
+			return err
+		}
+
+		if err = csvWriter.Write(append(formatLocalTime(read.Time), formatFloat(value), string(glucoseUnit))); err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// WriteCalibrationsCsv writes the calibrations as CSV records with their value normalized to the given unit
+func WriteCalibrationsCsv(csvWriter *csv.Writer, calibrations []apimodel.CalibrationRead, glucoseUnit apimodel.GlucoseUnit) (err error) {
+	for _, calibration := range calibrations {
+		value, err := calibration.GetNormalizedValue(glucoseUnit)
+		if err != nil {
+			return err
+		}
+
+		if err = csvWriter.Write(append(formatLocalTime(calibration.Time), formatFloat(value), string(glucoseUnit))); err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// WriteInjectionsCsv writes the injections as CSV records
+func WriteInjectionsCsv(csvWriter *csv.Writer, injections []apimodel.Injection) (err error) {
+	for _, injection := range injections {
+		if err = csvWriter.Write(append(formatLocalTime(injection.Time), formatFloat(injection.Units), injection.InsulinName, injection.InsulinType)); err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// WriteMealsCsv writes the meals as CSV records
+func WriteMealsCsv(csvWriter *csv.Writer, meals []apimodel.Meal) (err error) {
+	for _, meal := range meals {
+		if err = csvWriter.Write(append(formatLocalTime(meal.Time), formatFloat(meal.Carbohydrates), formatFloat(meal.Proteins), formatFloat(meal.Fat), formatFloat(meal.SaturatedFat))); err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// WriteExercisesCsv writes the exercises as CSV records
+func WriteExercisesCsv(csvWriter *csv.Writer, exercises []apimodel.Exercise) (err error) {
+	for _, exercise := range exercises {
+		if err = csvWriter.Write(append(formatLocalTime(exercise.Time), strconv.Itoa(exercise.DurationMinutes), exercise.Intensity, exercise.Description)); err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// formatLocalTime returns the local time and timezone fields of a time value
+func formatLocalTime(value apimodel.Time) []string {
+	return []string{value.GetTime().Format(util.TIMEFORMAT_NO_TZ), value.TimeZoneId}
+}
+
+func formatFloat(value float32) string {
+	return strconv.FormatFloat(float64(value), 'f', -1, 32)
+}
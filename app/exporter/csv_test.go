@@ -0,0 +1,40 @@
+package exporter_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	. "github.com/alexandre-normand/glukit/app/exporter"
+	"testing"
+	"time"
+)
+
+func TestWriteGlucoseReadsCsvInLocalTimeAndUnit(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 16:00")
+	reads := []apimodel.GlucoseRead{apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(ct), "America/Los_Angeles"}, apimodel.MG_PER_DL, float32(180)}}
+
+	var buffer bytes.Buffer
+	if err := WriteGlucoseReadsCsv(csv.NewWriter(&buffer), reads, apimodel.MMOL_PER_L); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "2014-04-18 09:00:00,America/Los_Angeles,9.99,mmolPerL\n"
+	if buffer.String() != expected {
+		t.Errorf("Expected csv [%s] but got [%s]", expected, buffer.String())
+	}
+}
+
+func TestWriteExercisesCsv(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 16:00")
+	exercises := []apimodel.Exercise{apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(ct), "-0400"}, 45, "Medium", "Run, uphill"}}
+
+	var buffer bytes.Buffer
+	if err := WriteExercisesCsv(csv.NewWriter(&buffer), exercises); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "2014-04-18 12:00:00,-0400,45,Medium,\"Run, uphill\"\n"
+	if buffer.String() != expected {
+		t.Errorf("Expected csv [%s] but got [%s]", expected, buffer.String())
+	}
+}
@@ -0,0 +1,204 @@
+package exporter
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/dexcomimporter"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"golang.org/x/net/context"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Element names of the Dexcom Studio xml format
+const (
+	DEXCOM_PATIENT          = "Patient"
+	DEXCOM_METER_READINGS   = "MeterReadings"
+	DEXCOM_METER            = "Meter"
+	DEXCOM_GLUCOSE_READINGS = "GlucoseReadings"
+	DEXCOM_GLUCOSE          = "Glucose"
+	DEXCOM_EVENT_MARKERS    = "EventMarkers"
+	DEXCOM_EVENT            = "Event"
+)
+
+// ExportDexcomXml streams all of the user's data between the two (inclusive) bounds to the writer in the Dexcom Studio xml
+// format. The result can be read back by importer.ParseContent.
+func ExportDexcomXml(context context.Context, email string, lowerBound, upperBound time.Time, writer io.Writer) (err error) {
+	_, glukitUser, err := store.GetGlukitUser(context, email)
+	if err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(writer)
+	patient := NewPatientElement(*glukitUser)
+	if err = encoder.EncodeToken(patient); err != nil {
+		return err
+	}
+
+	if err = encoder.EncodeToken(xml.StartElement{Name: xml.Name{Local: DEXCOM_METER_READINGS}}); err != nil {
+		return err
+	}
+	if err = store.ScanCalibrations(context, email, lowerBound, upperBound, func(calibrations []apimodel.CalibrationRead) error {
+		return WriteMeterReadings(encoder, calibrations)
+	}); err != nil {
+		return err
+	}
+	if err = encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: DEXCOM_METER_READINGS}}); err != nil {
+		return err
+	}
+
+	if err = encoder.EncodeToken(xml.StartElement{Name: xml.Name{Local: DEXCOM_GLUCOSE_READINGS}}); err != nil {
+		return err
+	}
+	if err = store.ScanGlucoseReads(context, email, lowerBound, upperBound, func(reads []apimodel.GlucoseRead) error {
+		return WriteGlucoseReadings(encoder, reads)
+	}); err != nil {
+		return err
+	}
+	if err = encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: DEXCOM_GLUCOSE_READINGS}}); err != nil {
+		return err
+	}
+
+	if err = encoder.EncodeToken(xml.StartElement{Name: xml.Name{Local: DEXCOM_EVENT_MARKERS}}); err != nil {
+		return err
+	}
+	if err = store.ScanInjections(context, email, lowerBound, upperBound, func(injections []apimodel.Injection) error {
+		return WriteInjectionEvents(encoder, injections)
+	}); err != nil {
+		return err
+	}
+	if err = store.ScanMeals(context, email, lowerBound, upperBound, func(meals []apimodel.Meal) error {
+		return WriteMealEvents(encoder, meals)
+	}); err != nil {
+		return err
+	}
+	if err = store.ScanExercises(context, email, lowerBound, upperBound, func(exercises []apimodel.Exercise) error {
+		return WriteExerciseEvents(encoder, exercises)
+	}); err != nil {
+		return err
+	}
+	if err = encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: DEXCOM_EVENT_MARKERS}}); err != nil {
+		return err
+	}
+
+	if err = encoder.EncodeToken(patient.End()); err != nil {
+		return err
+	}
+
+	return encoder.Flush()
+}
+
+// NewPatientElement returns the root Patient element for the given user
+func NewPatientElement(glukitUser model.GlukitUser) xml.StartElement {
+	return xml.StartElement{Name: xml.Name{Local: DEXCOM_PATIENT}, Attr: []xml.Attr{
+		xml.Attr{Name: xml.Name{Local: "Id"}, Value: glukitUser.Email},
+		xml.Attr{Name: xml.Name{Local: "FirstName"}, Value: glukitUser.FirstName},
+		xml.Attr{Name: xml.Name{Local: "LastName"}, Value: glukitUser.LastName},
+		xml.Attr{Name: xml.Name{Local: "DateOfBirth"}, Value: glukitUser.DateOfBirth.Format(util.TIMEFORMAT_NO_TZ)},
+		xml.Attr{Name: xml.Name{Local: "Email"}, Value: glukitUser.Email}}}
+}
+
+// WriteMeterReadings writes the calibrations as Meter elements
+func WriteMeterReadings(encoder *xml.Encoder, calibrations []apimodel.CalibrationRead) (err error) {
+	for _, calibration := range calibrations {
+		internalTime, displayTime := formatDexcomTimes(calibration.Time)
+		meter := dexcomimporter.Calibration{InternalTime: internalTime, DisplayTime: displayTime, Value: formatDexcomGlucoseValue(calibration.Value, calibration.Unit)}
+		if err = encoder.EncodeElement(meter, xml.StartElement{Name: xml.Name{Local: DEXCOM_METER}}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteGlucoseReadings writes the reads as Glucose elements
+func WriteGlucoseReadings(encoder *xml.Encoder, reads []apimodel.GlucoseRead) (err error) {
+	for _, read := range reads {
+		internalTime, displayTime := formatDexcomTimes(read.Time)
+		glucose := dexcomimporter.Glucose{InternalTime: internalTime, DisplayTime: displayTime, Value: formatDexcomGlucoseValue(read.Value, read.Unit)}
+		if err = encoder.EncodeElement(glucose, xml.StartElement{Name: xml.Name{Local: DEXCOM_GLUCOSE}}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteInjectionEvents writes the injections as Insulin Event elements. The insulin name and type are written as
+// attributes that Dexcom Studio doesn't have.
+func WriteInjectionEvents(encoder *xml.Encoder, injections []apimodel.Injection) (err error) {
+	for _, injection := range injections {
+		event := newEvent(injection.Time, "Insulin", fmt.Sprintf("Insulin %s units", strconv.FormatFloat(float64(injection.Units), 'f', -1, 32)))
+		event.InsulinName = injection.InsulinName
+		event.InsulinType = injection.InsulinType
+		if err = writeEvent(encoder, event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteMealEvents writes the meals as Carbs Event elements. Dexcom only writes whole grams of carbohydrates but
+// fractions are kept since they're parsed back the same way. The other nutrients are written as attributes that
+// Dexcom Studio doesn't have.
+func WriteMealEvents(encoder *xml.Encoder, meals []apimodel.Meal) (err error) {
+	for _, meal := range meals {
+		event := newEvent(meal.Time, "Carbs", fmt.Sprintf("Carbs %s grams", strconv.FormatFloat(float64(meal.Carbohydrates), 'f', -1, 32)))
+		event.Proteins = meal.Proteins
+		event.Fat = meal.Fat
+		event.SaturatedFat = meal.SaturatedFat
+		if err = writeEvent(encoder, event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteExerciseEvents writes the exercises as Exercise Event elements (i.e. ExerciseLight). The description is
+// written as a Note attribute that Dexcom Studio doesn't have.
+func WriteExerciseEvents(encoder *xml.Encoder, exercises []apimodel.Exercise) (err error) {
+	for _, exercise := range exercises {
+		// The intensity is a single word in the Dexcom format
+		intensity := strings.Replace(exercise.Intensity, " ", "", -1)
+		event := newEvent(exercise.Time, "Exercise"+intensity, fmt.Sprintf("Exercise %s (%d minutes)", intensity, exercise.DurationMinutes))
+		event.Note = exercise.Description
+		if err = writeEvent(encoder, event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newEvent(value apimodel.Time, eventType string, description string) dexcomimporter.Event {
+	internalTime, displayTime := formatDexcomTimes(value)
+	return dexcomimporter.Event{InternalTime: internalTime, DisplayTime: displayTime, EventTime: displayTime, EventType: eventType, Description: description}
+}
+
+func writeEvent(encoder *xml.Encoder, event dexcomimporter.Event) error {
+	return encoder.EncodeElement(event, xml.StartElement{Name: xml.Name{Local: DEXCOM_EVENT}})
+}
+
+// formatDexcomTimes returns the InternalTime/DisplayTime pair of a time value. The internal time is in UTC and the
+// display time is the local time, the difference between the two is what carries the timezone offset.
+func formatDexcomTimes(value apimodel.Time) (internalTime, displayTime string) {
+	timeValue := value.GetTime()
+	return util.TimeInUTCNoTz(timeValue), timeValue.Format(util.TIMEFORMAT_NO_TZ)
+}
+
+// formatDexcomGlucoseValue formats a glucose value the way Dexcom does so that the unit can be inferred back from it. Values
+// in mmol/L always have two decimals while values in mg/dL are whole numbers.
+func formatDexcomGlucoseValue(value float32, unit apimodel.GlucoseUnit) string {
+	if unit == apimodel.MMOL_PER_L {
+		return strconv.FormatFloat(float64(value), 'f', 2, 32)
+	}
+
+	return strconv.FormatFloat(float64(value), 'f', 0, 32)
+}
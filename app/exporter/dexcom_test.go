@@ -0,0 +1,158 @@
+package exporter_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/dexcomimporter"
+	. "github.com/alexandre-normand/glukit/app/exporter"
+	"github.com/alexandre-normand/glukit/app/importer"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"google.golang.org/appengine/aetest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const (
+	TEST_USER        = "test@glukit.com"
+	TEST_IMPORT_USER = "import@glukit.com"
+)
+
+func TestDexcomXmlRoundTrip(t *testing.T) {
+	c, err := aetest.NewContext(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	user := model.GlukitUser{Email: TEST_USER, FirstName: "Test", LastName: "User", DateOfBirth: ct, Timezone: "America/Los_Angeles",
+		LastUpdated: ct, MostRecentRead: apimodel.UNDEFINED_GLUCOSE_READ, BestScore: model.UNDEFINED_SCORE, MostRecentScore: model.UNDEFINED_SCORE,
+		AccountCreated: ct, MostRecentA1C: model.UNDEFINED_A1C_ESTIMATE}
+	key, err := store.StoreUserProfile(c, ct, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two days of reads at home and a few hours during a trip
+	reads := make([]apimodel.GlucoseRead, 0)
+	for i := 0; i < 288*2+36; i++ {
+		timezone := "America/Los_Angeles"
+		if i >= 288*2 {
+			timezone = "-0400"
+		}
+		reads = append(reads, apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(ct.Add(time.Duration(i*5) * time.Minute)), timezone}, apimodel.MG_PER_DL, float32(80 + i%120)})
+	}
+	calibrations := []apimodel.CalibrationRead{
+		apimodel.CalibrationRead{apimodel.Time{apimodel.GetTimeMillis(ct.Add(time.Duration(7) * time.Hour)), "America/Los_Angeles"}, apimodel.MG_PER_DL, float32(95)},
+		apimodel.CalibrationRead{apimodel.Time{apimodel.GetTimeMillis(ct.Add(time.Duration(19) * time.Hour)), "America/Los_Angeles"}, apimodel.MG_PER_DL, float32(143)}}
+	injections := []apimodel.Injection{apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(ct.Add(time.Duration(8) * time.Hour)), "America/Los_Angeles"}, float32(2.5), "Humalog", "Bolus"}}
+	meals := []apimodel.Meal{apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(ct.Add(time.Duration(8) * time.Hour)), "America/Los_Angeles"}, float32(45.5), 12., 8.5, 2.}}
+	exercises := []apimodel.Exercise{apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(ct.Add(time.Duration(49) * time.Hour)), "-0400"}, 30, "Light", "Walk to the hotel"}}
+
+	// Store the data for the user so that it's exported the way it would be in production
+	daysOfReads := []apimodel.DayOfGlucoseReads{apimodel.NewDayOfGlucoseReads(reads[:288]), apimodel.NewDayOfGlucoseReads(reads[288 : 288*2]),
+		apimodel.NewDayOfGlucoseReads(reads[288*2:])}
+	if _, err := store.StoreDaysOfReads(c, key, daysOfReads); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.StoreCalibrationReads(c, key, []apimodel.DayOfCalibrationReads{apimodel.NewDayOfCalibrationReads(calibrations)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.StoreDaysOfInjections(c, key, []apimodel.DayOfInjections{apimodel.NewDayOfInjections(injections)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.StoreDaysOfMeals(c, key, []apimodel.DayOfMeals{apimodel.NewDayOfMeals(meals)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.StoreDaysOfExercises(c, key, []apimodel.DayOfExercises{apimodel.NewDayOfExercises(exercises)}); err != nil {
+		t.Fatal(err)
+	}
+
+	lowerBound, upperBound := ct, ct.Add(time.Duration(72)*time.Hour)
+	var buffer bytes.Buffer
+	if err := ExportDexcomXml(c, TEST_USER, lowerBound, upperBound, &buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	// Import the export for another user and compare it with the original data
+	importUser := user
+	importUser.Email = TEST_IMPORT_USER
+	importKey, err := store.StoreUserProfile(c, ct, importUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := importer.ParseContent(c, &buffer, importKey, util.GLUKIT_EPOCH_TIME, store.StoreDaysOfReads, store.StoreDaysOfMeals, store.StoreDaysOfInjections, store.StoreDaysOfExercises); err != nil {
+		t.Fatal(err)
+	}
+
+	if parsedReads, err := store.GetGlucoseReads(c, TEST_IMPORT_USER, lowerBound, upperBound); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(parsedReads, reads) {
+		t.Errorf("TestDexcomXmlRoundTrip failed: got [%d] reads different from the [%d] exported", len(parsedReads), len(reads))
+	}
+
+	if parsedCalibrations, err := store.GetCalibrations(c, TEST_IMPORT_USER, lowerBound, upperBound); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(parsedCalibrations, calibrations) {
+		t.Errorf("TestDexcomXmlRoundTrip failed: expected calibrations [%v] but got [%v]", calibrations, parsedCalibrations)
+	}
+
+	if parsedInjections, err := store.GetInjections(c, TEST_IMPORT_USER, lowerBound, upperBound); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(parsedInjections, injections) {
+		t.Errorf("TestDexcomXmlRoundTrip failed: expected injections [%v] but got [%v]", injections, parsedInjections)
+	}
+
+	if parsedMeals, err := store.GetMeals(c, TEST_IMPORT_USER, lowerBound, upperBound); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(parsedMeals, meals) {
+		t.Errorf("TestDexcomXmlRoundTrip failed: expected meals [%v] but got [%v]", meals, parsedMeals)
+	}
+
+	if parsedExercises, err := store.GetExercises(c, TEST_IMPORT_USER, lowerBound, upperBound); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(parsedExercises, exercises) {
+		t.Errorf("TestDexcomXmlRoundTrip failed: expected exercises [%v] but got [%v]", exercises, parsedExercises)
+	}
+}
+
+func TestDexcomEventsKeepAllFields(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 08:00")
+	eventTime := apimodel.Time{apimodel.GetTimeMillis(ct), "America/Los_Angeles"}
+
+	var buffer bytes.Buffer
+	encoder := xml.NewEncoder(&buffer)
+	if err := WriteInjectionEvents(encoder, []apimodel.Injection{apimodel.Injection{eventTime, float32(2.5), "Lantus", "Basal"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteMealEvents(encoder, []apimodel.Meal{apimodel.Meal{eventTime, float32(45.5), 12., 8.5, 2.}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteExerciseEvents(encoder, []apimodel.Exercise{apimodel.Exercise{eventTime, 30, "Light", "Walk to the hotel"}}); err != nil {
+		t.Fatal(err)
+	}
+	encoder.Flush()
+
+	decoder := xml.NewDecoder(&buffer)
+	events := make([]dexcomimporter.Event, 3)
+	for i := range events {
+		if err := decoder.Decode(&events[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if events[0].Description != "Insulin 2.5 units" || events[0].InsulinName != "Lantus" || events[0].InsulinType != "Basal" {
+		t.Errorf("TestDexcomEventsKeepAllFields failed: insulin name and type missing from injection event [%v]", events[0])
+	}
+	if events[1].Description != "Carbs 45.5 grams" || events[1].Proteins != 12 || events[1].Fat != 8.5 || events[1].SaturatedFat != 2 {
+		t.Errorf("TestDexcomEventsKeepAllFields failed: nutrients missing from meal event [%v]", events[1])
+	}
+	if events[2].Description != "Exercise Light (30 minutes)" || events[2].Note != "Walk to the hotel" {
+		t.Errorf("TestDexcomEventsKeepAllFields failed: description missing from exercise event [%v]", events[2])
+	}
+}
@@ -0,0 +1,283 @@
+package exporter
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"github.com/alexandre-normand/glukit/lib/goauth2/oauth"
+	"golang.org/x/net/context"
+	"io"
+	"strconv"
+	"time"
+)
+
+// WriteTakeout writes a zip archive of everything we have about a user: its profile, all of its data in both json and csv,
+// its history of GlukitScores, A1CEstimates, lab a1cs, time in range, daily coverage and glycemic episodes, its dosing insight, its glucose patterns, its file imports and the oauth clients it granted access to.
+func WriteTakeout(context context.Context, email string, writer io.Writer) (err error) {
+	key, glukitUser, err := store.GetGlukitUser(context, email)
+	if err != nil {
+		return err
+	}
+
+	archive := zip.NewWriter(writer)
+
+	// Our own google tokens aren't the user's data
+	profile := *glukitUser
+	profile.Token = oauth.Token{}
+	profile.RefreshToken = ""
+	if err = writeJsonEntry(archive, "profile.json", profile); err != nil {
+		return err
+	}
+
+	glucoseUnit := glukitUser.MostRecentRead.Unit
+	if glucoseUnit != apimodel.MMOL_PER_L {
+		glucoseUnit = apimodel.MG_PER_DL
+	}
+
+	for _, dataType := range ALL_DATA_TYPES {
+		entry, err := archive.Create(dataType + ".csv")
+		if err != nil {
+			return err
+		}
+		if err = ExportCsv(context, email, dataType, util.GLUKIT_EPOCH_TIME, time.Now(), glucoseUnit, entry); err != nil {
+			return err
+		}
+
+		if err = writeJsonDataEntry(context, archive, email, dataType); err != nil {
+			return err
+		}
+	}
+
+	unlimited := -1
+	scores, err := store.GetGlukitScores(context, email, store.ScoreScanQuery{Limit: &unlimited})
+	if err != nil {
+		return err
+	}
+	if err = writeJsonEntry(archive, "glukitscores.json", scores); err != nil {
+		return err
+	}
+	scoreRecords := make([][]string, len(scores))
+	for i, score := range scores {
+		scoreRecords[i] = []string{score.LowerBound.Format(time.RFC3339), score.UpperBound.Format(time.RFC3339), strconv.FormatInt(score.Value, 10), score.CalculatedOn.Format(time.RFC3339), strconv.Itoa(score.ScoringVersion), strconv.Itoa(score.ReadCount), strconv.FormatFloat(score.Coverage, 'f', -1, 64), score.Interpolation.Name, strconv.Itoa(score.Interpolation.MaxGapMinutes), strconv.FormatInt(score.ClassicValue, 10),
+			strconv.FormatFloat(score.Profile.Target, 'f', -1, 64), strconv.FormatFloat(score.Profile.HighMultiplier, 'f', -1, 64), strconv.FormatFloat(score.Profile.LowMultiplier, 'f', -1, 64),
+			strconv.FormatFloat(score.Profile.HypoThreshold, 'f', -1, 64), strconv.FormatFloat(score.Profile.HypoMultiplier, 'f', -1, 64), strconv.FormatFloat(score.Profile.HypoExponent, 'f', -1, 64)}
+	}
+	if err = writeCsvEntry(archive, "glukitscores.csv", []string{"lowerBound", "upperBound", "value", "calculatedOn", "scoringVersion", "readCount", "coverage", "interpolation", "interpolationMaxGapMinutes", "classicValue",
+		"profileTarget", "profileHighMultiplier", "profileLowMultiplier", "profileHypoThreshold", "profileHypoMultiplier", "profileHypoExponent"}, scoreRecords); err != nil {
+		return err
+	}
+
+	a1cs, err := store.GetA1CEstimates(context, email, store.ScoreScanQuery{Limit: &unlimited})
+	if err != nil {
+		return err
+	}
+	if err = writeJsonEntry(archive, "a1cestimates.json", a1cs); err != nil {
+		return err
+	}
+	a1cRecords := make([][]string, len(a1cs))
+	for i, a1c := range a1cs {
+		a1cRecords[i] = []string{a1c.LowerBound.Format(time.RFC3339), a1c.UpperBound.Format(time.RFC3339), strconv.FormatFloat(a1c.Value, 'f', -1, 64), a1c.CalculatedOn.Format(time.RFC3339), strconv.Itoa(a1c.ScoringVersion), a1c.Estimator, strconv.FormatFloat(a1c.Correction, 'f', -1, 64), strconv.Itoa(a1c.ReadCount), strconv.FormatFloat(a1c.Coverage, 'f', -1, 64), a1c.Interpolation.Name, strconv.Itoa(a1c.Interpolation.MaxGapMinutes)}
+	}
+	if err = writeCsvEntry(archive, "a1cestimates.csv", []string{"lowerBound", "upperBound", "value", "calculatedOn", "scoringVersion", "estimator", "correction", "readCount", "coverage", "interpolation", "interpolationMaxGapMinutes"}, a1cRecords); err != nil {
+		return err
+	}
+
+	labA1Cs, err := store.GetLabA1Cs(context, email)
+	if err != nil {
+		return err
+	}
+	if err = writeJsonEntry(archive, "laba1cs.json", labA1Cs); err != nil {
+		return err
+	}
+
+	timeInRanges, err := store.GetTimeInRanges(context, email, store.ScoreScanQuery{})
+	if err != nil {
+		return err
+	}
+	if err = writeJsonEntry(archive, "timeinranges.json", timeInRanges); err != nil {
+		return err
+	}
+
+	coverage, err := store.GetDailyCoverage(context, email, util.GLUKIT_EPOCH_TIME, time.Now())
+	if err != nil {
+		return err
+	}
+	if err = writeJsonEntry(archive, "coverage.json", coverage); err != nil {
+		return err
+	}
+
+	episodes, err := store.GetGlycemicEpisodes(context, email, util.GLUKIT_EPOCH_TIME, time.Now())
+	if err != nil {
+		return err
+	}
+	if err = writeJsonEntry(archive, "episodes.json", episodes); err != nil {
+		return err
+	}
+
+	dosingInsight, err := store.GetDosingInsight(context, email)
+	if err != nil {
+		return err
+	}
+	if err = writeJsonEntry(archive, "dosinginsight.json", dosingInsight); err != nil {
+		return err
+	}
+
+	patterns, err := store.GetPatternReport(context, email)
+	if err != nil {
+		return err
+	}
+	if err = writeJsonEntry(archive, "patterns.json", patterns); err != nil {
+		return err
+	}
+
+	fileImports, err := store.GetFileImportLogs(context, key)
+	if err != nil {
+		return err
+	}
+	if err = writeJsonEntry(archive, "fileimports.json", fileImports); err != nil {
+		return err
+	}
+
+	osinStore := new(store.OsinAppEngineStore)
+	grants, err := osinStore.GetClientGrantsWithContext(email, context)
+	if err != nil {
+		return err
+	}
+	if err = writeJsonEntry(archive, "oauthclients.json", grants); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// writeJsonDataEntry writes all elements of a data type as a json array. Elements are scanned and encoded one day
+// at a time to keep multi-year histories out of memory.
+func writeJsonDataEntry(context context.Context, archive *zip.Writer, email string, dataType string) (err error) {
+	entry, err := archive.Create(dataType + ".json")
+	if err != nil {
+		return err
+	}
+
+	arrayWriter := &jsonArrayWriter{w: entry}
+	lowerBound, upperBound := util.GLUKIT_EPOCH_TIME, time.Now()
+	switch dataType {
+	case GLUCOSE_READS:
+		err = store.ScanGlucoseReads(context, email, lowerBound, upperBound, func(reads []apimodel.GlucoseRead) error {
+			for _, read := range reads {
+				if err := arrayWriter.Write(read); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case CALIBRATIONS:
+		err = store.ScanCalibrations(context, email, lowerBound, upperBound, func(calibrations []apimodel.CalibrationRead) error {
+			for _, calibration := range calibrations {
+				if err := arrayWriter.Write(calibration); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case INJECTIONS:
+		err = store.ScanInjections(context, email, lowerBound, upperBound, func(injections []apimodel.Injection) error {
+			for _, injection := range injections {
+				if err := arrayWriter.Write(injection); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case MEALS:
+		err = store.ScanMeals(context, email, lowerBound, upperBound, func(meals []apimodel.Meal) error {
+			for _, meal := range meals {
+				if err := arrayWriter.Write(meal); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case EXERCISES:
+		err = store.ScanExercises(context, email, lowerBound, upperBound, func(exercises []apimodel.Exercise) error {
+			for _, exercise := range exercises {
+				if err := arrayWriter.Write(exercise); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return arrayWriter.Close()
+}
+
+func writeJsonEntry(archive *zip.Writer, name string, value interface{}) (err error) {
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(entry).Encode(value)
+}
+
+func writeCsvEntry(archive *zip.Writer, name string, header []string, records [][]string) (err error) {
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	csvWriter := csv.NewWriter(entry)
+	if err = csvWriter.Write(header); err != nil {
+		return err
+	}
+	if err = csvWriter.WriteAll(records); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// jsonArrayWriter streams elements as a json array
+type jsonArrayWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (w *jsonArrayWriter) Write(element interface{}) (err error) {
+	separator := ","
+	if w.count == 0 {
+		separator = "["
+	}
+
+	content, err := json.Marshal(element)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.WriteString(w.w, separator); err != nil {
+		return err
+	}
+	if _, err = w.w.Write(content); err != nil {
+		return err
+	}
+
+	w.count = w.count + 1
+	return nil
+}
+
+func (w *jsonArrayWriter) Close() (err error) {
+	if w.count == 0 {
+		_, err = io.WriteString(w.w, "[]")
+	} else {
+		_, err = io.WriteString(w.w, "]")
+	}
+
+	return err
+}
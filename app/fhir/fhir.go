@@ -0,0 +1,125 @@
+// Package fhir maps glukit data to and from FHIR R4 resources. Only the subset of FHIR that we produce and consume
+// is modeled here.
+package fhir
+
+import (
+	"encoding/json"
+)
+
+const (
+	CONTENT_TYPE = "application/fhir+json"
+
+	RESOURCE_TYPE_BUNDLE                    = "Bundle"
+	RESOURCE_TYPE_OBSERVATION               = "Observation"
+	RESOURCE_TYPE_MEDICATION_ADMINISTRATION = "MedicationAdministration"
+
+	BUNDLE_TYPE_SEARCHSET = "searchset"
+
+	LOINC_SYSTEM              = "http://loinc.org"
+	UCUM_SYSTEM               = "http://unitsofmeasure.org"
+	OBSERVATION_CATEGORY_CODE = "http://terminology.hl7.org/CodeSystem/observation-category"
+	// Standard extension carrying the IANA timezone of a date time so that we don't lose it to a fixed offset
+	TIMEZONE_EXTENSION_URL = "http://hl7.org/fhir/StructureDefinition/tz-code"
+
+	// Glucose [Mass/volume] in Interstitial fluid, what a CGM measures
+	LOINC_INTERSTITIAL_GLUCOSE = "99504-3"
+	// Glucose [Mass/volume] in Blood, what a meter used for calibrations measures
+	LOINC_BLOOD_GLUCOSE = "2339-0"
+	// Carbohydrate intake Estimated
+	LOINC_CARBOHYDRATE_INTAKE = "9059-7"
+
+	UCUM_MG_PER_DL    = "mg/dL"
+	UCUM_MMOL_PER_L   = "mmol/L"
+	UCUM_GRAMS        = "g"
+	UCUM_INSULIN_UNIT = "[IU]"
+
+	STATUS_FINAL     = "final"
+	STATUS_COMPLETED = "completed"
+)
+
+// Bundle is a collection of resources
+type Bundle struct {
+	ResourceType string        `json:"resourceType"`
+	Type         string        `json:"type"`
+	Total        *int          `json:"total,omitempty"`
+	Entry        []BundleEntry `json:"entry"`
+}
+
+// BundleEntry is an entry of a Bundle. The resource is kept raw until we know its type.
+type BundleEntry struct {
+	FullUrl  string          `json:"fullUrl,omitempty"`
+	Resource json.RawMessage `json:"resource"`
+}
+
+// Observation is a measurement made about a patient
+type Observation struct {
+	ResourceType      string            `json:"resourceType"`
+	Id                string            `json:"id,omitempty"`
+	Status            string            `json:"status"`
+	Category          []CodeableConcept `json:"category,omitempty"`
+	Code              CodeableConcept   `json:"code"`
+	Subject           *Reference        `json:"subject,omitempty"`
+	EffectiveDateTime string            `json:"effectiveDateTime"`
+	EffectiveElement  *Element          `json:"_effectiveDateTime,omitempty"`
+	ValueQuantity     *Quantity         `json:"valueQuantity,omitempty"`
+}
+
+// MedicationAdministration is the record of a medication taken by a patient
+type MedicationAdministration struct {
+	ResourceType              string          `json:"resourceType"`
+	Id                        string          `json:"id,omitempty"`
+	Status                    string          `json:"status"`
+	MedicationCodeableConcept CodeableConcept `json:"medicationCodeableConcept"`
+	Subject                   Reference       `json:"subject"`
+	EffectiveDateTime         string          `json:"effectiveDateTime"`
+	EffectiveElement          *Element        `json:"_effectiveDateTime,omitempty"`
+	Dosage                    *Dosage         `json:"dosage,omitempty"`
+}
+
+type Dosage struct {
+	Text string   `json:"text,omitempty"`
+	Dose Quantity `json:"dose"`
+}
+
+type CodeableConcept struct {
+	Coding []Coding `json:"coding,omitempty"`
+	Text   string   `json:"text,omitempty"`
+}
+
+type Coding struct {
+	System  string `json:"system,omitempty"`
+	Code    string `json:"code"`
+	Display string `json:"display,omitempty"`
+}
+
+type Quantity struct {
+	Value  float64 `json:"value"`
+	Unit   string  `json:"unit,omitempty"`
+	System string  `json:"system,omitempty"`
+	Code   string  `json:"code,omitempty"`
+}
+
+type Reference struct {
+	Identifier *Identifier `json:"identifier,omitempty"`
+	Display    string      `json:"display,omitempty"`
+}
+
+type Identifier struct {
+	System string `json:"system"`
+	Value  string `json:"value"`
+}
+
+// Element holds the extensions of a primitive value. We only use it for the timezone of date times.
+type Element struct {
+	Extension []Extension `json:"extension"`
+}
+
+type Extension struct {
+	Url       string `json:"url"`
+	ValueCode string `json:"valueCode"`
+}
+
+// resourceHeader is what we decode first to find out the type of a resource
+type resourceHeader struct {
+	ResourceType string `json:"resourceType"`
+}
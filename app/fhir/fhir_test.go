@@ -0,0 +1,92 @@
+package fhir_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	. "github.com/alexandre-normand/glukit/app/fhir"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	subject := NewSubject("test@glukit.com")
+
+	reads := []apimodel.GlucoseRead{
+		apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(ct), "America/Los_Angeles"}, apimodel.MG_PER_DL, float32(80)},
+		apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(ct.Add(5 * time.Minute)), "-0400"}, apimodel.MMOL_PER_L, float32(5.5)},
+	}
+	calibrations := []apimodel.CalibrationRead{apimodel.CalibrationRead{apimodel.Time{apimodel.GetTimeMillis(ct.Add(time.Hour)), "America/Los_Angeles"}, apimodel.MG_PER_DL, float32(95)}}
+	injections := []apimodel.Injection{apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(ct.Add(2 * time.Hour)), "America/Los_Angeles"}, float32(3.5), "Humalog", "Bolus"}}
+	meals := []apimodel.Meal{apimodel.Meal{Time: apimodel.Time{apimodel.GetTimeMillis(ct.Add(2 * time.Hour)), "America/Los_Angeles"}, Carbohydrates: float32(45)}}
+
+	resources := []interface{}{NewMealObservation(meals[0], subject), NewInjectionAdministration(injections[0], subject),
+		NewCalibrationObservation(calibrations[0], subject), NewGlucoseReadObservation(reads[1], subject), NewGlucoseReadObservation(reads[0], subject)}
+	bundle, err := NewSearchBundle("https://glukit.appspot.com/fhir", resources)
+	if err != nil {
+		t.Fatalf("Unexpected error creating bundle: %v", err)
+	}
+
+	content, err := json.Marshal(bundle)
+	if err != nil {
+		t.Fatalf("Unexpected error encoding bundle: %v", err)
+	}
+
+	data, err := ParseBundle(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Unexpected error parsing bundle: %v", err)
+	}
+
+	if !reflect.DeepEqual(data.GlucoseReads, reads) {
+		t.Errorf("Expected reads [%v] but got [%v]", reads, data.GlucoseReads)
+	}
+	if !reflect.DeepEqual(data.Calibrations, calibrations) {
+		t.Errorf("Expected calibrations [%v] but got [%v]", calibrations, data.Calibrations)
+	}
+	if !reflect.DeepEqual(data.Injections, injections) {
+		t.Errorf("Expected injections [%v] but got [%v]", injections, data.Injections)
+	}
+	if !reflect.DeepEqual(data.Meals, meals) {
+		t.Errorf("Expected meals [%v] but got [%v]", meals, data.Meals)
+	}
+}
+
+func TestParseBundleSkipsUnknownResources(t *testing.T) {
+	content := `{"resourceType": "Bundle", "type": "collection", "entry": [
+		{"resource": {"resourceType": "Patient", "id": "1"}},
+		{"resource": {"resourceType": "Observation", "status": "final", "code": {"coding": [{"system": "http://loinc.org", "code": "8867-4"}]},
+			"effectiveDateTime": "2014-04-18T00:00:00-07:00", "valueQuantity": {"value": 60, "code": "/min"}}}]}`
+
+	data, err := ParseBundle(bytes.NewReader([]byte(content)))
+	if err != nil {
+		t.Fatalf("Unexpected error parsing bundle: %v", err)
+	}
+
+	if data.Skipped != 2 || len(data.GlucoseReads) != 0 {
+		t.Errorf("Expected [2] skipped resources and no reads but got [%d] skipped and [%d] reads", data.Skipped, len(data.GlucoseReads))
+	}
+}
+
+func TestParseDateSearch(t *testing.T) {
+	now, _ := time.Parse(time.RFC3339, "2014-05-01T12:00:00Z")
+
+	lowerBound, upperBound, err := ParseDateSearch([]string{"ge2014-04-01", "lt2014-04-15T00:00:00Z"}, "", now, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing date search: %v", err)
+	}
+
+	if lowerBound.Format(time.RFC3339) != "2014-04-01T00:00:00Z" || upperBound.Format(time.RFC3339) != "2014-04-15T00:00:00Z" {
+		t.Errorf("Expected range [2014-04-01T00:00:00Z, 2014-04-15T00:00:00Z] but got [%s, %s]", lowerBound.Format(time.RFC3339), upperBound.Format(time.RFC3339))
+	}
+
+	lowerBound, upperBound, err = ParseDateSearch(nil, "", now, 24*time.Hour)
+	if err != nil || !upperBound.Equal(now) || !lowerBound.Equal(now.Add(-24*time.Hour)) {
+		t.Errorf("Expected default range ending now but got [%s, %s]: %v", lowerBound, upperBound, err)
+	}
+
+	if _, _, err = ParseDateSearch([]string{"xx2014-04-01"}, "", now, 24*time.Hour); err == nil {
+		t.Errorf("Expected error for unsupported prefix")
+	}
+}
@@ -0,0 +1,275 @@
+package fhir
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/util"
+	"io"
+	"sort"
+	"time"
+)
+
+// BundleData holds the glukit data found in a FHIR Bundle, sorted chronologically
+type BundleData struct {
+	GlucoseReads []apimodel.GlucoseRead
+	Calibrations []apimodel.CalibrationRead
+	Injections   []apimodel.Injection
+	Meals        []apimodel.Meal
+	// Number of resources that we don't know how to import
+	Skipped int
+}
+
+// NewSubject returns the reference to the patient identified by an email address
+func NewSubject(email string) Reference {
+	return Reference{Identifier: &Identifier{System: "urn:ietf:rfc:3986", Value: "mailto:" + email}}
+}
+
+// NewGlucoseReadObservation maps a CGM read to an Observation of interstitial glucose
+func NewGlucoseReadObservation(read apimodel.GlucoseRead, subject Reference) Observation {
+	return newGlucoseObservation("glucoseread", LOINC_INTERSTITIAL_GLUCOSE, "Glucose [Mass/volume] in Interstitial fluid", read.Time, read.Unit, read.Value, subject)
+}
+
+// NewCalibrationObservation maps a calibration read to an Observation of blood glucose
+func NewCalibrationObservation(calibration apimodel.CalibrationRead, subject Reference) Observation {
+	return newGlucoseObservation("calibration", LOINC_BLOOD_GLUCOSE, "Glucose [Mass/volume] in Blood", calibration.Time, calibration.Unit, calibration.Value, subject)
+}
+
+func newGlucoseObservation(idPrefix string, code string, display string, value apimodel.Time, unit apimodel.GlucoseUnit, glucose float32, subject Reference) Observation {
+	ucumUnit := UCUM_MG_PER_DL
+	if unit == apimodel.MMOL_PER_L {
+		ucumUnit = UCUM_MMOL_PER_L
+	}
+
+	effectiveDateTime, effectiveElement := newEffectiveDateTime(value)
+	return Observation{
+		ResourceType:      RESOURCE_TYPE_OBSERVATION,
+		Id:                fmt.Sprintf("%s-%d", idPrefix, value.Timestamp),
+		Status:            STATUS_FINAL,
+		Category:          []CodeableConcept{CodeableConcept{Coding: []Coding{Coding{System: OBSERVATION_CATEGORY_CODE, Code: "laboratory"}}}},
+		Code:              CodeableConcept{Coding: []Coding{Coding{System: LOINC_SYSTEM, Code: code, Display: display}}},
+		Subject:           &subject,
+		EffectiveDateTime: effectiveDateTime,
+		EffectiveElement:  effectiveElement,
+		ValueQuantity:     &Quantity{Value: float64(glucose), Unit: ucumUnit, System: UCUM_SYSTEM, Code: ucumUnit},
+	}
+}
+
+// NewMealObservation maps a meal to a nutrition Observation of its carbohydrates
+func NewMealObservation(meal apimodel.Meal, subject Reference) Observation {
+	effectiveDateTime, effectiveElement := newEffectiveDateTime(meal.Time)
+	return Observation{
+		ResourceType:      RESOURCE_TYPE_OBSERVATION,
+		Id:                fmt.Sprintf("meal-%d", meal.Time.Timestamp),
+		Status:            STATUS_FINAL,
+		Category:          []CodeableConcept{CodeableConcept{Text: "nutrition"}},
+		Code:              CodeableConcept{Coding: []Coding{Coding{System: LOINC_SYSTEM, Code: LOINC_CARBOHYDRATE_INTAKE, Display: "Carbohydrate intake Estimated"}}},
+		Subject:           &subject,
+		EffectiveDateTime: effectiveDateTime,
+		EffectiveElement:  effectiveElement,
+		ValueQuantity:     &Quantity{Value: float64(meal.Carbohydrates), Unit: UCUM_GRAMS, System: UCUM_SYSTEM, Code: UCUM_GRAMS},
+	}
+}
+
+// NewInjectionAdministration maps an insulin injection to a MedicationAdministration
+func NewInjectionAdministration(injection apimodel.Injection, subject Reference) MedicationAdministration {
+	effectiveDateTime, effectiveElement := newEffectiveDateTime(injection.Time)
+	return MedicationAdministration{
+		ResourceType:              RESOURCE_TYPE_MEDICATION_ADMINISTRATION,
+		Id:                        fmt.Sprintf("injection-%d", injection.Time.Timestamp),
+		Status:                    STATUS_COMPLETED,
+		MedicationCodeableConcept: CodeableConcept{Text: injection.InsulinName},
+		Subject:                   subject,
+		EffectiveDateTime:         effectiveDateTime,
+		EffectiveElement:          effectiveElement,
+		Dosage:                    &Dosage{Text: injection.InsulinType, Dose: Quantity{Value: float64(injection.Units), Unit: "U", System: UCUM_SYSTEM, Code: UCUM_INSULIN_UNIT}},
+	}
+}
+
+// NewSearchBundle wraps resources in a searchset Bundle
+func NewSearchBundle(baseUrl string, resources []interface{}) (bundle Bundle, err error) {
+	total := len(resources)
+	bundle = Bundle{ResourceType: RESOURCE_TYPE_BUNDLE, Type: BUNDLE_TYPE_SEARCHSET, Total: &total, Entry: make([]BundleEntry, len(resources))}
+	for i, resource := range resources {
+		content, err := json.Marshal(resource)
+		if err != nil {
+			return bundle, err
+		}
+
+		fullUrl := ""
+		switch r := resource.(type) {
+		case Observation:
+			fullUrl = fmt.Sprintf("%s/%s/%s", baseUrl, r.ResourceType, r.Id)
+		case MedicationAdministration:
+			fullUrl = fmt.Sprintf("%s/%s/%s", baseUrl, r.ResourceType, r.Id)
+		}
+
+		bundle.Entry[i] = BundleEntry{FullUrl: fullUrl, Resource: content}
+	}
+
+	return bundle, nil
+}
+
+// ParseBundle reads a FHIR Bundle and maps the resources we know about back to glukit data. Observations are
+// recognized by their LOINC code, anything else is skipped.
+func ParseBundle(reader io.Reader) (data *BundleData, err error) {
+	var bundle Bundle
+	if err = json.NewDecoder(reader).Decode(&bundle); err != nil {
+		return nil, err
+	}
+
+	if bundle.ResourceType != RESOURCE_TYPE_BUNDLE {
+		return nil, fmt.Errorf("Expected a resource of type [%s] but got [%s]", RESOURCE_TYPE_BUNDLE, bundle.ResourceType)
+	}
+
+	data = new(BundleData)
+	for _, entry := range bundle.Entry {
+		var header resourceHeader
+		if err = json.Unmarshal(entry.Resource, &header); err != nil {
+			return nil, err
+		}
+
+		switch header.ResourceType {
+		case RESOURCE_TYPE_OBSERVATION:
+			var observation Observation
+			if err = json.Unmarshal(entry.Resource, &observation); err != nil {
+				return nil, err
+			}
+			if err = data.addObservation(observation); err != nil {
+				return nil, err
+			}
+		case RESOURCE_TYPE_MEDICATION_ADMINISTRATION:
+			var administration MedicationAdministration
+			if err = json.Unmarshal(entry.Resource, &administration); err != nil {
+				return nil, err
+			}
+			if err = data.addAdministration(administration); err != nil {
+				return nil, err
+			}
+		default:
+			data.Skipped = data.Skipped + 1
+		}
+	}
+
+	sort.Sort(apimodel.GlucoseReadSlice(data.GlucoseReads))
+	sort.Sort(apimodel.CalibrationReadSlice(data.Calibrations))
+	sort.Sort(apimodel.InjectionSlice(data.Injections))
+	sort.Sort(apimodel.MealSlice(data.Meals))
+
+	return data, nil
+}
+
+func (data *BundleData) addObservation(observation Observation) (err error) {
+	if observation.ValueQuantity == nil {
+		data.Skipped = data.Skipped + 1
+		return nil
+	}
+
+	value, err := parseEffectiveDateTime(observation.EffectiveDateTime, observation.EffectiveElement)
+	if err != nil {
+		return err
+	}
+
+	quantity := observation.ValueQuantity
+	switch getLoincCode(observation.Code) {
+	case LOINC_INTERSTITIAL_GLUCOSE:
+		unit, err := parseGlucoseUnit(*quantity)
+		if err != nil {
+			return err
+		}
+		data.GlucoseReads = append(data.GlucoseReads, apimodel.GlucoseRead{value, unit, float32(quantity.Value)})
+	case LOINC_BLOOD_GLUCOSE:
+		unit, err := parseGlucoseUnit(*quantity)
+		if err != nil {
+			return err
+		}
+		data.Calibrations = append(data.Calibrations, apimodel.CalibrationRead{value, unit, float32(quantity.Value)})
+	case LOINC_CARBOHYDRATE_INTAKE:
+		data.Meals = append(data.Meals, apimodel.Meal{Time: value, Carbohydrates: float32(quantity.Value)})
+	default:
+		data.Skipped = data.Skipped + 1
+	}
+
+	return nil
+}
+
+func (data *BundleData) addAdministration(administration MedicationAdministration) (err error) {
+	if administration.Dosage == nil {
+		data.Skipped = data.Skipped + 1
+		return nil
+	}
+
+	value, err := parseEffectiveDateTime(administration.EffectiveDateTime, administration.EffectiveElement)
+	if err != nil {
+		return err
+	}
+
+	insulinName := administration.MedicationCodeableConcept.Text
+	if len(insulinName) == 0 && len(administration.MedicationCodeableConcept.Coding) > 0 {
+		insulinName = administration.MedicationCodeableConcept.Coding[0].Display
+	}
+
+	data.Injections = append(data.Injections, apimodel.Injection{value, float32(administration.Dosage.Dose.Value), insulinName, administration.Dosage.Text})
+	return nil
+}
+
+func getLoincCode(concept CodeableConcept) string {
+	for _, coding := range concept.Coding {
+		if coding.System == LOINC_SYSTEM {
+			return coding.Code
+		}
+	}
+
+	return ""
+}
+
+func parseGlucoseUnit(quantity Quantity) (unit apimodel.GlucoseUnit, err error) {
+	code := quantity.Code
+	if len(code) == 0 {
+		code = quantity.Unit
+	}
+
+	switch code {
+	case UCUM_MG_PER_DL:
+		return apimodel.MG_PER_DL, nil
+	case UCUM_MMOL_PER_L:
+		return apimodel.MMOL_PER_L, nil
+	default:
+		return apimodel.UNKNOWN_GLUCOSE_MEASUREMENT_UNIT, fmt.Errorf("Unsupported glucose unit [%s], must be one of [%s, %s]", code, UCUM_MG_PER_DL, UCUM_MMOL_PER_L)
+	}
+}
+
+// newEffectiveDateTime formats a time as a FHIR dateTime along with the timezone extension when the time has a proper
+// zone rather than a fixed offset
+func newEffectiveDateTime(value apimodel.Time) (effectiveDateTime string, element *Element) {
+	effectiveDateTime = value.GetTime().Format(time.RFC3339)
+	if !util.IsFixedOffsetLocationName(value.TimeZoneId) {
+		element = &Element{Extension: []Extension{Extension{Url: TIMEZONE_EXTENSION_URL, ValueCode: value.TimeZoneId}}}
+	}
+
+	return effectiveDateTime, element
+}
+
+// parseEffectiveDateTime parses a FHIR dateTime. The timezone comes from the timezone extension if present and falls
+// back to the fixed offset of the value otherwise.
+func parseEffectiveDateTime(effectiveDateTime string, element *Element) (value apimodel.Time, err error) {
+	t, err := time.Parse(time.RFC3339, effectiveDateTime)
+	if err != nil {
+		return value, err
+	}
+
+	timezone := t.Format("-0700")
+	if element != nil {
+		for _, extension := range element.Extension {
+			if extension.Url != TIMEZONE_EXTENSION_URL {
+				continue
+			}
+
+			if _, err := util.GetOrLoadLocationForName(extension.ValueCode); err == nil {
+				timezone = extension.ValueCode
+			}
+		}
+	}
+
+	return apimodel.Time{apimodel.GetTimeMillis(t), timezone}, nil
+}
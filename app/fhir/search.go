@@ -0,0 +1,86 @@
+package fhir
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	SEARCH_PARAM_DATE  = "date"
+	SEARCH_PARAM_SINCE = "_since"
+	SEARCH_PARAM_CODE  = "code"
+
+	FHIR_DATE_FORMAT = "2006-01-02"
+)
+
+// ParseDateSearch returns the time range matching FHIR date search values (i.e. "ge2014-04-01" or
+// "lt2014-05-01T00:00:00Z") and an optional _since instant. Since our data is never updated after the fact, _since
+// is applied to the effective time. Without a lower bound, the range starts defaultPeriod before its upper bound.
+func ParseDateSearch(dateValues []string, since string, now time.Time, defaultPeriod time.Duration) (lowerBound, upperBound time.Time, err error) {
+	upperBound = now
+	hasLowerBound := false
+
+	for _, dateValue := range dateValues {
+		prefix, value := "eq", dateValue
+		if len(dateValue) > 2 && strings.IndexAny(dateValue[:1], "0123456789") < 0 {
+			prefix, value = dateValue[:2], dateValue[2:]
+		}
+
+		start, end, err := parseSearchDate(value)
+		if err != nil {
+			return lowerBound, upperBound, err
+		}
+
+		switch prefix {
+		case "ge", "sa":
+			lowerBound, hasLowerBound = start, true
+		case "gt":
+			lowerBound, hasLowerBound = end, true
+		case "le", "eb":
+			upperBound = end
+		case "lt":
+			upperBound = start
+		case "eq":
+			lowerBound, upperBound, hasLowerBound = start, end, true
+		default:
+			return lowerBound, upperBound, fmt.Errorf("Unsupported date search prefix [%s]", prefix)
+		}
+	}
+
+	if len(since) > 0 {
+		sinceTime, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return lowerBound, upperBound, fmt.Errorf("Invalid value for %s: [%s]", SEARCH_PARAM_SINCE, since)
+		}
+
+		if !hasLowerBound || sinceTime.After(lowerBound) {
+			lowerBound, hasLowerBound = sinceTime, true
+		}
+	}
+
+	if !hasLowerBound {
+		lowerBound = upperBound.Add(-defaultPeriod)
+	}
+
+	if upperBound.Before(lowerBound) {
+		return lowerBound, upperBound, fmt.Errorf("Invalid date search, [%s] is before [%s]", upperBound.Format(time.RFC3339), lowerBound.Format(time.RFC3339))
+	}
+
+	return lowerBound, upperBound, nil
+}
+
+// parseSearchDate parses a search date value into the range it covers. A date covers the whole day while an
+// instant only covers itself.
+func parseSearchDate(value string) (start, end time.Time, err error) {
+	if t, err := time.Parse(FHIR_DATE_FORMAT, value); err == nil {
+		return t, t.AddDate(0, 0, 1).Add(-time.Second), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return start, end, fmt.Errorf("Invalid date search value [%s]", value)
+	}
+
+	return t, t, nil
+}
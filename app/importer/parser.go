@@ -43,6 +43,17 @@ func ParseContent(context context.Context, reader io.Reader, parentKey *datastor
 	exerciseBatchingWriter := bufio.NewExerciseWriterSize(exerciseDataStoreWriter, store.GLUKIT_SCORE_PUT_MULTI_SIZE)
 	exerciseStreamer := streaming.NewExerciseStreamerDuration(exerciseBatchingWriter, apimodel.DAY_OF_DATA_DURATION)
 
+	// Resolve timezones against the user's configured zone, if any, so that daylight saving transitions
+	// don't show up as different locations
+	var zone *time.Location
+	if userProfile, err := store.GetUserProfile(context, parentKey); err != nil {
+		log.Warningf(context, "Error getting user profile [%s] to resolve its timezone, falling back to fixed offsets: %v", parentKey, err)
+	} else if len(userProfile.Timezone) > 0 {
+		if zone, err = util.GetOrLoadLocationForName(userProfile.Timezone); err != nil {
+			log.Warningf(context, "Invalid timezone [%s] for user [%s], falling back to fixed offsets: %v", userProfile.Timezone, parentKey, err)
+		}
+	}
+
 	var lastRead *apimodel.GlucoseRead
 	for {
 		// Read tokens from the XML document in a stream.
@@ -62,7 +73,7 @@ func ParseContent(context context.Context, reader io.Reader, parentKey *datastor
 				var read dexcomimporter.Glucose
 				// decode a whole chunk of following XML into the
 				decoder.DecodeElement(&read, &se)
-				glucoseRead, err := dexcomimporter.ConvertXmlGlucoseRead(read)
+				glucoseRead, err := dexcomimporter.ConvertXmlGlucoseRead(read, zone)
 				if err != nil {
 					return lastRead.GetTime(), err
 				}
@@ -87,7 +98,7 @@ func ParseContent(context context.Context, reader io.Reader, parentKey *datastor
 
 				// Skip everything that's before the last import's read time
 				if internalEventTime.Unix() > startTime.Unix() {
-					location := util.GetLocaltimeOffset(event.EventTime, internalEventTime)
+					location := util.ResolveLocation(event.EventTime, internalEventTime, zone)
 
 					eventTime, err := util.GetTimeWithImpliedLocation(event.EventTime, location)
 					if err != nil {
@@ -96,10 +107,10 @@ func ParseContent(context context.Context, reader io.Reader, parentKey *datastor
 					}
 
 					if event.EventType == "Carbs" {
-						var mealQuantityInGrams int
-						fmt.Sscanf(event.Description, "Carbs %d grams", &mealQuantityInGrams)
+						var mealQuantityInGrams float32
+						fmt.Sscanf(event.Description, "Carbs %f grams", &mealQuantityInGrams)
 
-						meal := apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(eventTime), location.String()}, float32(mealQuantityInGrams), 0., 0., 0.}
+						meal := apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(eventTime), location.String()}, mealQuantityInGrams, event.Proteins, event.Fat, event.SaturatedFat}
 
 						mealStreamer, err = mealStreamer.WriteMeal(meal)
 						if err != nil {
@@ -112,7 +123,7 @@ func ParseContent(context context.Context, reader io.Reader, parentKey *datastor
 						if err != nil {
 							log.Warningf(context, "Failed to parse event as injection [%s]: %v", event.Description, err)
 						} else {
-							injection := apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(eventTime), location.String()}, float32(insulinUnits), "", ""}
+							injection := apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(eventTime), location.String()}, float32(insulinUnits), event.InsulinName, event.InsulinType}
 
 							injectionStreamer, err = injectionStreamer.WriteInjection(injection)
 
@@ -125,7 +136,7 @@ func ParseContent(context context.Context, reader io.Reader, parentKey *datastor
 						var intensity string
 						fmt.Sscanf(event.Description, "Exercise %s (%d minutes)", &intensity, &duration)
 
-						exercise := apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(eventTime), location.String()}, duration, intensity, ""}
+						exercise := apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(eventTime), location.String()}, duration, intensity, event.Note}
 						exerciseStreamer, err = exerciseStreamer.WriteExercise(exercise)
 						if err != nil {
 							return lastRead.GetTime(), err
@@ -136,7 +147,7 @@ func ParseContent(context context.Context, reader io.Reader, parentKey *datastor
 				var c dexcomimporter.Calibration
 				decoder.DecodeElement(&c, &se)
 
-				if calibrationRead, err := dexcomimporter.ConvertXmlCalibrationRead(c); err != nil {
+				if calibrationRead, err := dexcomimporter.ConvertXmlCalibrationRead(c, zone); err != nil {
 					return lastRead.GetTime(), err
 				} else {
 					calibrationStreamer, err = calibrationStreamer.WriteCalibration(*calibrationRead)
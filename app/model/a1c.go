@@ -12,18 +12,33 @@ import (
 // the version of the calculation algorithm used to calculate a given estimate
 // It is used to discard/recalculate older versions of glukit
 // scores in the eventuality where we change how we calculate the internal
-// estimation.
+// estimation. The estimator is the name of the formula that produced the estimate and
+// the correction is the user's glycation index that was added to the estimator's value. The read count and
+// coverage, the percentage of the period's expected reads that were received, tell how much data the estimate
+// is based on and the interpolation is the policy that filled the gaps between those reads.
 type A1CEstimate struct {
-	Value          float64   `datastore:"value"`
-	LowerBound     time.Time `datastore:"lowerBound"`
-	UpperBound     time.Time `datastore:"upperBound"`
-	CalculatedOn   time.Time `datastore:"calculatedOn"`
-	ScoringVersion int       `datastore:"scoringVersion`
+	Value          float64             `datastore:"value"`
+	LowerBound     time.Time           `datastore:"lowerBound"`
+	UpperBound     time.Time           `datastore:"upperBound"`
+	CalculatedOn   time.Time           `datastore:"calculatedOn"`
+	ScoringVersion int                 `datastore:"scoringVersion`
+	Estimator      string              `datastore:"estimator,noindex"`
+	Correction     float64             `datastore:"correction,noindex"`
+	ReadCount      int                 `datastore:"readCount,noindex"`
+	Coverage       float64             `datastore:"coverage,noindex"`
+	Interpolation  InterpolationPolicy `datastore:"interpolation"`
 }
 
 const (
 	UNDEFINED_A1C_VALUE = math.SmallestNonzeroFloat64
 )
 
+// Names of the a1c estimators a user can choose from
+const (
+	A1C_ESTIMATOR_MEDIAN = "median"
+	A1C_ESTIMATOR_ADAG   = "adag"
+	A1C_ESTIMATOR_GMI    = "gmi"
+)
+
 // "Dynamic" constants, those should never be updated
 var UNDEFINED_A1C_ESTIMATE = A1CEstimate{Value: UNDEFINED_A1C_VALUE, LowerBound: util.GLUKIT_EPOCH_TIME, UpperBound: util.GLUKIT_EPOCH_TIME, CalculatedOn: util.GLUKIT_EPOCH_TIME, ScoringVersion: -1}
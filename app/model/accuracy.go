@@ -0,0 +1,69 @@
+package model
+
+import (
+	"time"
+)
+
+// Error grid zones, from clinically accurate (A) to erroneous treatment (E)
+const (
+	ERROR_GRID_ZONE_A = "A"
+	ERROR_GRID_ZONE_B = "B"
+	ERROR_GRID_ZONE_C = "C"
+	ERROR_GRID_ZONE_D = "D"
+	ERROR_GRID_ZONE_E = "E"
+)
+
+// MeterComparison is a meter calibration paired with the CGM value interpolated at the same time. Values are in
+// mg/dL and the difference is positive when the sensor reads higher than the meter.
+type MeterComparison struct {
+	Time                       time.Time `json:"time"`
+	MeterValue                 float64   `json:"meterValue"`
+	SensorValue                float64   `json:"sensorValue"`
+	Difference                 float64   `json:"difference"`
+	AbsoluteRelativeDifference float64   `json:"absoluteRelativeDifference"`
+	ClarkeZone                 string    `json:"clarkeZone"`
+	ParkesZone                 string    `json:"parkesZone"`
+}
+
+// ErrorGridZones is the percentage of comparisons in each zone of an error grid
+type ErrorGridZones struct {
+	A float64 `json:"a"`
+	B float64 `json:"b"`
+	C float64 `json:"c"`
+	D float64 `json:"d"`
+	E float64 `json:"e"`
+}
+
+// SensorAccuracy is how close the CGM was to the meter over a period. MARD is the mean absolute relative
+// difference in percent, the bias is the mean difference in mg/dL and ISO15197 is the percentage of comparisons
+// within 15 mg/dL of meter values under 100 mg/dL or within 15% of higher meter values.
+type SensorAccuracy struct {
+	LowerBound      time.Time      `json:"lowerBound"`
+	UpperBound      time.Time      `json:"upperBound"`
+	ComparisonCount int            `json:"comparisonCount"`
+	MARD            float64        `json:"mard"`
+	Bias            float64        `json:"bias"`
+	ISO15197        float64        `json:"iso15197"`
+	Clarke          ErrorGridZones `json:"clarke"`
+	Parkes          ErrorGridZones `json:"parkes"`
+}
+
+// SensorSessionAccuracy is the accuracy of a single sensor session. The bias trend is how much the bias changed
+// per day over the session, it's nil when there aren't enough comparisons to tell. A sensor is drifting when that
+// change is large enough that the sensor should be calibrated more often or replaced.
+type SensorSessionAccuracy struct {
+	SensorAccuracy
+	BiasTrend *float64 `json:"biasTrend,omitempty"`
+	Drifting  bool     `json:"drifting"`
+}
+
+// AccuracyReport holds the accuracy of the CGM against meter calibrations over a range, overall, by sensor session
+// and by week, along with every comparison it's based on
+type AccuracyReport struct {
+	LowerBound  time.Time               `json:"lowerBound"`
+	UpperBound  time.Time               `json:"upperBound"`
+	Overall     SensorAccuracy          `json:"overall"`
+	Sessions    []SensorSessionAccuracy `json:"sessions"`
+	Weekly      []SensorAccuracy        `json:"weekly"`
+	Comparisons []MeterComparison       `json:"comparisons"`
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"time"
+)
+
+// AmbulatoryGlucoseProfile represents the standard AGP report of a range of reads: the distribution of glucose by
+// time of day, the glucose profile of every day and the summary metrics of the whole range. All glucose values are
+// in mg/dL.
+type AmbulatoryGlucoseProfile struct {
+	LowerBound    time.Time             `json:"lowerBound"`
+	UpperBound    time.Time             `json:"upperBound"`
+	DayCount      int                   `json:"dayCount"`
+	Bins          []AgpBin              `json:"bins"`
+	DailyProfiles []DailyGlucoseProfile `json:"dailyProfiles"`
+	Summary       AgpSummary            `json:"summary"`
+}
+
+// AgpBin holds the percentiles of all reads taken during the same slice of the day. Percentiles are only set when
+// the bin has reads.
+type AgpBin struct {
+	MinuteOfDay int      `json:"minuteOfDay"`
+	ReadCount   int      `json:"readCount"`
+	P5          *float64 `json:"p5,omitempty"`
+	P25         *float64 `json:"p25,omitempty"`
+	P50         *float64 `json:"p50,omitempty"`
+	P75         *float64 `json:"p75,omitempty"`
+	P95         *float64 `json:"p95,omitempty"`
+}
+
+// DailyGlucoseProfile is the glucose curve of a single local day, as the mean value of each of its bins. Bins
+// without reads are nil.
+type DailyGlucoseProfile struct {
+	Date   string     `json:"date"`
+	Values []*float64 `json:"values"`
+}
+
+// AgpSummary is the block of summary metrics that goes with an AmbulatoryGlucoseProfile
+type AgpSummary struct {
+	ReadCount                  int          `json:"readCount"`
+	SensorActivePercentage     float64      `json:"sensorActivePercentage"`
+	Mean                       float64      `json:"mean"`
+	GlucoseManagementIndicator float64      `json:"glucoseManagementIndicator"`
+	StandardDeviation          float64      `json:"standardDeviation"`
+	CoefficientOfVariation     float64      `json:"coefficientOfVariation"`
+	TimeInRange                *TimeInRange `json:"timeInRange,omitempty"`
+}
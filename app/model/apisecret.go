@@ -0,0 +1,12 @@
+package model
+
+import (
+	"time"
+)
+
+// ApiSecret maps the sha1 hash of a user's API secret to the user's email. It lets read-only clients like the
+// ones built for the Nightscout API authenticate without going through oauth. The secret itself is never stored.
+type ApiSecret struct {
+	Email     string    `datastore:"email"`
+	CreatedOn time.Time `datastore:"createdOn,noindex"`
+}
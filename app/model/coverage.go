@@ -0,0 +1,115 @@
+package model
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// The interval at which a CGM is expected to send reads
+	EXPECTED_READ_INTERVAL = time.Duration(5) * time.Minute
+
+	// Periods without reads longer than this are gaps, that's two missed reads in a row
+	COVERAGE_GAP_THRESHOLD = time.Duration(15) * time.Minute
+
+	COVERAGE_DATE_FORMAT = "2006-01-02"
+)
+
+// ReadGap is a period without reads
+type ReadGap struct {
+	Start           time.Time `datastore:"start,noindex" json:"start"`
+	End             time.Time `datastore:"end,noindex" json:"end"`
+	DurationMinutes int       `datastore:"durationMinutes,noindex" json:"durationMinutes"`
+}
+
+// DailyCoverage is how much of a local day is covered by reads: the percentage of the expected 5-minute reads that
+// were received and the gaps between them. A day that isn't over yet is only covered up to when it was calculated.
+type DailyCoverage struct {
+	Date              string    `datastore:"date,noindex" json:"date"`
+	Start             time.Time `datastore:"start" json:"start"`
+	ReadCount         int       `datastore:"readCount,noindex" json:"readCount"`
+	ExpectedReadCount int       `datastore:"expectedReadCount,noindex" json:"expectedReadCount"`
+	Percentage        float64   `datastore:"percentage,noindex" json:"percentage"`
+	Gaps              []ReadGap `datastore:"gaps,noindex" json:"gaps"`
+}
+
+// GetExpectedReadCount returns the number of reads a CGM is expected to send over a period
+func GetExpectedReadCount(lowerBound, upperBound time.Time) int {
+	if !upperBound.After(lowerBound) {
+		return 0
+	}
+
+	return int(upperBound.Sub(lowerBound) / EXPECTED_READ_INTERVAL)
+}
+
+// GetCoveragePercentage returns the percentage of the expected reads of a period that were received, up to 100%
+func GetCoveragePercentage(readCount int, lowerBound, upperBound time.Time) float64 {
+	expectedReadCount := GetExpectedReadCount(lowerBound, upperBound)
+	if expectedReadCount == 0 {
+		return 0
+	}
+
+	return math.Min(100, float64(readCount)*100/float64(expectedReadCount))
+}
+
+// CalculateDailyCoverage calculates the coverage of every local day from the one of the first read to the one of
+// the last read, days without any read included. Days are in the timezone of their first read or, if they have
+// none, of the last read before them. Days end at midnight or at now, whichever comes first.
+func CalculateDailyCoverage(reads []apimodel.GlucoseRead, now time.Time) (coverage []DailyCoverage) {
+	coverage = make([]DailyCoverage, 0)
+	if len(reads) == 0 {
+		return coverage
+	}
+
+	sortedReads := make([]apimodel.GlucoseRead, len(reads))
+	copy(sortedReads, reads)
+	sort.Sort(apimodel.GlucoseReadSlice(sortedReads))
+
+	location := sortedReads[0].GetTime().Location()
+	dayStart := getLocalMidnight(sortedReads[0].GetTime(), location)
+	lastRead := sortedReads[len(sortedReads)-1].GetTime()
+	for i := 0; dayStart.Before(lastRead) || dayStart.Equal(lastRead); {
+		if i < len(sortedReads) && sortedReads[i].GetTime().Format(COVERAGE_DATE_FORMAT) == dayStart.Format(COVERAGE_DATE_FORMAT) {
+			location = sortedReads[i].GetTime().Location()
+			dayStart = getLocalMidnight(dayStart, location)
+		}
+		dayEnd := getLocalMidnight(dayStart.AddDate(0, 0, 1), location)
+
+		dayReads := make([]time.Time, 0)
+		for ; i < len(sortedReads) && sortedReads[i].GetTime().Before(dayEnd); i++ {
+			dayReads = append(dayReads, sortedReads[i].GetTime())
+		}
+
+		coverage = append(coverage, calculateDayCoverage(dayStart, dayEnd, dayReads, now))
+		dayStart = dayEnd
+	}
+
+	return coverage
+}
+
+func calculateDayCoverage(dayStart, dayEnd time.Time, readTimes []time.Time, now time.Time) DailyCoverage {
+	if now.Before(dayEnd) {
+		dayEnd = now
+	}
+
+	day := DailyCoverage{Date: dayStart.Format(COVERAGE_DATE_FORMAT), Start: dayStart, ReadCount: len(readTimes),
+		ExpectedReadCount: GetExpectedReadCount(dayStart, dayEnd), Percentage: GetCoveragePercentage(len(readTimes), dayStart, dayEnd),
+		Gaps: make([]ReadGap, 0)}
+
+	previous := dayStart
+	for _, readTime := range append(readTimes, dayEnd) {
+		if readTime.Sub(previous) > COVERAGE_GAP_THRESHOLD {
+			day.Gaps = append(day.Gaps, ReadGap{Start: previous, End: readTime, DurationMinutes: int(readTime.Sub(previous).Minutes())})
+		}
+		previous = readTime
+	}
+
+	return day
+}
+
+func getLocalMidnight(t time.Time, location *time.Location) time.Time {
+	local := t.In(location)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+}
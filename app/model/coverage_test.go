@@ -0,0 +1,82 @@
+package model_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	. "github.com/alexandre-normand/glukit/app/model"
+	"math"
+	"testing"
+	"time"
+)
+
+// newCoverageReads returns a read every five minutes of the given days starting at local midnight in Los Angeles,
+// skipping the reads for which skip returns true
+func newCoverageReads(days []int, skip func(minuteOfDay int) bool) (start time.Time, reads []apimodel.GlucoseRead) {
+	location, _ := time.LoadLocation("America/Los_Angeles")
+	start = time.Date(2014, time.April, 18, 0, 0, 0, 0, location)
+
+	reads = make([]apimodel.GlucoseRead, 0)
+	for _, day := range days {
+		for minutes := 0; minutes < 24*60; minutes = minutes + 5 {
+			if skip != nil && skip(minutes) {
+				continue
+			}
+			readTime := start.AddDate(0, 0, day).Add(time.Duration(minutes) * time.Minute)
+			reads = append(reads, apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime), "America/Los_Angeles"}, apimodel.MG_PER_DL, float32(100)})
+		}
+	}
+
+	return start, reads
+}
+
+func TestDailyCoverageWithGap(t *testing.T) {
+	start, reads := newCoverageReads([]int{0}, func(minute int) bool {
+		return minute >= 10*60 && minute < 11*60
+	})
+
+	coverage := CalculateDailyCoverage(reads, start.AddDate(0, 0, 7))
+	if len(coverage) != 1 {
+		t.Fatalf("Expected [1] day of coverage but got [%d]: %v", len(coverage), coverage)
+	}
+
+	day := coverage[0]
+	if day.Date != "2014-04-18" || !day.Start.Equal(start) || day.ReadCount != 276 || day.ExpectedReadCount != 288 {
+		t.Errorf("Unexpected coverage [%v]", day)
+	}
+	if math.Abs(day.Percentage-95.833) > 0.001 {
+		t.Errorf("Expected coverage of [95.833]%% but got [%f]", day.Percentage)
+	}
+
+	expectedGapStart := start.Add(time.Duration(9*60+55) * time.Minute)
+	if len(day.Gaps) != 1 || !day.Gaps[0].Start.Equal(expectedGapStart) || day.Gaps[0].DurationMinutes != 65 {
+		t.Errorf("Expected a single gap of [65] minutes starting at [%s] but got [%v]", expectedGapStart, day.Gaps)
+	}
+}
+
+func TestDailyCoverageIncludesDaysWithoutReads(t *testing.T) {
+	start, reads := newCoverageReads([]int{0, 2}, nil)
+
+	coverage := CalculateDailyCoverage(reads, start.AddDate(0, 0, 7))
+	if len(coverage) != 3 {
+		t.Fatalf("Expected [3] days of coverage but got [%d]: %v", len(coverage), coverage)
+	}
+
+	if coverage[0].Percentage != 100 || coverage[2].Percentage != 100 || len(coverage[0].Gaps) != 0 {
+		t.Errorf("Expected full coverage of the first and last days but got [%v] and [%v]", coverage[0], coverage[2])
+	}
+
+	empty := coverage[1]
+	if empty.Date != "2014-04-19" || empty.ReadCount != 0 || empty.Percentage != 0 || len(empty.Gaps) != 1 || empty.Gaps[0].DurationMinutes != 24*60 {
+		t.Errorf("Expected an uncovered day with a single gap of the whole day but got [%v]", empty)
+	}
+}
+
+func TestDailyCoverageOfOngoingDay(t *testing.T) {
+	start, reads := newCoverageReads([]int{0}, func(minute int) bool {
+		return minute >= 12*60
+	})
+
+	coverage := CalculateDailyCoverage(reads, start.Add(time.Duration(12)*time.Hour))
+	if len(coverage) != 1 || coverage[0].ExpectedReadCount != 144 || coverage[0].Percentage != 100 || len(coverage[0].Gaps) != 0 {
+		t.Errorf("Expected a fully covered half day but got [%v]", coverage)
+	}
+}
@@ -0,0 +1,13 @@
+package model
+
+import (
+	"time"
+)
+
+// DirtyDay is a UTC day that got new reads after it might already have been part of calculated glukit scores and
+// a1c estimates. Every calculated window overlapping it needs to be calculated again. A day marked again while it's
+// being recalculated gets a new MarkedOn so that it stays dirty until the next recalculation.
+type DirtyDay struct {
+	Start    time.Time `datastore:"start,noindex" json:"start"`
+	MarkedOn time.Time `datastore:"markedOn,noindex" json:"markedOn"`
+}
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"time"
+)
+
+const (
+	DOSING_CONFIDENCE_LOW    = "Low"
+	DOSING_CONFIDENCE_MEDIUM = "Medium"
+	DOSING_CONFIDENCE_HIGH   = "High"
+)
+
+// DosingEstimate is the median of the values observed in clean windows along with their interquartile range and a
+// confidence level based on how many windows there were and how much they agreed. It's empty when there weren't any
+// windows.
+type DosingEstimate struct {
+	Value       float64 `datastore:"value,noindex" json:"value"`
+	P25         float64 `datastore:"p25,noindex" json:"p25"`
+	P75         float64 `datastore:"p75,noindex" json:"p75"`
+	WindowCount int     `datastore:"windowCount,noindex" json:"windowCount"`
+	Confidence  string  `datastore:"confidence,noindex" json:"confidence,omitempty"`
+}
+
+// DosingInsightBlock holds the effective carb ratio, in grams per unit, and correction factor, in mg/dL per unit,
+// observed during a block of the day
+type DosingInsightBlock struct {
+	TimeOfDay        string         `datastore:"timeOfDay,noindex" json:"timeOfDay"`
+	CarbRatio        DosingEstimate `datastore:"carbRatio" json:"carbRatio"`
+	CorrectionFactor DosingEstimate `datastore:"correctionFactor" json:"correctionFactor"`
+}
+
+// DosingInsight is the retrospective analysis of how meal and correction boluses of a period actually worked out.
+// It describes the past and isn't a dosing recommendation.
+type DosingInsight struct {
+	LowerBound   time.Time            `datastore:"lowerBound,noindex" json:"lowerBound"`
+	UpperBound   time.Time            `datastore:"upperBound,noindex" json:"upperBound"`
+	CalculatedOn time.Time            `datastore:"calculatedOn,noindex" json:"calculatedOn"`
+	Overall      DosingInsightBlock   `datastore:"overall" json:"overall"`
+	Blocks       []DosingInsightBlock `datastore:"blocks" json:"blocks"`
+}
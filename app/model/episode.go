@@ -0,0 +1,31 @@
+package model
+
+import (
+	"time"
+)
+
+// Types of glycemic episodes
+const (
+	EPISODE_TYPE_HYPOGLYCEMIA  = "Hypoglycemia"
+	EPISODE_TYPE_HYPERGLYCEMIA = "Hyperglycemia"
+)
+
+// GlycemicEpisode is a period spent beyond one of the episode thresholds. The extreme is the nadir of a
+// hypoglycemia or the peak of a hyperglycemia, in mg/dL. An ongoing episode hadn't recovered by the last read
+// we had when it was detected and ends with that read.
+type GlycemicEpisode struct {
+	Type            string    `datastore:"type" json:"type"`
+	Level           int       `datastore:"level" json:"level"`
+	Threshold       float64   `datastore:"threshold,noindex" json:"threshold"`
+	Start           time.Time `datastore:"start" json:"start"`
+	End             time.Time `datastore:"end,noindex" json:"end"`
+	DurationMinutes int       `datastore:"durationMinutes,noindex" json:"durationMinutes"`
+	Extreme         float64   `datastore:"extreme,noindex" json:"extreme"`
+	ExtremeTime     time.Time `datastore:"extremeTime,noindex" json:"extremeTime"`
+	Ongoing         bool      `datastore:"ongoing,noindex" json:"ongoing"`
+}
+
+// EpisodeDetectionProgress is how far in a user's reads episodes have been detected
+type EpisodeDetectionProgress struct {
+	DetectedUntil time.Time `datastore:"detectedUntil,noindex"`
+}
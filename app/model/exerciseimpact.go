@@ -0,0 +1,44 @@
+package model
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"time"
+)
+
+// ExerciseImpact is the glucose response to an exercise session. Glucose values are in mg/dL and the drop is
+// positive when glucose went down during the session. The delayed fields are about the 24 hours that follow the
+// session: the lowest read, the low blood glucose index and when the first low started. They're nil when there
+// aren't enough reads to tell and DelayedHypoglycemiaStart is also nil when there was no low.
+type ExerciseImpact struct {
+	Exercise                 apimodel.Exercise `json:"exercise"`
+	DurationGroup            string            `json:"durationGroup"`
+	StartGlucose             float64           `json:"startGlucose"`
+	EndGlucose               float64           `json:"endGlucose"`
+	Drop                     float64           `json:"drop"`
+	DropPerHour              float64           `json:"dropPerHour"`
+	Nadir                    float64           `json:"nadir"`
+	DelayedNadir             *float64          `json:"delayedNadir,omitempty"`
+	DelayedLBGI              *float64          `json:"delayedLbgi,omitempty"`
+	DelayedHypoglycemiaStart *time.Time        `json:"delayedHypoglycemiaStart,omitempty"`
+}
+
+// ExerciseImpactAggregate is the average impact of a group of exercise sessions. The delayed hypoglycemia rate is
+// the fraction of the sessions with enough reads afterwards that were followed by a low within 24 hours.
+type ExerciseImpactAggregate struct {
+	Group                   string   `json:"group"`
+	SessionCount            int      `json:"sessionCount"`
+	MeanDrop                float64  `json:"meanDrop"`
+	MeanDropPerHour         float64  `json:"meanDropPerHour"`
+	MeanNadir               float64  `json:"meanNadir"`
+	MeanDelayedLBGI         *float64 `json:"meanDelayedLbgi,omitempty"`
+	DelayedHypoglycemiaRate *float64 `json:"delayedHypoglycemiaRate,omitempty"`
+}
+
+// ExerciseImpactReport holds the average impact of the exercise sessions of a range by intensity and by duration
+type ExerciseImpactReport struct {
+	LowerBound   time.Time                 `json:"lowerBound"`
+	UpperBound   time.Time                 `json:"upperBound"`
+	SessionCount int                       `json:"sessionCount"`
+	ByIntensity  []ExerciseImpactAggregate `json:"byIntensity"`
+	ByDuration   []ExerciseImpactAggregate `json:"byDuration"`
+}
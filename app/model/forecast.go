@@ -0,0 +1,38 @@
+package model
+
+import (
+	"time"
+)
+
+// ForecastError is how far forecasts of a holdout set of reads were from what actually happened, in mg/dL, 30 and
+// 60 minutes ahead
+type ForecastError struct {
+	SampleCount int     `json:"sampleCount"`
+	MAE30       float64 `json:"mae30"`
+	RMSE30      float64 `json:"rmse30"`
+	MAE60       float64 `json:"mae60"`
+	RMSE60      float64 `json:"rmse60"`
+}
+
+// ForecastModel is an autoregressive model of the change of glucose every 5 minutes from the previous changes and,
+// when it uses what's on board, the insulin and carbs absorbed over the same 5 minutes
+type ForecastModel struct {
+	Coefficients        []float64     `json:"coefficients"`
+	UsesOnBoard         bool          `json:"usesOnBoard"`
+	InsulinCoefficient  float64       `json:"insulinCoefficient"`
+	CarbCoefficient     float64       `json:"carbCoefficient"`
+	TrainingSampleCount int           `json:"trainingSampleCount"`
+	Holdout             ForecastError `json:"holdout"`
+}
+
+// ForecastPoint is a forecasted glucose value, in mg/dL
+type ForecastPoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// GlucoseForecast is the projection of glucose following the most recent read along with the model it comes from
+type GlucoseForecast struct {
+	Model  ForecastModel   `json:"model"`
+	Points []ForecastPoint `json:"points"`
+}
@@ -33,13 +33,23 @@ type GlukitUser struct {
 // the version of the calculation algorithm used to calculate a given
 // score. It is used to discard/recalculate older versions of glukit
 // scores in the eventuality where we change how we calculate the internal
-// score.
+// score. The read count and coverage, the percentage of the period's expected
+// reads that were received, tell how much data the score is based on and the
+// interpolation is the policy that filled the gaps between those reads. Since
+// scoring version 2, the value is calculated with the user's scoring profile
+// and the classic value is the same reads scored with the classic profile
+// for comparison.
 type GlukitScore struct {
-	Value          int64     `datastore:"value"`
-	LowerBound     time.Time `datastore:"lowerBound"`
-	UpperBound     time.Time `datastore:"upperBound"`
-	CalculatedOn   time.Time `datastore:"calculatedOn"`
-	ScoringVersion int       `datastore:"scoringVersion`
+	Value          int64               `datastore:"value"`
+	LowerBound     time.Time           `datastore:"lowerBound"`
+	UpperBound     time.Time           `datastore:"upperBound"`
+	CalculatedOn   time.Time           `datastore:"calculatedOn"`
+	ScoringVersion int                 `datastore:"scoringVersion`
+	ReadCount      int                 `datastore:"readCount,noindex"`
+	Coverage       float64             `datastore:"coverage,noindex"`
+	Interpolation  InterpolationPolicy `datastore:"interpolation"`
+	Profile        ScoringProfile      `datastore:"profile"`
+	ClassicValue   int64               `datastore:"classicValue,noindex"`
 }
 
 // Type of diabetes
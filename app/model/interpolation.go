@@ -0,0 +1,62 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Names of the policies used to fill gaps between reads before calculating scores, estimates and statistics
+const (
+	// Reads are used as they are, gaps included
+	INTERPOLATION_POLICY_NONE = "none"
+	// Missing reads are placed on the straight line between the reads around the gap
+	INTERPOLATION_POLICY_LINEAR = "linear"
+	// Missing reads are placed on a monotone cubic spline through the reads around the gap
+	INTERPOLATION_POLICY_SPLINE = "spline"
+)
+
+const (
+	DEFAULT_INTERPOLATION_MAX_GAP_MINUTES = 30
+
+	// Gaps longer than this are sensor changes or time without wearing a sensor, never short dropouts
+	MAX_INTERPOLATION_MAX_GAP_MINUTES = 120
+)
+
+// All interpolation policies a user can choose from
+var INTERPOLATION_POLICIES = []string{INTERPOLATION_POLICY_NONE, INTERPOLATION_POLICY_LINEAR, INTERPOLATION_POLICY_SPLINE}
+
+// InterpolationPolicy is how gaps between reads are filled. Only gaps of up to MaxGapMinutes are filled, longer
+// ones are left out of calculations. Metrics calculated before interpolation existed have an empty policy name,
+// which is the same as INTERPOLATION_POLICY_NONE.
+type InterpolationPolicy struct {
+	Name          string `datastore:"name,noindex" json:"name"`
+	MaxGapMinutes int    `datastore:"maxGapMinutes,noindex" json:"maxGapMinutes"`
+}
+
+var DEFAULT_INTERPOLATION_POLICY = InterpolationPolicy{Name: INTERPOLATION_POLICY_LINEAR, MaxGapMinutes: DEFAULT_INTERPOLATION_MAX_GAP_MINUTES}
+
+// NO_INTERPOLATION is the policy of calculations that work on raw reads
+var NO_INTERPOLATION = InterpolationPolicy{Name: INTERPOLATION_POLICY_NONE}
+
+// Validate returns an error if the policy isn't a known one or if its max gap isn't between the expected read
+// interval and MAX_INTERPOLATION_MAX_GAP_MINUTES
+func (policy InterpolationPolicy) Validate() error {
+	known := false
+	for _, name := range INTERPOLATION_POLICIES {
+		if policy.Name == name {
+			known = true
+		}
+	}
+
+	if !known {
+		return errors.New(fmt.Sprintf("Unknown interpolation policy [%s], must be one of %v.", policy.Name, INTERPOLATION_POLICIES))
+	}
+
+	minimumGapMinutes := int(EXPECTED_READ_INTERVAL.Minutes())
+	if policy.Name != INTERPOLATION_POLICY_NONE && (policy.MaxGapMinutes <= minimumGapMinutes || policy.MaxGapMinutes > MAX_INTERPOLATION_MAX_GAP_MINUTES) {
+		return errors.New(fmt.Sprintf("Invalid max gap of [%d] minutes, must be more than [%d] and at most [%d].", policy.MaxGapMinutes,
+			minimumGapMinutes, MAX_INTERPOLATION_MAX_GAP_MINUTES))
+	}
+
+	return nil
+}
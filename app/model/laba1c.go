@@ -0,0 +1,34 @@
+package model
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"time"
+)
+
+// A1CComparison is a lab a1c along with the a1c estimate of the same period. Errors are the estimate minus the lab
+// value so a positive error means we overestimated.
+type A1CComparison struct {
+	LabA1C             apimodel.LabA1C `json:"labA1C"`
+	Estimate           float64         `json:"estimate"`
+	RawEstimate        float64         `json:"rawEstimate"`
+	Error              float64         `json:"error"`
+	RawError           float64         `json:"rawError"`
+	Estimator          string          `json:"estimator"`
+	EstimateUpperBound time.Time       `json:"estimateUpperBound"`
+}
+
+// A1CCalibration is the personal correction of a user's a1c estimates. The glycation index is the average difference
+// between its lab a1cs and what the estimator predicted for the same periods. It only applies to the estimator it
+// was fitted for.
+type A1CCalibration struct {
+	GlycationIndex  float64   `datastore:"glycationIndex,noindex" json:"glycationIndex"`
+	ComparisonCount int       `datastore:"comparisonCount,noindex" json:"comparisonCount"`
+	Estimator       string    `datastore:"estimator,noindex" json:"estimator"`
+	UpdatedOn       time.Time `datastore:"updatedOn,noindex" json:"updatedOn"`
+}
+
+// A1CCalibrationReport is a user's current a1c calibration along with the history of comparisons it was fitted from
+type A1CCalibrationReport struct {
+	Calibration A1CCalibration  `json:"calibration"`
+	Comparisons []A1CComparison `json:"comparisons"`
+}
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"time"
+)
+
+// MealResponse is the glucose response that followed a meal. Glucose values are in mg/dL, the incremental area
+// under the curve is in mg/dL·min and TwoHourDelta is nil when there is no read two hours after the meal.
+type MealResponse struct {
+	Meal              apimodel.Meal `json:"meal"`
+	Baseline          float64       `json:"baseline"`
+	Peak              float64       `json:"peak"`
+	PeakExcursion     float64       `json:"peakExcursion"`
+	TimeToPeakMinutes int           `json:"timeToPeakMinutes"`
+	TwoHourDelta      *float64      `json:"twoHourDelta,omitempty"`
+	IncrementalAUC    float64       `json:"incrementalAuc"`
+	TimeOfDay         string        `json:"timeOfDay"`
+	CarbBucket        string        `json:"carbBucket"`
+}
+
+// MealResponseAggregate is the average response of a group of meals
+type MealResponseAggregate struct {
+	Group                 string   `json:"group"`
+	MealCount             int      `json:"mealCount"`
+	MeanPeakExcursion     float64  `json:"meanPeakExcursion"`
+	MeanTimeToPeakMinutes float64  `json:"meanTimeToPeakMinutes"`
+	MeanTwoHourDelta      *float64 `json:"meanTwoHourDelta,omitempty"`
+	MeanIncrementalAUC    float64  `json:"meanIncrementalAuc"`
+}
+
+// MealResponseReport holds the response of every meal of a range, most recent first, along with their averages
+// by time of day and by amount of carbohydrates
+type MealResponseReport struct {
+	LowerBound  time.Time               `json:"lowerBound"`
+	UpperBound  time.Time               `json:"upperBound"`
+	Meals       []MealResponse          `json:"meals"`
+	ByTimeOfDay []MealResponseAggregate `json:"byTimeOfDay"`
+	ByCarbs     []MealResponseAggregate `json:"byCarbs"`
+}
@@ -0,0 +1,57 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	INSULIN_TYPE_RAPID       = "Rapid"
+	INSULIN_TYPE_ULTRA_RAPID = "UltraRapid"
+	INSULIN_TYPE_BASAL       = "Basal"
+
+	// Longest duration of action an insulin activity curve can have
+	MAX_INSULIN_DURATION_MINUTES = 24 * 60
+)
+
+// InsulinActivityCurve describes the exponential activity curve of an insulin type by the time at which its activity
+// peaks and its total duration of action
+type InsulinActivityCurve struct {
+	InsulinType     string `datastore:"insulinType,noindex" json:"insulinType"`
+	PeakMinutes     int    `datastore:"peakMinutes,noindex" json:"peakMinutes"`
+	DurationMinutes int    `datastore:"durationMinutes,noindex" json:"durationMinutes"`
+}
+
+// The curves used for insulin types a user didn't define a curve for
+var DEFAULT_INSULIN_ACTIVITY_CURVES = []InsulinActivityCurve{
+	InsulinActivityCurve{InsulinType: INSULIN_TYPE_RAPID, PeakMinutes: 75, DurationMinutes: 360},
+	InsulinActivityCurve{InsulinType: INSULIN_TYPE_ULTRA_RAPID, PeakMinutes: 55, DurationMinutes: 360},
+}
+
+// Validate returns an error if the curve can't be modeled as an exponential curve, which requires the peak to be
+// before half of the duration of action
+func (curve InsulinActivityCurve) Validate() error {
+	if len(curve.InsulinType) == 0 || curve.PeakMinutes <= 0 || curve.PeakMinutes*2 >= curve.DurationMinutes || curve.DurationMinutes > MAX_INSULIN_DURATION_MINUTES {
+		return errors.New(fmt.Sprintf("Invalid insulin activity curve [%v], must have a type and a peak before half of a duration of at most %d minutes", curve, MAX_INSULIN_DURATION_MINUTES))
+	}
+
+	return nil
+}
+
+// OnBoardSettings are the parameters of the insulin on board and carbs on board calculations. Insulin activity
+// curves only hold the curves a user defined. Carbs are absorbed linearly at CarbAbsorptionRate grams per hour.
+type OnBoardSettings struct {
+	InsulinActivityCurves []InsulinActivityCurve `datastore:"insulinActivityCurves" json:"insulinActivityCurves"`
+	CarbAbsorptionRate    float64                `datastore:"carbAbsorptionRate,noindex" json:"carbAbsorptionRate"`
+}
+
+// The carb absorption rate, in grams per hour, of a user that never changed it
+const DEFAULT_CARB_ABSORPTION_RATE = 30.
+
+// OnBoardValue is the insulin, in units, and carbs, in grams, still active at a point in time
+type OnBoardValue struct {
+	Time           time.Time `json:"time"`
+	InsulinOnBoard float64   `json:"insulinOnBoard"`
+	CarbsOnBoard   float64   `json:"carbsOnBoard"`
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"time"
+)
+
+const (
+	PATTERN_TYPE_OVERNIGHT_LOW   = "OvernightLow"
+	PATTERN_TYPE_DAWN_PHENOMENON = "DawnPhenomenon"
+	PATTERN_TYPE_REBOUND_HIGH    = "ReboundHigh"
+	PATTERN_TYPE_POST_MEAL_SPIKE = "PostMealSpike"
+)
+
+// GlucosePattern is a pattern that recurred over the days of a period. Supporting days are the local dates
+// (YYYY-MM-DD) the pattern was observed on and eligible days the ones with enough data to tell. The strength is the
+// fraction of eligible days that support the pattern. The magnitude, in mg/dL, is the mean nadir of overnight lows,
+// the mean rise of the dawn phenomenon, the mean peak of rebound highs and the mean peak excursion of post-meal
+// spikes. The meal slot is only set for post-meal spikes.
+type GlucosePattern struct {
+	Type           string   `datastore:"type" json:"type"`
+	MealSlot       string   `datastore:"mealSlot,noindex" json:"mealSlot,omitempty"`
+	SupportingDays []string `datastore:"supportingDays,noindex" json:"supportingDays"`
+	EligibleDays   int      `datastore:"eligibleDays,noindex" json:"eligibleDays"`
+	Strength       float64  `datastore:"strength,noindex" json:"strength"`
+	Magnitude      float64  `datastore:"magnitude,noindex" json:"magnitude"`
+}
+
+// PatternDetection is the period over which the patterns of a user were last detected and when that happened
+type PatternDetection struct {
+	LowerBound   time.Time `datastore:"lowerBound,noindex" json:"lowerBound"`
+	UpperBound   time.Time `datastore:"upperBound,noindex" json:"upperBound"`
+	CalculatedOn time.Time `datastore:"calculatedOn,noindex" json:"calculatedOn"`
+}
+
+// PatternReport is a pattern detection along with the patterns it found, strongest first
+type PatternReport struct {
+	PatternDetection
+	Patterns []GlucosePattern `json:"patterns"`
+}
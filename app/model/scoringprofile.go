@@ -0,0 +1,73 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	// Bounds of the values a scoring profile can be set to, glucose values are in mg/dL
+	MIN_SCORING_TARGET        = 70.
+	MAX_SCORING_TARGET        = 180.
+	MAX_SCORING_MULTIPLIER    = 10.
+	MIN_HYPO_THRESHOLD        = 50.
+	MIN_HYPO_PENALTY_EXPONENT = 1.
+	MAX_HYPO_PENALTY_EXPONENT = 3.
+)
+
+// ScoringProfile is how a user's reads count toward its glukit score. Every read adds its distance to the target
+// weighted by the high or low multiplier. Reads under the hypo threshold also add the hypo multiplier times their
+// distance to the threshold raised to the hypo exponent so that deeper lows weigh more than their distance alone. A
+// hypo threshold of 0 turns the hypo penalty off. Glucose values are in mg/dL.
+type ScoringProfile struct {
+	Target         float64 `datastore:"target,noindex" json:"target"`
+	HighMultiplier float64 `datastore:"highMultiplier,noindex" json:"highMultiplier"`
+	LowMultiplier  float64 `datastore:"lowMultiplier,noindex" json:"lowMultiplier"`
+	HypoThreshold  float64 `datastore:"hypoThreshold,noindex" json:"hypoThreshold"`
+	HypoMultiplier float64 `datastore:"hypoMultiplier,noindex" json:"hypoMultiplier"`
+	HypoExponent   float64 `datastore:"hypoExponent,noindex" json:"hypoExponent"`
+}
+
+// IsDefined returns false for the zero value of settings and scores that predate scoring profiles
+func (profile ScoringProfile) IsDefined() bool {
+	return profile.Target > 0
+}
+
+// Validate returns an error if any value isn't a number or the target or any multiplier is outside of what a scoring
+// profile allows. Range checks are all false for NaN so it's rejected explicitly.
+func (profile ScoringProfile) Validate() error {
+	for _, value := range []float64{profile.Target, profile.HighMultiplier, profile.LowMultiplier, profile.HypoThreshold, profile.HypoMultiplier, profile.HypoExponent} {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return errors.New(fmt.Sprintf("Invalid value [%f], must be a finite number.", value))
+		}
+	}
+
+	if profile.Target < MIN_SCORING_TARGET || profile.Target > MAX_SCORING_TARGET {
+		return errors.New(fmt.Sprintf("Invalid target [%f], must be between [%f] and [%f].", profile.Target, MIN_SCORING_TARGET, MAX_SCORING_TARGET))
+	}
+
+	for _, multiplier := range []float64{profile.HighMultiplier, profile.LowMultiplier} {
+		if multiplier <= 0 || multiplier > MAX_SCORING_MULTIPLIER {
+			return errors.New(fmt.Sprintf("Invalid multiplier [%f], must be more than 0 and at most [%f].", multiplier, MAX_SCORING_MULTIPLIER))
+		}
+	}
+
+	if profile.HypoThreshold == 0 {
+		return nil
+	}
+
+	if profile.HypoThreshold < MIN_HYPO_THRESHOLD || profile.HypoThreshold > profile.Target {
+		return errors.New(fmt.Sprintf("Invalid hypo threshold [%f], must be 0 or between [%f] and the target.", profile.HypoThreshold, MIN_HYPO_THRESHOLD))
+	}
+
+	if profile.HypoMultiplier <= 0 || profile.HypoMultiplier > MAX_SCORING_MULTIPLIER {
+		return errors.New(fmt.Sprintf("Invalid hypo multiplier [%f], must be more than 0 and at most [%f].", profile.HypoMultiplier, MAX_SCORING_MULTIPLIER))
+	}
+
+	if profile.HypoExponent < MIN_HYPO_PENALTY_EXPONENT || profile.HypoExponent > MAX_HYPO_PENALTY_EXPONENT {
+		return errors.New(fmt.Sprintf("Invalid hypo exponent [%f], must be between [%f] and [%f].", profile.HypoExponent, MIN_HYPO_PENALTY_EXPONENT, MAX_HYPO_PENALTY_EXPONENT))
+	}
+
+	return nil
+}
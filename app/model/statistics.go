@@ -3,6 +3,7 @@ package model
 import (
 	"github.com/alexandre-normand/glukit/app/apimodel"
 	"github.com/alexandre-normand/glukit/app/util"
+	"time"
 )
 
 // Type for a slice of GlucoseReads with comparison based on value rather than time. It is used as read statistics.
@@ -30,10 +31,30 @@ func (slice ReadStatsSlice) Swap(i, j int) {
 
 // Represents the structure of the dashboard data for a user
 type DashboardData struct {
-	Average float64 `json:"average"`
-	Median  float64 `json:"median"`
-	High    float64 `json:"high"`
-	Low     float64 `json:"low"`
+	Average     float64                `json:"average"`
+	Median      float64                `json:"median"`
+	High        float64                `json:"high"`
+	Low         float64                `json:"low"`
+	Variability *VariabilityStatistics `json:"variability,omitempty"`
+}
+
+// VariabilityStatistics are the glycemic variability metrics of a series of reads. All glucose values are in mg/dL.
+// Metrics that can't be calculated from the reads (i.e. MODD without at least a day of reads) are nil. The read count
+// is the number of actual reads, not counting those added by the interpolation policy.
+type VariabilityStatistics struct {
+	ReadCount              int                 `json:"readCount"`
+	Mean                   float64             `json:"mean"`
+	StandardDeviation      float64             `json:"standardDeviation"`
+	CoefficientOfVariation float64             `json:"coefficientOfVariation"`
+	MAGE                   *float64            `json:"mage"`
+	CONGA                  *float64            `json:"conga"`
+	CONGAHours             int                 `json:"congaHours"`
+	MODD                   *float64            `json:"modd"`
+	LBGI                   float64             `json:"lbgi"`
+	HBGI                   float64             `json:"hbgi"`
+	LowerBound             time.Time           `json:"lowerBound"`
+	UpperBound             time.Time           `json:"upperBound"`
+	Interpolation          InterpolationPolicy `json:"interpolation"`
 }
 
 type CoordinateSlice []Coordinate
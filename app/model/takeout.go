@@ -0,0 +1,31 @@
+package model
+
+import (
+	"time"
+)
+
+// Takeout archive statuses
+const (
+	TAKEOUT_STATUS_PENDING = "pending"
+	TAKEOUT_STATUS_READY   = "ready"
+	TAKEOUT_STATUS_FAILED  = "failed"
+)
+
+// TakeoutArchive represents a complete export of a user's account. The archive content is generated asynchronously
+// and stored as TakeoutChunk children. It can only be downloaded with a link signed with the archive's signing key
+// and only until it expires.
+type TakeoutArchive struct {
+	Status      string    `datastore:"status" json:"status"`
+	RequestedOn time.Time `datastore:"requestedOn" json:"requestedOn"`
+	CompletedOn time.Time `datastore:"completedOn" json:"completedOn"`
+	ExpiresOn   time.Time `datastore:"expiresOn" json:"expiresOn"`
+	Size        int64     `datastore:"size,noindex" json:"size"`
+	ChunkCount  int       `datastore:"chunkCount,noindex" json:"-"`
+	SigningKey  []byte    `datastore:"signingKey,noindex" json:"-"`
+}
+
+// TakeoutChunk is a part of a TakeoutArchive's content. Archives are split in chunks to stay under the
+// datastore's maximum entity size.
+type TakeoutChunk struct {
+	Content []byte `datastore:"content,noindex"`
+}
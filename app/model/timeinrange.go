@@ -0,0 +1,58 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TimeInRangeThresholds are the boundaries, in mg/dL, of the time in range bands. Values below VeryLow are very low,
+// values from VeryLow to below Low are low, values from Low to High are in range, values above High up to VeryHigh
+// are high and values above VeryHigh are very high.
+type TimeInRangeThresholds struct {
+	VeryLow  float64 `datastore:"veryLow,noindex" json:"veryLow"`
+	Low      float64 `datastore:"low,noindex" json:"low"`
+	High     float64 `datastore:"high,noindex" json:"high"`
+	VeryHigh float64 `datastore:"veryHigh,noindex" json:"veryHigh"`
+}
+
+// The international consensus thresholds
+var DEFAULT_TIME_IN_RANGE_THRESHOLDS = TimeInRangeThresholds{VeryLow: 54, Low: 70, High: 180, VeryHigh: 250}
+
+// Validate returns an error if the thresholds aren't strictly increasing
+func (thresholds TimeInRangeThresholds) Validate() error {
+	if thresholds.VeryLow <= 0 || thresholds.VeryLow >= thresholds.Low || thresholds.Low >= thresholds.High || thresholds.High >= thresholds.VeryHigh {
+		return errors.New(fmt.Sprintf("Invalid time in range thresholds [%v], must be positive and strictly increasing", thresholds))
+	}
+
+	return nil
+}
+
+// TimeInRange is the percentage of reads in each band over a period. The read count lets us combine periods
+// into larger windows.
+type TimeInRange struct {
+	VeryLow        float64               `datastore:"veryLow,noindex" json:"veryLow"`
+	Low            float64               `datastore:"low,noindex" json:"low"`
+	InRange        float64               `datastore:"inRange,noindex" json:"inRange"`
+	High           float64               `datastore:"high,noindex" json:"high"`
+	VeryHigh       float64               `datastore:"veryHigh,noindex" json:"veryHigh"`
+	ReadCount      int                   `datastore:"readCount,noindex" json:"readCount"`
+	Thresholds     TimeInRangeThresholds `datastore:"thresholds" json:"thresholds"`
+	LowerBound     time.Time             `datastore:"lowerBound" json:"lowerBound"`
+	UpperBound     time.Time             `datastore:"upperBound" json:"upperBound"`
+	CalculatedOn   time.Time             `datastore:"calculatedOn" json:"calculatedOn"`
+	ScoringVersion int                   `datastore:"scoringVersion" json:"scoringVersion"`
+}
+
+// UserSettings holds a user's preferences for the calculations we do on its data
+type UserSettings struct {
+	TimeInRangeThresholds TimeInRangeThresholds `datastore:"timeInRangeThresholds" json:"timeInRangeThresholds"`
+	A1CEstimator          string                `datastore:"a1cEstimator,noindex" json:"a1cEstimator"`
+	OnBoard               OnBoardSettings       `datastore:"onBoard" json:"onBoard"`
+	Interpolation         InterpolationPolicy   `datastore:"interpolation" json:"interpolation"`
+	ScoringProfile        ScoringProfile        `datastore:"scoringProfile" json:"scoringProfile"`
+}
+
+// DEFAULT_USER_SETTINGS are the settings of a user that never changed them
+var DEFAULT_USER_SETTINGS = UserSettings{TimeInRangeThresholds: DEFAULT_TIME_IN_RANGE_THRESHOLDS, A1CEstimator: A1C_ESTIMATOR_MEDIAN,
+	OnBoard: OnBoardSettings{CarbAbsorptionRate: DEFAULT_CARB_ABSORPTION_RATE}, Interpolation: DEFAULT_INTERPOLATION_POLICY}
@@ -0,0 +1,134 @@
+package model
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/util"
+	"sort"
+	"time"
+)
+
+// TimezoneSegment represents a period of a user's history during which all reads were recorded in the
+// same timezone. A user who never travels has a single segment while a trip shows up as a segment with
+// the timezone of the destination in between two segments of the home timezone.
+type TimezoneSegment struct {
+	Start    time.Time `datastore:"start" json:"start"`
+	End      time.Time `datastore:"end" json:"end"`
+	Timezone string    `datastore:"timezone,noindex" json:"timezone"`
+}
+
+// GetLocation returns the location of the segment's timezone
+func (segment TimezoneSegment) GetLocation() (location *time.Location, err error) {
+	return util.GetOrLoadLocationForName(segment.Timezone)
+}
+
+// Contains returns true if the given time falls within the segment. Both bounds are inclusive.
+func (segment TimezoneSegment) Contains(timeValue time.Time) bool {
+	return !timeValue.Before(segment.Start) && !timeValue.After(segment.End)
+}
+
+type TimezoneSegmentSlice []TimezoneSegment
+
+func (slice TimezoneSegmentSlice) Len() int {
+	return len(slice)
+}
+
+func (slice TimezoneSegmentSlice) Less(i, j int) bool {
+	return slice[i].Start.Before(slice[j].Start)
+}
+
+func (slice TimezoneSegmentSlice) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+// DetectTimezoneSegments splits the given reads into segments of consecutive reads sharing the same timezone.
+// A new segment starts every time a read's timezone differs from the one of the read preceding it, which is what
+// happens when the receiver's display time is changed after landing somewhere.
+func DetectTimezoneSegments(reads []apimodel.GlucoseRead) (segments []TimezoneSegment) {
+	sortedReads := make([]apimodel.GlucoseRead, len(reads))
+	copy(sortedReads, reads)
+	sort.Sort(apimodel.GlucoseReadSlice(sortedReads))
+
+	segments = make([]TimezoneSegment, 0)
+	for _, read := range sortedReads {
+		readTime := time.Unix(read.Time.Timestamp/1000, 0)
+		if len(segments) > 0 && segments[len(segments)-1].Timezone == read.Time.TimeZoneId {
+			segments[len(segments)-1].End = readTime
+		} else {
+			segments = append(segments, TimezoneSegment{Start: readTime, End: readTime, Timezone: read.Time.TimeZoneId})
+		}
+	}
+
+	return segments
+}
+
+// MergeTimezoneSegments merges freshly detected segments with the existing segments they overlap or directly follow.
+// The fresh segments are authoritative for the period they cover: existing segments are trimmed around
+// them and consecutive segments of the same timezone are coalesced into one.
+func MergeTimezoneSegments(existing []TimezoneSegment, fresh []TimezoneSegment) (merged []TimezoneSegment) {
+	if len(fresh) == 0 {
+		return existing
+	}
+
+	sort.Sort(TimezoneSegmentSlice(fresh))
+	freshStart := fresh[0].Start
+	freshEnd := fresh[len(fresh)-1].End
+
+	combined := make([]TimezoneSegment, 0, len(existing)+len(fresh)+1)
+	for _, segment := range existing {
+		if segment.Start.Before(freshStart) {
+			prefix := segment
+			if prefix.End.After(freshStart) {
+				prefix.End = freshStart
+			}
+			combined = append(combined, prefix)
+		}
+
+		// The suffix starts after the last fresh read so that it never shares its start with the last fresh segment,
+		// which is the case when that segment is a single read. Segments are keyed by their start and one
+		// would overwrite the other.
+		if segment.End.After(freshEnd) {
+			suffix := segment
+			if !suffix.Start.After(freshEnd) {
+				suffix.Start = freshEnd.Add(time.Second)
+			}
+			combined = append(combined, suffix)
+		}
+	}
+	combined = append(combined, fresh...)
+	sort.Sort(TimezoneSegmentSlice(combined))
+
+	merged = make([]TimezoneSegment, 0, len(combined))
+	for _, segment := range combined {
+		if len(merged) > 0 && merged[len(merged)-1].Timezone == segment.Timezone {
+			if segment.End.After(merged[len(merged)-1].End) {
+				merged[len(merged)-1].End = segment.End
+			}
+		} else {
+			merged = append(merged, segment)
+		}
+	}
+
+	return merged
+}
+
+// GetLocationAt returns the location in effect at the given time. The timezone of the closest segment before
+// the given time is used when it falls in a gap between segments and the fallback location is returned if there
+// is no segment prior to it. The slice is expected to be sorted by start time.
+func (slice TimezoneSegmentSlice) GetLocationAt(timeValue time.Time, fallback *time.Location) (location *time.Location) {
+	location = fallback
+	for _, segment := range slice {
+		if segment.Start.After(timeValue) {
+			continue
+		}
+
+		if segmentLocation, err := segment.GetLocation(); err == nil {
+			location = segmentLocation
+		}
+
+		if segment.Contains(timeValue) {
+			break
+		}
+	}
+
+	return location
+}
@@ -0,0 +1,100 @@
+package model_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	. "github.com/alexandre-normand/glukit/app/model"
+	"testing"
+	"time"
+)
+
+func TestDetectTimezoneSegmentsOfTrip(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := make([]apimodel.GlucoseRead, 0)
+	for i := 0; i < 288*3; i++ {
+		readTime := ct.Add(time.Duration(i*5) * time.Minute)
+		timezone := "America/Los_Angeles"
+		if i >= 288 && i < 288*2 {
+			timezone = "-0400"
+		}
+		reads = append(reads, apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(readTime), timezone}, apimodel.MG_PER_DL, float32(80)})
+	}
+
+	segments := DetectTimezoneSegments(reads)
+	if len(segments) != 3 {
+		t.Fatalf("Expected [3] segments but got [%d]: %v", len(segments), segments)
+	}
+
+	if segments[1].Timezone != "-0400" || !segments[1].Start.Equal(ct.Add(24*time.Hour)) {
+		t.Errorf("Expected trip segment in [-0400] starting at [%s] but got [%v]", ct.Add(24*time.Hour), segments[1])
+	}
+}
+
+func TestMergeTimezoneSegmentsExtendsPreviousSegment(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	existing := []TimezoneSegment{TimezoneSegment{ct, ct.Add(24 * time.Hour), "America/Los_Angeles"}}
+	fresh := []TimezoneSegment{TimezoneSegment{ct.Add(25 * time.Hour), ct.Add(48 * time.Hour), "America/Los_Angeles"}}
+
+	merged := MergeTimezoneSegments(existing, fresh)
+	if len(merged) != 1 {
+		t.Fatalf("Expected [1] segment but got [%d]: %v", len(merged), merged)
+	}
+
+	if !merged[0].Start.Equal(ct) || !merged[0].End.Equal(ct.Add(48*time.Hour)) {
+		t.Errorf("Expected segment from [%s] to [%s] but got [%v]", ct, ct.Add(48*time.Hour), merged[0])
+	}
+}
+
+func TestMergeTimezoneSegmentsSplitsExistingSegment(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	existing := []TimezoneSegment{TimezoneSegment{ct, ct.Add(72 * time.Hour), "America/Los_Angeles"}}
+	fresh := []TimezoneSegment{TimezoneSegment{ct.Add(24 * time.Hour), ct.Add(48 * time.Hour), "-0400"}}
+
+	merged := MergeTimezoneSegments(existing, fresh)
+	if len(merged) != 3 {
+		t.Fatalf("Expected [3] segments but got [%d]: %v", len(merged), merged)
+	}
+
+	location := TimezoneSegmentSlice(merged).GetLocationAt(ct.Add(36*time.Hour), time.UTC)
+	if location.String() != "-0400" {
+		t.Errorf("Expected location [-0400] during the trip but got [%s]", location)
+	}
+
+	location = TimezoneSegmentSlice(merged).GetLocationAt(ct.Add(60*time.Hour), time.UTC)
+	if location.String() != "America/Los_Angeles" {
+		t.Errorf("Expected location [America/Los_Angeles] after the trip but got [%s]", location)
+	}
+
+	location = TimezoneSegmentSlice(merged).GetLocationAt(ct.Add(-1*time.Hour), time.UTC)
+	if location != time.UTC {
+		t.Errorf("Expected fallback location before any segment but got [%s]", location)
+	}
+}
+
+func TestMergeTimezoneSegmentsWithSingleReadFreshSegment(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	existing := []TimezoneSegment{TimezoneSegment{ct, ct.Add(72 * time.Hour), "America/Los_Angeles"}}
+	fresh := []TimezoneSegment{TimezoneSegment{ct.Add(24 * time.Hour), ct.Add(36 * time.Hour), "America/Los_Angeles"},
+		TimezoneSegment{ct.Add(36*time.Hour + 5*time.Minute), ct.Add(36*time.Hour + 5*time.Minute), "-0400"}}
+
+	merged := MergeTimezoneSegments(existing, fresh)
+	if len(merged) != 3 {
+		t.Fatalf("Expected [3] segments but got [%d]: %v", len(merged), merged)
+	}
+
+	// Segments are keyed by their start so they must all be different
+	for i := 1; i < len(merged); i++ {
+		if !merged[i].Start.After(merged[i-1].Start) {
+			t.Errorf("Expected segment [%v] to start after segment [%v]", merged[i], merged[i-1])
+		}
+	}
+
+	location := TimezoneSegmentSlice(merged).GetLocationAt(ct.Add(36*time.Hour+5*time.Minute), time.UTC)
+	if location.String() != "-0400" {
+		t.Errorf("Expected location [-0400] of the single read but got [%s]", location)
+	}
+
+	location = TimezoneSegmentSlice(merged).GetLocationAt(ct.Add(48*time.Hour), time.UTC)
+	if location.String() != "America/Los_Angeles" {
+		t.Errorf("Expected location [America/Los_Angeles] after the single read but got [%s]", location)
+	}
+}
@@ -0,0 +1,34 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// Account deletion statuses
+const (
+	ACCOUNT_DELETION_PENDING   = "pending"
+	ACCOUNT_DELETION_COMPLETED = "completed"
+)
+
+// AccountTombstone is the audit record of an account deletion. It outlives the account and only keeps a hash of the
+// user's email keyed with a server secret so that we can prove a deletion happened for a given email without the
+// record alone telling who it was for.
+type AccountTombstone struct {
+	EmailHash       string    `datastore:"emailHash" json:"emailHash"`
+	Status          string    `datastore:"status" json:"status"`
+	RequestedOn     time.Time `datastore:"requestedOn" json:"requestedOn"`
+	CompletedOn     time.Time `datastore:"completedOn" json:"completedOn"`
+	DeletedEntities int64     `datastore:"deletedEntities,noindex" json:"deletedEntities"`
+}
+
+// HashEmail returns the hex encoded hmac-sha256 of a normalized email address with the given key. Unlike a plain
+// hash, candidate emails can't be hashed to find a match without the key.
+func HashEmail(email string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
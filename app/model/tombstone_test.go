@@ -0,0 +1,30 @@
+package model_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	. "github.com/alexandre-normand/glukit/app/model"
+	"testing"
+)
+
+func TestHashEmailIsNormalized(t *testing.T) {
+	key := []byte("secret")
+	if HashEmail(" John.Doe@Gmail.com", key) != HashEmail("john.doe@gmail.com", key) {
+		t.Errorf("Expected hashes of the same email with different casing and spacing to be equal")
+	}
+
+	if HashEmail("john.doe@gmail.com", key) == "john.doe@gmail.com" || len(HashEmail("john.doe@gmail.com", key)) != 64 {
+		t.Errorf("Expected a hex encoded hmac-sha256 but got [%s]", HashEmail("john.doe@gmail.com", key))
+	}
+}
+
+func TestHashEmailIsKeyed(t *testing.T) {
+	unkeyed := sha256.Sum256([]byte("john.doe@gmail.com"))
+	if hash := HashEmail("john.doe@gmail.com", []byte("secret")); hash == hex.EncodeToString(unkeyed[:]) {
+		t.Errorf("Expected the hash of an email not to match its plain sha256")
+	}
+
+	if HashEmail("john.doe@gmail.com", []byte("secret")) == HashEmail("john.doe@gmail.com", []byte("other")) {
+		t.Errorf("Expected hashes of the same email with different keys to differ")
+	}
+}
@@ -0,0 +1,220 @@
+// Package nightscout converts glukit data to the models of the Nightscout REST API so that tools built for Nightscout
+// can read from glukit.
+package nightscout
+
+import (
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"sort"
+	"time"
+)
+
+const (
+	ENTRY_TYPE_SGV = "sgv"
+	DEVICE         = "glukit"
+
+	// Nightscout treatment event types
+	EVENT_TYPE_CORRECTION_BOLUS = "Correction Bolus"
+	EVENT_TYPE_CARB_CORRECTION  = "Carb Correction"
+	EVENT_TYPE_EXERCISE         = "Exercise"
+
+	// Nightscout trend directions
+	DIRECTION_DOUBLE_UP       = "DoubleUp"
+	DIRECTION_SINGLE_UP       = "SingleUp"
+	DIRECTION_FORTY_FIVE_UP   = "FortyFiveUp"
+	DIRECTION_FLAT            = "Flat"
+	DIRECTION_FORTY_FIVE_DOWN = "FortyFiveDown"
+	DIRECTION_SINGLE_DOWN     = "SingleDown"
+	DIRECTION_DOUBLE_DOWN     = "DoubleDown"
+	DIRECTION_NOT_COMPUTABLE  = "NOT COMPUTABLE"
+
+	// Maximum time between two reads for us to compute a trend from them
+	MAX_TREND_INTERVAL = time.Duration(15) * time.Minute
+)
+
+// Entry is a Nightscout sensor glucose value. Nightscout values are always in mg/dL.
+type Entry struct {
+	Id         string `json:"_id"`
+	Type       string `json:"type"`
+	Sgv        int    `json:"sgv"`
+	Date       int64  `json:"date"`
+	DateString string `json:"dateString"`
+	Trend      int    `json:"trend"`
+	Direction  string `json:"direction"`
+	Device     string `json:"device"`
+}
+
+// Treatment is a Nightscout careportal event
+type Treatment struct {
+	Id        string   `json:"_id"`
+	EventType string   `json:"eventType"`
+	CreatedAt string   `json:"created_at"`
+	Insulin   *float32 `json:"insulin,omitempty"`
+	Carbs     *float32 `json:"carbs,omitempty"`
+	Duration  int      `json:"duration,omitempty"`
+	Notes     string   `json:"notes,omitempty"`
+	EnteredBy string   `json:"enteredBy"`
+}
+
+// Status is the Nightscout server status
+type Status struct {
+	Status            string   `json:"status"`
+	Name              string   `json:"name"`
+	Version           string   `json:"version"`
+	ServerTime        string   `json:"serverTime"`
+	ServerTimeEpoch   int64    `json:"serverTimeEpoch"`
+	ApiEnabled        bool     `json:"apiEnabled"`
+	CareportalEnabled bool     `json:"careportalEnabled"`
+	Settings          Settings `json:"settings"`
+}
+
+// Settings are the subset of Nightscout settings that clients look at
+type Settings struct {
+	Units string `json:"units"`
+}
+
+// NewStatus returns the status of a glukit server for a user with the given glucose unit
+func NewStatus(version string, serverTime time.Time, glucoseUnit apimodel.GlucoseUnit) Status {
+	units := "mg/dl"
+	if glucoseUnit == apimodel.MMOL_PER_L {
+		units = "mmol"
+	}
+
+	return Status{"ok", "glukit", version, serverTime.UTC().Format(time.RFC3339), serverTime.UnixNano() / int64(time.Millisecond), true, false, Settings{units}}
+}
+
+// NewEntries converts glucose reads to Nightscout entries, most recent first as Nightscout returns them. The direction
+// of each entry is computed from the rate of change since the read that precedes it.
+func NewEntries(reads []apimodel.GlucoseRead) (entries []Entry, err error) {
+	sorted := make([]apimodel.GlucoseRead, len(reads))
+	copy(sorted, reads)
+	sort.Sort(apimodel.GlucoseReadSlice(sorted))
+
+	entries = make([]Entry, len(sorted))
+	for i, read := range sorted {
+		value, err := read.GetNormalizedValue(apimodel.MG_PER_DL)
+		if err != nil {
+			return nil, err
+		}
+
+		trend, direction := 9, DIRECTION_NOT_COMPUTABLE
+		if i > 0 {
+			trend, direction = GetDirection(sorted[i-1], read)
+		}
+
+		readTime := read.GetTime()
+		entries[len(sorted)-1-i] = Entry{
+			Id:         fmt.Sprintf("%s-%d", ENTRY_TYPE_SGV, read.Time.Timestamp),
+			Type:       ENTRY_TYPE_SGV,
+			Sgv:        int(value + 0.5),
+			Date:       read.Time.Timestamp,
+			DateString: readTime.Format(time.RFC3339),
+			Trend:      trend,
+			Direction:  direction,
+			Device:     DEVICE,
+		}
+	}
+
+	return entries, nil
+}
+
+// GetDirection returns the Nightscout trend and direction of the change between two consecutive reads
+func GetDirection(previous, current apimodel.GlucoseRead) (trend int, direction string) {
+	interval := current.GetTime().Sub(previous.GetTime())
+	if interval <= 0 || interval > MAX_TREND_INTERVAL {
+		return 9, DIRECTION_NOT_COMPUTABLE
+	}
+
+	previousValue, err := previous.GetNormalizedValue(apimodel.MG_PER_DL)
+	if err != nil {
+		return 9, DIRECTION_NOT_COMPUTABLE
+	}
+	currentValue, err := current.GetNormalizedValue(apimodel.MG_PER_DL)
+	if err != nil {
+		return 9, DIRECTION_NOT_COMPUTABLE
+	}
+
+	// Rate of change in mg/dL per minute, using the same thresholds as the dexcom receivers
+	rate := float64(currentValue-previousValue) / interval.Minutes()
+	switch {
+	case rate > 3:
+		return 1, DIRECTION_DOUBLE_UP
+	case rate > 2:
+		return 2, DIRECTION_SINGLE_UP
+	case rate > 1:
+		return 3, DIRECTION_FORTY_FIVE_UP
+	case rate >= -1:
+		return 4, DIRECTION_FLAT
+	case rate >= -2:
+		return 5, DIRECTION_FORTY_FIVE_DOWN
+	case rate >= -3:
+		return 6, DIRECTION_SINGLE_DOWN
+	default:
+		return 7, DIRECTION_DOUBLE_DOWN
+	}
+}
+
+// NewTreatments converts injections, meals and exercises to Nightscout treatments, most recent first
+func NewTreatments(injections []apimodel.Injection, meals []apimodel.Meal, exercises []apimodel.Exercise) (treatments []Treatment) {
+	timestamps := make([]int64, 0, len(injections)+len(meals)+len(exercises))
+	treatments = make([]Treatment, 0, cap(timestamps))
+
+	for _, injection := range injections {
+		units := injection.Units
+		treatment := newTreatment(injection.Time, EVENT_TYPE_CORRECTION_BOLUS)
+		treatment.Insulin = &units
+		treatment.Notes = injection.InsulinName
+		treatments = append(treatments, treatment)
+		timestamps = append(timestamps, injection.Time.Timestamp)
+	}
+
+	for _, meal := range meals {
+		carbs := meal.Carbohydrates
+		treatment := newTreatment(meal.Time, EVENT_TYPE_CARB_CORRECTION)
+		treatment.Carbs = &carbs
+		treatments = append(treatments, treatment)
+		timestamps = append(timestamps, meal.Time.Timestamp)
+	}
+
+	for _, exercise := range exercises {
+		treatment := newTreatment(exercise.Time, EVENT_TYPE_EXERCISE)
+		treatment.Duration = exercise.DurationMinutes
+		treatment.Notes = exercise.Description
+		if len(exercise.Intensity) > 0 {
+			treatment.Notes = fmt.Sprintf("%s (%s)", exercise.Description, exercise.Intensity)
+		}
+		treatments = append(treatments, treatment)
+		timestamps = append(timestamps, exercise.Time.Timestamp)
+	}
+
+	sort.Sort(byMostRecent{treatments, timestamps})
+	return treatments
+}
+
+func newTreatment(value apimodel.Time, eventType string) Treatment {
+	return Treatment{
+		Id:        fmt.Sprintf("%s-%d", eventType, value.Timestamp),
+		EventType: eventType,
+		CreatedAt: value.GetTime().Format(time.RFC3339),
+		EnteredBy: DEVICE,
+	}
+}
+
+// byMostRecent sorts treatments along with their timestamps, most recent first
+type byMostRecent struct {
+	treatments []Treatment
+	timestamps []int64
+}
+
+func (s byMostRecent) Len() int {
+	return len(s.treatments)
+}
+
+func (s byMostRecent) Less(i, j int) bool {
+	return s.timestamps[i] > s.timestamps[j]
+}
+
+func (s byMostRecent) Swap(i, j int) {
+	s.treatments[i], s.treatments[j] = s.treatments[j], s.treatments[i]
+	s.timestamps[i], s.timestamps[j] = s.timestamps[j], s.timestamps[i]
+}
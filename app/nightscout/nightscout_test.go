@@ -0,0 +1,68 @@
+package nightscout_test
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	. "github.com/alexandre-normand/glukit/app/nightscout"
+	"testing"
+	"time"
+)
+
+func TestNewEntriesAreMostRecentFirstWithDirection(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := []apimodel.GlucoseRead{
+		apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(ct), "America/Los_Angeles"}, apimodel.MG_PER_DL, float32(100)},
+		apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(ct.Add(5 * time.Minute)), "America/Los_Angeles"}, apimodel.MG_PER_DL, float32(108)},
+		apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(ct.Add(40 * time.Minute)), "America/Los_Angeles"}, apimodel.MG_PER_DL, float32(108)},
+	}
+
+	entries, err := NewEntries(reads)
+	if err != nil {
+		t.Fatalf("Unexpected error converting reads: %v", err)
+	}
+
+	if len(entries) != 3 || entries[0].Date != reads[2].Time.Timestamp {
+		t.Fatalf("Expected [3] entries starting with the most recent but got [%v]", entries)
+	}
+
+	if entries[0].Direction != DIRECTION_NOT_COMPUTABLE {
+		t.Errorf("Expected direction [%s] after a gap but got [%s]", DIRECTION_NOT_COMPUTABLE, entries[0].Direction)
+	}
+
+	if entries[1].Direction != DIRECTION_FORTY_FIVE_UP || entries[1].Sgv != 108 {
+		t.Errorf("Expected sgv [108] with direction [%s] but got [%v]", DIRECTION_FORTY_FIVE_UP, entries[1])
+	}
+}
+
+func TestNewEntriesConvertsToMgPerDl(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	reads := []apimodel.GlucoseRead{apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(ct), "America/Los_Angeles"}, apimodel.MMOL_PER_L, float32(5.5)}}
+
+	entries, err := NewEntries(reads)
+	if err != nil {
+		t.Fatalf("Unexpected error converting reads: %v", err)
+	}
+
+	if entries[0].Sgv != 99 {
+		t.Errorf("Expected sgv [99] but got [%d]", entries[0].Sgv)
+	}
+}
+
+func TestNewTreatmentsAreMostRecentFirst(t *testing.T) {
+	ct, _ := time.Parse("02/01/2006 15:04", "18/04/2014 00:00")
+	injections := []apimodel.Injection{apimodel.Injection{apimodel.Time{apimodel.GetTimeMillis(ct.Add(time.Hour)), "America/Los_Angeles"}, float32(2.5), "Humalog", "Bolus"}}
+	meals := []apimodel.Meal{apimodel.Meal{apimodel.Time{apimodel.GetTimeMillis(ct), "America/Los_Angeles"}, float32(45), 0, 0, 0}}
+	exercises := []apimodel.Exercise{apimodel.Exercise{apimodel.Time{apimodel.GetTimeMillis(ct.Add(2 * time.Hour)), "America/Los_Angeles"}, 30, "Light", "Walk"}}
+
+	treatments := NewTreatments(injections, meals, exercises)
+	if len(treatments) != 3 {
+		t.Fatalf("Expected [3] treatments but got [%d]", len(treatments))
+	}
+
+	if treatments[0].EventType != EVENT_TYPE_EXERCISE || treatments[1].EventType != EVENT_TYPE_CORRECTION_BOLUS || treatments[2].EventType != EVENT_TYPE_CARB_CORRECTION {
+		t.Errorf("Expected treatments in reverse chronological order but got [%v]", treatments)
+	}
+
+	if *treatments[1].Insulin != 2.5 || *treatments[2].Carbs != 45 {
+		t.Errorf("Expected [2.5] units of insulin and [45] grams of carbs but got [%v]", treatments)
+	}
+}
@@ -8,12 +8,21 @@ import (
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/log"
+	"math"
 	"net/http"
 	"time"
 )
 
 var appSecrets = secrets.NewAppSecrets()
 
+const (
+	// The max number of grants read, saved or deleted at once, the limit of a GetMulti, PutMulti or DeleteMulti
+	GRANT_MULTI_SIZE = 500
+)
+
+// GRANT_KINDS are the kinds of the grants a user gives to oauth clients
+var GRANT_KINDS = []string{"authorize.data", "access.data", "access.refresh"}
+
 type OsinAppEngineStore struct {
 }
 
@@ -33,7 +42,8 @@ type oAuthorizeData struct {
 	RedirectUri string    `datastore:"RedirectUri"`
 	State       string    `datastore:"State"`
 	CreatedAt   time.Time `datastore:"CreatedAt"`
-	UserData    string    `datastore:"UserData,noindex"`
+	// UserData is the email of the user who authorized the client and is indexed to revoke the grants of a user
+	UserData string `datastore:"UserData"`
 }
 
 // AccessData
@@ -47,7 +57,16 @@ type oAccessData struct {
 	Scope             string    `datastore:"Scope,noindex"`
 	RedirectUri       string    `datastore:"RedirectUri,noindex"`
 	CreatedAt         time.Time `datastore:"CreatedAt,noindex"`
-	UserData          string    `datastore:"UserData,noindex"`
+	// UserData is the email of the user who granted access and is indexed to look up the grants of a user
+	UserData string `datastore:"UserData"`
+}
+
+// ClientGrant represents an oauth client that was granted access to a user's data
+type ClientGrant struct {
+	ClientId    string    `json:"clientId"`
+	RedirectUri string    `json:"redirectUri"`
+	Scope       string    `json:"scope"`
+	GrantedOn   time.Time `json:"grantedOn"`
 }
 
 func NewOsinAppEngineStoreWithRequest(r *http.Request) *OsinAppEngineStore {
@@ -365,6 +384,98 @@ func (s *OsinAppEngineStore) RemoveAccessWithContext(code string, context contex
 	return nil
 }
 
+// getUserGrantKeys returns the keys of the grants of a kind saved for a user. Grants saved before UserData was
+// indexed are only found once IndexLegacyGrants saved them again.
+func getUserGrantKeys(context context.Context, kind string, email string) (keys []*datastore.Key, err error) {
+	return datastore.NewQuery(kind).Filter("UserData =", email).KeysOnly().GetAll(context, nil)
+}
+
+// IndexLegacyGrants saves again a chunk of the grants of a kind with keys following the given one so that the
+// UserData of grants saved before it was indexed gets indexed. It returns the name of the key of the last grant of
+// the chunk, empty once all grants were saved.
+func IndexLegacyGrants(context context.Context, kind string, after string) (last string, err error) {
+	query := datastore.NewQuery(kind).Order("__key__").Limit(GRANT_MULTI_SIZE)
+	if after != "" {
+		query = query.Filter("__key__ >", datastore.NewKey(context, kind, after, 0, nil))
+	}
+
+	var keys []*datastore.Key
+	if kind == "authorize.data" {
+		authorizeData := make([]oAuthorizeData, 0)
+		if keys, err = query.GetAll(context, &authorizeData); err == nil && len(keys) > 0 {
+			_, err = datastore.PutMulti(context, keys, authorizeData)
+		}
+	} else {
+		accessData := make([]oAccessData, 0)
+		if keys, err = query.GetAll(context, &accessData); err == nil && len(keys) > 0 {
+			_, err = datastore.PutMulti(context, keys, accessData)
+		}
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	log.Infof(context, "Indexed [%d] grants of kind [%s] after [%s]", len(keys), kind, after)
+	if len(keys) < GRANT_MULTI_SIZE {
+		return "", nil
+	}
+
+	return keys[len(keys)-1].StringID(), nil
+}
+
+// GetClientGrantsWithContext returns the clients that have been granted access to the user's data along with their
+// most recent grant
+func (s *OsinAppEngineStore) GetClientGrantsWithContext(email string, context context.Context) (grants []ClientGrant, err error) {
+	keys, err := getUserGrantKeys(context, "access.data", email)
+	if err != nil {
+		return nil, err
+	}
+
+	grantsByClient := make(map[string]ClientGrant)
+	for chunkStartIndex := 0; chunkStartIndex < len(keys); chunkStartIndex = chunkStartIndex + GRANT_MULTI_SIZE {
+		chunkKeys := keys[chunkStartIndex:int(math.Min(float64(chunkStartIndex+GRANT_MULTI_SIZE), float64(len(keys))))]
+		accessData := make([]oAccessData, len(chunkKeys))
+		if err = datastore.GetMulti(context, chunkKeys, accessData); err != nil {
+			return nil, err
+		}
+
+		for _, data := range accessData {
+			if grant, ok := grantsByClient[data.ClientId]; !ok || grant.GrantedOn.Before(data.CreatedAt) {
+				grantsByClient[data.ClientId] = ClientGrant{data.ClientId, data.RedirectUri, data.Scope, data.CreatedAt}
+			}
+		}
+	}
+
+	grants = make([]ClientGrant, 0, len(grantsByClient))
+	for _, grant := range grantsByClient {
+		grants = append(grants, grant)
+	}
+
+	return grants, nil
+}
+
+// RevokeUserGrantsWithContext deletes all authorizations, access tokens and refresh tokens granted by a user
+func (s *OsinAppEngineStore) RevokeUserGrantsWithContext(email string, context context.Context) (err error) {
+	for _, kind := range GRANT_KINDS {
+		keys, err := getUserGrantKeys(context, kind, email)
+		if err != nil {
+			return err
+		}
+
+		for chunkStartIndex := 0; chunkStartIndex < len(keys); chunkStartIndex = chunkStartIndex + GRANT_MULTI_SIZE {
+			chunkKeys := keys[chunkStartIndex:int(math.Min(float64(chunkStartIndex+GRANT_MULTI_SIZE), float64(len(keys))))]
+			if err = datastore.DeleteMulti(context, chunkKeys); err != nil {
+				return err
+			}
+		}
+
+		log.Infof(context, "Revoked [%d] grants of kind [%s] for user [%s]", len(keys), kind, email)
+	}
+
+	return nil
+}
+
 func (s *OsinAppEngineStore) LoadRefresh(code string, r *http.Request) (*osin.AccessData, error) {
 	context := appengine.NewContext(r)
 	return s.LoadRefreshWithContext(code, context)
@@ -130,6 +130,28 @@ func StoreDaysOfReads(context context.Context, userProfileKey *datastore.Key, da
 		return nil, error
 	}
 
+	// Keep track of timezone changes so that trips show up as their own segments
+	batchReads := make([]apimodel.GlucoseRead, 0)
+	for i := range daysOfReads {
+		batchReads = append(batchReads, daysOfReads[i].Reads...)
+	}
+	if err := updateTimezoneSegments(context, userProfileKey, batchReads); err != nil {
+		log.Criticalf(context, "Error updating timezone segments of user [%s]: %v", userProfileKey, err)
+		return nil, err
+	}
+
+	// Keep the coverage of the days of the batch up to date so that users can tell why a score is missing
+	if err := updateDailyCoverage(context, userProfileKey, batchReads); err != nil {
+		log.Criticalf(context, "Error updating daily coverage of user [%s]: %v", userProfileKey, err)
+		return nil, err
+	}
+
+	// Scores and estimates already calculated over the days of the batch are stale now, the backfill of older data included
+	if err := markDirtyDays(context, userProfileKey, batchReads); err != nil {
+		log.Criticalf(context, "Error marking dirty days of user [%s]: %v", userProfileKey, err)
+		return nil, err
+	}
+
 	// Get the time of the batch's last read and update the most recent read timestamp if necessary
 	userProfile, err := GetGlukitUserWithKey(context, userProfileKey)
 	if err != nil {
@@ -728,6 +750,16 @@ func GetFileImportLog(context context.Context, userProfileKey *datastore.Key, fi
 	return fileImport, nil
 }
 
+// GetFileImportLogs returns all FileImportLog entries of a user
+func GetFileImportLogs(context context.Context, userProfileKey *datastore.Key) (fileImports []model.FileImportLog, err error) {
+	query := datastore.NewQuery("FileImportLog").Ancestor(userProfileKey)
+	if _, err = query.GetAll(context, &fileImports); err != nil {
+		return nil, err
+	}
+
+	return fileImports, nil
+}
+
 func GetGlukitUser(context context.Context, email string) (key *datastore.Key, userProfile *model.GlukitUser, err error) {
 	key = GetUserKey(context, email)
 	userProfile, err = GetGlukitUserWithKey(context, key)
@@ -946,3 +978,356 @@ func GetA1CEstimates(context context.Context, email string, scanQuery ScoreScanQ
 	log.Infof(context, "Found [%d] a1c estimates.", len(scores))
 	return scores, nil
 }
+
+// MigrateFixedOffsetTimezones rewrites the legacy fixed offset timezones (i.e. "-0700") of all days of data starting
+// between the lower and upper bounds to the given zone. An element is only relocated if the zone's offset at that instant
+// matches the legacy fixed offset, which means that data recorded while travelling keeps its fixed offset.
+func MigrateFixedOffsetTimezones(context context.Context, email string, location *time.Location, lowerBound time.Time, upperBound time.Time) (migrated int, err error) {
+	key := GetUserKey(context, email)
+
+	log.Infof(context, "Migrating fixed offset timezones to [%s] for days of data between [%s] and [%s]", location, lowerBound, upperBound)
+
+	var daysOfReads []apimodel.DayOfGlucoseReads
+	keys, err := newDaysOfDataQuery("DayOfReads", key, lowerBound, upperBound).GetAll(context, &daysOfReads)
+	if err != nil {
+		return migrated, err
+	}
+	for i := range daysOfReads {
+		for j := range daysOfReads[i].Reads {
+			daysOfReads[i].Reads[j].Time, migrated = relocateTime(daysOfReads[i].Reads[j].Time, location, migrated)
+		}
+	}
+	if _, err = datastore.PutMulti(context, keys, daysOfReads); err != nil {
+		return migrated, err
+	}
+
+	// Relocated reads no longer differ across daylight saving transitions, redetect their timezone segments
+	migratedReads := make([]apimodel.GlucoseRead, 0)
+	for i := range daysOfReads {
+		migratedReads = append(migratedReads, daysOfReads[i].Reads...)
+	}
+	if err = updateTimezoneSegments(context, key, migratedReads); err != nil {
+		return migrated, err
+	}
+
+	var daysOfCalibrations []apimodel.DayOfCalibrationReads
+	keys, err = newDaysOfDataQuery("DayOfCalibrationReads", key, lowerBound, upperBound).GetAll(context, &daysOfCalibrations)
+	if err != nil {
+		return migrated, err
+	}
+	for i := range daysOfCalibrations {
+		for j := range daysOfCalibrations[i].Reads {
+			daysOfCalibrations[i].Reads[j].Time, migrated = relocateTime(daysOfCalibrations[i].Reads[j].Time, location, migrated)
+		}
+	}
+	if _, err = datastore.PutMulti(context, keys, daysOfCalibrations); err != nil {
+		return migrated, err
+	}
+
+	var daysOfInjections []apimodel.DayOfInjections
+	keys, err = newDaysOfDataQuery("DayOfInjections", key, lowerBound, upperBound).GetAll(context, &daysOfInjections)
+	if err != nil {
+		return migrated, err
+	}
+	for i := range daysOfInjections {
+		for j := range daysOfInjections[i].Injections {
+			daysOfInjections[i].Injections[j].Time, migrated = relocateTime(daysOfInjections[i].Injections[j].Time, location, migrated)
+		}
+	}
+	if _, err = datastore.PutMulti(context, keys, daysOfInjections); err != nil {
+		return migrated, err
+	}
+
+	var daysOfMeals []apimodel.DayOfMeals
+	keys, err = newDaysOfDataQuery("DayOfMeals", key, lowerBound, upperBound).GetAll(context, &daysOfMeals)
+	if err != nil {
+		return migrated, err
+	}
+	for i := range daysOfMeals {
+		for j := range daysOfMeals[i].Meals {
+			daysOfMeals[i].Meals[j].Time, migrated = relocateTime(daysOfMeals[i].Meals[j].Time, location, migrated)
+		}
+	}
+	if _, err = datastore.PutMulti(context, keys, daysOfMeals); err != nil {
+		return migrated, err
+	}
+
+	var daysOfExercises []apimodel.DayOfExercises
+	keys, err = newDaysOfDataQuery("DayOfExercises", key, lowerBound, upperBound).GetAll(context, &daysOfExercises)
+	if err != nil {
+		return migrated, err
+	}
+	for i := range daysOfExercises {
+		for j := range daysOfExercises[i].Exercises {
+			daysOfExercises[i].Exercises[j].Time, migrated = relocateTime(daysOfExercises[i].Exercises[j].Time, location, migrated)
+		}
+	}
+	if _, err = datastore.PutMulti(context, keys, daysOfExercises); err != nil {
+		return migrated, err
+	}
+
+	log.Infof(context, "Migrated [%d] fixed offset timezones to [%s] for user [%s]", migrated, location, email)
+	return migrated, nil
+}
+
+// newDaysOfDataQuery returns the query for all days of data of a given kind starting between the two (inclusive) bounds
+func newDaysOfDataQuery(kind string, userKey *datastore.Key, lowerBound time.Time, upperBound time.Time) *datastore.Query {
+	return datastore.NewQuery(kind).Ancestor(userKey).Filter("startTime >=", lowerBound).Filter("startTime <=", upperBound).Order("startTime")
+}
+
+// relocateTime returns the time value with its timezone set to the given location if it currently has a legacy fixed offset
+// that matches the location's offset at that instant. The migrated count is incremented if the time value was relocated.
+func relocateTime(value apimodel.Time, location *time.Location, migrated int) (relocated apimodel.Time, count int) {
+	if !util.IsFixedOffsetLocationName(value.TimeZoneId) {
+		return value, migrated
+	}
+
+	timeValue := value.GetTime()
+	_, fixedOffset := timeValue.Zone()
+	if _, zoneOffset := timeValue.In(location).Zone(); zoneOffset != fixedOffset {
+		return value, migrated
+	}
+
+	return apimodel.Time{Timestamp: value.Timestamp, TimeZoneId: location.String()}, migrated + 1
+}
+
+// updateTimezoneSegments detects the timezone segments of the given reads and merges them with the segments already
+// stored for the user. Existing segments that overlap the reads or directly precede them are rewritten with the merged
+// result so that an incremental import simply extends the current segment.
+func updateTimezoneSegments(context context.Context, userProfileKey *datastore.Key, reads []apimodel.GlucoseRead) (err error) {
+	freshSegments := model.DetectTimezoneSegments(reads)
+	if len(freshSegments) == 0 {
+		return nil
+	}
+
+	// Segments are replaced by their merged version, this has to be atomic or a failed write would lose them
+	return datastore.RunInTransaction(context, mergeTimezoneSegments(userProfileKey, freshSegments), nil)
+}
+
+// mergeTimezoneSegments returns the transaction replacing the existing segments overlapping fresh ones with their
+// merged version. All segments are in the user's entity group.
+func mergeTimezoneSegments(userProfileKey *datastore.Key, freshSegments []model.TimezoneSegment) func(context context.Context) error {
+	return func(context context.Context) error {
+		freshStart := freshSegments[0].Start
+		freshEnd := freshSegments[len(freshSegments)-1].End
+
+		query := datastore.NewQuery("TimezoneSegment").Ancestor(userProfileKey).Filter("start <=", freshEnd).Order("-start")
+		existingKeys := make([]*datastore.Key, 0)
+		existingSegments := make([]model.TimezoneSegment, 0)
+
+		iterator := query.Run(context)
+		for {
+			var segment model.TimezoneSegment
+			key, err := iterator.Next(&segment)
+			if err == datastore.Done {
+				break
+			} else if err != nil {
+				return err
+			}
+
+			existingKeys = append(existingKeys, key)
+			existingSegments = append(existingSegments, segment)
+
+			// Stop at the first segment entirely before the fresh reads, it's only needed to extend it
+			if segment.End.Before(freshStart) {
+				break
+			}
+		}
+
+		mergedSegments := model.MergeTimezoneSegments(existingSegments, freshSegments)
+		mergedKeys := make([]*datastore.Key, len(mergedSegments))
+		for i := range mergedSegments {
+			mergedKeys[i] = datastore.NewKey(context, "TimezoneSegment", "", mergedSegments[i].Start.Unix(), userProfileKey)
+		}
+
+		if len(existingKeys) > 0 {
+			if err := datastore.DeleteMulti(context, existingKeys); err != nil {
+				log.Warningf(context, "Error deleting [%d] timezone segments with keys [%s]: %v", len(existingKeys), existingKeys, err)
+				return err
+			}
+		}
+
+		log.Debugf(context, "Emitting a PutMulti with [%d] keys for all [%d] merged timezone segments", len(mergedKeys), len(mergedSegments))
+		if _, err := datastore.PutMulti(context, mergedKeys, mergedSegments); err != nil {
+			log.Warningf(context, "Error writing [%d] timezone segments with keys [%s]: %v", len(mergedKeys), mergedKeys, err)
+			return err
+		}
+
+		return nil
+	}
+}
+
+// GetTimezoneSegments returns all TimezoneSegments for the given email address and matching the query parameters. Segments
+// are matched if they overlap the period between from and to, most recent first.
+func GetTimezoneSegments(context context.Context, email string, scanQuery ScoreScanQuery) (segments []model.TimezoneSegment, err error) {
+	key := GetUserKey(context, email)
+
+	query := datastore.NewQuery("TimezoneSegment").Ancestor(key)
+	// The datastore only supports inequality filters on a single property so the overlap is checked against the end of
+	// the segment when scanning and against its start in memory
+	if scanQuery.From != nil {
+		query = query.Filter("end >=", *scanQuery.From)
+	}
+	query = query.Order("-end")
+
+	var candidates []model.TimezoneSegment
+	if _, err = query.GetAll(context, &candidates); err != nil {
+		return nil, err
+	}
+
+	segments = make([]model.TimezoneSegment, 0)
+	for _, segment := range candidates {
+		if scanQuery.To != nil && segment.Start.After(*scanQuery.To) {
+			continue
+		}
+
+		if scanQuery.Limit != nil && len(segments) >= *scanQuery.Limit {
+			break
+		}
+		segments = append(segments, segment)
+	}
+
+	log.Infof(context, "Found [%d] timezone segments.", len(segments))
+	return segments, nil
+}
+
+// GetLocationAt returns the location that was in effect for the user at the given time according to its timezone
+// segments. The fallback location is returned if no segment starts before that time.
+func GetLocationAt(context context.Context, email string, timeValue time.Time, fallback *time.Location) (location *time.Location, err error) {
+	key := GetUserKey(context, email)
+
+	var segments []model.TimezoneSegment
+	query := datastore.NewQuery("TimezoneSegment").Ancestor(key).Filter("start <=", timeValue).Order("-start").Limit(1)
+	if _, err = query.GetAll(context, &segments); err != nil {
+		return fallback, err
+	}
+
+	return model.TimezoneSegmentSlice(segments).GetLocationAt(timeValue, fallback), nil
+}
+
+// ScanGlucoseReads calls the handler with the GlucoseReads between the two (inclusive) bounds, one day at a time. The days are
+// fetched from an iterator so that scanning a range of multiple years never loads more than a single day in memory.
+func ScanGlucoseReads(context context.Context, email string, lowerBound time.Time, upperBound time.Time, handler func(reads []apimodel.GlucoseRead) error) (err error) {
+	iterator := newDaysOfDataQuery("DayOfReads", GetUserKey(context, email), lowerBound.Add(-1*apimodel.DAY_OF_DATA_DURATION), upperBound).Run(context)
+	for {
+		var dayOfReads apimodel.DayOfGlucoseReads
+		if _, err = iterator.Next(&dayOfReads); err == datastore.Done {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		reads := make([]apimodel.GlucoseRead, 0, len(dayOfReads.Reads))
+		for _, read := range dayOfReads.Reads {
+			if isWithinBounds(read.Time, lowerBound, upperBound) {
+				reads = append(reads, read)
+			}
+		}
+
+		if err = handler(reads); err != nil {
+			return err
+		}
+	}
+}
+
+// ScanCalibrations calls the handler with the CalibrationReads between the two (inclusive) bounds, one day at a time.
+func ScanCalibrations(context context.Context, email string, lowerBound time.Time, upperBound time.Time, handler func(calibrations []apimodel.CalibrationRead) error) (err error) {
+	iterator := newDaysOfDataQuery("DayOfCalibrationReads", GetUserKey(context, email), lowerBound.Add(-1*apimodel.DAY_OF_DATA_DURATION), upperBound).Run(context)
+	for {
+		var dayOfCalibrations apimodel.DayOfCalibrationReads
+		if _, err = iterator.Next(&dayOfCalibrations); err == datastore.Done {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		calibrations := make([]apimodel.CalibrationRead, 0, len(dayOfCalibrations.Reads))
+		for _, calibration := range dayOfCalibrations.Reads {
+			if isWithinBounds(calibration.Time, lowerBound, upperBound) {
+				calibrations = append(calibrations, calibration)
+			}
+		}
+
+		if err = handler(calibrations); err != nil {
+			return err
+		}
+	}
+}
+
+// ScanInjections calls the handler with the Injections between the two (inclusive) bounds, one day at a time.
+func ScanInjections(context context.Context, email string, lowerBound time.Time, upperBound time.Time, handler func(injections []apimodel.Injection) error) (err error) {
+	iterator := newDaysOfDataQuery("DayOfInjections", GetUserKey(context, email), lowerBound.Add(-1*apimodel.DAY_OF_DATA_DURATION), upperBound).Run(context)
+	for {
+		var dayOfInjections apimodel.DayOfInjections
+		if _, err = iterator.Next(&dayOfInjections); err == datastore.Done {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		injections := make([]apimodel.Injection, 0, len(dayOfInjections.Injections))
+		for _, injection := range dayOfInjections.Injections {
+			if isWithinBounds(injection.Time, lowerBound, upperBound) {
+				injections = append(injections, injection)
+			}
+		}
+
+		if err = handler(injections); err != nil {
+			return err
+		}
+	}
+}
+
+// ScanMeals calls the handler with the Meals between the two (inclusive) bounds, one day at a time.
+func ScanMeals(context context.Context, email string, lowerBound time.Time, upperBound time.Time, handler func(meals []apimodel.Meal) error) (err error) {
+	iterator := newDaysOfDataQuery("DayOfMeals", GetUserKey(context, email), lowerBound.Add(-1*apimodel.DAY_OF_DATA_DURATION), upperBound).Run(context)
+	for {
+		var dayOfMeals apimodel.DayOfMeals
+		if _, err = iterator.Next(&dayOfMeals); err == datastore.Done {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		meals := make([]apimodel.Meal, 0, len(dayOfMeals.Meals))
+		for _, meal := range dayOfMeals.Meals {
+			if isWithinBounds(meal.Time, lowerBound, upperBound) {
+				meals = append(meals, meal)
+			}
+		}
+
+		if err = handler(meals); err != nil {
+			return err
+		}
+	}
+}
+
+// ScanExercises calls the handler with the Exercises between the two (inclusive) bounds, one day at a time.
+func ScanExercises(context context.Context, email string, lowerBound time.Time, upperBound time.Time, handler func(exercises []apimodel.Exercise) error) (err error) {
+	iterator := newDaysOfDataQuery("DayOfExercises", GetUserKey(context, email), lowerBound.Add(-1*apimodel.DAY_OF_DATA_DURATION), upperBound).Run(context)
+	for {
+		var dayOfExercises apimodel.DayOfExercises
+		if _, err = iterator.Next(&dayOfExercises); err == datastore.Done {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		exercises := make([]apimodel.Exercise, 0, len(dayOfExercises.Exercises))
+		for _, exercise := range dayOfExercises.Exercises {
+			if isWithinBounds(exercise.Time, lowerBound, upperBound) {
+				exercises = append(exercises, exercise)
+			}
+		}
+
+		if err = handler(exercises); err != nil {
+			return err
+		}
+	}
+}
+
+// isWithinBounds returns true if the time value is between the two bounds, inclusively
+func isWithinBounds(value apimodel.Time, lowerBound time.Time, upperBound time.Time) bool {
+	timeValue := time.Unix(value.Timestamp/1000, 0)
+	return !timeValue.Before(lowerBound) && !timeValue.After(upperBound)
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/model"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+const (
+	// Maximum number of entities deleted by a single call to DeleteUserEntitiesBatch
+	ACCOUNT_DELETION_BATCH_SIZE = 500
+)
+
+// StoreAccountTombstone stores a new AccountTombstone and returns its key
+func StoreAccountTombstone(context context.Context, tombstone model.AccountTombstone) (key *datastore.Key, err error) {
+	key = datastore.NewIncompleteKey(context, "AccountTombstone", nil)
+	if key, err = datastore.Put(context, key, &tombstone); err != nil {
+		log.Warningf(context, "Error storing account tombstone [%s]: %v", tombstone.EmailHash, err)
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// UpdateAccountTombstone updates an existing AccountTombstone
+func UpdateAccountTombstone(context context.Context, key *datastore.Key, tombstone model.AccountTombstone) (err error) {
+	_, err = datastore.Put(context, key, &tombstone)
+	return err
+}
+
+// GetAccountTombstone returns the AccountTombstone with the given id
+func GetAccountTombstone(context context.Context, id int64) (key *datastore.Key, tombstone *model.AccountTombstone, err error) {
+	key = datastore.NewKey(context, "AccountTombstone", "", id, nil)
+	tombstone = new(model.AccountTombstone)
+	if err = datastore.Get(context, key, tombstone); err != nil {
+		return nil, nil, err
+	}
+
+	return key, tombstone, nil
+}
+
+// HashAccountEmail returns the hash of an email kept by account tombstones, keyed with the tombstone secret
+func HashAccountEmail(email string) string {
+	return model.HashEmail(email, []byte(appSecrets.AccountTombstoneKey))
+}
+
+// IsAccountDeletionPending returns true if the deletion of the account with the given email has been requested
+// but isn't completed yet
+func IsAccountDeletionPending(context context.Context, email string) (pending bool, err error) {
+	keys, err := datastore.NewQuery("AccountTombstone").Filter("emailHash =", HashAccountEmail(email)).
+		Filter("status =", model.ACCOUNT_DELETION_PENDING).KeysOnly().Limit(1).GetAll(context, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return len(keys) > 0, nil
+}
+
+// DeleteUserEntitiesBatch deletes up to ACCOUNT_DELETION_BATCH_SIZE entities under the user key, whatever their kind.
+// The GlukitUser entity itself is only deleted once it has no children left so that a deletion can always be resumed.
+// It returns the number of deleted entities and whether everything is gone.
+func DeleteUserEntitiesBatch(context context.Context, email string) (deleted int, done bool, err error) {
+	userKey := GetUserKey(context, email)
+
+	// An ancestor query includes the ancestor itself, hence the extra key
+	keys, err := datastore.NewQuery("").Ancestor(userKey).KeysOnly().Limit(ACCOUNT_DELETION_BATCH_SIZE+1).GetAll(context, nil)
+	if err != nil {
+		return 0, false, err
+	}
+
+	childKeys := make([]*datastore.Key, 0, len(keys))
+	for _, key := range keys {
+		if !key.Equal(userKey) {
+			childKeys = append(childKeys, key)
+		}
+	}
+
+	if len(childKeys) > ACCOUNT_DELETION_BATCH_SIZE {
+		childKeys = childKeys[:ACCOUNT_DELETION_BATCH_SIZE]
+	}
+
+	if len(childKeys) > 0 {
+		if err = datastore.DeleteMulti(context, childKeys); err != nil {
+			return 0, false, err
+		}
+
+		return len(childKeys), false, nil
+	}
+
+	if err = datastore.Delete(context, userKey); err != nil {
+		return 0, false, err
+	}
+
+	return len(keys), true, nil
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/model"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"time"
+)
+
+// StoreApiSecret stores the hash of a new API secret for a user. Any previous secret of the user is revoked.
+func StoreApiSecret(context context.Context, email string, secretHash string) (err error) {
+	if err = DeleteApiSecrets(context, email); err != nil {
+		return err
+	}
+
+	key := datastore.NewKey(context, "ApiSecret", secretHash, 0, nil)
+	if _, err = datastore.Put(context, key, &model.ApiSecret{Email: email, CreatedOn: time.Now()}); err != nil {
+		log.Warningf(context, "Error storing api secret for user [%s]: %v", email, err)
+		return err
+	}
+
+	return nil
+}
+
+// GetApiSecretEmail returns the email of the user with the API secret that has the given hash
+func GetApiSecretEmail(context context.Context, secretHash string) (email string, err error) {
+	secret := new(model.ApiSecret)
+	if err = datastore.Get(context, datastore.NewKey(context, "ApiSecret", secretHash, 0, nil), secret); err != nil {
+		return "", err
+	}
+
+	return secret.Email, nil
+}
+
+// DeleteApiSecrets revokes all API secrets of a user
+func DeleteApiSecrets(context context.Context, email string) (err error) {
+	keys, err := datastore.NewQuery("ApiSecret").Filter("email =", email).KeysOnly().GetAll(context, nil)
+	if err != nil {
+		return err
+	}
+
+	return datastore.DeleteMulti(context, keys)
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"time"
+)
+
+// updateDailyCoverage calculates the coverage of every day touched by a batch of reads again and stores it. Days
+// are calculated from all of their stored reads since a day might have been imported in more than one batch.
+func updateDailyCoverage(context context.Context, userProfileKey *datastore.Key, batchReads []apimodel.GlucoseRead) (err error) {
+	if len(batchReads) == 0 {
+		return nil
+	}
+
+	lowerBound, upperBound := batchReads[0].GetTime(), batchReads[0].GetTime()
+	for _, read := range batchReads {
+		if readTime := read.GetTime(); readTime.Before(lowerBound) {
+			lowerBound = readTime
+		} else if readTime.After(upperBound) {
+			upperBound = readTime
+		}
+	}
+
+	return storeDailyCoverage(context, userProfileKey, lowerBound, upperBound)
+}
+
+// UpdateDailyCoverage calculates the coverage of every day of a user touching the bounds again and stores it. This
+// is how the coverage of reads imported before coverage was tracked gets filled in.
+func UpdateDailyCoverage(context context.Context, email string, lowerBound time.Time, upperBound time.Time) (err error) {
+	return storeDailyCoverage(context, GetUserKey(context, email), lowerBound, upperBound)
+}
+
+// GetUncoveredReadsStart returns the time of the oldest read of a user if its day has no coverage, which is the case
+// of reads imported before coverage was tracked. It returns the zero time if there are no reads or they're covered.
+func GetUncoveredReadsStart(context context.Context, email string) (start time.Time, err error) {
+	daysOfReads := make([]apimodel.DayOfGlucoseReads, 0)
+	if _, err = datastore.NewQuery("DayOfReads").Ancestor(GetUserKey(context, email)).Order("startTime").Limit(1).GetAll(context, &daysOfReads); err != nil {
+		return time.Time{}, err
+	}
+
+	if len(daysOfReads) == 0 || len(daysOfReads[0].Reads) == 0 {
+		return time.Time{}, nil
+	}
+
+	firstRead := daysOfReads[0].Reads[0].GetTime()
+	for _, read := range daysOfReads[0].Reads {
+		if read.GetTime().Before(firstRead) {
+			firstRead = read.GetTime()
+		}
+	}
+
+	// The local day of the first read starts at most 38 hours before it
+	coverage, err := GetDailyCoverage(context, email, firstRead.Add(time.Duration(-38)*time.Hour), firstRead)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if len(coverage) > 0 {
+		return time.Time{}, nil
+	}
+
+	return firstRead, nil
+}
+
+// storeDailyCoverage calculates the coverage of every day touching the bounds from all of their stored reads and
+// stores it
+func storeDailyCoverage(context context.Context, userProfileKey *datastore.Key, lowerBound time.Time, upperBound time.Time) (err error) {
+	// Days are local so a day can start up to 14 hours before its UTC midnight and end as much after
+	reads, err := GetGlucoseReads(context, userProfileKey.StringID(), lowerBound.Add(time.Duration(-38)*time.Hour), upperBound.Add(time.Duration(38)*time.Hour))
+	if err != nil {
+		return err
+	}
+
+	coverage := make([]model.DailyCoverage, 0)
+	for _, day := range model.CalculateDailyCoverage(reads, time.Now()) {
+		dayEnd := day.Start.AddDate(0, 0, 1)
+		if dayEnd.After(lowerBound) && !day.Start.After(upperBound) {
+			coverage = append(coverage, day)
+		}
+	}
+
+	keys := make([]*datastore.Key, len(coverage))
+	for i := range coverage {
+		keys[i] = datastore.NewKey(context, "DailyCoverage", coverage[i].Date, 0, userProfileKey)
+	}
+
+	log.Debugf(context, "Emitting a PutMulti with [%d] keys for all [%d] days of coverage", len(keys), len(coverage))
+	if _, err = datastore.PutMulti(context, keys, coverage); err != nil {
+		log.Warningf(context, "Error writing [%d] days of coverage with keys [%s]: %v", len(keys), keys, err)
+		return err
+	}
+
+	return nil
+}
+
+// GetDailyCoverage returns the coverage of the days of a user starting between the bounds, oldest first
+func GetDailyCoverage(context context.Context, email string, lowerBound time.Time, upperBound time.Time) (coverage []model.DailyCoverage, err error) {
+	coverage = make([]model.DailyCoverage, 0)
+	query := datastore.NewQuery("DailyCoverage").Ancestor(GetUserKey(context, email)).Filter("start >=", lowerBound).Filter("start <=", upperBound).Order("start")
+	if _, err = query.GetAll(context, &coverage); err != nil {
+		return nil, err
+	}
+
+	return coverage, nil
+}
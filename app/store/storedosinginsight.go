@@ -0,0 +1,34 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/model"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+func getDosingInsightKey(context context.Context, email string) *datastore.Key {
+	return datastore.NewKey(context, "DosingInsight", "insight", 0, GetUserKey(context, email))
+}
+
+// GetDosingInsight returns the most recent dosing insight of a user or an empty one if it wasn't calculated yet
+func GetDosingInsight(context context.Context, email string) (insight *model.DosingInsight, err error) {
+	insight = new(model.DosingInsight)
+	if err = datastore.Get(context, getDosingInsightKey(context, email), insight); err == datastore.ErrNoSuchEntity {
+		return insight, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return insight, nil
+}
+
+// StoreDosingInsight stores the dosing insight of a user, replacing the previous one
+func StoreDosingInsight(context context.Context, email string, insight model.DosingInsight) (err error) {
+	if _, err = datastore.Put(context, getDosingInsightKey(context, email), &insight); err != nil {
+		log.Warningf(context, "Error storing dosing insight for user [%s]: %v", email, err)
+		return err
+	}
+
+	return nil
+}
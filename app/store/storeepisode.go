@@ -0,0 +1,71 @@
+package store
+
+import (
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/model"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"time"
+)
+
+// ReplaceGlycemicEpisodes replaces all episodes of a user starting between the lower bound (inclusive) and the
+// upper bound (exclusive) with the given episodes
+func ReplaceGlycemicEpisodes(context context.Context, email string, lowerBound, upperBound time.Time, episodes []model.GlycemicEpisode) (err error) {
+	parentKey := GetUserKey(context, email)
+	staleKeys, err := datastore.NewQuery("GlycemicEpisode").Ancestor(parentKey).Filter("start >=", lowerBound).Filter("start <", upperBound).KeysOnly().GetAll(context, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = datastore.DeleteMulti(context, staleKeys); err != nil {
+		return err
+	}
+
+	keys := make([]*datastore.Key, len(episodes))
+	for i, episode := range episodes {
+		keys[i] = datastore.NewKey(context, "GlycemicEpisode", fmt.Sprintf("%s-%d-%d", episode.Type, episode.Level, episode.Start.Unix()), 0, parentKey)
+	}
+
+	if _, err = datastore.PutMulti(context, keys, episodes); err != nil {
+		log.Criticalf(context, "Error writing [%d] glycemic episodes for user [%s]: %v", len(keys), email, err)
+		return err
+	}
+
+	log.Debugf(context, "Replaced [%d] glycemic episodes with [%d] for user [%s] from [%s] to [%s]", len(staleKeys), len(episodes), email, lowerBound, upperBound)
+	return nil
+}
+
+// GetGlycemicEpisodes returns the episodes of a user starting between the lower and upper bounds, most recent first
+func GetGlycemicEpisodes(context context.Context, email string, lowerBound, upperBound time.Time) (episodes []model.GlycemicEpisode, err error) {
+	episodes = make([]model.GlycemicEpisode, 0)
+	query := datastore.NewQuery("GlycemicEpisode").Ancestor(GetUserKey(context, email)).Filter("start >=", lowerBound).Filter("start <=", upperBound).Order("-start")
+	if _, err = query.GetAll(context, &episodes); err != nil {
+		return nil, err
+	}
+
+	return episodes, nil
+}
+
+func getEpisodeDetectionProgressKey(context context.Context, email string) *datastore.Key {
+	return datastore.NewKey(context, "EpisodeDetectionProgress", "progress", 0, GetUserKey(context, email))
+}
+
+// GetEpisodeDetectionProgress returns how far episodes have been detected for a user. Episodes of a user that never
+// had any detection are detected from the given default.
+func GetEpisodeDetectionProgress(context context.Context, email string, defaultDetectedUntil time.Time) (progress *model.EpisodeDetectionProgress, err error) {
+	progress = &model.EpisodeDetectionProgress{DetectedUntil: defaultDetectedUntil}
+	if err = datastore.Get(context, getEpisodeDetectionProgressKey(context, email), progress); err == datastore.ErrNoSuchEntity {
+		return progress, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return progress, nil
+}
+
+// StoreEpisodeDetectionProgress stores how far episodes have been detected for a user
+func StoreEpisodeDetectionProgress(context context.Context, email string, progress model.EpisodeDetectionProgress) (err error) {
+	_, err = datastore.Put(context, getEpisodeDetectionProgressKey(context, email), &progress)
+	return err
+}
@@ -0,0 +1,199 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/util"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// The max number of dirty days or calculations deleted at once, the limit of a DeleteMulti
+	INVALIDATION_DELETE_MULTI_SIZE = 500
+)
+
+type dirtyDaysByStart []model.DirtyDay
+
+func (slice dirtyDaysByStart) Len() int {
+	return len(slice)
+}
+
+func (slice dirtyDaysByStart) Less(i, j int) bool {
+	return slice[i].Start.Before(slice[j].Start)
+}
+
+func (slice dirtyDaysByStart) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+// markDirtyDays marks every UTC day touched by a batch of reads as dirty so that the calculated windows overlapping
+// them get calculated again
+func markDirtyDays(context context.Context, userProfileKey *datastore.Key, batchReads []apimodel.GlucoseRead) (err error) {
+	markedOn := time.Now()
+	days := make(map[int64]model.DirtyDay)
+	for _, read := range batchReads {
+		dayStart := util.GetMidnightUTCBefore(read.GetTime())
+		days[dayStart.Unix()] = model.DirtyDay{Start: dayStart, MarkedOn: markedOn}
+	}
+
+	keys := make([]*datastore.Key, 0, len(days))
+	dirtyDays := make([]model.DirtyDay, 0, len(days))
+	for id, day := range days {
+		keys = append(keys, datastore.NewKey(context, "DirtyDay", "", id, userProfileKey))
+		dirtyDays = append(dirtyDays, day)
+	}
+
+	log.Debugf(context, "Emitting a PutMulti with [%d] keys for all [%d] dirty days", len(keys), len(dirtyDays))
+	if _, err = datastore.PutMulti(context, keys, dirtyDays); err != nil {
+		log.Warningf(context, "Error writing [%d] dirty days with keys [%s]: %v", len(keys), keys, err)
+		return err
+	}
+
+	return nil
+}
+
+// GetDirtyDays returns the dirty days of a user that were marked at or before the given time, oldest first
+func GetDirtyDays(context context.Context, email string, markedBefore time.Time) (days []model.DirtyDay, err error) {
+	allDays := make([]model.DirtyDay, 0)
+	if _, err = datastore.NewQuery("DirtyDay").Ancestor(GetUserKey(context, email)).GetAll(context, &allDays); err != nil {
+		return nil, err
+	}
+
+	days = make([]model.DirtyDay, 0, len(allDays))
+	for _, day := range allDays {
+		if !day.MarkedOn.After(markedBefore) {
+			days = append(days, day)
+		}
+	}
+
+	sort.Sort(dirtyDaysByStart(days))
+	return days, nil
+}
+
+// ClearDirtyDays deletes the dirty days of a user that were marked at or before the given time. Days marked again
+// since then are kept. Days are cleared in chunks that each fit in a DeleteMulti.
+func ClearDirtyDays(context context.Context, email string, markedBefore time.Time) (err error) {
+	keys, err := datastore.NewQuery("DirtyDay").Ancestor(GetUserKey(context, email)).KeysOnly().GetAll(context, nil)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf(context, "Clearing dirty days of user [%s] marked before [%s] out of [%d]", email, markedBefore, len(keys))
+	for chunkStartIndex := 0; chunkStartIndex < len(keys); chunkStartIndex = chunkStartIndex + INVALIDATION_DELETE_MULTI_SIZE {
+		chunkEndIndex := int(math.Min(float64(chunkStartIndex+INVALIDATION_DELETE_MULTI_SIZE), float64(len(keys))))
+		if err = datastore.RunInTransaction(context, clearDirtyDaysMarkedBefore(keys[chunkStartIndex:chunkEndIndex], markedBefore), nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// clearDirtyDaysMarkedBefore returns the transaction deleting the dirty days of a chunk of keys that were marked at
+// or before the given time. Days already cleared by another recalculation are skipped.
+func clearDirtyDaysMarkedBefore(keys []*datastore.Key, markedBefore time.Time) func(context context.Context) error {
+	return func(context context.Context) error {
+		days := make([]model.DirtyDay, len(keys))
+		err := datastore.GetMulti(context, keys, days)
+		multierr, ok := err.(appengine.MultiError)
+		if !ok && err != nil {
+			return err
+		}
+
+		clearedKeys := make([]*datastore.Key, 0, len(keys))
+		for i, day := range days {
+			if multierr != nil && multierr[i] != nil {
+				if multierr[i] == datastore.ErrNoSuchEntity {
+					continue
+				}
+				return multierr[i]
+			}
+
+			if !day.MarkedOn.After(markedBefore) {
+				clearedKeys = append(clearedKeys, keys[i])
+			}
+		}
+
+		return datastore.DeleteMulti(context, clearedKeys)
+	}
+}
+
+// GetOutdatedGlukitScoreBounds returns the upper bounds of the glukit scores of a user calculated with a scoring
+// version older than the given one
+func GetOutdatedGlukitScoreBounds(context context.Context, email string, scoringVersion int) (upperBounds []time.Time, err error) {
+	return getOutdatedBounds(context, "GlukitScore", email, scoringVersion)
+}
+
+// GetOutdatedA1CEstimateBounds returns the upper bounds of the a1c estimates of a user calculated with a scoring
+// version older than the given one
+func GetOutdatedA1CEstimateBounds(context context.Context, email string, scoringVersion int) (upperBounds []time.Time, err error) {
+	return getOutdatedBounds(context, "A1CEstimate", email, scoringVersion)
+}
+
+// getOutdatedBounds returns the upper bounds of the scores of a kind with an older scoring version. Scores are keyed
+// by their upper bound. The scoring version property is named after the field since its datastore tag is unterminated.
+func getOutdatedBounds(context context.Context, kind string, email string, scoringVersion int) (upperBounds []time.Time, err error) {
+	keys, err := datastore.NewQuery(kind).Ancestor(GetUserKey(context, email)).Filter("ScoringVersion <", scoringVersion).KeysOnly().GetAll(context, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	upperBounds = make([]time.Time, len(keys))
+	for i, key := range keys {
+		upperBounds[i] = time.Unix(key.IntID(), 0).UTC()
+	}
+
+	log.Infof(context, "Found [%d] outdated [%s] of user [%s]", len(upperBounds), kind, email)
+	return upperBounds, nil
+}
+
+// DeleteGlukitScores deletes the glukit scores of a user with the given upper bounds
+func DeleteGlukitScores(context context.Context, email string, upperBounds []time.Time) (err error) {
+	return deleteScores(context, "GlukitScore", email, upperBounds)
+}
+
+// DeleteA1CEstimates deletes the a1c estimates of a user with the given upper bounds
+func DeleteA1CEstimates(context context.Context, email string, upperBounds []time.Time) (err error) {
+	return deleteScores(context, "A1CEstimate", email, upperBounds)
+}
+
+// GetA1CEstimateBounds returns the upper bounds of the a1c estimates of a user ending between the bounds, both
+// inclusive
+func GetA1CEstimateBounds(context context.Context, email string, lowerBound time.Time, upperBound time.Time) (upperBounds []time.Time, err error) {
+	query := datastore.NewQuery("A1CEstimate").Ancestor(GetUserKey(context, email)).Filter("upperBound >=", lowerBound).Filter("upperBound <=", upperBound).Order("-upperBound")
+	keys, err := query.KeysOnly().GetAll(context, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	upperBounds = make([]time.Time, len(keys))
+	for i, key := range keys {
+		upperBounds[i] = time.Unix(key.IntID(), 0).UTC()
+	}
+
+	return upperBounds, nil
+}
+
+func deleteScores(context context.Context, kind string, email string, upperBounds []time.Time) (err error) {
+	parentKey := GetUserKey(context, email)
+	keys := make([]*datastore.Key, len(upperBounds))
+	for i, upperBound := range upperBounds {
+		keys[i] = datastore.NewKey(context, kind, "", upperBound.Unix(), parentKey)
+	}
+
+	log.Infof(context, "Deleting [%d] [%s] of user [%s]", len(keys), kind, email)
+	for chunkStartIndex := 0; chunkStartIndex < len(keys); chunkStartIndex = chunkStartIndex + INVALIDATION_DELETE_MULTI_SIZE {
+		chunkEndIndex := int(math.Min(float64(chunkStartIndex+INVALIDATION_DELETE_MULTI_SIZE), float64(len(keys))))
+		if err = datastore.DeleteMulti(context, keys[chunkStartIndex:chunkEndIndex]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
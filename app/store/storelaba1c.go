@@ -0,0 +1,67 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+// StoreLabA1Cs stores lab a1c results of a user. Results are keyed by the time the sample was taken so sending the
+// same result again replaces it.
+func StoreLabA1Cs(context context.Context, email string, labA1Cs []apimodel.LabA1C) (err error) {
+	parentKey := GetUserKey(context, email)
+	keys := make([]*datastore.Key, len(labA1Cs))
+	for i, labA1C := range labA1Cs {
+		keys[i] = datastore.NewKey(context, "LabA1C", "", labA1C.GetTime().Unix(), parentKey)
+	}
+
+	if _, err = datastore.PutMulti(context, keys, labA1Cs); err != nil {
+		log.Warningf(context, "Error storing [%d] lab a1cs for user [%s]: %v", len(labA1Cs), email, err)
+		return err
+	}
+
+	return nil
+}
+
+// GetLabA1Cs returns all lab a1c results of a user, oldest first
+func GetLabA1Cs(context context.Context, email string) (labA1Cs []apimodel.LabA1C, err error) {
+	labA1Cs = make([]apimodel.LabA1C, 0)
+	if _, err = datastore.NewQuery("LabA1C").Ancestor(GetUserKey(context, email)).Order("__key__").GetAll(context, &labA1Cs); err != nil {
+		return nil, err
+	}
+
+	return labA1Cs, nil
+}
+
+// DeleteLabA1C deletes the lab a1c result of a user taken at the given time, in seconds since epoch
+func DeleteLabA1C(context context.Context, email string, timestamp int64) (err error) {
+	return datastore.Delete(context, datastore.NewKey(context, "LabA1C", "", timestamp, GetUserKey(context, email)))
+}
+
+func getA1CCalibrationKey(context context.Context, email string) *datastore.Key {
+	return datastore.NewKey(context, "A1CCalibration", "calibration", 0, GetUserKey(context, email))
+}
+
+// GetA1CCalibration returns the a1c calibration of a user or an empty calibration if it doesn't have one yet
+func GetA1CCalibration(context context.Context, email string) (calibration *model.A1CCalibration, err error) {
+	calibration = new(model.A1CCalibration)
+	if err = datastore.Get(context, getA1CCalibrationKey(context, email), calibration); err == datastore.ErrNoSuchEntity {
+		return calibration, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return calibration, nil
+}
+
+// StoreA1CCalibration stores the a1c calibration of a user
+func StoreA1CCalibration(context context.Context, email string, calibration model.A1CCalibration) (err error) {
+	if _, err = datastore.Put(context, getA1CCalibrationKey(context, email), &calibration); err != nil {
+		log.Warningf(context, "Error storing a1c calibration for user [%s]: %v", email, err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/model"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"sort"
+)
+
+func getPatternDetectionKey(context context.Context, email string) *datastore.Key {
+	return datastore.NewKey(context, "PatternDetection", "detection", 0, GetUserKey(context, email))
+}
+
+// GetPatternReport returns the most recent pattern detection of a user along with the patterns it found, strongest
+// first. The report is empty if patterns were never detected.
+func GetPatternReport(context context.Context, email string) (report *model.PatternReport, err error) {
+	report = new(model.PatternReport)
+	if err = datastore.Get(context, getPatternDetectionKey(context, email), &report.PatternDetection); err == datastore.ErrNoSuchEntity {
+		report.Patterns = make([]model.GlucosePattern, 0)
+		return report, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	report.Patterns = make([]model.GlucosePattern, 0)
+	if _, err = datastore.NewQuery("GlucosePattern").Ancestor(getPatternDetectionKey(context, email)).GetAll(context, &report.Patterns); err != nil {
+		return nil, err
+	}
+
+	sort.Sort(patternsByStrength(report.Patterns))
+	return report, nil
+}
+
+// StorePatternReport stores a pattern detection of a user and its patterns, replacing the previous ones
+func StorePatternReport(context context.Context, email string, report model.PatternReport) (err error) {
+	detectionKey := getPatternDetectionKey(context, email)
+	previousKeys, err := datastore.NewQuery("GlucosePattern").Ancestor(detectionKey).KeysOnly().GetAll(context, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = datastore.DeleteMulti(context, previousKeys); err != nil {
+		log.Warningf(context, "Error deleting previous glucose patterns of user [%s]: %v", email, err)
+		return err
+	}
+
+	keys := make([]*datastore.Key, len(report.Patterns))
+	for i, pattern := range report.Patterns {
+		keys[i] = datastore.NewKey(context, "GlucosePattern", pattern.Type+pattern.MealSlot, 0, detectionKey)
+	}
+
+	if _, err = datastore.PutMulti(context, keys, report.Patterns); err != nil {
+		log.Warningf(context, "Error storing [%d] glucose patterns for user [%s]: %v", len(report.Patterns), email, err)
+		return err
+	}
+
+	if _, err = datastore.Put(context, detectionKey, &report.PatternDetection); err != nil {
+		log.Warningf(context, "Error storing pattern detection for user [%s]: %v", email, err)
+		return err
+	}
+
+	return nil
+}
+
+type patternsByStrength []model.GlucosePattern
+
+func (slice patternsByStrength) Len() int {
+	return len(slice)
+}
+
+func (slice patternsByStrength) Less(i, j int) bool {
+	return slice[i].Strength > slice[j].Strength
+}
+
+func (slice patternsByStrength) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
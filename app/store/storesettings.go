@@ -0,0 +1,35 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/model"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+func getUserSettingsKey(context context.Context, email string) *datastore.Key {
+	return datastore.NewKey(context, "UserSettings", "settings", 0, GetUserKey(context, email))
+}
+
+// GetUserSettings returns the settings of a user or the default settings if the user never changed them
+func GetUserSettings(context context.Context, email string) (settings *model.UserSettings, err error) {
+	settings = new(model.UserSettings)
+	*settings = model.DEFAULT_USER_SETTINGS
+	if err = datastore.Get(context, getUserSettingsKey(context, email), settings); err == datastore.ErrNoSuchEntity {
+		return settings, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// StoreUserSettings stores the settings of a user
+func StoreUserSettings(context context.Context, email string, settings model.UserSettings) (err error) {
+	if _, err = datastore.Put(context, getUserSettingsKey(context, email), &settings); err != nil {
+		log.Warningf(context, "Error storing settings for user [%s]: %v", email, err)
+		return err
+	}
+
+	return nil
+}
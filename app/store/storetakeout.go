@@ -0,0 +1,130 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/model"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"io"
+)
+
+const (
+	// Size of a single TakeoutChunk, comfortably under the datastore's maximum entity size of 1MB
+	TAKEOUT_CHUNK_SIZE = 900 * 1024
+)
+
+// StoreTakeoutArchive stores a new TakeoutArchive for a user and returns its key
+func StoreTakeoutArchive(context context.Context, email string, archive model.TakeoutArchive) (key *datastore.Key, err error) {
+	key = datastore.NewIncompleteKey(context, "TakeoutArchive", GetUserKey(context, email))
+	if key, err = datastore.Put(context, key, &archive); err != nil {
+		log.Warningf(context, "Error storing takeout archive for user [%s]: %v", email, err)
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// UpdateTakeoutArchive updates an existing TakeoutArchive
+func UpdateTakeoutArchive(context context.Context, key *datastore.Key, archive model.TakeoutArchive) (err error) {
+	_, err = datastore.Put(context, key, &archive)
+	return err
+}
+
+// GetTakeoutArchive returns the TakeoutArchive of a user with the given id
+func GetTakeoutArchive(context context.Context, email string, id int64) (key *datastore.Key, archive *model.TakeoutArchive, err error) {
+	key = datastore.NewKey(context, "TakeoutArchive", "", id, GetUserKey(context, email))
+	archive = new(model.TakeoutArchive)
+	if err = datastore.Get(context, key, archive); err != nil {
+		return nil, nil, err
+	}
+
+	return key, archive, nil
+}
+
+// DeleteTakeoutArchive deletes a TakeoutArchive along with all of its content
+func DeleteTakeoutArchive(context context.Context, key *datastore.Key) (err error) {
+	chunkKeys, err := datastore.NewQuery("TakeoutChunk").Ancestor(key).KeysOnly().GetAll(context, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = datastore.DeleteMulti(context, chunkKeys); err != nil {
+		return err
+	}
+
+	return datastore.Delete(context, key)
+}
+
+// WriteTakeoutContent writes the content of a TakeoutArchive to the writer, one chunk at a time
+func WriteTakeoutContent(context context.Context, key *datastore.Key, chunkCount int, writer io.Writer) (err error) {
+	for i := 1; i <= chunkCount; i++ {
+		var chunk model.TakeoutChunk
+		if err = datastore.Get(context, datastore.NewKey(context, "TakeoutChunk", "", int64(i), key), &chunk); err != nil {
+			return err
+		}
+
+		if _, err = writer.Write(chunk.Content); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DataStoreTakeoutWriter is an io.Writer that persists the content of a TakeoutArchive as TakeoutChunks.
+type DataStoreTakeoutWriter struct {
+	c          context.Context
+	k          *datastore.Key
+	buffer     []byte
+	ChunkCount int
+	Size       int64
+}
+
+// NewDataStoreTakeoutWriter creates a new DataStoreTakeoutWriter for the TakeoutArchive with the given key
+func NewDataStoreTakeoutWriter(context context.Context, archiveKey *datastore.Key) *DataStoreTakeoutWriter {
+	w := new(DataStoreTakeoutWriter)
+	w.c = context
+	w.k = archiveKey
+	w.buffer = make([]byte, 0, TAKEOUT_CHUNK_SIZE)
+	return w
+}
+
+func (w *DataStoreTakeoutWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		available := TAKEOUT_CHUNK_SIZE - len(w.buffer)
+		if available > len(p) {
+			available = len(p)
+		}
+
+		w.buffer = append(w.buffer, p[:available]...)
+		p = p[available:]
+		n = n + available
+
+		if len(w.buffer) == TAKEOUT_CHUNK_SIZE {
+			if err = w.Flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// Flush persists any buffered content as a new TakeoutChunk
+func (w *DataStoreTakeoutWriter) Flush() (err error) {
+	if len(w.buffer) == 0 {
+		return nil
+	}
+
+	// Chunk ids start at 1 since 0 isn't a valid datastore id
+	key := datastore.NewKey(w.c, "TakeoutChunk", "", int64(w.ChunkCount+1), w.k)
+	if _, err = datastore.Put(w.c, key, &model.TakeoutChunk{Content: w.buffer}); err != nil {
+		log.Warningf(w.c, "Error writing takeout chunk with key [%s]: %v", key, err)
+		return err
+	}
+
+	w.ChunkCount = w.ChunkCount + 1
+	w.Size = w.Size + int64(len(w.buffer))
+	w.buffer = make([]byte, 0, TAKEOUT_CHUNK_SIZE)
+	return nil
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"github.com/alexandre-normand/glukit/app/model"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"math"
+)
+
+// StoreTimeInRangeBatch stores a batch of daily TimeInRange. Entries are keyed by their upper bound so that a
+// recalculation overwrites the previous value of a day.
+func StoreTimeInRangeBatch(context context.Context, userEmail string, timeInRanges []model.TimeInRange) (err error) {
+	parentKey := GetUserKey(context, userEmail)
+
+	totalBatchSize := float64(len(timeInRanges))
+	for chunkStartIndex := 0; chunkStartIndex < len(timeInRanges); chunkStartIndex = chunkStartIndex + GLUKIT_SCORE_PUT_MULTI_SIZE {
+		chunkEndIndex := int(math.Min(float64(chunkStartIndex+GLUKIT_SCORE_PUT_MULTI_SIZE), totalBatchSize))
+		chunk := timeInRanges[chunkStartIndex:chunkEndIndex]
+
+		elementKeys := make([]*datastore.Key, len(chunk))
+		for i := range chunk {
+			elementKeys[i] = datastore.NewKey(context, "TimeInRange", "", chunk[i].UpperBound.Unix(), parentKey)
+		}
+
+		if _, err = datastore.PutMulti(context, elementKeys, chunk); err != nil {
+			log.Criticalf(context, "Error writing [%d] time in ranges for user [%s]: %v", len(elementKeys), userEmail, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetTimeInRanges returns the daily TimeInRange for the given email address and matching the query parameters, most
+// recent first
+func GetTimeInRanges(context context.Context, email string, scanQuery ScoreScanQuery) (timeInRanges []model.TimeInRange, err error) {
+	key := GetUserKey(context, email)
+
+	query := datastore.NewQuery("TimeInRange").Ancestor(key)
+	if scanQuery.From != nil {
+		query = query.Filter("upperBound >=", *scanQuery.From)
+	}
+	if scanQuery.To != nil {
+		query = query.Filter("upperBound <=", *scanQuery.To)
+	}
+	if scanQuery.Limit != nil {
+		query = query.Limit(*scanQuery.Limit)
+	}
+	query = query.Order("-upperBound")
+
+	timeInRanges = make([]model.TimeInRange, 0)
+	if _, err = query.GetAll(context, &timeInRanges); err != nil {
+		return nil, err
+	}
+
+	log.Infof(context, "Found [%d] time in ranges for user [%s].", len(timeInRanges), email)
+	return timeInRanges, nil
+}
+
+// DeleteOutdatedTimeInRanges deletes the time in ranges of a user calculated with a scoring version older than the
+// given one. It returns the number of time in ranges deleted.
+func DeleteOutdatedTimeInRanges(context context.Context, email string, scoringVersion int) (deletedCount int, err error) {
+	keys, err := datastore.NewQuery("TimeInRange").Ancestor(GetUserKey(context, email)).Filter("scoringVersion <", scoringVersion).KeysOnly().GetAll(context, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	log.Infof(context, "Deleting [%d] outdated time in ranges of user [%s]", len(keys), email)
+	for chunkStartIndex := 0; chunkStartIndex < len(keys); chunkStartIndex = chunkStartIndex + INVALIDATION_DELETE_MULTI_SIZE {
+		chunkEndIndex := int(math.Min(float64(chunkStartIndex+INVALIDATION_DELETE_MULTI_SIZE), float64(len(keys))))
+		if err = datastore.DeleteMulti(context, keys[chunkStartIndex:chunkEndIndex]); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(keys), nil
+}
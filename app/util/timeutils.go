@@ -85,6 +85,15 @@ func GetMidnightUTCBefore(timeValue time.Time) (latestMidnightBoundary time.Time
 	return latestMidnightBoundary
 }
 
+// GetMidnightBefore returns the very last occurence of midnight in the given location before the given time.
+// To give an example, if the given time is July 17th 2h00 UTC and the location is PST, the boundary returned is
+// July 16th 00h00 PST.
+func GetMidnightBefore(timeValue time.Time, location *time.Location) (latestMidnightBoundary time.Time) {
+	local := timeValue.In(location)
+	latestMidnightBoundary = time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+	return latestMidnightBoundary
+}
+
 // Returns the timevalue with its timezone set to UTC but without
 // printing the timezone in the formatted string
 func TimeInUTCNoTz(timevalue time.Time) (localTime string) {
@@ -121,6 +130,30 @@ func GetLocaltimeOffset(localTime string, internalTime time.Time) (location *tim
 	return time.FixedZone(locationName, int(durationOffset.Seconds()))
 }
 
+// ResolveLocation returns the location of a local time given its matching internal time and the user's configured
+// zone. If the zone's offset at that instant matches the offset extrapolated from the local time, the zone itself is
+// returned so that daylight saving transitions don't split a user's history into different locations. Otherwise, the user
+// is likely travelling (or hasn't configured a zone yet) and we fall back to the fixed offset location.
+func ResolveLocation(localTime string, internalTime time.Time, zone *time.Location) (location *time.Location) {
+	location = GetLocaltimeOffset(localTime, internalTime)
+	if zone == nil {
+		return location
+	}
+
+	// The fixed offset name is truncated to the closest 15 minutes while the raw offset isn't, compare with the former
+	if _, zoneOffset := internalTime.In(zone).Zone(); zoneOffset == getFixedOffsetInSeconds(location.String()) {
+		return zone
+	}
+
+	return location
+}
+
+// IsFixedOffsetLocationName returns true if the location name is one of the legacy fixed offset
+// names (i.e. "-0700") rather than a proper zone name (i.e. "America/Los_Angeles")
+func IsFixedOffsetLocationName(locationName string) bool {
+	return zoneNameRegexp.MatchString(locationName)
+}
+
 // GetLocalTimeInProperLocation returns the parsed local time with the location appropriately set as extrapolated
 // by calculating the difference of the internal time vs the local time
 func GetLocalTimeInProperLocation(localTime string, internalTime time.Time) (localTimeWithLocation time.Time) {
@@ -136,11 +169,7 @@ func GetOrLoadLocationForName(locationName string) (location *time.Location, err
 			if !zoneNameRegexp.MatchString(locationName) {
 				return nil, errors.New(fmt.Sprintf("Invalid location name, not a valid timezone location [%s]", locationName))
 			} else {
-				var hours, minutes int64
-				fmt.Sscanf(locationName, "%+03d%02d", &hours, &minutes)
-				offsetInMinutes := hours*int64(time.Duration(60)*time.Minute) + minutes
-				offsetInSeconds := offsetInMinutes + minutes*int64(time.Duration(60)*time.Second)
-				location = time.FixedZone(locationName, int(offsetInSeconds))
+				location = time.FixedZone(locationName, getFixedOffsetInSeconds(locationName))
 				locationCache[locationName] = location
 			}
 		}
@@ -151,3 +180,15 @@ func GetOrLoadLocationForName(locationName string) (location *time.Location, err
 		return location, nil
 	}
 }
+
+// getFixedOffsetInSeconds parses a fixed offset location name (i.e. "-0730") into its offset in seconds
+func getFixedOffsetInSeconds(locationName string) (offsetInSeconds int) {
+	var hours, minutes int
+	fmt.Sscanf(locationName[1:], "%02d%02d", &hours, &minutes)
+	offsetInSeconds = hours*int(time.Hour/time.Second) + minutes*int(time.Minute/time.Second)
+	if locationName[0] == '-' {
+		return -offsetInSeconds
+	}
+
+	return offsetInSeconds
+}
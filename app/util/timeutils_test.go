@@ -60,3 +60,62 @@ func TestGetTimeWithImpliedLocation(t *testing.T) {
 		t.Errorf("Expected timestamp [%d] but got [%d]", expected, timeValue.Unix())
 	}
 }
+
+func TestResolveLocationAcrossDaylightSavingTransition(t *testing.T) {
+	zone, err := GetOrLoadLocationForName("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// PST (-0800) before the transition, PDT (-0700) after. Both should resolve to the same zone
+	winterTime, _ := time.Parse(TIMEFORMAT_NO_TZ, "2014-03-08 20:00:00")
+	if location := ResolveLocation("2014-03-08 12:00:00", winterTime, zone); location != zone {
+		t.Errorf("Expected location [%s] but got [%s]", zone, location)
+	}
+
+	summerTime, _ := time.Parse(TIMEFORMAT_NO_TZ, "2014-03-10 19:00:00")
+	if location := ResolveLocation("2014-03-10 12:00:00", summerTime, zone); location != zone {
+		t.Errorf("Expected location [%s] but got [%s]", zone, location)
+	}
+}
+
+func TestResolveLocationWhileTravelling(t *testing.T) {
+	zone, err := GetOrLoadLocationForName("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	internalTime, _ := time.Parse(TIMEFORMAT_NO_TZ, "2014-04-18 09:00:00")
+	location := ResolveLocation("2014-04-18 05:00:00", internalTime, zone)
+
+	if location.String() != "-0400" {
+		t.Errorf("Expected location [-0400] but got [%s]", location.String())
+	}
+}
+
+func TestFixedOffsetLocationLoading(t *testing.T) {
+	location, err := GetOrLoadLocationForName("-0730")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, offset := time.Now().In(location).Zone(); offset != -27000 {
+		t.Errorf("Expected offset of [-27000] seconds but got [%d]", offset)
+	}
+
+	if !IsFixedOffsetLocationName("+0130") || IsFixedOffsetLocationName("America/Montreal") {
+		t.Errorf("Fixed offset location names not properly detected")
+	}
+}
+
+func TestGetMidnightBefore(t *testing.T) {
+	zone, err := GetOrLoadLocationForName("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	midnight := GetMidnightBefore(time.Date(2014, time.July, 17, 2, 0, 0, 0, time.UTC), zone)
+	if expected := time.Date(2014, time.July, 16, 0, 0, 0, 0, zone); !midnight.Equal(expected) {
+		t.Errorf("Expected midnight [%s] but got [%s]", expected, midnight)
+	}
+}
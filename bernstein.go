@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/alexandre-normand/glukit/app/apimodel"
-	"github.com/alexandre-normand/glukit/app/engine"
 	"github.com/alexandre-normand/glukit/app/importer"
 	"github.com/alexandre-normand/glukit/app/model"
 	"github.com/alexandre-normand/glukit/app/store"
@@ -60,17 +59,7 @@ func initializeGlukitBernstein(writer http.ResponseWriter, reader *http.Request)
 		if glukitUser, err := store.GetUserProfile(context, userProfileKey); err != nil {
 			log.Warningf(context, "Error getting retrieving GlukitUser [%s], this needs attention: [%v]", GLUKIT_BERNSTEIN_EMAIL, err)
 		} else {
-			// Start batch calculation of the glukit scores
-			err := engine.StartGlukitScoreBatch(context, glukitUser)
-
-			if err != nil {
-				log.Warningf(context, "Error starting batch calculation of GlukitScores for [%s], this needs attention: [%v]", GLUKIT_BERNSTEIN_EMAIL, err)
-			}
-
-			err = engine.StartA1CCalculationBatch(context, glukitUser)
-			if err != nil {
-				log.Warningf(context, "Error starting batch calculation of a1cs for [%s], this needs attention: [%v]", GLUKIT_BERNSTEIN_EMAIL, err)
-			}
+			startCalculationsAfterImport(context, glukitUser)
 		}
 	} else if err != nil {
 		util.Propagate(err)
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/user"
+	"net/http"
+)
+
+const (
+	// Number of days of the coverage calendar when no range is requested
+	DEFAULT_COVERAGE_DAYS = 90
+)
+
+func coverageCalendar(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	coverageCalendarForEmail(writer, request, user.Email)
+}
+
+func coverageCalendarForDemo(writer http.ResponseWriter, request *http.Request) {
+	coverageCalendarForEmail(writer, request, DEMO_EMAIL)
+}
+
+// coverageCalendarForEmail is the endpoint to retrieve the coverage of every day of a range, oldest first: the
+// percentage of the expected reads that were received and the gaps between them. The range defaults to the last 90
+// days of data.
+func coverageCalendarForEmail(writer http.ResponseWriter, request *http.Request, email string) {
+	context := appengine.NewContext(request)
+
+	_, _, mostRecentRead, err := store.GetUserData(context, email)
+	if err == store.ErrNoImportedDataFound {
+		log.Debugf(context, "No imported data found for user [%s]", email)
+		http.Error(writer, err.Error(), 204)
+		return
+	} else if err != nil {
+		util.Propagate(err)
+	}
+
+	lowerBound, upperBound, err := newReadRange(request, mostRecentRead.AddDate(0, 0, -1*DEFAULT_COVERAGE_DAYS), mostRecentRead)
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	coverage, err := store.GetDailyCoverage(context, email, lowerBound, upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(coverage)
+}
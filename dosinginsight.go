@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/user"
+	"net/http"
+)
+
+const (
+	DOSING_INSIGHT_DISCLAIMER = "This describes how past boluses worked out and is not a dosing recommendation. " +
+		"Talk to your healthcare team before changing your carb ratio or correction factor."
+)
+
+// DosingInsightResponse is a dosing insight along with the disclaimer that must be shown with it
+type DosingInsightResponse struct {
+	Insight    model.DosingInsight `json:"insight"`
+	Disclaimer string              `json:"disclaimer"`
+}
+
+func dosingInsight(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	dosingInsightForEmail(writer, request, user.Email)
+}
+
+func dosingInsightForDemo(writer http.ResponseWriter, request *http.Request) {
+	dosingInsightForEmail(writer, request, DEMO_EMAIL)
+}
+
+// dosingInsightForEmail is the read-only endpoint to retrieve the most recent weekly dosing insight of a user: the
+// carb ratio and correction factor its boluses effectively had, by block of the day
+func dosingInsightForEmail(writer http.ResponseWriter, request *http.Request, email string) {
+	context := appengine.NewContext(request)
+
+	insight, err := store.GetDosingInsight(context, email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	if insight.CalculatedOn.IsZero() {
+		http.Error(writer, "No dosing insight calculated yet.", 204)
+		return
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(DosingInsightResponse{Insight: *insight, Disclaimer: DOSING_INSIGHT_DISCLAIMER})
+}
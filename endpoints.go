@@ -11,8 +11,10 @@ import (
 	"github.com/alexandre-normand/glukit/app/store"
 	"github.com/alexandre-normand/glukit/app/util"
 	"github.com/alexandre-normand/glukit/lib/github.com/grd/stat"
+	"golang.org/x/net/context"
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
 	"google.golang.org/appengine/user"
 	"net/http"
 	"sort"
@@ -27,6 +29,7 @@ type DataResponse struct {
 	Picture      string            `json:"picture"`
 	LastSync     time.Time         `json:"lastSync"`
 	Score        *int64            `json:"score"`
+	ClassicScore *int64            `json:"classicScore"`
 	ScoreDetails model.GlukitScore `json:"scoreDetails"`
 	JoinedOn     time.Time         `json:"joinedOn"`
 	Data         []DataSeries      `json:"data"`
@@ -41,9 +44,10 @@ type DataSeries struct {
 }
 
 const (
-	QUERY_PARAM_LIMIT = "limit"
-	QUERY_PARAM_FROM  = "from"
-	QUERY_PARAM_TO    = "to"
+	QUERY_PARAM_LIMIT    = "limit"
+	QUERY_PARAM_FROM     = "from"
+	QUERY_PARAM_TO       = "to"
+	QUERY_PARAM_TIMEZONE = "timezone"
 )
 
 // content renders the most recent day's worth of data as json for the active user
@@ -64,7 +68,6 @@ func demoContent(writer http.ResponseWriter, request *http.Request) {
 func mostRecentWeekAsJson(writer http.ResponseWriter, request *http.Request, email string) {
 	context := appengine.NewContext(request)
 	glukitUser, _, upperBound, err := store.GetUserData(context, email)
-	lowerBound := util.GetEndOfDayBoundaryBefore(upperBound).Add(model.DEFAULT_LOOKBACK_PERIOD)
 
 	if err != nil && err == store.ErrNoImportedDataFound {
 		log.Debugf(context, "No imported data found for user [%s]", email)
@@ -72,6 +75,8 @@ func mostRecentWeekAsJson(writer http.ResponseWriter, request *http.Request, ema
 	} else if err != nil {
 		util.Propagate(err)
 	} else {
+		lowerBound := util.GetEndOfDayBoundaryBefore(localizeForUser(context, email, upperBound)).Add(model.DEFAULT_LOOKBACK_PERIOD)
+
 		unitValue, err := resolveGlucoseUnit(email, request)
 		if err != nil {
 			http.Error(writer, err.Error(), http.StatusInternalServerError)
@@ -94,11 +99,25 @@ func mostRecentWeekAsJson(writer http.ResponseWriter, request *http.Request, ema
 		if err != nil {
 			util.Propagate(err)
 		}
+		episodes, err := store.GetGlycemicEpisodes(context, email, lowerBound, upperBound)
+		if err != nil {
+			util.Propagate(err)
+		}
+		settings, err := store.GetUserSettings(context, email)
+		if err != nil {
+			util.Propagate(err)
+		}
+		// A forecast that can't be made shouldn't keep the rest of the data from showing
+		forecast, err := newForecastDataPoints(reads, injections, carbs, settings.OnBoard, *unitValue)
+		if err != nil {
+			log.Warningf(context, "Error forecasting glucose of user [%s], leaving out the forecast: %v", email, err)
+			forecast = nil
+		}
 
 		value := writer.Header()
 		value.Add("Content-type", "application/json")
 
-		response := DataResponse{FirstName: glukitUser.FirstName, LastName: glukitUser.LastName, Picture: glukitUser.PictureUrl, LastSync: glukitUser.MostRecentRead.GetTime(), Score: engine.CalculateUserFacingScore(glukitUser.MostRecentScore), ScoreDetails: glukitUser.MostRecentScore, JoinedOn: glukitUser.AccountCreated, Data: generateDataSeriesFromData(reads, injections, carbs, exercises, *unitValue)}
+		response := DataResponse{FirstName: glukitUser.FirstName, LastName: glukitUser.LastName, Picture: glukitUser.PictureUrl, LastSync: glukitUser.MostRecentRead.GetTime(), Score: engine.CalculateUserFacingScore(glukitUser.MostRecentScore), ClassicScore: engine.CalculateClassicUserFacingScore(glukitUser.MostRecentScore), ScoreDetails: glukitUser.MostRecentScore, JoinedOn: glukitUser.AccountCreated, Data: generateDataSeriesFromData(reads, injections, carbs, exercises, newEpisodeDataPoints(context, email, episodes, *unitValue), &settings.OnBoard, forecast, *unitValue)}
 		writeAsJson(writer, response)
 	}
 }
@@ -143,7 +162,7 @@ func steadySailorDataForEmail(writer http.ResponseWriter, request *http.Request,
 		value := writer.Header()
 		value.Add("Content-type", "application/json")
 
-		response := DataResponse{FirstName: steadySailor.FirstName, LastName: steadySailor.LastName, Picture: steadySailor.PictureUrl, LastSync: steadySailor.MostRecentRead.GetTime(), Score: engine.CalculateUserFacingScore(steadySailor.MostRecentScore), ScoreDetails: steadySailor.MostRecentScore, JoinedOn: steadySailor.AccountCreated, Data: generateDataSeriesFromData(reads, nil, nil, nil, *unitValue)}
+		response := DataResponse{FirstName: steadySailor.FirstName, LastName: steadySailor.LastName, Picture: steadySailor.PictureUrl, LastSync: steadySailor.MostRecentRead.GetTime(), Score: engine.CalculateUserFacingScore(steadySailor.MostRecentScore), ClassicScore: engine.CalculateClassicUserFacingScore(steadySailor.MostRecentScore), ScoreDetails: steadySailor.MostRecentScore, JoinedOn: steadySailor.AccountCreated, Data: generateDataSeriesFromData(reads, nil, nil, nil, nil, nil, make([]apimodel.DataPoint, 0), *unitValue)}
 		writeAsJson(writer, response)
 	}
 }
@@ -155,7 +174,7 @@ func writeAsJson(writer http.ResponseWriter, response DataResponse) {
 	enc.Encode(response)
 }
 
-func generateDataSeriesFromData(reads []apimodel.GlucoseRead, injections []apimodel.Injection, carbs []apimodel.Meal, exercises []apimodel.Exercise, glucoseUnit apimodel.GlucoseUnit) (dataSeries []DataSeries) {
+func generateDataSeriesFromData(reads []apimodel.GlucoseRead, injections []apimodel.Injection, carbs []apimodel.Meal, exercises []apimodel.Exercise, episodes []apimodel.DataPoint, onBoardSettings *model.OnBoardSettings, forecast []apimodel.DataPoint, glucoseUnit apimodel.GlucoseUnit) (dataSeries []DataSeries) {
 	data := make([]DataSeries, 1)
 
 	data[0] = DataSeries{"GlucoseReads", apimodel.GlucoseReadSlice(reads).ToDataPointSlice(glucoseUnit), "GlucoseReads"}
@@ -178,6 +197,22 @@ func generateDataSeriesFromData(reads []apimodel.GlucoseRead, injections []apimo
 
 	data = append(data, DataSeries{"UserEvents", userEvents, "UserEvents"})
 
+	if episodes == nil {
+		episodes = make([]apimodel.DataPoint, 0)
+	}
+	data = append(data, DataSeries{"Episodes", episodes, "Episodes"})
+
+	insulinOnBoard, carbsOnBoard := make([]apimodel.DataPoint, 0), make([]apimodel.DataPoint, 0)
+	if onBoardSettings != nil {
+		insulinOnBoard, carbsOnBoard = newOnBoardDataPoints(reads, injections, carbs, *onBoardSettings, glucoseUnit)
+	}
+	data = append(data, DataSeries{"InsulinOnBoard", insulinOnBoard, INSULIN_ON_BOARD_TAG})
+	data = append(data, DataSeries{"CarbsOnBoard", carbsOnBoard, CARBS_ON_BOARD_TAG})
+
+	if forecast != nil {
+		data = append(data, DataSeries{"Forecast", forecast, FORECAST_TAG})
+	}
+
 	return data
 }
 
@@ -194,12 +229,12 @@ func demoDashboard(writer http.ResponseWriter, request *http.Request) {
 	dashboardDataForUser(writer, request, DEMO_EMAIL)
 }
 
-// dashboardDataForUser retrieves reads and generates dashboard statistics from them
+// dashboardDataForUser retrieves reads and generates dashboard statistics from them. The range defaults to the
+// last day of data and can be set with the from and to parameters.
 func dashboardDataForUser(writer http.ResponseWriter, request *http.Request, email string) {
 	context := appengine.NewContext(request)
 
 	_, _, upperBound, err := store.GetUserData(context, email)
-	lowerBound := util.GetEndOfDayBoundaryBefore(upperBound).Add(time.Duration(-1*24) * time.Hour)
 
 	if err != nil && err == store.ErrNoImportedDataFound {
 		log.Debugf(context, "No imported data found for user [%s]", email)
@@ -207,18 +242,30 @@ func dashboardDataForUser(writer http.ResponseWriter, request *http.Request, ema
 	} else if err != nil {
 		util.Propagate(err)
 	} else {
+		defaultLowerBound := util.GetEndOfDayBoundaryBefore(localizeForUser(context, email, upperBound)).Add(time.Duration(-1*24) * time.Hour)
+		lowerBound, upperBound, err := newReadRange(request, defaultLowerBound, upperBound)
+		if err != nil {
+			http.Error(writer, err.Error(), 400)
+			return
+		}
+
 		reads, err := store.GetGlucoseReads(context, email, lowerBound, upperBound)
 		if err != nil {
 			util.Propagate(err)
 		}
 
-		writeDashboardDataAsJson(writer, request, reads)
+		settings, err := store.GetUserSettings(context, email)
+		if err != nil {
+			util.Propagate(err)
+		}
+
+		writeDashboardDataAsJson(writer, request, reads, settings.Interpolation)
 	}
 }
 
 // writedashboardDataAsJson calculates dashboard statistics from an array of GlucoseReads and writes it
-// as json
-func writeDashboardDataAsJson(writer http.ResponseWriter, request *http.Request, reads []apimodel.GlucoseRead) {
+// as json. Variability statistics fill the gaps between reads according to the interpolation policy.
+func writeDashboardDataAsJson(writer http.ResponseWriter, request *http.Request, reads []apimodel.GlucoseRead, policy model.InterpolationPolicy) {
 	value := writer.Header()
 	value.Add("Content-type", "application/json")
 
@@ -229,6 +276,12 @@ func writeDashboardDataAsJson(writer http.ResponseWriter, request *http.Request,
 		dashboardData.High, _ = stat.Max(model.ReadStatsSlice(reads))
 		dashboardData.Low, _ = stat.Min(model.ReadStatsSlice(reads))
 		dashboardData.Median = stat.MedianFromSortedData(model.ReadStatsSlice(reads))
+
+		variability, err := engine.CalculateVariability(reads, engine.DEFAULT_CONGA_HOURS, policy)
+		if err != nil {
+			util.Propagate(err)
+		}
+		dashboardData.Variability = variability
 	}
 
 	enc := json.NewEncoder(writer)
@@ -310,6 +363,55 @@ func a1csForEmail(writer http.ResponseWriter, request *http.Request, email strin
 	enc.Encode(a1cs)
 }
 
+func timezoneSegments(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	timezoneSegmentsForEmail(writer, request, user.Email)
+}
+
+func timezoneSegmentsForDemo(writer http.ResponseWriter, request *http.Request) {
+	timezoneSegmentsForEmail(writer, request, DEMO_EMAIL)
+}
+
+// timezoneSegmentsForEmail is the endpoint to retrieve the list of timezone segments (i.e. trips) of a user.
+func timezoneSegmentsForEmail(writer http.ResponseWriter, request *http.Request, email string) {
+	context := appengine.NewContext(request)
+
+	scanQuery, err := newScanQuery(request)
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	segments, err := store.GetTimezoneSegments(context, email, *scanQuery)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	if len(segments) < 1 {
+		http.Error(writer, "No timezone segments detected yet.", 204)
+		return
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(segments)
+}
+
+// localizeForUser returns the time in the location the user was in at that moment according to its timezone
+// segments. This is what makes day boundaries match the local day while travelling.
+func localizeForUser(context context.Context, email string, timeValue time.Time) (localTime time.Time) {
+	location, err := store.GetLocationAt(context, email, timeValue, timeValue.Location())
+	if err != nil {
+		log.Warningf(context, "Error getting location of user [%s] at [%s], keeping [%s]: %v", email, timeValue, timeValue.Location(), err)
+	}
+
+	return timeValue.In(location)
+}
+
 func newScanQuery(request *http.Request) (scanQuery *store.ScoreScanQuery, err error) {
 	limit := request.FormValue(QUERY_PARAM_LIMIT)
 	fromTimestamp := request.FormValue(QUERY_PARAM_FROM)
@@ -370,3 +472,46 @@ func handleDonation(writer http.ResponseWriter, request *http.Request) {
 		writer.WriteHeader(200)
 	}
 }
+
+// updateTimezone sets the user's timezone to the given zone name (i.e. "America/Los_Angeles") and kicks off the
+// migration of the legacy fixed offset timezones of its data if the zone changed.
+func updateTimezone(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	timezone := request.FormValue(QUERY_PARAM_TIMEZONE)
+	if util.IsFixedOffsetLocationName(timezone) {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s, must be a zone name: [%s].", QUERY_PARAM_TIMEZONE, timezone), 400)
+		return
+	}
+
+	if _, err := time.LoadLocation(timezone); err != nil || len(timezone) == 0 {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%s].", QUERY_PARAM_TIMEZONE, timezone), 400)
+		return
+	}
+
+	_, glukitUser, err := store.GetGlukitUser(context, user.Email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	if glukitUser.Timezone == timezone {
+		writer.WriteHeader(200)
+		return
+	}
+
+	log.Infof(context, "Updating timezone of user [%s] from [%s] to [%s]", user.Email, glukitUser.Timezone, timezone)
+	glukitUser.Timezone = timezone
+	if _, err := store.StoreUserProfile(context, time.Now(), *glukitUser); err != nil {
+		util.Propagate(err)
+	}
+
+	task, err := migrateTimezones.Task(user.Email, util.GLUKIT_EPOCH_TIME)
+	if err != nil {
+		log.Criticalf(context, "Couldn't schedule the timezone migration for user [%s]: %v", user.Email, err)
+	} else {
+		taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME)
+	}
+
+	writer.WriteHeader(200)
+}
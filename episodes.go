@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/user"
+	"net/http"
+)
+
+const (
+	QUERY_PARAM_TYPE = "type"
+
+	// Number of days of episodes listed when no range is requested
+	DEFAULT_EPISODE_DAYS = 14
+)
+
+func glycemicEpisodes(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	glycemicEpisodesForEmail(writer, request, user.Email)
+}
+
+func glycemicEpisodesForDemo(writer http.ResponseWriter, request *http.Request) {
+	glycemicEpisodesForEmail(writer, request, DEMO_EMAIL)
+}
+
+// glycemicEpisodesForEmail is the endpoint to list the hypoglycemia and hyperglycemia episodes of a range, most
+// recent first. The range defaults to the last 14 days of data and episodes can be filtered by type.
+func glycemicEpisodesForEmail(writer http.ResponseWriter, request *http.Request, email string) {
+	context := appengine.NewContext(request)
+
+	_, _, mostRecentRead, err := store.GetUserData(context, email)
+	if err == store.ErrNoImportedDataFound {
+		log.Debugf(context, "No imported data found for user [%s]", email)
+		http.Error(writer, err.Error(), 204)
+		return
+	} else if err != nil {
+		util.Propagate(err)
+	}
+
+	lowerBound, upperBound, err := newReadRange(request, mostRecentRead.AddDate(0, 0, -1*DEFAULT_EPISODE_DAYS), mostRecentRead)
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	episodes, err := store.GetGlycemicEpisodes(context, email, lowerBound, upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	if episodeType := request.FormValue(QUERY_PARAM_TYPE); len(episodeType) > 0 {
+		filtered := make([]model.GlycemicEpisode, 0)
+		for _, episode := range episodes {
+			if episode.Type == episodeType {
+				filtered = append(filtered, episode)
+			}
+		}
+		episodes = filtered
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(episodes)
+}
+
+// newEpisodeDataPoints converts episodes, sorted most recent first, to data points of the data browser. Data points
+// are at the start of their episode with the nadir or peak as y and the duration in minutes as value.
+func newEpisodeDataPoints(context context.Context, email string, episodes []model.GlycemicEpisode, glucoseUnit apimodel.GlucoseUnit) (dataPoints []apimodel.DataPoint) {
+	dataPoints = make([]apimodel.DataPoint, len(episodes))
+	for i, episode := range episodes {
+		extreme := apimodel.GlucoseRead{Unit: apimodel.MG_PER_DL, Value: float32(episode.Extreme)}
+		convertedValue, err := extreme.GetNormalizedValue(glucoseUnit)
+		if err != nil {
+			util.Propagate(err)
+		}
+
+		localTime := localizeForUser(context, email, episode.Start).Format(util.TIMEFORMAT)
+		dataPoints[len(episodes)-1-i] = apimodel.DataPoint{localTime, episode.Start.Unix(), convertedValue, float32(episode.DurationMinutes), episode.Type, glucoseUnit}
+	}
+
+	return dataPoints
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/user"
+	"net/http"
+	"time"
+)
+
+const (
+	// Number of days of exercise sessions analyzed when no range is requested
+	DEFAULT_EXERCISE_IMPACT_DAYS = 90
+)
+
+func exerciseImpacts(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	exerciseImpactsForEmail(writer, request, user.Email)
+}
+
+func exerciseImpactsForDemo(writer http.ResponseWriter, request *http.Request) {
+	exerciseImpactsForEmail(writer, request, DEMO_EMAIL)
+}
+
+// exerciseImpactsForEmail is the endpoint to retrieve the glucose impact of every exercise session of a range, most
+// recent first. The range defaults to the last 90 days of data.
+func exerciseImpactsForEmail(writer http.ResponseWriter, request *http.Request, email string) {
+	impacts, _, _, ok := getExerciseImpacts(writer, request, email)
+	if !ok {
+		return
+	}
+
+	sessions := make([]model.ExerciseImpact, len(impacts))
+	for i, impact := range impacts {
+		sessions[len(impacts)-1-i] = impact
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(sessions)
+}
+
+func exerciseImpactSummary(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	exerciseImpactSummaryForEmail(writer, request, user.Email)
+}
+
+func exerciseImpactSummaryForDemo(writer http.ResponseWriter, request *http.Request) {
+	exerciseImpactSummaryForEmail(writer, request, DEMO_EMAIL)
+}
+
+// exerciseImpactSummaryForEmail is the endpoint to retrieve the average glucose impact of the exercise sessions of
+// a range by intensity and by duration. The range defaults to the last 90 days of data.
+func exerciseImpactSummaryForEmail(writer http.ResponseWriter, request *http.Request, email string) {
+	impacts, lowerBound, upperBound, ok := getExerciseImpacts(writer, request, email)
+	if !ok {
+		return
+	}
+
+	report := model.ExerciseImpactReport{LowerBound: lowerBound, UpperBound: upperBound, SessionCount: len(impacts)}
+	report.ByIntensity, report.ByDuration = engine.AggregateExerciseImpacts(impacts)
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(report)
+}
+
+// getExerciseImpacts calculates the impacts of the exercise sessions of the requested range. It writes the error
+// response and returns false when they can't be calculated.
+func getExerciseImpacts(writer http.ResponseWriter, request *http.Request, email string) (impacts []model.ExerciseImpact, lowerBound, upperBound time.Time, ok bool) {
+	context := appengine.NewContext(request)
+
+	_, _, mostRecentRead, err := store.GetUserData(context, email)
+	if err == store.ErrNoImportedDataFound {
+		log.Debugf(context, "No imported data found for user [%s]", email)
+		http.Error(writer, err.Error(), 204)
+		return nil, lowerBound, upperBound, false
+	} else if err != nil {
+		util.Propagate(err)
+	}
+
+	lowerBound, upperBound, err = newReadRange(request, mostRecentRead.AddDate(0, 0, -1*DEFAULT_EXERCISE_IMPACT_DAYS), mostRecentRead)
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return nil, lowerBound, upperBound, false
+	}
+
+	settings, err := store.GetUserSettings(context, email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	exercises, err := store.GetExercises(context, email, lowerBound, upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	// Impacts of sessions close to the bounds use reads outside of the range
+	reads, err := store.GetGlucoseReads(context, email, lowerBound.Add(-1*engine.DOSING_READ_TOLERANCE), upperBound.Add(engine.EXERCISE_DELAYED_WINDOW))
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	impacts, err = engine.CalculateExerciseImpacts(exercises, reads, settings.TimeInRangeThresholds)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	return impacts, lowerBound, upperBound, true
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/exporter"
+	"github.com/alexandre-normand/glukit/app/util"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/user"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	QUERY_PARAM_DATA_TYPE = "type"
+	QUERY_PARAM_FORMAT    = "format"
+
+	// Export formats
+	EXPORT_FORMAT_CSV    = "csv"
+	EXPORT_FORMAT_DEXCOM = "dexcom"
+)
+
+// exportData is the endpoint to download the user's data
+func exportData(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	exportDataForEmail(writer, request, user.Email)
+}
+
+func exportDataForDemo(writer http.ResponseWriter, request *http.Request) {
+	exportDataForEmail(writer, request, DEMO_EMAIL)
+}
+
+// trackingWriter keeps track of whether anything was written to the response so that an error can still be
+// reported with a proper status before the export starts streaming
+type trackingWriter struct {
+	io.Writer
+	written bool
+}
+
+func (writer *trackingWriter) Write(p []byte) (n int, err error) {
+	writer.written = writer.written || len(p) > 0
+	return writer.Writer.Write(p)
+}
+
+// exportDataForEmail streams the data between the from and to bounds in the requested format. Both bounds
+// are optional and default to the beginning of glukit time and now. The csv format (default) covers a single
+// data type while the dexcom format includes all of them in a single Dexcom Studio xml document. Parameters are
+// validated before anything is written and an error happening once the export is streaming only truncates it.
+func exportDataForEmail(writer http.ResponseWriter, request *http.Request, email string) {
+	context := appengine.NewContext(request)
+
+	lowerBound, upperBound := util.GLUKIT_EPOCH_TIME, time.Now()
+
+	if len(request.FormValue(QUERY_PARAM_FROM)) > 0 || len(request.FormValue(QUERY_PARAM_TO)) > 0 {
+		scanQuery, err := newScanQuery(request)
+		if err != nil {
+			http.Error(writer, err.Error(), 400)
+			return
+		}
+
+		if scanQuery.From != nil {
+			lowerBound = *scanQuery.From
+		}
+		if scanQuery.To != nil {
+			upperBound = *scanQuery.To
+		}
+	}
+
+	output := &trackingWriter{Writer: writer}
+	switch request.FormValue(QUERY_PARAM_FORMAT) {
+	case EXPORT_FORMAT_DEXCOM:
+		value := writer.Header()
+		value.Add("Content-type", "application/xml")
+		value.Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"glukit-%d-%d.xml\"", lowerBound.Unix(), upperBound.Unix()))
+
+		log.Infof(context, "Exporting data of user [%s] between [%s] and [%s] as dexcom xml", email, lowerBound, upperBound)
+		if err := exporter.ExportDexcomXml(context, email, lowerBound, upperBound, output); err != nil {
+			log.Warningf(context, "Error exporting data of user [%s] as dexcom xml: %v", email, err)
+			if !output.written {
+				writer.Header().Del("Content-Disposition")
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+			}
+		}
+	case "", EXPORT_FORMAT_CSV:
+		dataType := request.FormValue(QUERY_PARAM_DATA_TYPE)
+		if !exporter.IsSupportedDataType(dataType) {
+			http.Error(writer, fmt.Sprintf("Unsupported data type [%s], should be one of %v.", dataType, exporter.ALL_DATA_TYPES), 400)
+			return
+		}
+
+		if rawUnitValue := request.FormValue(GLUCOSE_UNIT_PARAMETER); len(rawUnitValue) > 0 && rawUnitValue != apimodel.MMOL_PER_L && rawUnitValue != apimodel.MG_PER_DL {
+			http.Error(writer, fmt.Sprintf("Unsupported unit [%s], should be one of [%s] or [%s].", rawUnitValue, apimodel.MG_PER_DL, apimodel.MMOL_PER_L), 400)
+			return
+		}
+
+		unitValue, err := resolveGlucoseUnit(email, request)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		value := writer.Header()
+		value.Add("Content-type", "text/csv")
+		value.Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"glukit-%s-%d-%d.csv\"", dataType, lowerBound.Unix(), upperBound.Unix()))
+
+		log.Infof(context, "Exporting [%s] of user [%s] between [%s] and [%s] as csv", dataType, email, lowerBound, upperBound)
+		if err := exporter.ExportCsv(context, email, dataType, lowerBound, upperBound, *unitValue, output); err != nil {
+			log.Warningf(context, "Error exporting [%s] of user [%s] as csv: %v", dataType, email, err)
+			if !output.written {
+				writer.Header().Del("Content-Disposition")
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+			}
+		}
+	default:
+		http.Error(writer, fmt.Sprintf("Unsupported export format [%s], should be one of [%s] or [%s].", request.FormValue(QUERY_PARAM_FORMAT), EXPORT_FORMAT_CSV, EXPORT_FORMAT_DEXCOM), 400)
+	}
+}
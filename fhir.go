@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/bufio"
+	"github.com/alexandre-normand/glukit/app/fhir"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/streaming"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"net/http"
+	"time"
+)
+
+const (
+	FHIR_OBSERVATIONS_ROUTE               = "fhir_observations"
+	FHIR_MEDICATION_ADMINISTRATIONS_ROUTE = "fhir_medicationadministrations"
+	FHIR_IMPORT_ROUTE                     = "fhir_import"
+	// Period covered by a search without a lower bound
+	FHIR_DEFAULT_SEARCH_PERIOD = time.Duration(24) * time.Hour
+)
+
+// fhirObservations handles a search of Observations. Glucose reads, calibrations and meals are all observations,
+// the code parameter restricts the search to one of them (i.e. "http://loinc.org|99504-3" or "99504-3").
+func fhirObservations(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := CurrentApiUser(request)
+
+	lowerBound, upperBound, err := fhir.ParseDateSearch(request.Form[fhir.SEARCH_PARAM_DATE], request.FormValue(fhir.SEARCH_PARAM_SINCE), time.Now(), FHIR_DEFAULT_SEARCH_PERIOD)
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	code := request.FormValue(fhir.SEARCH_PARAM_CODE)
+	if len(code) > len(fhir.LOINC_SYSTEM) && code[:len(fhir.LOINC_SYSTEM)+1] == fhir.LOINC_SYSTEM+"|" {
+		code = code[len(fhir.LOINC_SYSTEM)+1:]
+	}
+
+	subject := fhir.NewSubject(user.Email)
+	resources := make([]interface{}, 0)
+
+	if len(code) == 0 || code == fhir.LOINC_INTERSTITIAL_GLUCOSE {
+		reads, err := store.GetGlucoseReads(context, user.Email, lowerBound, upperBound)
+		if err != nil {
+			writeFhirError(writer, context, err)
+			return
+		}
+		for _, read := range reads {
+			resources = append(resources, fhir.NewGlucoseReadObservation(read, subject))
+		}
+	}
+
+	if len(code) == 0 || code == fhir.LOINC_BLOOD_GLUCOSE {
+		calibrations, err := store.GetCalibrations(context, user.Email, lowerBound, upperBound)
+		if err != nil {
+			writeFhirError(writer, context, err)
+			return
+		}
+		for _, calibration := range calibrations {
+			resources = append(resources, fhir.NewCalibrationObservation(calibration, subject))
+		}
+	}
+
+	if len(code) == 0 || code == fhir.LOINC_CARBOHYDRATE_INTAKE {
+		meals, err := store.GetMeals(context, user.Email, lowerBound, upperBound)
+		if err != nil {
+			writeFhirError(writer, context, err)
+			return
+		}
+		for _, meal := range meals {
+			resources = append(resources, fhir.NewMealObservation(meal, subject))
+		}
+	}
+
+	writeFhirBundle(writer, context, resources)
+}
+
+// fhirMedicationAdministrations handles a search of MedicationAdministrations which are the user's insulin injections
+func fhirMedicationAdministrations(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := CurrentApiUser(request)
+
+	lowerBound, upperBound, err := fhir.ParseDateSearch(request.Form[fhir.SEARCH_PARAM_DATE], request.FormValue(fhir.SEARCH_PARAM_SINCE), time.Now(), FHIR_DEFAULT_SEARCH_PERIOD)
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	injections, err := store.GetInjections(context, user.Email, lowerBound, upperBound)
+	if err != nil {
+		writeFhirError(writer, context, err)
+		return
+	}
+
+	subject := fhir.NewSubject(user.Email)
+	resources := make([]interface{}, len(injections))
+	for i, injection := range injections {
+		resources[i] = fhir.NewInjectionAdministration(injection, subject)
+	}
+
+	writeFhirBundle(writer, context, resources)
+}
+
+// fhirImport handles a Post of a FHIR Bundle. Glucose reads, calibrations, meals and injections found in the bundle
+// are stored through the same streaming pipeline as the v1 api.
+func fhirImport(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := CurrentApiUser(request)
+
+	userProfileKey, glukitUser, err := store.GetGlukitUser(context, user.Email)
+	if err != nil {
+		log.Warningf(context, "Error getting user to process fhir bundle, user email is [%s]: %v", user.Email, err)
+		http.Error(writer, "Error getting user to process fhir bundle", 500)
+		return
+	}
+
+	data, err := fhir.ParseBundle(request.Body)
+	if err != nil {
+		log.Warningf(context, "Error decoding fhir bundle for user [%s]: %v", user.Email, err)
+		http.Error(writer, fmt.Sprintf("Error decoding data: %v", err), 400)
+		return
+	}
+
+	if err = storeFhirBundleData(context, userProfileKey, data); err != nil {
+		log.Warningf(context, "Error storing fhir bundle data for user [%s]: %v", user.Email, err)
+		http.Error(writer, fmt.Sprintf("Error storing data: %v", err), 502)
+		return
+	}
+
+	if len(data.GlucoseReads) > 0 {
+		startCalculationsAfterImport(context, glukitUser)
+	}
+
+	log.Infof(context, "Imported fhir bundle for user [%s]: [%d] reads, [%d] calibrations, [%d] injections, [%d] meals, [%d] skipped",
+		user.Email, len(data.GlucoseReads), len(data.Calibrations), len(data.Injections), len(data.Meals), data.Skipped)
+	writer.WriteHeader(200)
+}
+
+func storeFhirBundleData(context context.Context, userProfileKey *datastore.Key, data *fhir.BundleData) (err error) {
+	if len(data.GlucoseReads) > 0 {
+		glucoseReadStreamer := streaming.NewGlucoseStreamerDuration(bufio.NewGlucoseReadWriterSize(store.NewDataStoreGlucoseReadBatchWriter(context, userProfileKey), store.GLUKIT_SCORE_PUT_MULTI_SIZE), apimodel.DAY_OF_DATA_DURATION)
+		if glucoseReadStreamer, err = glucoseReadStreamer.WriteGlucoseReads(data.GlucoseReads); err != nil {
+			return err
+		}
+		if _, err = glucoseReadStreamer.Close(); err != nil {
+			return err
+		}
+	}
+
+	if len(data.Calibrations) > 0 {
+		calibrationStreamer := streaming.NewCalibrationReadStreamerDuration(bufio.NewCalibrationWriterSize(store.NewDataStoreCalibrationBatchWriter(context, userProfileKey), store.GLUKIT_SCORE_PUT_MULTI_SIZE), apimodel.DAY_OF_DATA_DURATION)
+		if calibrationStreamer, err = calibrationStreamer.WriteCalibrations(data.Calibrations); err != nil {
+			return err
+		}
+		if _, err = calibrationStreamer.Close(); err != nil {
+			return err
+		}
+	}
+
+	if len(data.Injections) > 0 {
+		injectionStreamer := streaming.NewInjectionStreamerDuration(bufio.NewInjectionWriterSize(store.NewDataStoreInjectionBatchWriter(context, userProfileKey), store.GLUKIT_SCORE_PUT_MULTI_SIZE), apimodel.DAY_OF_DATA_DURATION)
+		if injectionStreamer, err = injectionStreamer.WriteInjections(data.Injections); err != nil {
+			return err
+		}
+		if _, err = injectionStreamer.Close(); err != nil {
+			return err
+		}
+	}
+
+	if len(data.Meals) > 0 {
+		mealStreamer := streaming.NewMealStreamerDuration(bufio.NewMealWriterSize(store.NewDataStoreMealBatchWriter(context, userProfileKey), store.GLUKIT_SCORE_PUT_MULTI_SIZE), apimodel.DAY_OF_DATA_DURATION)
+		if mealStreamer, err = mealStreamer.WriteMeals(data.Meals); err != nil {
+			return err
+		}
+		if _, err = mealStreamer.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeFhirBundle(writer http.ResponseWriter, context context.Context, resources []interface{}) {
+	bundle, err := fhir.NewSearchBundle(appConfig.SSLHost+"/fhir", resources)
+	if err != nil {
+		writeFhirError(writer, context, err)
+		return
+	}
+
+	writer.Header().Add("Content-type", fhir.CONTENT_TYPE)
+	enc := json.NewEncoder(writer)
+	enc.Encode(bundle)
+}
+
+func writeFhirError(writer http.ResponseWriter, context context.Context, err error) {
+	log.Warningf(context, "Error serving fhir request: %v", err)
+	http.Error(writer, fmt.Sprintf("Error getting data: %v", err), 500)
+}
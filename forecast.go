@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/user"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	QUERY_PARAM_HORIZON  = "horizon"
+	QUERY_PARAM_ON_BOARD = "onBoard"
+
+	FORECAST_TAG = "Forecast"
+)
+
+func glucoseForecast(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	glucoseForecastForEmail(writer, request, user.Email)
+}
+
+func glucoseForecastForDemo(writer http.ResponseWriter, request *http.Request) {
+	glucoseForecastForEmail(writer, request, DEMO_EMAIL)
+}
+
+// glucoseForecastForEmail is the endpoint to forecast glucose following the most recent read with a model fitted to
+// the last 14 days of reads. The horizon is in minutes and defaults to an hour. The insulin and carbs on board are
+// used unless onBoard is false.
+func glucoseForecastForEmail(writer http.ResponseWriter, request *http.Request, email string) {
+	context := appengine.NewContext(request)
+
+	horizon := engine.FORECAST_MAX_HORIZON
+	if value := request.FormValue(QUERY_PARAM_HORIZON); len(value) > 0 {
+		minutes, err := strconv.Atoi(value)
+		if err != nil || minutes < 5 || time.Duration(minutes)*time.Minute > engine.FORECAST_MAX_HORIZON {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s, must be between 5 and %d: [%s].", QUERY_PARAM_HORIZON, int(engine.FORECAST_MAX_HORIZON.Minutes()), value), 400)
+			return
+		}
+		horizon = time.Duration(minutes) * time.Minute
+	}
+
+	usesOnBoard := true
+	if value := request.FormValue(QUERY_PARAM_ON_BOARD); len(value) > 0 {
+		var err error
+		if usesOnBoard, err = strconv.ParseBool(value); err != nil {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s: [%s].", QUERY_PARAM_ON_BOARD, value), 400)
+			return
+		}
+	}
+
+	_, _, upperBound, err := store.GetUserData(context, email)
+	if err == store.ErrNoImportedDataFound {
+		log.Debugf(context, "No imported data found for user [%s]", email)
+		http.Error(writer, err.Error(), 204)
+		return
+	} else if err != nil {
+		util.Propagate(err)
+	}
+
+	settings, err := store.GetUserSettings(context, email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	lowerBound := upperBound.AddDate(0, 0, -1*engine.FORECAST_TRAINING_DAYS)
+	reads, err := store.GetGlucoseReads(context, email, lowerBound, upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	var injections []apimodel.Injection
+	var meals []apimodel.Meal
+	var onBoardSettings *model.OnBoardSettings
+	if usesOnBoard {
+		onBoardSettings = &settings.OnBoard
+		if injections, err = store.GetInjections(context, email, lowerBound.Add(-1*engine.ONBOARD_LOOKBACK), upperBound); err != nil {
+			util.Propagate(err)
+		}
+		if meals, err = store.GetMeals(context, email, lowerBound.Add(-1*engine.ONBOARD_LOOKBACK), upperBound); err != nil {
+			util.Propagate(err)
+		}
+	}
+
+	forecast, err := newGlucoseForecast(reads, injections, meals, onBoardSettings, horizon)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	if forecast == nil {
+		http.Error(writer, "Not enough recent reads to forecast glucose.", 204)
+		return
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(forecast)
+}
+
+// newGlucoseForecast fits a forecast model to reads and forecasts from the last one. It returns nil if there isn't
+// enough data to do either.
+func newGlucoseForecast(reads []apimodel.GlucoseRead, injections []apimodel.Injection, meals []apimodel.Meal, onBoardSettings *model.OnBoardSettings, horizon time.Duration) (forecast *model.GlucoseForecast, err error) {
+	forecastModel, err := engine.FitForecastModel(reads, injections, meals, onBoardSettings)
+	if err != nil || forecastModel == nil {
+		return nil, err
+	}
+
+	settings := model.OnBoardSettings{}
+	if onBoardSettings != nil {
+		settings = *onBoardSettings
+	}
+
+	points, err := engine.ForecastGlucose(*forecastModel, reads, injections, meals, settings, horizon)
+	if err != nil || points == nil {
+		return nil, err
+	}
+
+	return &model.GlucoseForecast{Model: *forecastModel, Points: points}, nil
+}
+
+// newForecastDataPoints returns the forecast of the hour following the last read as data points of the data browser
+func newForecastDataPoints(reads []apimodel.GlucoseRead, injections []apimodel.Injection, meals []apimodel.Meal, onBoardSettings model.OnBoardSettings, glucoseUnit apimodel.GlucoseUnit) (dataPoints []apimodel.DataPoint, err error) {
+	dataPoints = make([]apimodel.DataPoint, 0)
+	forecast, err := newGlucoseForecast(reads, injections, meals, &onBoardSettings, engine.FORECAST_MAX_HORIZON)
+	if err != nil || forecast == nil {
+		return dataPoints, err
+	}
+
+	timezone := reads[len(reads)-1].Time.TimeZoneId
+	forecastReads := make([]apimodel.GlucoseRead, len(forecast.Points))
+	for i, point := range forecast.Points {
+		forecastReads[i] = apimodel.GlucoseRead{apimodel.Time{apimodel.GetTimeMillis(point.Time), timezone}, apimodel.MG_PER_DL, float32(point.Value)}
+	}
+
+	dataPoints = apimodel.GlucoseReadSlice(forecastReads).ToDataPointSlice(glucoseUnit)
+	for i := range dataPoints {
+		dataPoints[i].Tag = FORECAST_TAG
+	}
+
+	return dataPoints, nil
+}
@@ -52,6 +52,14 @@ func handleLoggedInUser(writer http.ResponseWriter, request *http.Request) {
 	context := appengine.NewContext(request)
 	user := user.Current(context)
 
+	// Signing up again while the previous account is being deleted would have the new account deleted along with it
+	if pending, err := store.IsAccountDeletionPending(context, user.Email); err != nil {
+		util.Propagate(err)
+	} else if pending {
+		http.Error(writer, "Your account is being deleted, please try again later.", http.StatusConflict)
+		return
+	}
+
 	transport := new(oauth.Transport)
 	var oauthToken oauth.Token
 	glukitUser, _, _, err := store.GetUserData(context, user.Email)
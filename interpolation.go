@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/user"
+	"net/http"
+	"strconv"
+)
+
+const (
+	QUERY_PARAM_POLICY          = "policy"
+	QUERY_PARAM_MAX_GAP_MINUTES = "maxGapMinutes"
+)
+
+// InterpolationPolicyResponse is the interpolation policy chosen by a user along with all policies it can choose from
+type InterpolationPolicyResponse struct {
+	Policy    model.InterpolationPolicy `json:"policy"`
+	Available []string                  `json:"available"`
+}
+
+// interpolationPolicy is the endpoint to retrieve the interpolation policy of the current user
+func interpolationPolicy(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	settings, err := store.GetUserSettings(context, user.Email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(InterpolationPolicyResponse{Policy: settings.Interpolation, Available: model.INTERPOLATION_POLICIES})
+}
+
+// updateInterpolationPolicy sets the interpolation policy of the current user and kicks off the recalculation of its
+// glukit score and a1c history. The max gap defaults to DEFAULT_INTERPOLATION_MAX_GAP_MINUTES.
+func updateInterpolationPolicy(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	policy := model.InterpolationPolicy{Name: request.FormValue(QUERY_PARAM_POLICY), MaxGapMinutes: model.DEFAULT_INTERPOLATION_MAX_GAP_MINUTES}
+	if value := request.FormValue(QUERY_PARAM_MAX_GAP_MINUTES); len(value) > 0 {
+		maxGapMinutes, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s: [%s].", QUERY_PARAM_MAX_GAP_MINUTES, value), 400)
+			return
+		}
+		policy.MaxGapMinutes = maxGapMinutes
+	}
+
+	if policy.Name == model.INTERPOLATION_POLICY_NONE {
+		policy.MaxGapMinutes = 0
+	}
+
+	if err := policy.Validate(); err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	settings, err := store.GetUserSettings(context, user.Email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	if settings.Interpolation == policy {
+		writer.WriteHeader(200)
+		return
+	}
+
+	log.Infof(context, "Updating interpolation policy of user [%s] from [%v] to [%v]", user.Email, settings.Interpolation, policy)
+	settings.Interpolation = policy
+	if err := store.StoreUserSettings(context, user.Email, *settings); err != nil {
+		util.Propagate(err)
+	}
+
+	if err := engine.RecalculateGlukitScores(context, user.Email); err != nil {
+		log.Criticalf(context, "Couldn't schedule the recalculation of glukit scores for user [%s]: %v", user.Email, err)
+	}
+
+	if err := engine.RecalculateA1C(context, user.Email); err != nil {
+		log.Criticalf(context, "Couldn't schedule the recalculation of a1c estimates for user [%s]: %v", user.Email, err)
+	}
+
+	writer.WriteHeader(200)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/user"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	LAB_A1CS_V1_ROUTE = "v1_laba1cs"
+
+	QUERY_PARAM_VALUE = "value"
+	QUERY_PARAM_TIME  = "time"
+)
+
+// processNewLabA1CData handles a Post of lab a1c results to the api and refits the user's a1c calibration
+func processNewLabA1CData(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := CurrentApiUser(request)
+
+	var labA1Cs []apimodel.LabA1C
+	if err := json.NewDecoder(request.Body).Decode(&labA1Cs); err != nil {
+		log.Warningf(context, "Error decoding lab a1c data for user [%s]: %v", user.Email, err)
+		http.Error(writer, fmt.Sprintf("Error decoding data: %v", err), 400)
+		return
+	}
+
+	storeLabA1Cs(writer, request, user.Email, labA1Cs)
+}
+
+// labA1Cs is the endpoint to retrieve the current user's a1c calibration along with how each of its lab a1cs compares
+// with our estimates
+func labA1Cs(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	calibration, err := store.GetA1CCalibration(context, user.Email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	comparisons, err := engine.CompareLabA1Cs(context, user.Email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(model.A1CCalibrationReport{Calibration: *calibration, Comparisons: comparisons})
+}
+
+// addLabA1C adds a lab a1c for the current user. The time is in seconds since epoch and the timezone defaults to the
+// user's timezone.
+func addLabA1C(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	value, err := strconv.ParseFloat(request.FormValue(QUERY_PARAM_VALUE), 64)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%s].", QUERY_PARAM_VALUE, request.FormValue(QUERY_PARAM_VALUE)), 400)
+		return
+	}
+
+	timestamp, err := strconv.ParseInt(request.FormValue(QUERY_PARAM_TIME), 10, 64)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%s].", QUERY_PARAM_TIME, request.FormValue(QUERY_PARAM_TIME)), 400)
+		return
+	}
+
+	timezone := request.FormValue(QUERY_PARAM_TIMEZONE)
+	if len(timezone) == 0 {
+		timezone = localizeForUser(context, user.Email, time.Unix(timestamp, 0).UTC()).Location().String()
+	}
+	if _, err := util.GetOrLoadLocationForName(timezone); err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%s].", QUERY_PARAM_TIMEZONE, timezone), 400)
+		return
+	}
+
+	storeLabA1Cs(writer, request, user.Email, []apimodel.LabA1C{apimodel.LabA1C{Time: apimodel.Time{Timestamp: timestamp * 1000, TimeZoneId: timezone}, Value: value}})
+}
+
+// deleteLabA1C deletes a lab a1c of the current user, identified by its time in seconds since epoch
+func deleteLabA1C(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	timestamp, err := strconv.ParseInt(request.FormValue(QUERY_PARAM_TIME), 10, 64)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%s].", QUERY_PARAM_TIME, request.FormValue(QUERY_PARAM_TIME)), 400)
+		return
+	}
+
+	if err := store.DeleteLabA1C(context, user.Email, timestamp); err != nil {
+		util.Propagate(err)
+	}
+
+	if _, err := engine.RecalibrateA1C(context, user.Email); err != nil {
+		util.Propagate(err)
+	}
+
+	writer.WriteHeader(200)
+}
+
+func storeLabA1Cs(writer http.ResponseWriter, request *http.Request, email string, labA1Cs []apimodel.LabA1C) {
+	context := appengine.NewContext(request)
+
+	for _, labA1C := range labA1Cs {
+		if err := labA1C.Validate(); err != nil {
+			http.Error(writer, err.Error(), 400)
+			return
+		}
+	}
+
+	if err := store.StoreLabA1Cs(context, email, labA1Cs); err != nil {
+		http.Error(writer, fmt.Sprintf("Error storing data: %v", err), 502)
+		return
+	}
+
+	calibration, err := engine.RecalibrateA1C(context, email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	log.Infof(context, "Stored [%d] lab a1cs for user [%s], glycation index is now [%f]", len(labA1Cs), email, calibration.GlycationIndex)
+	writer.WriteHeader(200)
+}
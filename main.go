@@ -24,6 +24,7 @@ import (
 
 var dataBrowserTemplate = template.Must(template.ParseFiles("view/templates/databrowser.html"))
 var reportTemplate = template.Must(template.ParseFiles("view/templates/report.html"))
+var agpTemplate = template.Must(template.ParseFiles("view/templates/agp.html"))
 var landingTemplate = template.Must(template.ParseFiles("view/templates/landing.html"))
 var muxRouter = mux.NewRouter()
 var initOnce sync.Once
@@ -52,6 +53,7 @@ func main() {
 	// Create user Glukit Bernstein as a fallback for comparisons
 	muxRouter.HandleFunc("/_ah/warmup", warmUp)
 	muxRouter.HandleFunc("/initpower", warmUp)
+	muxRouter.HandleFunc("/admin/grants/index", startLegacyGrantIndexing).Methods("POST")
 
 	// GAE Json endpoints
 	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"data", demoContent)
@@ -62,15 +64,66 @@ func main() {
 	muxRouter.HandleFunc("/dashboard", dashboard)
 	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"glukitScores", glukitScoresForDemo)
 	muxRouter.HandleFunc("/glukitScores", glukitScores)
+	muxRouter.HandleFunc("/glukitScores/profile", scoringProfile).Methods("GET")
+	muxRouter.HandleFunc("/glukitScores/profile", updateScoringProfile).Methods("POST")
 	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"a1cs", a1cEstimatesForDemo)
 	muxRouter.HandleFunc("/a1cs", a1cEstimates)
+	muxRouter.HandleFunc("/a1cs/estimator", a1cEstimator).Methods("GET")
+	muxRouter.HandleFunc("/a1cs/estimator", updateA1CEstimator).Methods("POST")
+	muxRouter.HandleFunc("/labA1Cs", labA1Cs).Methods("GET")
+	muxRouter.HandleFunc("/labA1Cs", addLabA1C).Methods("POST")
+	muxRouter.HandleFunc("/labA1Cs/delete", deleteLabA1C).Methods("POST")
+	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"timeInRange", timeInRangeForDemo)
+	muxRouter.HandleFunc("/timeInRange", timeInRange)
+	muxRouter.HandleFunc("/timeInRange/thresholds", timeInRangeThresholds).Methods("GET")
+	muxRouter.HandleFunc("/timeInRange/thresholds", updateTimeInRangeThresholds).Methods("POST")
+	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"stats", statsForDemo)
+	muxRouter.HandleFunc("/stats", stats)
+	muxRouter.HandleFunc("/interpolation", interpolationPolicy).Methods("GET")
+	muxRouter.HandleFunc("/interpolation", updateInterpolationPolicy).Methods("POST")
+	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"forecast", glucoseForecastForDemo)
+	muxRouter.HandleFunc("/forecast", glucoseForecast)
+	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"insights/dosing", dosingInsightForDemo)
+	muxRouter.HandleFunc("/insights/dosing", dosingInsight).Methods("GET")
+	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"insights/patterns", glucosePatternsForDemo)
+	muxRouter.HandleFunc("/insights/patterns", glucosePatterns).Methods("GET")
+	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"onBoard", onBoardForDemo)
+	muxRouter.HandleFunc("/onBoard", onBoard)
+	muxRouter.HandleFunc("/onBoard/settings", onBoardSettings).Methods("GET")
+	muxRouter.HandleFunc("/onBoard/settings", updateOnBoardSettings).Methods("POST")
+	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"mealResponses", mealResponsesForDemo)
+	muxRouter.HandleFunc("/mealResponses", mealResponses)
+	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"exerciseImpacts", exerciseImpactsForDemo)
+	muxRouter.HandleFunc("/exerciseImpacts", exerciseImpacts)
+	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"exerciseImpacts/summary", exerciseImpactSummaryForDemo)
+	muxRouter.HandleFunc("/exerciseImpacts/summary", exerciseImpactSummary)
+	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"accuracy", sensorAccuracyForDemo)
+	muxRouter.HandleFunc("/accuracy", sensorAccuracy)
+	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"coverage", coverageCalendarForDemo)
+	muxRouter.HandleFunc("/coverage", coverageCalendar)
+	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"episodes", glycemicEpisodesForDemo)
+	muxRouter.HandleFunc("/episodes", glycemicEpisodes)
+	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"agp", ambulatoryGlucoseProfileForDemo)
+	muxRouter.HandleFunc("/agp", ambulatoryGlucoseProfile)
+	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"timezoneSegments", timezoneSegmentsForDemo)
+	muxRouter.HandleFunc("/timezoneSegments", timezoneSegments)
+	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"export", exportDataForDemo)
+	muxRouter.HandleFunc("/export", exportData)
+	muxRouter.HandleFunc("/takeout", requestTakeout).Methods("POST")
+	muxRouter.HandleFunc("/takeout", takeoutStatus).Methods("GET")
+	muxRouter.HandleFunc("/takeout/download", downloadTakeout)
+	muxRouter.HandleFunc("/account/delete", deleteAccount).Methods("POST")
+	muxRouter.HandleFunc("/nightscout/secret", generateNightscoutSecret).Methods("POST")
 	muxRouter.HandleFunc("/donation", handleDonation)
+	muxRouter.HandleFunc("/timezone", updateTimezone).Methods("POST")
 
 	// "main"-page for both demo and real users
 	muxRouter.HandleFunc("/demo", renderDemo)
 	muxRouter.HandleFunc("/browse", renderRealUser)
 	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"report", demoReport)
 	muxRouter.HandleFunc("/report", report)
+	muxRouter.HandleFunc("/"+DEMO_PATH_PREFIX+"agpReport", demoAgpReport)
+	muxRouter.HandleFunc("/agpReport", agpReport)
 
 	// Static pages
 	muxRouter.HandleFunc("/", landing)
@@ -84,6 +137,17 @@ func main() {
 	muxRouter.HandleFunc("/v1/meals", initializeAndHandleRequest).Methods("POST").Name(MEALS_V1_ROUTE)
 	muxRouter.HandleFunc("/v1/glucosereads", initializeAndHandleRequest).Methods("POST").Name(GLUCOSEREADS_V1_ROUTE)
 	muxRouter.HandleFunc("/v1/exercises", initializeAndHandleRequest).Methods("POST").Name(EXERCISES_V1_ROUTE)
+	muxRouter.HandleFunc("/v1/laba1cs", initializeAndHandleRequest).Methods("POST").Name(LAB_A1CS_V1_ROUTE)
+
+	muxRouter.HandleFunc("/fhir/Observation", initializeAndHandleRequest).Methods("GET").Name(FHIR_OBSERVATIONS_ROUTE)
+	muxRouter.HandleFunc("/fhir/MedicationAdministration", initializeAndHandleRequest).Methods("GET").Name(FHIR_MEDICATION_ADMINISTRATIONS_ROUTE)
+	muxRouter.HandleFunc("/fhir", initializeAndHandleRequest).Methods("POST").Name(FHIR_IMPORT_ROUTE)
+
+	// Nightscout api emulation, authenticated with an api secret or an oauth token
+	muxRouter.HandleFunc("/api/v1/entries.json", nightscoutEntries).Methods("GET")
+	muxRouter.HandleFunc("/api/v1/entries/sgv.json", nightscoutEntries).Methods("GET")
+	muxRouter.HandleFunc("/api/v1/treatments.json", nightscoutTreatments).Methods("GET")
+	muxRouter.HandleFunc("/api/v1/status.json", nightscoutStatus).Methods("GET")
 
 	// Register oauth endpoints to warmup which will initilize the oauth server and replace the routes with the actual oauth handlers
 	muxRouter.HandleFunc("/token", initializeAndHandleRequest).Methods("POST").Name(TOKEN_ROUTE)
@@ -92,8 +156,15 @@ func main() {
 	// Initialize task functions that would otherwise be prone to initialization loops
 	refreshUserData = delay.Func(REFRESH_USER_DATA_FUNCTION_NAME, updateUserData)
 	processFile = delay.Func(PROCESS_FILE_FUNCTION_NAME, processSingleFile)
+	migrateTimezones = delay.Func(MIGRATE_TIMEZONES_FUNCTION_NAME, migrateUserTimezones)
+	backfillCoverage = delay.Func(BACKFILL_COVERAGE_FUNCTION_NAME, backfillUserCoverage)
+	indexLegacyGrants = delay.Func(INDEX_LEGACY_GRANTS_FUNCTION_NAME, indexLegacyOauthGrants)
+	deleteAccountData = delay.Func(DELETE_ACCOUNT_DATA_FUNCTION_NAME, deleteUserAccountData)
 	engine.RunGlukitScoreCalculationChunk = delay.Func(engine.GLUKIT_SCORE_BATCH_CALCULATION_FUNCTION_NAME, engine.RunGlukitScoreBatchCalculation)
 	engine.RunA1CCalculationChunk = delay.Func(engine.A1C_BATCH_CALCULATION_FUNCTION_NAME, engine.RunA1CBatchCalculation)
+	engine.RunTimeInRangeCalculationChunk = delay.Func(engine.TIME_IN_RANGE_BATCH_CALCULATION_FUNCTION_NAME, engine.RunTimeInRangeBatchCalculation)
+	engine.RunEpisodeDetectionChunk = delay.Func(engine.EPISODE_DETECTION_FUNCTION_NAME, engine.RunEpisodeDetectionBatch)
+	engine.RunInvalidatedRecalculationChunk = delay.Func(engine.INVALIDATED_RECALCULATION_FUNCTION_NAME, engine.RunInvalidatedBatchRecalculation)
 
 	appengine.Main()
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/user"
+	"net/http"
+)
+
+const (
+	// Number of days of meals analyzed when no range is requested
+	DEFAULT_MEAL_RESPONSE_DAYS = 30
+)
+
+func mealResponses(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	mealResponsesForEmail(writer, request, user.Email)
+}
+
+func mealResponsesForDemo(writer http.ResponseWriter, request *http.Request) {
+	mealResponsesForEmail(writer, request, DEMO_EMAIL)
+}
+
+// mealResponsesForEmail is the endpoint to retrieve the glucose response of the meals of a range, most recent first,
+// along with their averages by time of day and carb bucket. The range defaults to the last 30 days of data.
+func mealResponsesForEmail(writer http.ResponseWriter, request *http.Request, email string) {
+	context := appengine.NewContext(request)
+
+	_, _, mostRecentRead, err := store.GetUserData(context, email)
+	if err == store.ErrNoImportedDataFound {
+		log.Debugf(context, "No imported data found for user [%s]", email)
+		http.Error(writer, err.Error(), 204)
+		return
+	} else if err != nil {
+		util.Propagate(err)
+	}
+
+	lowerBound, upperBound, err := newReadRange(request, mostRecentRead.AddDate(0, 0, -1*DEFAULT_MEAL_RESPONSE_DAYS), mostRecentRead)
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	meals, err := store.GetMeals(context, email, lowerBound, upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	// Responses of meals close to the bounds use reads outside of the range
+	reads, err := store.GetGlucoseReads(context, email, lowerBound.Add(-1*engine.MEAL_BASELINE_WINDOW), upperBound.Add(engine.MEAL_RESPONSE_DURATION))
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	responses, err := engine.CalculateMealResponses(meals, reads)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	report := model.MealResponseReport{LowerBound: lowerBound, UpperBound: upperBound}
+	report.ByTimeOfDay, report.ByCarbs = engine.AggregateMealResponses(responses)
+
+	report.Meals = make([]model.MealResponse, len(responses))
+	for i, response := range responses {
+		report.Meals[len(responses)-1-i] = response
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(report)
+}
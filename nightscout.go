@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/nightscout"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/user"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	NIGHTSCOUT_API_SECRET_HEADER = "api-secret"
+	NIGHTSCOUT_DEFAULT_COUNT     = 10
+	// Reads are at most every 5 minutes, we look back far enough to find count reads with some room for gaps
+	NIGHTSCOUT_READ_INTERVAL = time.Duration(5) * time.Minute
+	NIGHTSCOUT_MIN_LOOKBACK  = time.Duration(24) * time.Hour
+
+	QUERY_PARAM_COUNT = "count"
+)
+
+// NightscoutSecretResponse holds a newly generated API secret. It is only ever returned once.
+type NightscoutSecretResponse struct {
+	Url    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+// generateNightscoutSecret generates a new API secret for the current user, revoking any previous one
+func generateNightscoutSecret(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	secretBytes := make([]byte, 16)
+	if _, err := rand.Read(secretBytes); err != nil {
+		util.Propagate(err)
+	}
+	secret := hex.EncodeToString(secretBytes)
+
+	if err := store.StoreApiSecret(context, user.Email, hashNightscoutSecret(secret)); err != nil {
+		util.Propagate(err)
+	}
+
+	log.Infof(context, "Generated new api secret for user [%s]", user.Email)
+	writeNightscoutResponse(writer, NightscoutSecretResponse{appConfig.SSLHost, secret})
+}
+
+// nightscoutEntries serves the glucose reads of the authenticated user like the Nightscout /api/v1/entries.json endpoint
+func nightscoutEntries(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	email, ok := authenticateNightscoutUser(context, request)
+	if !ok {
+		http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	count, lowerBound, upperBound, err := parseNightscoutQuery(context, request, email, "date")
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	reads, err := store.GetGlucoseReads(context, email, lowerBound, upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	entries, err := nightscout.NewEntries(reads)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	if len(entries) > count {
+		entries = entries[:count]
+	}
+
+	writeNightscoutResponse(writer, entries)
+}
+
+// nightscoutTreatments serves the injections, meals and exercises of the authenticated user like the Nightscout
+// /api/v1/treatments.json endpoint
+func nightscoutTreatments(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	email, ok := authenticateNightscoutUser(context, request)
+	if !ok {
+		http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	count, lowerBound, upperBound, err := parseNightscoutQuery(context, request, email, "created_at")
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	injections, err := store.GetInjections(context, email, lowerBound, upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	meals, err := store.GetMeals(context, email, lowerBound, upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	exercises, err := store.GetExercises(context, email, lowerBound, upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	treatments := nightscout.NewTreatments(injections, meals, exercises)
+	if len(treatments) > count {
+		treatments = treatments[:count]
+	}
+
+	writeNightscoutResponse(writer, treatments)
+}
+
+// nightscoutStatus serves the status of glukit like the Nightscout /api/v1/status.json endpoint
+func nightscoutStatus(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	email, ok := authenticateNightscoutUser(context, request)
+	if !ok {
+		http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	glucoseUnit := apimodel.GlucoseUnit(apimodel.MG_PER_DL)
+	if _, glukitUser, err := store.GetGlukitUser(context, email); err != nil {
+		util.Propagate(err)
+	} else if glukitUser.MostRecentRead.Unit == apimodel.MMOL_PER_L {
+		glucoseUnit = apimodel.MMOL_PER_L
+	}
+
+	writeNightscoutResponse(writer, nightscout.NewStatus(appengine.VersionID(context), time.Now(), glucoseUnit))
+}
+
+// authenticateNightscoutUser returns the email of the user identified by the request. Nightscout clients send the
+// sha1 hash of the API secret in the api-secret header, the secret itself is never accepted so that it stays out of
+// urls and request logs. Clients that went through our oauth flow can also use their bearer token.
+func authenticateNightscoutUser(context context.Context, request *http.Request) (email string, ok bool) {
+	secretHash := strings.ToLower(request.Header.Get(NIGHTSCOUT_API_SECRET_HEADER))
+	if len(secretHash) > 0 {
+		email, err := store.GetApiSecretEmail(context, secretHash)
+		if err != nil {
+			log.Infof(context, "Api secret not found: %v", err)
+			return "", false
+		}
+
+		return email, true
+	}
+
+	accessCode := strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+	if len(accessCode) == 0 {
+		return "", false
+	}
+
+	osinStore := new(store.OsinAppEngineStore)
+	accessData, err := osinStore.LoadAccessWithContext(accessCode, context)
+	if err != nil || accessData.Client == nil || accessData.IsExpired() {
+		return "", false
+	}
+
+	return accessData.UserData.(string), true
+}
+
+// parseNightscoutQuery parses the count and the find[field][$gte|$gt|$lte|$lt] bounds of a Nightscout query. Dates
+// are in epoch milliseconds for entries and in ISO-8601 for treatments. Without a lower bound, we look back from the
+// user's most recent read far enough to return count elements.
+func parseNightscoutQuery(context context.Context, request *http.Request, email string, field string) (count int, lowerBound, upperBound time.Time, err error) {
+	count = NIGHTSCOUT_DEFAULT_COUNT
+	if value := request.FormValue(QUERY_PARAM_COUNT); len(value) > 0 {
+		if count, err = strconv.Atoi(value); err != nil || count < 1 {
+			return 0, lowerBound, upperBound, fmt.Errorf("Invalid value for %s: [%s].", QUERY_PARAM_COUNT, value)
+		}
+	}
+
+	upperBound = time.Now()
+	for _, operator := range []string{"$lte", "$lt"} {
+		if value := request.FormValue(fmt.Sprintf("find[%s][%s]", field, operator)); len(value) > 0 {
+			if upperBound, err = parseNightscoutTime(value); err != nil {
+				return 0, lowerBound, upperBound, err
+			}
+		}
+	}
+
+	for _, operator := range []string{"$gte", "$gt"} {
+		if value := request.FormValue(fmt.Sprintf("find[%s][%s]", field, operator)); len(value) > 0 {
+			lowerBound, err = parseNightscoutTime(value)
+			return count, lowerBound, upperBound, err
+		}
+	}
+
+	lookbackStart := upperBound
+	if _, glukitUser, err := store.GetGlukitUser(context, email); err == nil && glukitUser.MostRecentRead.GetTime().Before(upperBound) {
+		lookbackStart = glukitUser.MostRecentRead.GetTime()
+	}
+
+	lookback := time.Duration(count) * NIGHTSCOUT_READ_INTERVAL
+	if lookback < NIGHTSCOUT_MIN_LOOKBACK {
+		lookback = NIGHTSCOUT_MIN_LOOKBACK
+	}
+
+	return count, lookbackStart.Add(-lookback), upperBound, nil
+}
+
+// parseNightscoutTime parses a Nightscout time value which is either epoch milliseconds or ISO-8601
+func parseNightscoutTime(value string) (t time.Time, err error) {
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(0, millis*int64(time.Millisecond)), nil
+	}
+
+	if t, err = time.Parse(time.RFC3339, value); err != nil {
+		return t, fmt.Errorf("Invalid time value: [%s].", value)
+	}
+
+	return t, nil
+}
+
+// hashNightscoutSecret returns the sha1 hash of an API secret the way Nightscout clients send it
+func hashNightscoutSecret(secret string) string {
+	sum := sha1.Sum([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func writeNightscoutResponse(writer http.ResponseWriter, value interface{}) {
+	header := writer.Header()
+	header.Add("Content-type", "application/json")
+	// Nightscout clients include browser-based watch faces and widgets
+	header.Add("Access-Control-Allow-Origin", "*")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(value)
+}
@@ -11,6 +11,7 @@ import (
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
 	"google.golang.org/appengine/user"
 	"html/template"
 	"net/http"
@@ -186,9 +187,38 @@ func (handler *oauthAuthenticatedHandler) ServeHTTP(writer http.ResponseWriter,
 		return
 	}
 
+	// Tokens are revoked when the deletion starts but one saved in between shouldn't let a client write data back
+	if pending, err := store.IsAccountDeletionPending(c, accessData.UserData.(string)); err != nil {
+		util.Propagate(err)
+	} else if pending {
+		ret.SetError(osin.E_ACCESS_DENIED, "Account is being deleted")
+		ret.StatusCode = 403
+		osin.OutputJSON(ret, writer, request)
+		return
+	}
+
 	handler.authenticatedHandler.ServeHTTP(writer, request)
 }
 
 func newOauthAuthenticationHandler(next http.Handler) *oauthAuthenticatedHandler {
 	return &oauthAuthenticatedHandler{next}
 }
+
+// startLegacyGrantIndexing is an admin endpoint that kicks off the one-off indexing of the oauth grants saved before
+// UserData was indexed, one chain of tasks per kind of grant
+func startLegacyGrantIndexing(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+
+	for _, kind := range store.GRANT_KINDS {
+		task, err := indexLegacyGrants.Task(kind, "")
+		if err != nil {
+			util.Propagate(err)
+		}
+		if _, err := taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME); err != nil {
+			util.Propagate(err)
+		}
+		log.Infof(context, "Queued up indexing of legacy grants of kind [%s]", kind)
+	}
+
+	writer.WriteHeader(200)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/user"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	QUERY_PARAM_INSULIN_TYPE         = "insulinType"
+	QUERY_PARAM_PEAK_MINUTES         = "peakMinutes"
+	QUERY_PARAM_DURATION_MINUTES     = "durationMinutes"
+	QUERY_PARAM_CARB_ABSORPTION_RATE = "carbAbsorptionRate"
+
+	INSULIN_ON_BOARD_TAG = "InsulinOnBoard"
+	CARBS_ON_BOARD_TAG   = "CarbsOnBoard"
+
+	// Number of hours of on board values returned when no range is requested
+	DEFAULT_ONBOARD_HOURS = 24
+
+	// Longest range of on board values that can be requested
+	MAX_ONBOARD_RANGE = time.Duration(14*24) * time.Hour
+
+	// Fastest carb absorption rate a user can set, in grams per hour
+	MAX_CARB_ABSORPTION_RATE = 200.
+)
+
+func onBoard(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	onBoardForEmail(writer, request, user.Email)
+}
+
+func onBoardForDemo(writer http.ResponseWriter, request *http.Request) {
+	onBoardForEmail(writer, request, DEMO_EMAIL)
+}
+
+// onBoardForEmail is the endpoint to retrieve the insulin and carbs on board of a range, every 5 minutes. The range
+// defaults to the last 24 hours of data.
+func onBoardForEmail(writer http.ResponseWriter, request *http.Request, email string) {
+	context := appengine.NewContext(request)
+
+	_, _, mostRecentRead, err := store.GetUserData(context, email)
+	if err == store.ErrNoImportedDataFound {
+		log.Debugf(context, "No imported data found for user [%s]", email)
+		http.Error(writer, err.Error(), 204)
+		return
+	} else if err != nil {
+		util.Propagate(err)
+	}
+
+	lowerBound, upperBound, err := newReadRange(request, mostRecentRead.Add(time.Duration(-1*DEFAULT_ONBOARD_HOURS)*time.Hour), mostRecentRead)
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	if upperBound.Sub(lowerBound) > MAX_ONBOARD_RANGE {
+		http.Error(writer, fmt.Sprintf("Range can't be longer than %d days.", int(MAX_ONBOARD_RANGE.Hours()/24)), 400)
+		return
+	}
+
+	settings, err := store.GetUserSettings(context, email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	// Injections and meals prior to the range can still be on board at its start
+	injections, err := store.GetInjections(context, email, lowerBound.Add(-1*engine.ONBOARD_LOOKBACK), upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+	meals, err := store.GetMeals(context, email, lowerBound.Add(-1*engine.ONBOARD_LOOKBACK), upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	values := engine.CalculateOnBoard(injections, meals, settings.OnBoard, engine.GetOnBoardSeriesTimes(lowerBound, upperBound))
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(values)
+}
+
+// OnBoardSettingsResponse holds the carb absorption rate of a user and the insulin activity curves in effect for it,
+// its own curves followed by the default ones it didn't override
+type OnBoardSettingsResponse struct {
+	InsulinActivityCurves []model.InsulinActivityCurve `json:"insulinActivityCurves"`
+	CarbAbsorptionRate    float64                      `json:"carbAbsorptionRate"`
+}
+
+// onBoardSettings is the endpoint to retrieve the insulin activity curves and carb absorption rate of the current user
+func onBoardSettings(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	settings, err := store.GetUserSettings(context, user.Email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	response := OnBoardSettingsResponse{CarbAbsorptionRate: settings.OnBoard.CarbAbsorptionRate}
+	response.InsulinActivityCurves = append(response.InsulinActivityCurves, settings.OnBoard.InsulinActivityCurves...)
+	for _, curve := range model.DEFAULT_INSULIN_ACTIVITY_CURVES {
+		if findCurveIndex(settings.OnBoard.InsulinActivityCurves, curve.InsulinType) < 0 {
+			response.InsulinActivityCurves = append(response.InsulinActivityCurves, curve)
+		}
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(response)
+}
+
+// updateOnBoardSettings sets the activity curve of an insulin type and/or the carb absorption rate of the current
+// user. A curve is set with the insulinType, peakMinutes and durationMinutes parameters.
+func updateOnBoardSettings(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	settings, err := store.GetUserSettings(context, user.Email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	insulinType := request.FormValue(QUERY_PARAM_INSULIN_TYPE)
+	rate := request.FormValue(QUERY_PARAM_CARB_ABSORPTION_RATE)
+	if len(insulinType) == 0 && len(rate) == 0 {
+		http.Error(writer, fmt.Sprintf("Request must specify at least one of: %s or %s.", QUERY_PARAM_INSULIN_TYPE, QUERY_PARAM_CARB_ABSORPTION_RATE), 400)
+		return
+	}
+
+	if len(insulinType) > 0 {
+		curve := model.InsulinActivityCurve{InsulinType: insulinType}
+		for name, parameter := range map[string]*int{QUERY_PARAM_PEAK_MINUTES: &curve.PeakMinutes, QUERY_PARAM_DURATION_MINUTES: &curve.DurationMinutes} {
+			if *parameter, err = strconv.Atoi(request.FormValue(name)); err != nil {
+				http.Error(writer, fmt.Sprintf("Invalid value for %s: [%s].", name, request.FormValue(name)), 400)
+				return
+			}
+		}
+
+		if err := curve.Validate(); err != nil {
+			http.Error(writer, err.Error(), 400)
+			return
+		}
+
+		if i := findCurveIndex(settings.OnBoard.InsulinActivityCurves, insulinType); i >= 0 {
+			settings.OnBoard.InsulinActivityCurves[i] = curve
+		} else {
+			settings.OnBoard.InsulinActivityCurves = append(settings.OnBoard.InsulinActivityCurves, curve)
+		}
+	}
+
+	if len(rate) > 0 {
+		value, err := strconv.ParseFloat(rate, 64)
+		if err != nil || value <= 0 || value > MAX_CARB_ABSORPTION_RATE {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s, must be between 0 and %.0f: [%s].", QUERY_PARAM_CARB_ABSORPTION_RATE, MAX_CARB_ABSORPTION_RATE, rate), 400)
+			return
+		}
+		settings.OnBoard.CarbAbsorptionRate = value
+	}
+
+	log.Infof(context, "Updating on board settings of user [%s] to [%v]", user.Email, settings.OnBoard)
+	if err := store.StoreUserSettings(context, user.Email, *settings); err != nil {
+		util.Propagate(err)
+	}
+
+	writer.WriteHeader(200)
+}
+
+func findCurveIndex(curves []model.InsulinActivityCurve, insulinType string) int {
+	for i, curve := range curves {
+		if strings.EqualFold(curve.InsulinType, insulinType) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// newOnBoardDataPoints returns the insulin and carbs on board at the time of each read as data points of the data
+// browser. Only the injections and meals given are accounted for so the first values can miss what was on board
+// before them.
+func newOnBoardDataPoints(reads []apimodel.GlucoseRead, injections []apimodel.Injection, meals []apimodel.Meal, settings model.OnBoardSettings, glucoseUnit apimodel.GlucoseUnit) (insulinOnBoard []apimodel.DataPoint, carbsOnBoard []apimodel.DataPoint) {
+	times := make([]time.Time, len(reads))
+	for i, read := range reads {
+		times[i] = read.GetTime()
+	}
+	values := engine.CalculateOnBoard(injections, meals, settings, times)
+
+	readPoints := apimodel.GlucoseReadSlice(reads).ToDataPointSlice(glucoseUnit)
+	insulinOnBoard = make([]apimodel.DataPoint, len(readPoints))
+	carbsOnBoard = make([]apimodel.DataPoint, len(readPoints))
+	for i, readPoint := range readPoints {
+		insulinOnBoard[i] = apimodel.DataPoint{readPoint.LocalTime, readPoint.EpochTime, readPoint.Y, float32(values[i].InsulinOnBoard), INSULIN_ON_BOARD_TAG, glucoseUnit}
+		carbsOnBoard[i] = apimodel.DataPoint{readPoint.LocalTime, readPoint.EpochTime, readPoint.Y, float32(values[i].CarbsOnBoard), CARBS_ON_BOARD_TAG, glucoseUnit}
+	}
+
+	return insulinOnBoard, carbsOnBoard
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/user"
+	"net/http"
+)
+
+func glucosePatterns(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	glucosePatternsForEmail(writer, request, user.Email)
+}
+
+func glucosePatternsForDemo(writer http.ResponseWriter, request *http.Request) {
+	glucosePatternsForEmail(writer, request, DEMO_EMAIL)
+}
+
+// glucosePatternsForEmail is the endpoint to retrieve the recurring patterns found by the most recent weekly pattern
+// detection of a user, strongest first
+func glucosePatternsForEmail(writer http.ResponseWriter, request *http.Request, email string) {
+	context := appengine.NewContext(request)
+
+	report, err := store.GetPatternReport(context, email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	if report.CalculatedOn.IsZero() {
+		http.Error(writer, "No patterns detected yet.", 204)
+		return
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(report)
+}
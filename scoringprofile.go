@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/user"
+	"net/http"
+	"strconv"
+)
+
+const (
+	QUERY_PARAM_TARGET          = "target"
+	QUERY_PARAM_HIGH_MULTIPLIER = "highMultiplier"
+	QUERY_PARAM_LOW_MULTIPLIER  = "lowMultiplier"
+	QUERY_PARAM_HYPO_THRESHOLD  = "hypoThreshold"
+	QUERY_PARAM_HYPO_MULTIPLIER = "hypoMultiplier"
+	QUERY_PARAM_HYPO_EXPONENT   = "hypoExponent"
+)
+
+// ScoringProfileResponse is the scoring profile of a user along with the classic profile it can be compared with
+type ScoringProfileResponse struct {
+	Profile model.ScoringProfile `json:"profile"`
+	Classic model.ScoringProfile `json:"classic"`
+}
+
+// scoringProfile is the endpoint to retrieve the current user's glukit scoring profile, in mg/dL
+func scoringProfile(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	settings, err := store.GetUserSettings(context, user.Email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(ScoringProfileResponse{Profile: engine.GetScoringProfile(settings.ScoringProfile), Classic: engine.CLASSIC_SCORING_PROFILE})
+}
+
+// updateScoringProfile sets the current user's glukit scoring profile and kicks off the recalculation of its glukit
+// score history. The target and hypo threshold are in mg/dL unless the unit parameter says otherwise. The hypo
+// parameters are optional, the hypo penalty is off without them.
+func updateScoringProfile(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	multiplier := 1.
+	if request.FormValue(GLUCOSE_UNIT_PARAMETER) == apimodel.MMOL_PER_L {
+		multiplier = 18.0182
+	}
+
+	var profile model.ScoringProfile
+	for name, field := range map[string]*float64{QUERY_PARAM_TARGET: &profile.Target, QUERY_PARAM_HIGH_MULTIPLIER: &profile.HighMultiplier,
+		QUERY_PARAM_LOW_MULTIPLIER: &profile.LowMultiplier, QUERY_PARAM_HYPO_THRESHOLD: &profile.HypoThreshold,
+		QUERY_PARAM_HYPO_MULTIPLIER: &profile.HypoMultiplier, QUERY_PARAM_HYPO_EXPONENT: &profile.HypoExponent} {
+		value := request.FormValue(name)
+		if len(value) == 0 && (name == QUERY_PARAM_HYPO_THRESHOLD || name == QUERY_PARAM_HYPO_MULTIPLIER || name == QUERY_PARAM_HYPO_EXPONENT) {
+			continue
+		}
+
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s: [%s].", name, value), 400)
+			return
+		}
+		*field = parsed
+	}
+	profile.Target = profile.Target * multiplier
+	profile.HypoThreshold = profile.HypoThreshold * multiplier
+
+	if err := profile.Validate(); err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	settings, err := store.GetUserSettings(context, user.Email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	if engine.GetScoringProfile(settings.ScoringProfile) == profile {
+		writer.WriteHeader(200)
+		return
+	}
+
+	log.Infof(context, "Updating scoring profile of user [%s] from [%v] to [%v]", user.Email, settings.ScoringProfile, profile)
+	settings.ScoringProfile = profile
+	if err := store.StoreUserSettings(context, user.Email, *settings); err != nil {
+		util.Propagate(err)
+	}
+
+	if err := engine.RecalculateGlukitScores(context, user.Email); err != nil {
+		log.Criticalf(context, "Couldn't schedule the recalculation of glukit scores for user [%s]: %v", user.Email, err)
+	}
+
+	writer.WriteHeader(200)
+}
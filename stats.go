@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/user"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	QUERY_PARAM_CONGA_HOURS = "congaHours"
+
+	// Number of days covered by statistics when no range is requested
+	DEFAULT_STATS_DAYS = 14
+
+	// Largest range that statistics can be calculated over
+	MAX_STATS_RANGE = time.Duration(365*24) * time.Hour
+
+	// Largest number of hours between reads compared by CONGA
+	MAX_CONGA_HOURS = 24
+)
+
+func stats(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	statsForEmail(writer, request, user.Email)
+}
+
+func statsForDemo(writer http.ResponseWriter, request *http.Request) {
+	statsForEmail(writer, request, DEMO_EMAIL)
+}
+
+// statsForEmail is the endpoint to retrieve the glycemic variability statistics of a range of reads. The range
+// defaults to the last 14 days of data.
+func statsForEmail(writer http.ResponseWriter, request *http.Request, email string) {
+	context := appengine.NewContext(request)
+
+	_, _, mostRecentRead, err := store.GetUserData(context, email)
+	if err == store.ErrNoImportedDataFound {
+		log.Debugf(context, "No imported data found for user [%s]", email)
+		http.Error(writer, err.Error(), 204)
+		return
+	} else if err != nil {
+		util.Propagate(err)
+	}
+
+	lowerBound, upperBound, err := newReadRange(request, mostRecentRead.AddDate(0, 0, -1*DEFAULT_STATS_DAYS), mostRecentRead)
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	congaHours := engine.DEFAULT_CONGA_HOURS
+	if value := request.FormValue(QUERY_PARAM_CONGA_HOURS); len(value) > 0 {
+		if congaHours, err = strconv.Atoi(value); err != nil || congaHours < 1 || congaHours > MAX_CONGA_HOURS {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s, must be between 1 and %d: [%s].", QUERY_PARAM_CONGA_HOURS, MAX_CONGA_HOURS, value), 400)
+			return
+		}
+	}
+
+	reads, err := store.GetGlucoseReads(context, email, lowerBound, upperBound)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	settings, err := store.GetUserSettings(context, email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	statistics, err := engine.CalculateVariability(reads, congaHours, settings.Interpolation)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	if statistics == nil {
+		http.Error(writer, "Not enough reads to calculate statistics.", 204)
+		return
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(statistics)
+}
+
+// newReadRange returns the range of reads requested with the from and to parameters, both in seconds since epoch.
+// Missing bounds are set to their default value.
+func newReadRange(request *http.Request, defaultLowerBound, defaultUpperBound time.Time) (lowerBound, upperBound time.Time, err error) {
+	lowerBound, upperBound = defaultLowerBound, defaultUpperBound
+
+	if value := request.FormValue(QUERY_PARAM_FROM); len(value) > 0 {
+		fromValue, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return lowerBound, upperBound, errors.New(fmt.Sprintf("Invalid value for %s: [%v].", QUERY_PARAM_FROM, err))
+		}
+		lowerBound = time.Unix(fromValue, 0)
+	}
+
+	if value := request.FormValue(QUERY_PARAM_TO); len(value) > 0 {
+		toValue, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return lowerBound, upperBound, errors.New(fmt.Sprintf("Invalid value for %s: [%v].", QUERY_PARAM_TO, err))
+		}
+		upperBound = time.Unix(toValue, 0)
+	}
+
+	if !upperBound.After(lowerBound) {
+		return lowerBound, upperBound, errors.New(fmt.Sprintf("%s must be before %s.", QUERY_PARAM_FROM, QUERY_PARAM_TO))
+	}
+
+	if upperBound.Sub(lowerBound) > MAX_STATS_RANGE {
+		return lowerBound, upperBound, errors.New(fmt.Sprintf("Range can't be longer than %d days.", int(MAX_STATS_RANGE.Hours()/24)))
+	}
+
+	return lowerBound, upperBound, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+	"google.golang.org/appengine/user"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	QUERY_PARAM_ID        = "id"
+	QUERY_PARAM_EMAIL     = "email"
+	QUERY_PARAM_EXPIRES   = "expires"
+	QUERY_PARAM_SIGNATURE = "signature"
+
+	// How long a takeout archive can be downloaded once it's ready
+	TAKEOUT_EXPIRY = time.Duration(7*24) * time.Hour
+)
+
+// TakeoutResponse represents the status of a takeout archive. The download link is only set once the archive is ready.
+type TakeoutResponse struct {
+	Id      int64                `json:"id"`
+	Archive model.TakeoutArchive `json:"archive"`
+	Link    string               `json:"link,omitempty"`
+}
+
+// requestTakeout creates a new takeout archive for the current user and enqueues its generation
+func requestTakeout(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	signingKey := make([]byte, 32)
+	if _, err := rand.Read(signingKey); err != nil {
+		util.Propagate(err)
+	}
+
+	archive := model.TakeoutArchive{Status: model.TAKEOUT_STATUS_PENDING, RequestedOn: time.Now(), SigningKey: signingKey}
+	key, err := store.StoreTakeoutArchive(context, user.Email, archive)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	task, err := generateTakeout.Task(user.Email, key.IntID())
+	if err != nil {
+		util.Propagate(err)
+	}
+	taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME)
+
+	log.Infof(context, "Enqueued generation of takeout archive [%d] for user [%s]", key.IntID(), user.Email)
+	writeTakeoutResponse(writer, user.Email, key.IntID(), archive)
+}
+
+// takeoutStatus is the endpoint to poll the status of a takeout archive of the current user
+func takeoutStatus(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	id, err := strconv.ParseInt(request.FormValue(QUERY_PARAM_ID), 10, 64)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", QUERY_PARAM_ID, err), 400)
+		return
+	}
+
+	_, archive, err := store.GetTakeoutArchive(context, user.Email, id)
+	if err == datastore.ErrNoSuchEntity {
+		http.Error(writer, "Takeout archive not found.", http.StatusNotFound)
+		return
+	} else if err != nil {
+		util.Propagate(err)
+	}
+
+	writeTakeoutResponse(writer, user.Email, id, *archive)
+}
+
+// downloadTakeout streams the content of a takeout archive. It doesn't require the user to be logged in but the link
+// has to be signed with the archive's signing key and not be expired.
+func downloadTakeout(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+
+	email := request.FormValue(QUERY_PARAM_EMAIL)
+	id, err := strconv.ParseInt(request.FormValue(QUERY_PARAM_ID), 10, 64)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", QUERY_PARAM_ID, err), 400)
+		return
+	}
+	expires, err := strconv.ParseInt(request.FormValue(QUERY_PARAM_EXPIRES), 10, 64)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid value for %s: [%v].", QUERY_PARAM_EXPIRES, err), 400)
+		return
+	}
+
+	key, archive, err := store.GetTakeoutArchive(context, email, id)
+	if err == datastore.ErrNoSuchEntity {
+		http.Error(writer, "Takeout archive not found.", http.StatusNotFound)
+		return
+	} else if err != nil {
+		util.Propagate(err)
+	}
+
+	signature := signTakeoutLink(archive.SigningKey, email, id, expires)
+	if !hmac.Equal([]byte(signature), []byte(request.FormValue(QUERY_PARAM_SIGNATURE))) {
+		log.Warningf(context, "Invalid signature for takeout archive [%d] of user [%s]", id, email)
+		http.Error(writer, "Invalid signature.", http.StatusForbidden)
+		return
+	}
+
+	if archive.Status != model.TAKEOUT_STATUS_READY || time.Now().After(archive.ExpiresOn) || time.Now().Unix() > expires {
+		http.Error(writer, "Takeout archive expired.", http.StatusGone)
+		return
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/zip")
+	value.Add("Content-Length", strconv.FormatInt(archive.Size, 10))
+	value.Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"glukit-takeout-%s.zip\"", archive.CompletedOn.Format("2006-01-02")))
+
+	if err := store.WriteTakeoutContent(context, key, archive.ChunkCount, writer); err != nil {
+		log.Errorf(context, "Error writing content of takeout archive [%d] of user [%s]: %v", id, email, err)
+	}
+}
+
+func writeTakeoutResponse(writer http.ResponseWriter, email string, id int64, archive model.TakeoutArchive) {
+	response := TakeoutResponse{Id: id, Archive: archive}
+	if archive.Status == model.TAKEOUT_STATUS_READY {
+		response.Link = newTakeoutLink(email, id, archive)
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(response)
+}
+
+// newTakeoutLink returns the signed download link of an archive. The link expires at the same time as the archive.
+func newTakeoutLink(email string, id int64, archive model.TakeoutArchive) string {
+	expires := archive.ExpiresOn.Unix()
+	parameters := url.Values{}
+	parameters.Set(QUERY_PARAM_EMAIL, email)
+	parameters.Set(QUERY_PARAM_ID, strconv.FormatInt(id, 10))
+	parameters.Set(QUERY_PARAM_EXPIRES, strconv.FormatInt(expires, 10))
+	parameters.Set(QUERY_PARAM_SIGNATURE, signTakeoutLink(archive.SigningKey, email, id, expires))
+
+	return fmt.Sprintf("%s/takeout/download?%s", appConfig.SSLHost, parameters.Encode())
+}
+
+// signTakeoutLink signs the parameters of a download link with the archive's signing key
+func signTakeoutLink(signingKey []byte, email string, id int64, expires int64) string {
+	mac := hmac.New(sha256.New, signingKey)
+	fmt.Fprintf(mac, "%s:%d:%d", email, id, expires)
+	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"bufio"
+	"crypto/sha1"
+	"fmt"
 	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/exporter"
 	"github.com/alexandre-normand/glukit/app/importer"
 	"github.com/alexandre-normand/glukit/app/model"
 	"github.com/alexandre-normand/glukit/app/store"
@@ -33,11 +36,47 @@ var refreshUserData = delay.Func(REFRESH_USER_DATA_FUNCTION_NAME, func(context c
 		"shows up because the function calls itself. This implementation defines the same signature as the "+
 		"real one which we define in init() to override this implementation!")
 })
+var migrateTimezones = delay.Func(MIGRATE_TIMEZONES_FUNCTION_NAME, func(context context.Context, userEmail string,
+	lowerBound time.Time) {
+	log.Criticalf(context, "This function purely exists as a workaround to the \"initialization loop\" error that "+
+		"shows up because the function calls itself. This implementation defines the same signature as the "+
+		"real one which we define in init() to override this implementation!")
+})
+var backfillCoverage = delay.Func(BACKFILL_COVERAGE_FUNCTION_NAME, func(context context.Context, userEmail string,
+	lowerBound time.Time) {
+	log.Criticalf(context, "This function purely exists as a workaround to the \"initialization loop\" error that "+
+		"shows up because the function calls itself. This implementation defines the same signature as the "+
+		"real one which we define in init() to override this implementation!")
+})
+var indexLegacyGrants = delay.Func(INDEX_LEGACY_GRANTS_FUNCTION_NAME, func(context context.Context, kind string,
+	after string) {
+	log.Criticalf(context, "This function purely exists as a workaround to the \"initialization loop\" error that "+
+		"shows up because the function calls itself. This implementation defines the same signature as the "+
+		"real one which we define in init() to override this implementation!")
+})
+var deleteAccountData = delay.Func(DELETE_ACCOUNT_DATA_FUNCTION_NAME, func(context context.Context, userEmail string,
+	tombstoneId int64) {
+	log.Criticalf(context, "This function purely exists as a workaround to the \"initialization loop\" error that "+
+		"shows up because the function calls itself. This implementation defines the same signature as the "+
+		"real one which we define in init() to override this implementation!")
+})
+var generateTakeout = delay.Func(GENERATE_TAKEOUT_FUNCTION_NAME, generateUserTakeout)
+var expireTakeout = delay.Func(EXPIRE_TAKEOUT_FUNCTION_NAME, expireUserTakeout)
 
 const (
-	REFRESH_USER_DATA_FUNCTION_NAME = "refreshUserData"
-	PROCESS_FILE_FUNCTION_NAME      = "processSingleFile"
-	DATASTORE_WRITES_QUEUE_NAME     = "datastore-writes"
+	REFRESH_USER_DATA_FUNCTION_NAME   = "refreshUserData"
+	PROCESS_FILE_FUNCTION_NAME        = "processSingleFile"
+	MIGRATE_TIMEZONES_FUNCTION_NAME   = "migrateTimezones"
+	BACKFILL_COVERAGE_FUNCTION_NAME   = "backfillCoverage"
+	INDEX_LEGACY_GRANTS_FUNCTION_NAME = "indexLegacyGrants"
+	GENERATE_TAKEOUT_FUNCTION_NAME    = "generateTakeout"
+	EXPIRE_TAKEOUT_FUNCTION_NAME      = "expireTakeout"
+	DELETE_ACCOUNT_DATA_FUNCTION_NAME = "deleteAccountData"
+	DATASTORE_WRITES_QUEUE_NAME       = "datastore-writes"
+	// The number of days of data to migrate in a single timezone migration task
+	TIMEZONE_MIGRATION_DAYS_PER_CHUNK = 90
+	// The number of days of coverage calculated by a single coverage backfill task, well under a PutMulti's limit
+	COVERAGE_BACKFILL_DAYS_PER_CHUNK = 30
 )
 
 func disabledUpdateUserData(context context.Context, userEmail string, autoScheduleNextRun bool) {
@@ -55,6 +94,14 @@ func updateUserData(context context.Context, userEmail string, autoScheduleNextR
 		return
 	}
 
+	// Breaks the refresh chain of an account being deleted
+	if pending, err := store.IsAccountDeletionPending(context, userEmail); err != nil {
+		util.Propagate(err)
+	} else if pending {
+		log.Infof(context, "Account [%s] is being deleted, not refreshing its data", userEmail)
+		return
+	}
+
 	transport := &oauth.Transport{
 		Config: configuration(),
 		Transport: &urlfetch.Transport{
@@ -93,8 +140,7 @@ func updateUserData(context context.Context, userEmail string, autoScheduleNextR
 		}
 	}
 
-	engine.StartGlukitScoreBatch(context, glukitUser)
-	engine.StartA1CCalculationBatch(context, glukitUser)
+	startCalculationsAfterImport(context, glukitUser)
 
 	if autoScheduleNextRun {
 		task, err := refreshUserData.Task(userEmail, autoScheduleNextRun)
@@ -143,6 +189,13 @@ func enqueueFileImport(context context.Context, token *oauth.Token, file *drive.
 //    3. Sending a "refresh" message to any connected client
 func processSingleFile(context context.Context, token *oauth.Token, file *drive.File, userEmail string,
 	userProfileKey *datastore.Key) {
+	if pending, err := store.IsAccountDeletionPending(context, userEmail); err != nil {
+		util.Propagate(err)
+	} else if pending {
+		log.Infof(context, "Account [%s] is being deleted, skipping import of file [%s]", userEmail, file.Id)
+		return
+	}
+
 	t := &oauth.Transport{
 		Config: configuration(),
 		Transport: &urlfetch.Transport{
@@ -183,16 +236,7 @@ func processSingleFile(context context.Context, token *oauth.Token, file *drive.
 			if glukitUser, err := store.GetUserProfile(context, userProfileKey); err != nil {
 				log.Warningf(context, "Error getting retrieving GlukitUser [%s], this needs attention: [%v]", userEmail, err)
 			} else {
-				// Calculate Glukit Score batch here for the newly imported data
-				err := engine.StartGlukitScoreBatch(context, glukitUser)
-				if err != nil {
-					log.Warningf(context, "Error starting batch calculation of GlukitScores for [%s], this needs attention: [%v]", userEmail, err)
-				}
-
-				err = engine.StartA1CCalculationBatch(context, glukitUser)
-				if err != nil {
-					log.Warningf(context, "Error starting a1c calculation batch for user [%s]: %v", userEmail, err)
-				}
+				startCalculationsAfterImport(context, glukitUser)
 			}
 		}
 	}
@@ -229,15 +273,244 @@ func processStaticDemoFile(context context.Context, userProfileKey *datastore.Ke
 	if userProfile, err := store.GetUserProfile(context, userProfileKey); err != nil {
 		log.Warningf(context, "Error while persisting score for %s: %v", DEMO_EMAIL, err)
 	} else {
-		if err := engine.StartGlukitScoreBatch(context, userProfile); err != nil {
-			log.Warningf(context, "Error while starting batch calculation of glukit scores for %s: %v", DEMO_EMAIL, err)
+		startCalculationsAfterImport(context, userProfile)
+	}
+
+	channel.Send(context, DEMO_EMAIL, "Refresh")
+}
+
+// migrateUserTimezones is an async task that rewrites a chunk of a user's legacy fixed offset timezones to its configured
+// zone. It schedules itself to run again with the following chunk until it reaches the present.
+func migrateUserTimezones(context context.Context, userEmail string, lowerBound time.Time) {
+	_, glukitUser, err := store.GetGlukitUser(context, userEmail)
+	if err != nil {
+		log.Errorf(context, "We're trying to run a timezone migration for user [%s] that doesn't exist. "+
+			"Got error: %v", userEmail, err)
+		return
+	}
+
+	location, err := util.GetOrLoadLocationForName(glukitUser.Timezone)
+	if err != nil {
+		log.Errorf(context, "Invalid timezone [%s] for user [%s], aborting migration: %v", glukitUser.Timezone, userEmail, err)
+		return
+	}
+
+	upperBound := lowerBound.AddDate(0, 0, TIMEZONE_MIGRATION_DAYS_PER_CHUNK)
+	// Failing the task makes the queue retry it
+	_, err = store.MigrateFixedOffsetTimezones(context, userEmail, location, lowerBound, upperBound)
+	util.Propagate(err)
+
+	if upperBound.Before(time.Now()) {
+		task, err := migrateTimezones.Task(userEmail, upperBound.Add(time.Second))
+		if err != nil {
+			log.Criticalf(context, "Couldn't schedule the next execution of [%s] for user [%s]. "+
+				"This breaks the timezone migration for that user!: %v", MIGRATE_TIMEZONES_FUNCTION_NAME, userEmail, err)
+			return
+		}
+		taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME)
+	} else {
+		log.Infof(context, "Done with timezone migration for user [%s]", userEmail)
+	}
+}
+
+// importCalculation is a calculation started after every import of a user's data
+type importCalculation struct {
+	name  string
+	start func(context.Context, *model.GlukitUser) error
+}
+
+var importCalculations = []importCalculation{
+	{"glukit score calculation batch", engine.StartGlukitScoreBatch},
+	{"a1c calculation batch", engine.StartA1CCalculationBatch},
+	{"time in range calculation batch", engine.StartTimeInRangeBatch},
+	{"episode detection batch", engine.StartEpisodeDetectionBatch},
+	{"dosing insight", engine.StartDosingInsightBatch},
+	{"pattern detection", engine.StartPatternDetectionBatch},
+	{"recalculation of invalidated scores", engine.StartInvalidatedRecalculation},
+}
+
+// startCalculationsAfterImport kicks off every calculation that follows an import of a user's data. A calculation
+// that fails to start is logged and doesn't keep the others from starting.
+func startCalculationsAfterImport(context context.Context, glukitUser *model.GlukitUser) {
+	for _, calculation := range importCalculations {
+		if err := calculation.start(context, glukitUser); err != nil {
+			log.Warningf(context, "Error starting %s for user [%s]: %v", calculation.name, glukitUser.Email, err)
 		}
+	}
+
+	startCoverageBackfill(context, glukitUser.Email)
+}
+
+// startCoverageBackfill queues the calculation of the daily coverage of the reads of a user that were imported
+// before coverage was tracked. Nothing is queued once the oldest reads are covered. The first task is named after the
+// user and the oldest read so that imports happening before the first chunk is done don't start another backfill.
+func startCoverageBackfill(context context.Context, userEmail string) {
+	start, err := store.GetUncoveredReadsStart(context, userEmail)
+	if err != nil {
+		log.Warningf(context, "Couldn't check the coverage of the oldest reads of user [%s]: %v", userEmail, err)
+		return
+	} else if start.IsZero() {
+		return
+	}
+
+	task, err := backfillCoverage.Task(userEmail, start)
+	if err != nil {
+		log.Criticalf(context, "Couldn't schedule the coverage backfill for user [%s]: %v", userEmail, err)
+		return
+	}
+	userHash := fmt.Sprintf("%x", sha1.Sum([]byte(userEmail)))
+	task.Name = BACKFILL_COVERAGE_FUNCTION_NAME + "-" + userHash + "-" + start.UTC().Format("20060102150405")
 
-		err = engine.StartA1CCalculationBatch(context, userProfile)
+	if _, err = taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME); err == taskqueue.ErrTaskAlreadyAdded {
+		log.Debugf(context, "Coverage backfill for user [%s] starting at [%s] already queued up", userEmail, start)
+		return
+	} else if err != nil {
+		log.Criticalf(context, "Couldn't queue up the coverage backfill for user [%s]: %v", userEmail, err)
+		return
+	}
+	log.Infof(context, "Queued up coverage backfill for user [%s] starting at [%s]", userEmail, start)
+}
+
+// backfillUserCoverage is an async task that calculates and stores the coverage of a chunk of a user's days. It
+// schedules itself to run again with the following chunk until it reaches the present.
+func backfillUserCoverage(context context.Context, userEmail string, lowerBound time.Time) {
+	upperBound := lowerBound.AddDate(0, 0, COVERAGE_BACKFILL_DAYS_PER_CHUNK)
+	// Failing the task makes the queue retry it
+	err := store.UpdateDailyCoverage(context, userEmail, lowerBound, upperBound)
+	util.Propagate(err)
+
+	if upperBound.Before(time.Now()) {
+		task, err := backfillCoverage.Task(userEmail, upperBound.Add(time.Second))
 		if err != nil {
-			log.Warningf(context, "Error starting a1c calculation batch for user [%s]: %v", DEMO_EMAIL, err)
+			log.Criticalf(context, "Couldn't schedule the next execution of [%s] for user [%s]. "+
+				"This breaks the coverage backfill for that user!: %v", BACKFILL_COVERAGE_FUNCTION_NAME, userEmail, err)
+			return
 		}
+		taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME)
+	} else {
+		log.Infof(context, "Done with coverage backfill for user [%s]", userEmail)
 	}
+}
 
-	channel.Send(context, DEMO_EMAIL, "Refresh")
+// indexLegacyOauthGrants is an async task that saves a chunk of the oauth grants of a kind again so that grants
+// saved before UserData was indexed can be looked up by user. It schedules itself to run again with the following
+// chunk until all grants of the kind were saved.
+func indexLegacyOauthGrants(context context.Context, kind string, after string) {
+	// Failing the task makes the queue retry it
+	last, err := store.IndexLegacyGrants(context, kind, after)
+	util.Propagate(err)
+
+	if last != "" {
+		task, err := indexLegacyGrants.Task(kind, last)
+		if err != nil {
+			log.Criticalf(context, "Couldn't schedule the next execution of [%s] for kind [%s]. "+
+				"This breaks the indexing of legacy grants!: %v", INDEX_LEGACY_GRANTS_FUNCTION_NAME, kind, err)
+			return
+		}
+		taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME)
+	} else {
+		log.Infof(context, "Done with indexing legacy grants of kind [%s]", kind)
+	}
+}
+
+// generateUserTakeout is an async task that generates the content of a takeout archive. Once ready, the archive can
+// be downloaded until it expires at which point another task deletes it.
+func generateUserTakeout(context context.Context, userEmail string, archiveId int64) {
+	key, archive, err := store.GetTakeoutArchive(context, userEmail, archiveId)
+	if err != nil {
+		log.Errorf(context, "We're trying to generate takeout archive [%d] for user [%s] that doesn't exist. "+
+			"Got error: %v", archiveId, userEmail, err)
+		return
+	}
+
+	takeoutWriter := store.NewDataStoreTakeoutWriter(context, key)
+	err = exporter.WriteTakeout(context, userEmail, takeoutWriter)
+	if err == nil {
+		err = takeoutWriter.Flush()
+	}
+
+	if err != nil {
+		log.Errorf(context, "Error generating takeout archive [%d] for user [%s]: %v", archiveId, userEmail, err)
+		archive.Status = model.TAKEOUT_STATUS_FAILED
+	} else {
+		archive.Status = model.TAKEOUT_STATUS_READY
+		archive.CompletedOn = time.Now()
+		archive.ExpiresOn = archive.CompletedOn.Add(TAKEOUT_EXPIRY)
+		archive.Size = takeoutWriter.Size
+		archive.ChunkCount = takeoutWriter.ChunkCount
+	}
+
+	if err := store.UpdateTakeoutArchive(context, key, *archive); err != nil {
+		util.Propagate(err)
+	}
+
+	if archive.Status == model.TAKEOUT_STATUS_READY {
+		task, err := expireTakeout.Task(userEmail, archiveId)
+		if err != nil {
+			log.Criticalf(context, "Couldn't schedule the expiry of takeout archive [%d] for user [%s]: %v", archiveId, userEmail, err)
+			return
+		}
+		task.ETA = archive.ExpiresOn
+		taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME)
+
+		log.Infof(context, "Takeout archive [%d] of [%d] bytes ready for user [%s], expiring at [%s]", archiveId, archive.Size, userEmail, archive.ExpiresOn.Format(util.TIMEFORMAT))
+	}
+}
+
+// expireUserTakeout deletes an expired takeout archive and its content
+func expireUserTakeout(context context.Context, userEmail string, archiveId int64) {
+	key, _, err := store.GetTakeoutArchive(context, userEmail, archiveId)
+	if err == datastore.ErrNoSuchEntity {
+		log.Infof(context, "Takeout archive [%d] for user [%s] already deleted", archiveId, userEmail)
+		return
+	} else if err != nil {
+		util.Propagate(err)
+	}
+
+	// Failing the task makes the queue retry it
+	util.Propagate(store.DeleteTakeoutArchive(context, key))
+	log.Infof(context, "Deleted expired takeout archive [%d] for user [%s]", archiveId, userEmail)
+}
+
+// deleteUserAccountData is an async task that deletes a batch of entities of an account being deleted. It schedules
+// itself to run again until all of the user's entities are gone at which point it completes the tombstone.
+func deleteUserAccountData(context context.Context, userEmail string, tombstoneId int64) {
+	tombstoneKey, tombstone, err := store.GetAccountTombstone(context, tombstoneId)
+	if err != nil {
+		log.Errorf(context, "We're trying to delete the account of user [%s] with tombstone [%d] that doesn't exist. "+
+			"Got error: %v", userEmail, tombstoneId, err)
+		return
+	}
+
+	// Revoke the oauth grants and api secrets before every batch so that clients stop reading and writing data while
+	// it's being deleted, including with grants or secrets made in between. Failing the task makes the queue retry it.
+	osinStore := new(store.OsinAppEngineStore)
+	util.Propagate(osinStore.RevokeUserGrantsWithContext(userEmail, context))
+	util.Propagate(store.DeleteApiSecrets(context, userEmail))
+
+	deleted, done, err := store.DeleteUserEntitiesBatch(context, userEmail)
+	util.Propagate(err)
+
+	tombstone.DeletedEntities = tombstone.DeletedEntities + int64(deleted)
+	if done {
+		tombstone.Status = model.ACCOUNT_DELETION_COMPLETED
+		tombstone.CompletedOn = time.Now()
+	}
+
+	if err := store.UpdateAccountTombstone(context, tombstoneKey, *tombstone); err != nil {
+		util.Propagate(err)
+	}
+
+	if done {
+		log.Infof(context, "Done deleting account [%s], deleted [%d] entities", userEmail, tombstone.DeletedEntities)
+		return
+	}
+
+	task, err := deleteAccountData.Task(userEmail, tombstoneId)
+	if err != nil {
+		log.Criticalf(context, "Couldn't schedule the next execution of [%s] for user [%s]. "+
+			"This breaks the deletion of that account!: %v", DELETE_ACCOUNT_DATA_FUNCTION_NAME, userEmail, err)
+		return
+	}
+	taskqueue.Add(context, task, DATASTORE_WRITES_QUEUE_NAME)
 }
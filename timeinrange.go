@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alexandre-normand/glukit/app/apimodel"
+	"github.com/alexandre-normand/glukit/app/engine"
+	"github.com/alexandre-normand/glukit/app/model"
+	"github.com/alexandre-normand/glukit/app/store"
+	"github.com/alexandre-normand/glukit/app/util"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/user"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	QUERY_PARAM_WINDOW    = "window"
+	QUERY_PARAM_VERY_LOW  = "veryLow"
+	QUERY_PARAM_LOW       = "low"
+	QUERY_PARAM_HIGH      = "high"
+	QUERY_PARAM_VERY_HIGH = "veryHigh"
+
+	// Largest window of days that time in range can be aggregated over
+	MAX_TIME_IN_RANGE_WINDOW = 90
+)
+
+func timeInRange(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	timeInRangeForEmail(writer, request, user.Email)
+}
+
+func timeInRangeForDemo(writer http.ResponseWriter, request *http.Request) {
+	timeInRangeForEmail(writer, request, DEMO_EMAIL)
+}
+
+// timeInRangeForEmail is the endpoint to retrieve a list of daily time in ranges. With a window of more than one day,
+// each day is the aggregate of the window of days ending with it (i.e. window=14 for the usual 14 day time in range).
+func timeInRangeForEmail(writer http.ResponseWriter, request *http.Request, email string) {
+	context := appengine.NewContext(request)
+
+	scanQuery, err := newScanQuery(request)
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	window := 1
+	if value := request.FormValue(QUERY_PARAM_WINDOW); len(value) > 0 {
+		if window, err = strconv.Atoi(value); err != nil || window < 1 || window > MAX_TIME_IN_RANGE_WINDOW {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s, must be between 1 and %d: [%s].", QUERY_PARAM_WINDOW, MAX_TIME_IN_RANGE_WINDOW, value), 400)
+			return
+		}
+	}
+
+	timeInRanges, err := store.GetTimeInRanges(context, email, *scanQuery)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	if len(timeInRanges) < 1 {
+		http.Error(writer, "No time in range calculated yet.", 204)
+		return
+	}
+
+	if window > 1 {
+		// Get the days preceding the oldest one to fill its window
+		from := timeInRanges[len(timeInRanges)-1].UpperBound.AddDate(0, 0, -1*window).Add(time.Second)
+		to := timeInRanges[0].UpperBound
+		history, err := store.GetTimeInRanges(context, email, store.ScoreScanQuery{From: &from, To: &to})
+		if err != nil {
+			util.Propagate(err)
+		}
+
+		timeInRanges = engine.GetRollingTimeInRanges(history, len(timeInRanges), window)
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(timeInRanges)
+}
+
+// timeInRangeThresholds is the endpoint to retrieve the current user's time in range thresholds, in mg/dL
+func timeInRangeThresholds(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	settings, err := store.GetUserSettings(context, user.Email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	value := writer.Header()
+	value.Add("Content-type", "application/json")
+
+	enc := json.NewEncoder(writer)
+	enc.Encode(settings.TimeInRangeThresholds)
+}
+
+// updateTimeInRangeThresholds sets the current user's time in range thresholds and kicks off the recalculation of
+// its time in range history. Thresholds are in mg/dL unless the unit parameter says otherwise.
+func updateTimeInRangeThresholds(writer http.ResponseWriter, request *http.Request) {
+	context := appengine.NewContext(request)
+	user := user.Current(context)
+
+	multiplier := 1.
+	if request.FormValue(GLUCOSE_UNIT_PARAMETER) == apimodel.MMOL_PER_L {
+		multiplier = 18.0182
+	}
+
+	var thresholds model.TimeInRangeThresholds
+	for name, threshold := range map[string]*float64{QUERY_PARAM_VERY_LOW: &thresholds.VeryLow, QUERY_PARAM_LOW: &thresholds.Low,
+		QUERY_PARAM_HIGH: &thresholds.High, QUERY_PARAM_VERY_HIGH: &thresholds.VeryHigh} {
+		value, err := strconv.ParseFloat(request.FormValue(name), 64)
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("Invalid value for %s: [%s].", name, request.FormValue(name)), 400)
+			return
+		}
+		*threshold = value * multiplier
+	}
+
+	if err := thresholds.Validate(); err != nil {
+		http.Error(writer, err.Error(), 400)
+		return
+	}
+
+	settings, err := store.GetUserSettings(context, user.Email)
+	if err != nil {
+		util.Propagate(err)
+	}
+
+	if settings.TimeInRangeThresholds == thresholds {
+		writer.WriteHeader(200)
+		return
+	}
+
+	log.Infof(context, "Updating time in range thresholds of user [%s] from [%v] to [%v]", user.Email, settings.TimeInRangeThresholds, thresholds)
+	settings.TimeInRangeThresholds = thresholds
+	if err := store.StoreUserSettings(context, user.Email, *settings); err != nil {
+		util.Propagate(err)
+	}
+
+	if err := engine.RecalculateTimeInRange(context, user.Email); err != nil {
+		log.Criticalf(context, "Couldn't schedule the recalculation of time in range for user [%s]: %v", user.Email, err)
+	}
+
+	if err := engine.RedetectEpisodes(context, user.Email); err != nil {
+		log.Criticalf(context, "Couldn't schedule the detection of episodes for user [%s]: %v", user.Email, err)
+	}
+
+	writer.WriteHeader(200)
+}
@@ -36,7 +36,22 @@ function getAngleForA1C(value) {
     }
 }
 
+// Let the server know about the browser's zone so that data gets resolved to it (real users only)
+function updateTimezone(pathPrefix) {
+    if (pathPrefix !== "" || typeof Intl === "undefined") {
+        return;
+    }
+
+    var timezone = Intl.DateTimeFormat().resolvedOptions().timeZone;
+    if (timezone) {
+        $.post("/timezone", {
+            timezone: timezone
+        });
+    }
+}
+
 function showDataBrowser(pathPrefix, unit) {
+    updateTimezone(pathPrefix);
     var highChartOffset = unit === "mmolPerL" ? 5.55 : 100;
     var TARGET_RANGE_LOWER_BOUND = getLowerRangeValue(unit);
     var TARGET_RANGE_UPPER_BOUND = getUpperRangeValue(unit)
@@ -119,17 +134,23 @@ function showDataBrowser(pathPrefix, unit) {
     d3.json("/" + pathPrefix + "data?unit=" + unit, function(error, data) {
         showProfile(data, "self_dashboard");
         addTrendToProfile(pathPrefix, data, "self_dashboard");
+        showPatterns(pathPrefix, unit);
 
         glucoseReads = data.data[0].data;
         userEvents = data.data[1].data;
+        var episodes = getSeries(data, "Episodes");
+        var forecast = getSeries(data, "Forecast");
         timeRangeLowerBound = glucoseReads[0].x;
         glucoseReads.forEach(function(d) {
             d.date = parseDate(d.x * 1000);
         });
-        var highestOnChart = d3.max(glucoseReads, function(d) {
+        forecast.forEach(function(d) {
+            d.date = parseDate(d.x * 1000);
+        });
+        var highestOnChart = d3.max(glucoseReads.concat(forecast), function(d) {
             return d.y;
         }) + highChartOffset;
-        x.domain(d3.extent(glucoseReads.map(function(d) {
+        x.domain(d3.extent(glucoseReads.concat(forecast).map(function(d) {
             return d.date;
         })));
         y.domain([0, highestOnChart]);
@@ -213,8 +234,34 @@ function showDataBrowser(pathPrefix, unit) {
                 return x(d.start);
             })
             .attr("y", height - 5);
+        // Episodes are drawn right above the nights, from their start and for their duration in minutes
+        timebar
+            .selectAll(".episode")
+            .data(episodes)
+            .enter()
+            .append("rect")
+            .attr("class", function(d) {
+                return "episode " + d.tag;
+            })
+            .attr("clip-path", "url(#clip)")
+            .attr("width", function(d) {
+                return x(moment.unix(d.x + d.value * 60).toDate()) - x(moment.unix(d.x).toDate());
+            })
+            .attr("height", 5)
+            .attr("x", function(d) {
+                return x(moment.unix(d.x).toDate());
+            })
+            .attr("y", height - 10);
         var segments = splitReadsInRangeSegments(glucoseReads, unit);
         addToGraph(focus, "self", context, segments, glucoseReads, y, glucoseLine, true, viewfinderLine);
+        // The projection starts from the most recent read so that it connects with the glucose line
+        if (forecast.length > 0) {
+            focus.append("path")
+                .attr("class", "forecast")
+                .attr("clip-path", "url(#clip)")
+                .datum([glucoseReads[glucoseReads.length - 1]].concat(forecast))
+                .attr("d", glucoseLine);
+        }
         // Trying out the grouping, it doesn't actually use any of this
         userEvents.forEach(function(d) {
             d.date = parseDate(d.x * 1000);
@@ -241,6 +288,7 @@ function showDataBrowser(pathPrefix, unit) {
             x.domain(brush.empty() ? x2.domain() : brush.extent());
             focus.selectAll("path.self").attr("d", glucoseLine);
             focus.selectAll("path.steadySailor").attr("d", glucoseLine);
+            focus.selectAll("path.forecast").attr("d", glucoseLine);
             focus.selectAll("#dayBoundaries")
             dayBoundaryGroup.selectAll(".dayBoundary")
                 .attr("x", function(d) {
@@ -254,6 +302,14 @@ function showDataBrowser(pathPrefix, unit) {
                 .attr("x", function(d) {
                     return x(d.start);
                 });
+            timebar
+                .selectAll(".episode")
+                .attr("width", function(d) {
+                    return x(moment.unix(d.x + d.value * 60).toDate()) - x(moment.unix(d.x).toDate());
+                })
+                .attr("x", function(d) {
+                    return x(moment.unix(d.x).toDate());
+                });
             focus.selectAll("path.event").attr("transform", function(d) {
                 return "translate(" + x(d.date) + "," + y(d.y) + ")";
             });
@@ -335,6 +391,17 @@ function showDataBrowser(pathPrefix, unit) {
     });
 }
 
+// getSeries returns the data of a series of the data response by name or an empty array if it isn't there
+function getSeries(data, name) {
+    for (var i = 0; i < data.data.length; i++) {
+        if (data.data[i].name === name) {
+            return data.data[i].data;
+        }
+    }
+
+    return [];
+}
+
 function addToGraph(focus, className, context, segments, glucoseReads, y, glucoseLineFunc, highlightSegments, viewfinderLineFunc) {
     for (var i = 0; i < segments.length; i++) {
         var segment = segments[i];
@@ -443,13 +510,44 @@ function showProfile(dashboardData, sectionName) {
         document.getElementById(sectionPrefix + "glukitScore").innerHTML = "?";
     } else {
         document.getElementById(sectionPrefix + "glukitScore").innerHTML = glukitScore;
+        var explanation = getCoverageExplanation(dashboardData.scoreDetails);
+        if (dashboardData.classicScore != null && dashboardData.classicScore != glukitScore) {
+            explanation = explanation + (explanation.length > 0 ? ", " : "") + "classic score of " + dashboardData.classicScore;
+        }
+        document.getElementById(sectionPrefix + "glukitScore").title = explanation;
     }
     document.getElementById(sectionPrefix + "glukitScoreDates").innerHTML = moment(lowerBound, "YYYY-MM-DDHH:mm:ssZ").format('LL') + ' - ' + moment(upperBound, "YYYY-MM-DDHH:mm:ssZ").format('LL');
 }
 
+// Explains how much sensor data a glukit score or an a1c estimate is based on
+function getCoverageExplanation(details) {
+    if (details.ReadCount == null || details.ReadCount == 0) {
+        return "";
+    }
+
+    return "Based on " + details.ReadCount + " reads, " + details.Coverage.toFixed(0) + "% of the expected sensor data";
+}
+
+// Explains a missing glukit score with the sensor coverage of the last week
+function explainMissingScore(pathPrefix, element) {
+    $.getJSON("/" + pathPrefix + "coverage", function(coverage) {
+        if (!coverage || coverage.length == 0) {
+            return;
+        }
+
+        var lastWeek = coverage.slice(-7);
+        var percentage = d3.mean(lastWeek, function(d) { return d.percentage; });
+        element.title = "Not enough sensor data for a score: the last " + lastWeek.length + " days are " + percentage.toFixed(0) + "% covered";
+    });
+}
+
 function addTrendToProfile(pathPrefix, dashboardData, sectionName) {
     var sectionPrefix = sectionName + ".";
     var glukitScore = dashboardData.score;
+    if (glukitScore == null) {
+        explainMissingScore(pathPrefix, document.getElementById(sectionPrefix + "glukitScore"));
+    }
+
     $.getJSON("/" + pathPrefix + "glukitScores?limit=8", function(data) {
         var mostRecentScore = data[0].Value;
         var referenceScore = data[data.length - 1].Value;
@@ -463,11 +561,56 @@ function addTrendToProfile(pathPrefix, dashboardData, sectionName) {
             var mostRecentA1C = data[0].Value;
             var angle = getAngleForA1C(mostRecentA1C)
             document.getElementById("a1c").innerHTML = mostRecentA1C.toFixed(1);
+            document.getElementById("a1c").title = getCoverageExplanation(data[0]);
             $(".a1cNeedle").attr("style", "-ms-transform: rotate(" + angle + "deg); -moz-transform:rotate(" + angle + "deg); -webkit-transform:rotate(" + angle + "deg); transform:rotate(" + angle + "deg);");
+        } else {
+            document.getElementById("a1c").title = "Not enough sensor data yet, an a1c estimate needs 90 days of reads";
         }
     });
 }
 
+var PATTERN_DESCRIPTIONS = {
+    OvernightLow: "Overnight lows",
+    DawnPhenomenon: "Dawn phenomenon",
+    ReboundHigh: "Rebound highs after lows",
+    PostMealSpike: "Spikes after meals"
+};
+
+var PATTERN_MAGNITUDE_LABELS = {
+    OvernightLow: "average low of ",
+    DawnPhenomenon: "average rise of ",
+    ReboundHigh: "average peak of ",
+    PostMealSpike: "average rise of "
+};
+
+// Lists the patterns of the most recent weekly pattern detection, their magnitudes are in mg/dL
+function showPatterns(pathPrefix, unit) {
+    $.getJSON("/" + pathPrefix + "insights/patterns", function(report) {
+        if (!report || report.patterns.length == 0) {
+            return;
+        }
+
+        var $patterns = $("#patterns");
+        $patterns.empty();
+        $.each(report.patterns, function(i, pattern) {
+            var description = PATTERN_DESCRIPTIONS[pattern.type];
+            if (pattern.mealSlot) {
+                description = description + " (" + pattern.mealSlot.toLowerCase() + ")";
+            }
+            var magnitude = unit === "mmolPerL" ? pattern.magnitude / 18.0182 : pattern.magnitude;
+            var details = pattern.supportingDays.length + " of " + pattern.eligibleDays + " days, " + PATTERN_MAGNITUDE_LABELS[pattern.type] +
+                formatGlucoseValue(magnitude, unit) + " " + getGlucoseUnitString(unit);
+
+            var $pattern = $("<li class=\"pattern\"/>").text(description + ": " + details);
+            $pattern.append($("<span class=\"patternStrength\"/>").css("width", Math.round(pattern.strength * 100) + "%"));
+            $patterns.append($pattern);
+        });
+
+        $("#patternsPeriod").text("Based on data from " + moment(report.lowerBound).format("LL") + " - " + moment(report.upperBound).format("LL"));
+        $("#patternsSection").show();
+    });
+}
+
 function toggleNormalRange() {
     $rangeSelection = $('#inRange');
 